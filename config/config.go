@@ -27,6 +27,17 @@ const (
 // AuthenticationType is the text that represents the authentication type
 type AuthenticationType string
 
+// PolicyConfig store the state and the parameters of a scan policy. When Enabled isn't
+// defined the policy keeps the state of the parent configuration, that is enabled by
+// default, except for the opt-in policies like the security probes. The configurations
+// of all zones that contain the domain are applied, from the less to the most specific
+// zone, and the zones inside a zone configuration are ignored
+type PolicyConfig struct {
+	Enabled    *bool                   // Flag to enable or disable the policy
+	Parameters map[string]string       // Parameters of the policy indexed by name
+	Zones      map[string]PolicyConfig // Configuration for the domains below each zone
+}
+
 // List of possible log levels
 const (
 	// LogLevelNormal logs only errors
@@ -121,12 +132,97 @@ type Config struct {
 		// After that will consider a timeout problem
 		ConnectionRetries int
 
+		// Limits of the queries sent to each nameserver host. Many domains share the same
+		// hosts, and large providers can rate limit us when we send too many queries
+		RateLimit struct {
+			// Number of queries per second that each host can receive. Use zero to keep the
+			// default value (500) or a negative number to disable the rate limit
+			QueriesPerSecond int
+
+			// Maximum number of queries that a host can receive at once, after a period without
+			// queries. Use zero to keep the default value (500)
+			Burst int
+
+			// Number of times that a domain can be postponed while waiting for a host. After
+			// that the domain isn't checked in this scan. Use zero to keep the default value
+			// (10)
+			MaxPostponements int
+		}
+
+		// Weights used to rank the domains in the scan, the domains with higher priority are
+		// checked first. Each criterion is a number between 0 and 1 multiplied by its weight.
+		// Use zero to keep the default value or a negative number to ignore the criterion.
+		// The priority is stored when the domain is scheduled, so that the domains are
+		// selected in this order from the database. The on-demand checks of the REST server
+		// go before all domains of a running scan
+		Priority struct {
+			// Weight of the DNSSEC signatures expiration proximity (default 3)
+			ExpirationWeight float64
+
+			// Weight of the proportion of nameservers and DS records with errors (default 2)
+			ErrorWeight float64
+
+			// Weight of the time since the last check (default 1)
+			LastCheckWeight float64
+		}
+
+		// Split the scan between many Shelter instances that share the same database. The
+		// coordinator splits the domains that are due into batches stored in the database,
+		// and the workers lease, scan and commit the batches. The coordinator doesn't scan
+		// the domains, so at least one worker is necessary
+		Distributed struct {
+			Enabled bool
+
+			// The coordinator creates the batches when the scan job runs (see Time and
+			// IntervalHours) and merges the statistics of the workers in one scan. Only one
+			// instance should be the coordinator, all others are workers
+			Coordinator bool
+
+			// Identification of this worker in the leases. Use an empty string to identify the
+			// worker by the host name and the process id
+			WorkerID string
+
+			// Number of domains in each batch. Use zero to keep the default value (1000)
+			BatchSize int
+
+			// Time that a worker has to scan a batch before another worker can lease it. The
+			// worker renews the lease while scanning, so it only expires when the worker
+			// crashed. When the batches don't change for 3 lease durations, or when the next
+			// scan should start, the coordinator gives up the batches that weren't committed.
+			// Use zero to keep the default value (5)
+			LeaseMinutes int
+
+			// Time that a worker waits before looking for batches again when there's nothing
+			// to scan, and that the coordinator waits between checks of the workers' progress.
+			// Use zero to keep the default value (10)
+			PollSeconds int
+		}
+
+		// Store the progress of the scan in the database while it is executing, so that a
+		// scan interrupted by a crash or restart isn't lost
+		Checkpoint struct {
+			// Number of seconds between each save of the scan progress. Use zero to keep the
+			// default value (60)
+			IntervalSeconds int
+
+			// When Shelter starts and finds an interrupted scan, the scan continues from the
+			// domains that weren't checked yet. Otherwise the scan is marked as aborted
+			Resume bool
+		}
+
 		// Information about the recursive DNS server for specific services of the scan. Like
-		// QueryDomain, that retrieves the nameservers and DS records from a domain name
+		// QueryDomain, that retrieves the nameservers and DS records from a domain name, the
+		// parent zone check, that finds the nameservers of the parent zone to verify if the DS
+		// records are published, or the resolution of the nameservers' names. When there's no
+		// resolver, the nameservers' names are resolved by the operating system
 		Resolver struct {
 			// IP address from the resolver
 			Address string
 
+			// IP addresses of other resolvers, used in order when the previous resolvers don't
+			// answer or fail while resolving the nameservers' names
+			Fallbacks []string
+
 			// Port from the resolver
 			Port int
 		}
@@ -162,6 +258,72 @@ type Config struct {
 			// resigned
 			MaxExpirationAlertDays int
 		}
+
+		// Automated DS maintenance using the CDS and CDNSKEY records published by the child
+		// zones (RFC 7344 and RFC 8078). Only domains that already have DNSSEC are checked.
+		// The checks are the "ds.cds" policy, where the "minStableScans" parameter is the
+		// number of consecutive scans that must find the same DS update before it can be
+		// applied (default 3)
+		CDS struct {
+			// Flag to apply the stable DS updates automatically in the domain. The owners are
+			// notified about each applied update
+			AutoApply bool
+		}
+
+		// Automated nameserver and glue maintenance using the CSYNC records published by the
+		// child zones (RFC 7477). Only domains that already have DNSSEC are checked. The
+		// checks are the "ns.csync" policy
+		CSYNC struct {
+			// Flag to apply the nameserver updates automatically in the domain, when the CSYNC
+			// record has the immediate flag. Updates without the immediate flag are only
+			// proposed. The owners are notified about each applied update
+			AutoApply bool
+		}
+
+		// Configuration of the policies executed by the scan, indexed by the policy
+		// identifier (e.g. "ns.tcp" or "ds.algorithm"). The policies are enabled by default
+		// (except for the security probes), so only the policies with a different state or
+		// parameters need to be listed. A policy is only executed when the policies that it
+		// depends on are also enabled. The zones allow a different configuration for the
+		// domains below a zone, as each TLD can have its own local policies. Beyond the checks
+		// of the nameserver and DNSKEY responses, there are policies for the checks that send
+		// their own queries or compare the nameservers of the domain:
+		//
+		//   * "ns.edns": EDNS compliance probes
+		//   * "ns.soafields": SOA timers ranges ("minRefresh", "maxRefresh", "minRetry",
+		//     "maxRetry", "minExpire", "maxExpire", "minTTL" and "maxTTL" in seconds)
+		//   * "ns.serial": zone version comparison, reporting nameservers behind the newest
+		//     version by more than "maxIncrements" (default 1) for longer than
+		//     "maxDelayHours" (default 6)
+		//   * "ns.rtt": nameservers slower than "maxMilliseconds" (default 0, no limit)
+		//   * "ns.recursion" and "ns.transfer": open recursion (using the "probeName"
+		//     parameter) and zone transfer probes. These probes can be reported as attacks by
+		//     the nameservers' operators, so they are disabled unless enabled here
+		//   * "ds.denial": NSEC or NSEC3 proof of a random name, reporting NSEC3 records
+		//     with more than "maxIterations" (default 50) as an error
+		//   * "ds.rrset": signatures of the SOA and NS RRsets
+		//   * "ds.parent": DS set published by the parent zone's nameservers
+		//   * "ds.fragmentation": DNSKEY queries with smaller EDNS buffers when the DNSKEY
+		//     response timed out, to detect responses lost in the path
+		//   * "ds.cds" and "ns.csync": records of the child zones asking for updates
+		//
+		// For example:
+		//
+		//     "policies": {
+		//       "ns.tcp": {
+		//         "enabled": false,
+		//         "zones": {
+		//           "gov.br.": { "enabled": true }
+		//         }
+		//       },
+		//       "ns.transfer": {
+		//         "enabled": true
+		//       },
+		//       "ds.algorithm": {
+		//         "parameters": { "minRSAKeySize": "1024" }
+		//       }
+		//     }
+		Policies map[string]PolicyConfig
 	}
 
 	// Store all variables related to the REST server
@@ -291,6 +453,11 @@ type Config struct {
 		// we notify the domain's owners
 		DSTimeoutAlertDays int
 
+		// How many days we will wait with a finding of each severity (INFO, WARNING or ERROR)
+		// until we notify the domain's owners. The severities that aren't listed are only
+		// notified together with the other problems of the domain
+		SeverityAlertDays map[string]int
+
 		// All notification e-mails are sent with this From
 		From string
 
@@ -336,10 +503,55 @@ type Config struct {
 		//       {{else if nsStatusEq $nameserver.LastStatus "NOTSYNCH"}}
 		//         Error description.
 		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "NSMISSING"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "NSEXTRA"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "GLUEERR"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "EDNS"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "NOTCP"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "TCPDIFF"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "OPENREC"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "OPENAXFR"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "SOATIMERS"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "SOANAMES"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "SLOW"}}
+		//         Error description.
+		//
+		//       {{else if nsStatusEq $nameserver.LastStatus "RESOLVFAIL"}}
+		//         Error description.
+		//
 		//       {{else if nsStatusEq $nameserver.LastStatus "ERROR"}}
 		//         Error description.
 		//
 		//       {{end}}
+		//       {{with nsFailedAddresses $nameserver}}
+		//         Addresses with problems: {{.}}
+		//       {{end}}
+		//       {{with diagnosticText $nameserver.Diagnostic}}
+		//         Diagnostic: {{.}}
+		//       {{end}}
+		//       {{with findingsText $nameserver.Findings "INFO"}}
+		//         Informational findings: {{.}}
+		//       {{end}}
 		//     {{end}}
 		//
 		//     {{range $ds := $domain.DSSet}}
@@ -364,10 +576,68 @@ type Config struct {
 		//       {{else if dsStatusEq $ds.LastStatus "DNSERR"}}
 		//         Error description.
 		//
+		//       {{else if dsStatusEq $ds.LastStatus "NOTPUB"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "NODENIAL"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "DENIALERR"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "DENIALSIGERR"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "NSEC3PARAM"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "NSEC3ITER"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "ALGERR"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "NOALGSIG"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "DEPALG"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "DEPDIGEST"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "WEAKKEY"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "FRAGMENT"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "RRSETSIGERR"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "RRSETEXPSIG"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "SIGTTL"}}
+		//         Error description.
+		//
+		//       {{else if dsStatusEq $ds.LastStatus "KEYDIFF"}}
+		//         Error description.
+		//
 		//       {{else if isNearExpiration $ds}}
 		//         Error description.
 		//
 		//       {{end}}
+		//       {{with diagnosticText $ds.Diagnostic}}
+		//         Diagnostic: {{.}}
+		//       {{end}}
+		//       {{with findingsText $ds.Findings "INFO"}}
+		//         Informational findings: {{.}}
+		//       {{end}}
+		//     {{end}}
+		//
+		//     {{if dsStatusEq $domain.ParentDS.LastStatus "NOTREG"}}
+		//       Error description.
 		//     {{end}}
 		//
 		//     Goodbye message.
@@ -127,6 +127,40 @@ func init() {
 
 		return database.C(domainDAOCollection).EnsureIndex(index)
 	})
+
+	// Add index on parentds.laststatus to speed up the query that check the domains that
+	// need to be notified. Here the status has a high selectivity, as few domains have DS
+	// records in the parent zone that aren't registered
+	mongodb.RegisterIndexFunction(func(database *mgo.Database) error {
+		index := mgo.Index{
+			Name: "parentds",
+			Key:  []string{"parentds.laststatus"},
+		}
+
+		return database.C(domainDAOCollection).EnsureIndex(index)
+	})
+
+	// Add index on nextcheckat to speed up the query that selects the domains that are due
+	// for the scan. Without it the scan would need to load all domains from the database
+	mongodb.RegisterIndexFunction(func(database *mgo.Database) error {
+		index := mgo.Index{
+			Name: "nextcheckat",
+			Key:  []string{"nextcheckat"},
+		}
+
+		return database.C(domainDAOCollection).EnsureIndex(index)
+	})
+
+	// Add index on priority and nextcheckat to speed up the query that selects the domains
+	// that are due for the scan in the order that they must be checked
+	mongodb.RegisterIndexFunction(func(database *mgo.Database) error {
+		index := mgo.Index{
+			Name: "priority",
+			Key:  []string{"-priority", "nextcheckat"},
+		}
+
+		return database.C(domainDAOCollection).EnsureIndex(index)
+	})
 }
 
 // DomainDAO is the structure responsable for keeping the database connection to save the
@@ -171,6 +205,23 @@ func (dao DomainDAO) Save(domain *model.Domain) error {
 	return err
 }
 
+// Store only the next check date and the priority of the domain, without changing the
+// revision or the last modification date, as the domain itself didn't change. It's used to
+// schedule the domains stored before the next check date existed
+func (dao DomainDAO) SaveNextCheck(domain *model.Domain) error {
+	// Check if the programmer forgot to set the database in DomainDAO object
+	if dao.Database == nil {
+		return ErrDomainDAOUndefinedDatabase
+	}
+
+	return dao.Database.C(domainDAOCollection).UpdateId(domain.Id, bson.M{
+		"$set": bson.M{
+			"nextcheckat": domain.NextCheckAt,
+			"priority":    domain.Priority,
+		},
+	})
+}
+
 // Save many domains at once, creating go routines to execute each domain in the classic
 // Save method. This happens because there's no method to execute Upsert to many documents
 // at once in the mgo API. http://stackoverflow.com/questions/19810176/mongodb-mgo-
@@ -184,8 +235,11 @@ func (dao DomainDAO) SaveMany(domains []*model.Domain) []DomainResult {
 // pagination to analyze the data in amounts. When pagination values are not informed,
 // default values are adopted. There's also an expand flag that can control if each domain
 // object from the list will have only the FQDN, last modification, nameserver and DS
-// status or the full information
-func (dao DomainDAO) FindAll(pagination *DomainDAOPagination, expand bool, filter string) ([]model.Domain, error) {
+// status or the full information. The result set can be filtered by the FQDN (regular
+// expression) and by the severities of the findings
+func (dao DomainDAO) FindAll(pagination *DomainDAOPagination, expand bool, filter string,
+	severities []model.Severity) ([]model.Domain, error) {
+
 	// Check if the programmer forgot to set the database in DomainDAO object
 	if dao.Database == nil {
 		return nil, ErrDomainDAOUndefinedDatabase
@@ -227,15 +281,23 @@ func (dao DomainDAO) FindAll(pagination *DomainDAOPagination, expand bool, filte
 		sortList = append(sortList, sortTmp)
 	}
 
-	if len(filter) == 0 {
-		query = dao.Database.C(domainDAOCollection).Find(bson.M{})
+	conditions := bson.M{}
 
-	} else {
-		query = dao.Database.C(domainDAOCollection).Find(bson.M{
-			"fqdn": bson.RegEx{Pattern: filter, Options: "i"},
-		})
+	if len(filter) > 0 {
+		conditions["fqdn"] = bson.RegEx{Pattern: filter, Options: "i"}
+	}
+
+	// Only the domains with at least one finding of the given severities in the
+	// nameservers or DS records
+	if len(severities) > 0 {
+		conditions["$or"] = []bson.M{
+			{"nameservers.findings.severity": bson.M{"$in": severities}},
+			{"dsset.findings.severity": bson.M{"$in": severities}},
+		}
 	}
 
+	query = dao.Database.C(domainDAOCollection).Find(conditions)
+
 	// We store the number of items before applying pagination, if we do this after we get only the
 	// number of items of a page size
 	var err error
@@ -276,18 +338,20 @@ func (dao DomainDAO) FindAll(pagination *DomainDAOPagination, expand bool, filte
 
 	// When the expand flag if not defined, we should compress the domain object so the
 	// network data isn't too big. For now the compressed object will have the FQDN, last
-	// modification and the status of the nameservers and DS set, this is useful to detect
-	// quickly the domains that have some issue
+	// modification and the status and findings of the nameservers and DS set, this is
+	// useful to detect quickly the domains that have some issue
 	if !expand {
 		for i := range domains {
 			for j := range domains[i].Nameservers {
 				domains[i].Nameservers[j] = model.Nameserver{
+					Findings:   domains[i].Nameservers[j].Findings,
 					LastStatus: domains[i].Nameservers[j].LastStatus,
 				}
 			}
 
 			for j := range domains[i].DSSet {
 				domains[i].DSSet[j] = model.DS{
+					Findings:   domains[i].DSSet[j].Findings,
 					LastStatus: domains[i].DSSet[j].LastStatus,
 				}
 			}
@@ -335,6 +399,110 @@ func (dao DomainDAO) FindAllAsync() (chan DomainResult, error) {
 	return domainChannel, nil
 }
 
+// Return all domains that should be checked by the scan until the given date, according to
+// the next check date computed when the domain was saved. Domains stored before the next
+// check date existed and not scheduled yet (see FindAllAsyncUnscheduled) are also
+// returned, so that they are scheduled after the check. The domains with higher priority
+// (computed when the domain was scheduled) are returned first, and the domains that are
+// waiting longer between the ones with the same priority, so that an interrupted scan
+// already checked the most urgent domains. The domains already checked by the scan that
+// started at the given time are ignored, so that an interrupted scan can be resumed. As
+// there can be many domains, this method works asynchronously, returning the domain as
+// soon as it is selected. When the done channel is closed the database cursor is released
+// and no more results are sent
+func (dao DomainDAO) FindAllAsyncToBeScanned(until, scanStartedAt time.Time,
+	done <-chan bool) (chan DomainResult, error) {
+
+	// Check if the programmer forgot to set the database in DomainDAO object
+	if dao.Database == nil {
+		return nil, ErrDomainDAOUndefinedDatabase
+	}
+
+	// Channel to be used for returning each retrieved domain
+	domainChannel := make(chan DomainResult)
+
+	go func() {
+		query := dao.Database.C(domainDAOCollection).Find(bson.M{
+			"$or": []bson.M{
+				{"nextcheckat": bson.M{"$lte": until}},
+				{"nextcheckat": bson.M{"$exists": false}},
+			},
+			"lastscanstartedat": bson.M{"$ne": scanStartedAt},
+		}).Sort("-priority", "nextcheckat")
+
+		sendDomainsUntilDone(query.Iter(), domainChannel, done)
+	}()
+
+	return domainChannel, nil
+}
+
+// Return all domains stored before the next check date existed, so that they can be
+// scheduled according to their last check before the scan selects the domains that are
+// due. Otherwise all these domains would be checked in the same scan. As there can be
+// many domains, this method works asynchronously, returning the domain as soon as it is
+// selected
+func (dao DomainDAO) FindAllAsyncUnscheduled() (chan DomainResult, error) {
+	// Check if the programmer forgot to set the database in DomainDAO object
+	if dao.Database == nil {
+		return nil, ErrDomainDAOUndefinedDatabase
+	}
+
+	// Channel to be used for returning each retrieved domain
+	domainChannel := make(chan DomainResult)
+
+	go func() {
+		// Gets the database result iterator
+		it := dao.Database.C(domainDAOCollection).Find(bson.M{
+			"nextcheckat": bson.M{"$exists": false},
+		}).Iter()
+
+		var domainIt model.Domain
+		for it.Next(&domainIt) {
+			domain := domainIt // Copy the domainIt object to send it to the channel
+			domainChannel <- DomainResult{
+				Domain: &domain,
+				Error:  nil,
+			}
+		}
+
+		err := it.Close()
+		domainChannel <- DomainResult{
+			Domain: nil,
+			Error:  err,
+		}
+	}()
+
+	return domainChannel, nil
+}
+
+// Return the domains with the given FQDNs, ordered in the same way of the domains that
+// should be checked by the scan. It's used by the workers of a distributed scan to load
+// the domains of a batch. Domains that were removed after the batch was created are
+// ignored. This method works asynchronously, returning the domain as soon as it is
+// selected. When the done channel is closed the database cursor is released and no more
+// results are sent
+func (dao DomainDAO) FindAllAsyncByFQDNs(fqdns []string,
+	done <-chan bool) (chan DomainResult, error) {
+
+	// Check if the programmer forgot to set the database in DomainDAO object
+	if dao.Database == nil {
+		return nil, ErrDomainDAOUndefinedDatabase
+	}
+
+	// Channel to be used for returning each retrieved domain
+	domainChannel := make(chan DomainResult)
+
+	go func() {
+		query := dao.Database.C(domainDAOCollection).Find(bson.M{
+			"fqdn": bson.M{"$in": fqdns},
+		}).Sort("-priority", "nextcheckat")
+
+		sendDomainsUntilDone(query.Iter(), domainChannel, done)
+	}()
+
+	return domainChannel, nil
+}
+
 // Return all domains that need to be notified due to the error tolerancy policy. The
 // objective is to help the user to configure correctly the nameservers alerting about
 // problems. We are going to have different notification tolerances for nameserver, ds and
@@ -347,6 +515,7 @@ func (dao DomainDAO) FindAllAsyncToBeNotified(
 	dsErrorAlertDays,
 	dsTimeoutAlertDays,
 	maxExpirationAlertDays int,
+	severityAlertDays map[model.Severity]int,
 ) (chan DomainResult, error) {
 
 	// Check if the programmer forgot to set the database in DomainDAO object
@@ -363,61 +532,104 @@ func (dao DomainDAO) FindAllAsyncToBeNotified(
 		// query with $or operators inside the main $or but if we do that the "explain" show
 		// us that MongoDB don't use indexes for that sittuation (so avoid it!)
 
-		it := dao.Database.C(domainDAOCollection).Find(bson.M{
-			"$or": []bson.M{
-				{
-					"nameservers": bson.M{"$elemMatch": bson.M{
-						"laststatus": bson.M{"$nin": []model.NameserverStatus{
-							model.NameserverStatusNotChecked,
-							model.NameserverStatusOK,
-							model.NameserverStatusTimeout,
-						},
-						},
-						"lastokat": bson.M{
-							"$lte": time.Now().Add(time.Duration(-nameserverErrorAlertDays*24) * time.Hour),
-						},
+		conditions := []bson.M{
+			{
+				"nameservers": bson.M{"$elemMatch": bson.M{
+					"laststatus": bson.M{"$nin": []model.NameserverStatus{
+						model.NameserverStatusNotChecked,
+						model.NameserverStatusOK,
+						model.NameserverStatusTimeout,
 					},
 					},
-				},
-				{
-					"nameservers": bson.M{"$elemMatch": bson.M{
-						"laststatus": model.NameserverStatusTimeout,
-						"lastokat": bson.M{
-							"$lte": time.Now().Add(time.Duration(-nameserverTimeoutAlertDays*24) * time.Hour),
-						},
+					"lastokat": bson.M{
+						"$lte": time.Now().Add(time.Duration(-nameserverErrorAlertDays*24) * time.Hour),
 					},
+				},
+				},
+			},
+			{
+				"nameservers": bson.M{"$elemMatch": bson.M{
+					"laststatus": model.NameserverStatusTimeout,
+					"lastokat": bson.M{
+						"$lte": time.Now().Add(time.Duration(-nameserverTimeoutAlertDays*24) * time.Hour),
 					},
 				},
-				{
-					"dsset": bson.M{"$elemMatch": bson.M{
-						"laststatus": bson.M{"$nin": []model.DSStatus{
-							model.DSStatusNotChecked,
-							model.DSStatusOK,
-							model.DSStatusTimeout,
-						},
-						},
-						"lastokat": bson.M{
-							"$lte": time.Now().Add(time.Duration(-dsErrorAlertDays*24) * time.Hour),
-						},
+				},
+			},
+			{
+				"dsset": bson.M{"$elemMatch": bson.M{
+					"laststatus": bson.M{"$nin": []model.DSStatus{
+						model.DSStatusNotChecked,
+						model.DSStatusOK,
+						model.DSStatusTimeout,
 					},
 					},
-				},
-				{
-					"dsset": bson.M{"$elemMatch": bson.M{"laststatus": model.DSStatusTimeout,
-						"lastokat": bson.M{
-							"$lte": time.Now().Add(time.Duration(-dsTimeoutAlertDays*24) * time.Hour),
-						},
+					"lastokat": bson.M{
+						"$lte": time.Now().Add(time.Duration(-dsErrorAlertDays*24) * time.Hour),
 					},
+				},
+				},
+			},
+			{
+				"dsset": bson.M{"$elemMatch": bson.M{"laststatus": model.DSStatusTimeout,
+					"lastokat": bson.M{
+						"$lte": time.Now().Add(time.Duration(-dsTimeoutAlertDays*24) * time.Hour),
 					},
 				},
-				{
-					"dsset": bson.M{"$elemMatch": bson.M{"expiresat": bson.M{
-						"$lte": time.Now().Add(time.Duration(maxExpirationAlertDays*24) * time.Hour),
+				},
+			},
+			{
+				"dsset": bson.M{"$elemMatch": bson.M{"expiresat": bson.M{
+					"$lte": time.Now().Add(time.Duration(maxExpirationAlertDays*24) * time.Hour),
+				},
+				},
+				},
+			},
+			{
+				// DS records published by the parent zone that aren't registered, also for
+				// unsigned domains
+				"parentds.laststatus": model.DSStatusNotRegistered,
+				"parentds.lastokat": bson.M{
+					"$lte": time.Now().Add(time.Duration(-dsErrorAlertDays*24) * time.Hour),
+				},
+			},
+			{
+				// DS updates applied automatically (CDS/CDNSKEY) that the owners don't know
+				// about yet
+				"dsupdates": bson.M{"$elemMatch": bson.M{"notifiedat": time.Time{}}},
+			},
+			{
+				// Nameserver updates applied automatically (CSYNC) that the owners don't know
+				// about yet
+				"nameserverupdates": bson.M{"$elemMatch": bson.M{"notifiedat": time.Time{}}},
+			},
+		}
+
+		// Findings of the nameservers and DS records that exist for longer than the alert
+		// days of their severity
+		for severity, alertDays := range severityAlertDays {
+			firstSeenAt := time.Now().Add(time.Duration(-alertDays*24) * time.Hour)
+
+			conditions = append(conditions,
+				bson.M{
+					"nameservers.findings": bson.M{"$elemMatch": bson.M{
+						"severity":    severity,
+						"firstseenat": bson.M{"$lte": firstSeenAt},
 					},
 					},
+				},
+				bson.M{
+					"dsset.findings": bson.M{"$elemMatch": bson.M{
+						"severity":    severity,
+						"firstseenat": bson.M{"$lte": firstSeenAt},
+					},
 					},
 				},
-			},
+			)
+		}
+
+		it := dao.Database.C(domainDAOCollection).Find(bson.M{
+			"$or": conditions,
 		}).Iter()
 
 		var domainIt model.Domain
@@ -502,6 +714,31 @@ func (dao DomainDAO) RemoveAll() error {
 	return err
 }
 
+// Send the domains of the database result iterator to the channel, finishing with the
+// result without domain that carries the error of the iterator. When the done channel is
+// closed the iterator is closed and nothing else is sent, as the receiver isn't reading
+// the channel anymore. A nil done channel is never closed
+func sendDomainsUntilDone(it *mgo.Iter, domainChannel chan DomainResult, done <-chan bool) {
+	var domainIt model.Domain
+	for it.Next(&domainIt) {
+		domain := domainIt // Copy the domainIt object to send it to the channel
+
+		select {
+		case domainChannel <- DomainResult{Domain: &domain, Error: nil}:
+		case <-done:
+			it.Close()
+			return
+		}
+	}
+
+	err := it.Close()
+
+	select {
+	case domainChannel <- DomainResult{Domain: nil, Error: err}:
+	case <-done:
+	}
+}
+
 // Method used to execute an operation over domains concurrently. It was created because
 // the SaveMany and RemoveMany methods were exactly the same, except for the database
 // operation
@@ -0,0 +1,291 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package dao manage the objects persistence layer
+package dao
+
+import (
+	"errors"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2/bson"
+	"github.com/rafaeljusto/shelter/database/mongodb"
+	"github.com/rafaeljusto/shelter/model"
+	"time"
+)
+
+// List of possible errors that can occur in this DAO. There can be also other errors from
+// low level drivers.
+var (
+	// Programmer must set the Database attribute from ScanBatchDAO with a valid connection
+	// before using this object
+	ErrScanBatchDAOUndefinedDatabase = errors.New("No database defined for ScanBatchDAO")
+
+	// The lease of the batch expired and another worker leased it, so the worker that lost
+	// the lease cannot renew or commit the batch anymore
+	ErrScanBatchDAOLeaseLost = errors.New("Scan batch lease lost to another worker")
+)
+
+const (
+	scanBatchDAOCollection = "scanbatch" // Collection used to store all scan batch objects in the MongoDB database
+)
+
+func init() {
+	// Add index on status and sequence to speed up the lease of the next batch. The batches
+	// are leased in the order that they were created, so that the most urgent domains are
+	// scanned first
+	mongodb.RegisterIndexFunction(func(database *mgo.Database) error {
+		index := mgo.Index{
+			Name: "lease",
+			Key:  []string{"status", "sequence"},
+		}
+
+		return database.C(scanBatchDAOCollection).EnsureIndex(index)
+	})
+
+	// Add index on ScanStartedAt to speed up the search of the batches of a scan
+	mongodb.RegisterIndexFunction(func(database *mgo.Database) error {
+		index := mgo.Index{
+			Name: "scanstartedat",
+			Key:  []string{"scanstartedat"},
+		}
+
+		return database.C(scanBatchDAOCollection).EnsureIndex(index)
+	})
+}
+
+// ScanBatchDAO is the structure responsible for keeping the database connection to
+// coordinate the workers of a distributed scan using the scan batches
+type ScanBatchDAO struct {
+	Database *mgo.Database // MongoDB Database
+}
+
+// Save the scan batch object in the database. On creation the scan batch object is going
+// to receive the id that refers to the entry in the database
+func (dao ScanBatchDAO) Save(batch *model.ScanBatch) error {
+	// Check if the programmer forgot to set the database in ScanBatchDAO object
+	if dao.Database == nil {
+		return ErrScanBatchDAOUndefinedDatabase
+	}
+
+	// When creating a new scan batch object, the id will be probably nil (or kind of new
+	// according to bson.ObjectId), so we must initialize it
+	if len(batch.Id.Hex()) == 0 {
+		batch.Id = bson.NewObjectId()
+	}
+
+	// Every time we modified a scan batch object we increase the revision counter to
+	// identify changes in high level structures
+	batch.Revision += 1
+
+	// Store the last time that the object was modified
+	batch.LastModifiedAt = time.Now().UTC()
+
+	// Upsert try to update the collection entry if exists, if not, it creates a new entry.
+	// We also avoid concurency adding the revision as a paremeter for updating the entry
+	_, err := dao.Database.C(scanBatchDAOCollection).Upsert(bson.M{
+		"_id":      batch.Id,
+		"revision": batch.Revision - 1,
+	}, batch)
+
+	return err
+}
+
+// Lease the next batch to be scanned by the worker. A batch can be leased when it is
+// pending or when the lease of another worker expired, probably because the worker
+// crashed. The lease is atomic in the database, so two workers never receive the same
+// batch at the same time. When there's no batch to lease the mgo.ErrNotFound error is
+// returned
+func (dao ScanBatchDAO) Lease(worker string, duration time.Duration) (model.ScanBatch, error) {
+	var batch model.ScanBatch
+
+	// Check if the programmer forgot to set the database in ScanBatchDAO object
+	if dao.Database == nil {
+		return batch, ErrScanBatchDAOUndefinedDatabase
+	}
+
+	now := time.Now().UTC()
+
+	query := dao.Database.C(scanBatchDAOCollection).Find(bson.M{
+		"$or": []bson.M{
+			{"status": model.ScanBatchStatusPending},
+			{
+				"status":      model.ScanBatchStatusLeased,
+				"leaseduntil": bson.M{"$lt": now},
+			},
+		},
+	}).Sort("sequence")
+
+	_, err := query.Apply(mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{
+				"status":         model.ScanBatchStatusLeased,
+				"worker":         worker,
+				"leaseduntil":    now.Add(duration),
+				"lastmodifiedat": now,
+			},
+			"$inc": bson.M{
+				"leases":   1,
+				"revision": 1,
+			},
+		},
+		ReturnNew: true,
+	}, &batch)
+
+	return batch, err
+}
+
+// Extend the lease of a batch that the worker is still scanning. If another worker leased
+// the batch after the lease expired the ErrScanBatchDAOLeaseLost error is returned
+func (dao ScanBatchDAO) Renew(batch *model.ScanBatch, duration time.Duration) error {
+	// Check if the programmer forgot to set the database in ScanBatchDAO object
+	if dao.Database == nil {
+		return ErrScanBatchDAOUndefinedDatabase
+	}
+
+	now := time.Now().UTC()
+
+	err := dao.Database.C(scanBatchDAOCollection).Update(bson.M{
+		"_id":    batch.Id,
+		"status": model.ScanBatchStatusLeased,
+		"worker": batch.Worker,
+		"leases": batch.Leases,
+	}, bson.M{
+		"$set": bson.M{
+			"leaseduntil":    now.Add(duration),
+			"lastmodifiedat": now,
+		},
+	})
+
+	if err == mgo.ErrNotFound {
+		return ErrScanBatchDAOLeaseLost
+	}
+
+	return err
+}
+
+// Finish a batch leased by the worker, storing the statistics of the scan. If another
+// worker leased the batch after the lease expired the ErrScanBatchDAOLeaseLost error is
+// returned, and the statistics are discarded, because the other worker is going to commit
+// them
+func (dao ScanBatchDAO) Commit(batch *model.ScanBatch) error {
+	// Check if the programmer forgot to set the database in ScanBatchDAO object
+	if dao.Database == nil {
+		return ErrScanBatchDAOUndefinedDatabase
+	}
+
+	now := time.Now().UTC()
+
+	err := dao.Database.C(scanBatchDAOCollection).Update(bson.M{
+		"_id":    batch.Id,
+		"status": model.ScanBatchStatusLeased,
+		"worker": batch.Worker,
+		"leases": batch.Leases,
+	}, bson.M{
+		"$set": bson.M{
+			"status":         model.ScanBatchStatusCommitted,
+			"statistics":     batch.Statistics,
+			"lastmodifiedat": now,
+		},
+		"$inc": bson.M{
+			"revision": 1,
+		},
+	})
+
+	if err == mgo.ErrNotFound {
+		return ErrScanBatchDAOLeaseLost
+	}
+
+	if err == nil {
+		batch.Status = model.ScanBatchStatusCommitted
+	}
+
+	return err
+}
+
+// Give up the batches of the scan that started at the given time that weren't committed,
+// so that no worker leases them anymore. The coordinator uses it when the workers stopped
+// making progress for too long. A worker that is still scanning one of these batches gets
+// the ErrScanBatchDAOLeaseLost error on the next renewal or on the commit
+func (dao ScanBatchDAO) FailAllByScan(scanStartedAt time.Time) error {
+	// Check if the programmer forgot to set the database in ScanBatchDAO object
+	if dao.Database == nil {
+		return ErrScanBatchDAOUndefinedDatabase
+	}
+
+	_, err := dao.Database.C(scanBatchDAOCollection).UpdateAll(bson.M{
+		"scanstartedat": scanStartedAt,
+		"status": bson.M{
+			"$in": []model.ScanBatchStatus{
+				model.ScanBatchStatusPending,
+				model.ScanBatchStatusLeased,
+			},
+		},
+	}, bson.M{
+		"$set": bson.M{
+			"status":         model.ScanBatchStatusFailed,
+			"lastmodifiedat": time.Now().UTC(),
+		},
+		"$inc": bson.M{
+			"revision": 1,
+		},
+	})
+
+	return err
+}
+
+// Retrieve all batches created for the scan that started at the given time, ordered by the
+// sequence of the batches. There's also an expand flag that controls if the domains of the
+// batches are loaded. The domains are only useful for the workers and for a coordinator
+// that resumes the creation of the batches, and the coordinator checks the batches many
+// times while waiting for the workers
+func (dao ScanBatchDAO) FindAllByScan(scanStartedAt time.Time,
+	expand bool) ([]model.ScanBatch, error) {
+
+	// Check if the programmer forgot to set the database in ScanBatchDAO object
+	if dao.Database == nil {
+		return nil, ErrScanBatchDAOUndefinedDatabase
+	}
+
+	query := dao.Database.C(scanBatchDAOCollection).Find(bson.M{
+		"scanstartedat": scanStartedAt,
+	})
+
+	if !expand {
+		query = query.Select(bson.M{"fqdns": 0})
+	}
+
+	var batches []model.ScanBatch
+	err := query.Sort("sequence").All(&batches)
+	return batches, err
+}
+
+// Remove all batches of the scan that started at the given time. The coordinator removes
+// the batches after storing the scan, as they are only useful during the execution
+func (dao ScanBatchDAO) RemoveAllByScan(scanStartedAt time.Time) error {
+	// Check if the programmer forgot to set the database in ScanBatchDAO object
+	if dao.Database == nil {
+		return ErrScanBatchDAOUndefinedDatabase
+	}
+
+	_, err := dao.Database.C(scanBatchDAOCollection).RemoveAll(bson.M{
+		"scanstartedat": scanStartedAt,
+	})
+
+	return err
+}
+
+// Remove all scan batch entries from the database. This is a DANGEROUS method, as the
+// batches of a scan being executed are also removed, for now is used only by the
+// integration test enviroments to clear the database before starting a new test. The
+// batches of an interrupted scan are removed with RemoveAllByScan when the scan is resumed
+// or aborted. We don't drop the collection because we don't wanna lose the indexes
+func (dao ScanBatchDAO) RemoveAll() error {
+	// Check if the programmer forgot to set the database in ScanBatchDAO object
+	if dao.Database == nil {
+		return ErrScanBatchDAOUndefinedDatabase
+	}
+
+	_, err := dao.Database.C(scanBatchDAOCollection).RemoveAll(bson.M{})
+	return err
+}
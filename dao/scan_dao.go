@@ -171,6 +171,30 @@ func (dao ScanDAO) FindByStartedAt(startedAt time.Time) (model.Scan, error) {
 	return scan, err
 }
 
+// Retrieve all scans that were stored while executing and didn't finish, ordered by the
+// start date. When Shelter starts, these scans were interrupted by a crash or restart and
+// must be resumed or aborted
+func (dao ScanDAO) FindAllUnfinished() ([]model.Scan, error) {
+	// Check if the programmer forgot to set the database in ScanDAO object
+	if dao.Database == nil {
+		return nil, ErrScanDAOUndefinedDatabase
+	}
+
+	var scans []model.Scan
+	err := dao.Database.C(scanDAOCollection).Find(bson.M{
+		"status": bson.M{
+			"$in": []model.ScanStatus{
+				model.ScanStatusLoadingData,
+				model.ScanStatusRunning,
+				model.ScanStatusPaused,
+				model.ScanStatusCanceled,
+			},
+		},
+	}).Sort("startedat").All(&scans)
+
+	return scans, err
+}
+
 // Retrieve all scans using pagination control. This method is used by an end user to see
 // all scans that were executed in the system. The user will probably wants pagination to
 // analyze the data in amounts. When pagination values are not informed, default values
@@ -0,0 +1,138 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// List of possible CDS status. The CDS status represents the result of the check of the
+// CDS and CDNSKEY records that the child zone publishes to ask for a DS update (RFC 7344
+// and RFC 8078)
+const (
+	CDSStatusNotChecked   = iota // CDS and CDNSKEY records not checked yet
+	CDSStatusNotFound            // Child zone doesn't publish CDS or CDNSKEY records
+	CDSStatusValid               // Child zone asks for a valid DS update
+	CDSStatusDelete              // Child zone asks to remove the DS records (algorithm 0)
+	CDSStatusUnchanged           // CDS or CDNSKEY records are the same of the current DS set
+	CDSStatusInconsistent        // Nameservers answer different records, or CDS and CDNSKEY don't match
+	CDSStatusNoSignature         // Records aren't signed by a key of the current chain of trust
+	CDSStatusInvalid             // Records are malformed (e.g. delete signal mixed with keys)
+	CDSStatusDNSError            // Couldn't retrieve the records from all nameservers
+	CDSStatusUnpublished         // Proposed DS records don't match a key that signs the DNSKEY set
+)
+
+// CDSStatus is a number that represents one of the possible CDS status listed in the
+// constant group above
+type CDSStatus int
+
+// Convert the CDS status enum to text for printing in reports or debugging
+func CDSStatusToString(status CDSStatus) string {
+	switch status {
+	case CDSStatusNotChecked:
+		return "NOTCHECKED"
+	case CDSStatusNotFound:
+		return "NOTFOUND"
+	case CDSStatusValid:
+		return "VALID"
+	case CDSStatusDelete:
+		return "DELETE"
+	case CDSStatusUnchanged:
+		return "UNCHANGED"
+	case CDSStatusInconsistent:
+		return "INCONSISTENT"
+	case CDSStatusNoSignature:
+		return "NOSIG"
+	case CDSStatusInvalid:
+		return "INVALID"
+	case CDSStatusDNSError:
+		return "DNSERROR"
+	case CDSStatusUnpublished:
+		return "UNPUBLISHED"
+	}
+
+	return ""
+}
+
+// CDS store the DS update that the child zone asks for using the CDS and CDNSKEY records.
+// The update is only proposed after being found in many consecutive scans, so that a
+// temporary problem in the child zone doesn't change the chain of trust
+type CDS struct {
+	DSSet       []DS      // DS records that the child zone wants in the parent zone
+	StableScans int       // Number of consecutive scans that found the same DS update
+	FirstSeenAt time.Time // First time that the DS update was found
+	LastStatus  CDSStatus // Result of the last check
+	LastCheckAt time.Time // Time of the last check
+}
+
+// DSUpdate store a DS update applied automatically in the domain, keeping an audit trail
+// of the changes in the chain of trust. The owners are notified about each update
+type DSUpdate struct {
+	OldDSSet   []DS      // DS records before the update
+	NewDSSet   []DS      // DS records after the update (empty when the DS set was removed)
+	AppliedAt  time.Time // Time that the update was applied
+	NotifiedAt time.Time // Time that the owners were notified about the update
+}
+
+// ChangeStatus is a easy way to change the status of the CDS check because it also
+// updates the last check date and the number of consecutive scans that found the same
+// DS update. The DS set is the update found in this check, and is ignored when the status
+// isn't a valid update
+func (c *CDS) ChangeStatus(status CDSStatus, dsSet []DS) {
+	c.LastStatus = status
+	c.LastCheckAt = time.Now()
+
+	if status != CDSStatusValid && status != CDSStatusDelete {
+		c.DSSet = nil
+		c.StableScans = 0
+		c.FirstSeenAt = time.Time{}
+		return
+	}
+
+	if c.StableScans > 0 && SameDSSet(c.DSSet, dsSet) {
+		c.StableScans += 1
+		return
+	}
+
+	c.DSSet = dsSet
+	c.StableScans = 1
+	c.FirstSeenAt = c.LastCheckAt
+}
+
+// Check if the DS update was found in enough consecutive scans to be applied
+func (c CDS) IsStable(minStableScans int) bool {
+	return (c.LastStatus == CDSStatusValid || c.LastStatus == CDSStatusDelete) &&
+		c.StableScans >= minStableScans
+}
+
+// Compare two DS sets without considering the order of the records or the status of the
+// last checks. Digests are compared ignoring the case
+func SameDSSet(dsSet1, dsSet2 []DS) bool {
+	if len(dsSet1) != len(dsSet2) {
+		return false
+	}
+
+	for _, ds1 := range dsSet1 {
+		found := false
+		for _, ds2 := range dsSet2 {
+			if ds1.Keytag == ds2.Keytag &&
+				ds1.Algorithm == ds2.Algorithm &&
+				ds1.DigestType == ds2.DigestType &&
+				strings.ToLower(ds1.Digest) == strings.ToLower(ds2.Digest) {
+
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,121 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"testing"
+)
+
+func TestCDSStatusToString(t *testing.T) {
+	if CDSStatusToString(CDSStatusNotChecked) != "NOTCHECKED" {
+		t.Error("CDS status NOTCHECKED not converting correctly to string")
+	}
+
+	if CDSStatusToString(CDSStatusNotFound) != "NOTFOUND" {
+		t.Error("CDS status NOTFOUND not converting correctly to string")
+	}
+
+	if CDSStatusToString(CDSStatusValid) != "VALID" {
+		t.Error("CDS status VALID not converting correctly to string")
+	}
+
+	if CDSStatusToString(CDSStatusDelete) != "DELETE" {
+		t.Error("CDS status DELETE not converting correctly to string")
+	}
+
+	if CDSStatusToString(CDSStatusUnchanged) != "UNCHANGED" {
+		t.Error("CDS status UNCHANGED not converting correctly to string")
+	}
+
+	if CDSStatusToString(CDSStatusInconsistent) != "INCONSISTENT" {
+		t.Error("CDS status INCONSISTENT not converting correctly to string")
+	}
+
+	if CDSStatusToString(CDSStatusNoSignature) != "NOSIG" {
+		t.Error("CDS status NOSIG not converting correctly to string")
+	}
+
+	if CDSStatusToString(CDSStatusInvalid) != "INVALID" {
+		t.Error("CDS status INVALID not converting correctly to string")
+	}
+
+	if CDSStatusToString(CDSStatusDNSError) != "DNSERROR" {
+		t.Error("CDS status DNSERROR not converting correctly to string")
+	}
+
+	if CDSStatusToString(CDSStatusUnpublished) != "UNPUBLISHED" {
+		t.Error("CDS status UNPUBLISHED not converting correctly to string")
+	}
+
+	if CDSStatusToString(999999) != "" {
+		t.Error("Unknown CDS status associated to some existing status")
+	}
+}
+
+func TestCDSChangeStatus(t *testing.T) {
+	dsSet := []DS{
+		{Keytag: 1234, Algorithm: DSAlgorithmRSASHA256, DigestType: DSDigestTypeSHA256, Digest: "ABCD"},
+	}
+
+	var cds CDS
+	cds.ChangeStatus(CDSStatusValid, dsSet)
+
+	if cds.StableScans != 1 || cds.FirstSeenAt.IsZero() || cds.LastCheckAt.IsZero() {
+		t.Error("Not starting to count the scans of a new DS update")
+	}
+
+	cds.ChangeStatus(CDSStatusValid, []DS{
+		{Keytag: 1234, Algorithm: DSAlgorithmRSASHA256, DigestType: DSDigestTypeSHA256, Digest: "abcd"},
+	})
+
+	if cds.StableScans != 2 {
+		t.Error("Not counting the scans that found the same DS update")
+	}
+
+	if cds.IsStable(3) || !cds.IsStable(2) {
+		t.Error("Not checking correctly if the DS update is stable")
+	}
+
+	cds.ChangeStatus(CDSStatusValid, []DS{
+		{Keytag: 4321, Algorithm: DSAlgorithmRSASHA256, DigestType: DSDigestTypeSHA256, Digest: "ABCD"},
+	})
+
+	if cds.StableScans != 1 || cds.DSSet[0].Keytag != 4321 {
+		t.Error("Not restarting the count when the DS update changes")
+	}
+
+	cds.ChangeStatus(CDSStatusInconsistent, dsSet)
+
+	if cds.StableScans != 0 || cds.DSSet != nil || cds.IsStable(0) {
+		t.Error("Not discarding the DS update when the check fails")
+	}
+}
+
+func TestSameDSSet(t *testing.T) {
+	dsSet1 := []DS{
+		{Keytag: 1, Algorithm: DSAlgorithmRSASHA256, DigestType: DSDigestTypeSHA256, Digest: "AA"},
+		{Keytag: 2, Algorithm: DSAlgorithmRSASHA256, DigestType: DSDigestTypeSHA256, Digest: "BB"},
+	}
+
+	dsSet2 := []DS{
+		{Keytag: 2, Algorithm: DSAlgorithmRSASHA256, DigestType: DSDigestTypeSHA256, Digest: "bb"},
+		{Keytag: 1, Algorithm: DSAlgorithmRSASHA256, DigestType: DSDigestTypeSHA256, Digest: "aa",
+			LastStatus: DSStatusOK},
+	}
+
+	if !SameDSSet(dsSet1, dsSet2) {
+		t.Error("Not detecting equal DS sets in a different order")
+	}
+
+	if SameDSSet(dsSet1, dsSet2[:1]) {
+		t.Error("Not detecting DS sets with different sizes")
+	}
+
+	dsSet2[0].DigestType = DSDigestTypeSHA1
+	if SameDSSet(dsSet1, dsSet2) {
+		t.Error("Not detecting DS sets with different digest types")
+	}
+}
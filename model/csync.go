@@ -0,0 +1,134 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// List of flags of the CSYNC record (RFC 7477 - section 2.1.1.2)
+const (
+	CSYNCFlagImmediate  = 1 << 0 // Update can be applied without an out-of-band approval
+	CSYNCFlagSOAMinimum = 1 << 1 // Zone must have at least the SOA serial of the CSYNC record
+)
+
+// List of possible CSYNC status. The CSYNC status represents the result of the check of
+// the CSYNC record that the child zone publishes to ask for a nameserver and glue update
+// (RFC 7477)
+const (
+	CSYNCStatusNotChecked   = iota // CSYNC record not checked yet
+	CSYNCStatusNotFound            // Child zone doesn't publish a CSYNC record
+	CSYNCStatusValid               // Child zone asks for a valid nameserver update
+	CSYNCStatusUnchanged           // Child zone records are the same of the current nameservers
+	CSYNCStatusInconsistent        // Nameservers answer different records or the zone changed during the check
+	CSYNCStatusNoSignature         // Records aren't signed by a key of the current chain of trust
+	CSYNCStatusInvalid             // Record is malformed or asks for an invalid update
+	CSYNCStatusSOAMinimum          // Zone SOA serial is older than the CSYNC record serial
+	CSYNCStatusDNSError            // Couldn't retrieve the records from all nameservers
+)
+
+// CSYNCStatus is a number that represents one of the possible CSYNC status listed in the
+// constant group above
+type CSYNCStatus int
+
+// Convert the CSYNC status enum to text for printing in reports or debugging
+func CSYNCStatusToString(status CSYNCStatus) string {
+	switch status {
+	case CSYNCStatusNotChecked:
+		return "NOTCHECKED"
+	case CSYNCStatusNotFound:
+		return "NOTFOUND"
+	case CSYNCStatusValid:
+		return "VALID"
+	case CSYNCStatusUnchanged:
+		return "UNCHANGED"
+	case CSYNCStatusInconsistent:
+		return "INCONSISTENT"
+	case CSYNCStatusNoSignature:
+		return "NOSIG"
+	case CSYNCStatusInvalid:
+		return "INVALID"
+	case CSYNCStatusSOAMinimum:
+		return "SOAMIN"
+	case CSYNCStatusDNSError:
+		return "DNSERROR"
+	}
+
+	return ""
+}
+
+// CSYNC store the nameserver and glue update that the child zone asks for using the CSYNC
+// record. When the immediate flag isn't set the update is only proposed, waiting for the
+// approval of the domain's owners
+type CSYNC struct {
+	Serial      uint32       // SOA serial of the CSYNC record
+	Flags       uint16       // Flags of the CSYNC record (immediate and soaminimum)
+	Nameservers []Nameserver // Nameservers that the child zone wants in the parent zone
+	LastStatus  CSYNCStatus  // Result of the last check
+	LastCheckAt time.Time    // Time of the last check
+}
+
+// NameserverUpdate store a nameserver update applied automatically in the domain, keeping
+// an audit trail of the changes in the delegation. The owners are notified about each
+// update
+type NameserverUpdate struct {
+	OldNameservers []Nameserver // Nameservers before the update
+	NewNameservers []Nameserver // Nameservers after the update
+	AppliedAt      time.Time    // Time that the update was applied
+	NotifiedAt     time.Time    // Time that the owners were notified about the update
+}
+
+// ChangeStatus is a easy way to change the status of the CSYNC check because it also
+// updates the last check date. The nameservers are the update found in this check, and are
+// ignored when the status isn't a valid update
+func (c *CSYNC) ChangeStatus(status CSYNCStatus, serial uint32, flags uint16,
+	nameservers []Nameserver) {
+
+	c.LastStatus = status
+	c.LastCheckAt = time.Now()
+	c.Serial = serial
+	c.Flags = flags
+
+	if status != CSYNCStatusValid {
+		c.Nameservers = nil
+		return
+	}
+
+	c.Nameservers = nameservers
+}
+
+// Check if the child zone allows the update to be applied without an out-of-band approval
+func (c CSYNC) IsImmediate() bool {
+	return c.Flags&CSYNCFlagImmediate != 0
+}
+
+// Compare two nameserver lists without considering the order of the nameservers or the
+// status of the last checks. Hosts are compared ignoring the case
+func SameNameservers(nameservers1, nameservers2 []Nameserver) bool {
+	if len(nameservers1) != len(nameservers2) {
+		return false
+	}
+
+	for _, nameserver1 := range nameservers1 {
+		found := false
+		for _, nameserver2 := range nameservers2 {
+			if strings.ToLower(nameserver1.Host) == strings.ToLower(nameserver2.Host) &&
+				nameserver1.IPv4.Equal(nameserver2.IPv4) &&
+				nameserver1.IPv6.Equal(nameserver2.IPv6) {
+
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCSYNCStatusToString(t *testing.T) {
+	if CSYNCStatusToString(CSYNCStatusNotChecked) != "NOTCHECKED" {
+		t.Error("CSYNC status NOTCHECKED not converting correctly to string")
+	}
+
+	if CSYNCStatusToString(CSYNCStatusNotFound) != "NOTFOUND" {
+		t.Error("CSYNC status NOTFOUND not converting correctly to string")
+	}
+
+	if CSYNCStatusToString(CSYNCStatusValid) != "VALID" {
+		t.Error("CSYNC status VALID not converting correctly to string")
+	}
+
+	if CSYNCStatusToString(CSYNCStatusUnchanged) != "UNCHANGED" {
+		t.Error("CSYNC status UNCHANGED not converting correctly to string")
+	}
+
+	if CSYNCStatusToString(CSYNCStatusInconsistent) != "INCONSISTENT" {
+		t.Error("CSYNC status INCONSISTENT not converting correctly to string")
+	}
+
+	if CSYNCStatusToString(CSYNCStatusNoSignature) != "NOSIG" {
+		t.Error("CSYNC status NOSIG not converting correctly to string")
+	}
+
+	if CSYNCStatusToString(CSYNCStatusInvalid) != "INVALID" {
+		t.Error("CSYNC status INVALID not converting correctly to string")
+	}
+
+	if CSYNCStatusToString(CSYNCStatusSOAMinimum) != "SOAMIN" {
+		t.Error("CSYNC status SOAMIN not converting correctly to string")
+	}
+
+	if CSYNCStatusToString(CSYNCStatusDNSError) != "DNSERROR" {
+		t.Error("CSYNC status DNSERROR not converting correctly to string")
+	}
+
+	if CSYNCStatusToString(999999) != "" {
+		t.Error("Unknown CSYNC status associated to some existing status")
+	}
+}
+
+func TestCSYNCChangeStatus(t *testing.T) {
+	nameservers := []Nameserver{
+		{Host: "ns1.example.com.br.", IPv4: net.ParseIP("192.0.2.1")},
+	}
+
+	var csync CSYNC
+	csync.ChangeStatus(CSYNCStatusValid, 10, CSYNCFlagImmediate|CSYNCFlagSOAMinimum, nameservers)
+
+	if csync.Serial != 10 || !csync.IsImmediate() || len(csync.Nameservers) != 1 ||
+		csync.LastCheckAt.IsZero() {
+		t.Error("Not storing the nameserver update")
+	}
+
+	csync.ChangeStatus(CSYNCStatusNoSignature, 10, 0, nameservers)
+
+	if csync.Nameservers != nil || csync.IsImmediate() {
+		t.Error("Not discarding the nameserver update when the check fails")
+	}
+}
+
+func TestSameNameservers(t *testing.T) {
+	nameservers1 := []Nameserver{
+		{Host: "ns1.example.com.br.", IPv4: net.ParseIP("192.0.2.1")},
+		{Host: "ns2.example.net."},
+	}
+
+	nameservers2 := []Nameserver{
+		{Host: "NS2.example.net.", LastStatus: NameserverStatusOK},
+		{Host: "ns1.example.com.br.", IPv4: net.ParseIP("192.0.2.1")},
+	}
+
+	if !SameNameservers(nameservers1, nameservers2) {
+		t.Error("Not detecting equal nameservers in a different order")
+	}
+
+	if SameNameservers(nameservers1, nameservers2[:1]) {
+		t.Error("Not detecting nameserver lists with different sizes")
+	}
+
+	nameservers2[1].IPv4 = net.ParseIP("192.0.2.2")
+	if SameNameservers(nameservers1, nameservers2) {
+		t.Error("Not detecting nameservers with different glue records")
+	}
+}
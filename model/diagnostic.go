@@ -0,0 +1,24 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+// Diagnostic store the details of a problem detected in a configuration check. The status
+// only tells what kind of problem was found, the diagnostic tells where and why, so that
+// the domain's owner can fix it without reproducing the check
+type Diagnostic struct {
+	Address string   // Address (host:port) of the nameserver that received the query
+	Query   string   // Question of the query that detected the problem (name, class and type)
+	Rcode   string   // Response code of the answer, empty when there was no answer
+	Error   string   // Description of the problem or the network error message
+	Records []string // Records of the answer related to the problem, in presentation format
+}
+
+// Check if the diagnostic doesn't have any detail. Objects with status OK always have an
+// empty diagnostic
+func (d Diagnostic) Empty() bool {
+	return len(d.Address) == 0 && len(d.Query) == 0 && len(d.Rcode) == 0 &&
+		len(d.Error) == 0 && len(d.Records) == 0
+}
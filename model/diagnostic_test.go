@@ -0,0 +1,30 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"testing"
+)
+
+func TestDiagnosticEmpty(t *testing.T) {
+	if !(Diagnostic{}).Empty() {
+		t.Error("Not detecting an empty diagnostic")
+	}
+
+	diagnostics := []Diagnostic{
+		{Address: "192.0.2.1:53"},
+		{Query: "example.com.br. IN SOA"},
+		{Rcode: "SERVFAIL"},
+		{Error: "i/o timeout"},
+		{Records: []string{"example.com.br.\t86400\tIN\tCNAME\texample.net.br."}},
+	}
+
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Empty() {
+			t.Errorf("Diagnostic %#v detected as empty", diagnostic)
+		}
+	}
+}
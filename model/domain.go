@@ -7,65 +7,72 @@ package model
 
 import (
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2/bson"
-	"math"
-	"math/rand"
 	"time"
 )
 
-var (
-	// Start the random with seed only once, we are going to reuse it on every domain
-	// check to randomly select a domain to the scan or not. As we are using the current
-	// nanosecond, we have the entropy necessary to be really random
-	domainRandomSelector = rand.New(rand.NewSource(time.Now().UnixNano()))
-)
-
 // Domain stores all the necessary information for validating the DNS and DNSSEC. It also
 // stores information to alert the domain's owners about the problems
 type Domain struct {
-	Id             bson.ObjectId `bson:"_id"` // Database identification
-	Revision       int           // Version of the object
-	LastModifiedAt time.Time     // Last time the object was modified
-	FQDN           string        // Actual domain name
-	Nameservers    []Nameserver  // Nameservers that asnwer with authority for this domain
-	DSSet          []DS          // Records for the DNS tree chain of trust
-	Owners         []Owner       // Responsables for the domains that will receive alerts
+	Id                bson.ObjectId      `bson:"_id"` // Database identification
+	Revision          int                // Version of the object
+	LastModifiedAt    time.Time          // Last time the object was modified
+	FQDN              string             // Actual domain name
+	Nameservers       []Nameserver       // Nameservers that asnwer with authority for this domain
+	DSSet             []DS               // Records for the DNS tree chain of trust
+	ParentDS          ParentDS           // Comparison of the DS set with the DS records published by the parent zone
+	CDS               CDS                // DS update requested by the child zone (CDS and CDNSKEY records)
+	DSUpdates         []DSUpdate         // Audit trail of the DS updates applied automatically
+	CSYNC             CSYNC              // Nameserver update requested by the child zone (CSYNC record)
+	NameserverUpdates []NameserverUpdate // Audit trail of the nameserver updates applied automatically
+	Owners            []Owner            // Responsables for the domains that will receive alerts
+	NextCheckAt       time.Time          // When the domain should be checked again by the scan
+	Priority          float64            // Priority of the domain when it's due, the scan checks the domains with higher priority first
+	LastScanStartedAt time.Time          // Start of the last scan that checked the domain, used to resume an interrupted scan
 }
 
-// ShouldBeScanned method is responsable for telling if the domain can be scanned or not
-// using some business rules based on the last verification, nameservers and DS status and
-// DNSSEC signatures expiration date. For now this method is used by scan injector
-func (d Domain) ShouldBeScanned(maxOKVerificationDays,
-	maxErrorVerificationDays, maxExpirationAlertDays int) bool {
+// ScheduleNextCheck computes when the domain should be checked again by the scan, using
+// some business rules based on the last verification, nameservers and DS status and
+// DNSSEC signatures expiration date. The date is stored in the domain, so that the scan
+// injector can select only the domains that are due directly in the database. A domain
+// that was never checked is scheduled to the next scan
+func (d *Domain) ScheduleNextCheck(maxOKVerificationDays,
+	maxErrorVerificationDays, maxExpirationAlertDays int) {
 
-	var maxDays int
+	lastCheckAt := d.lastCheckAt()
+	if lastCheckAt.IsZero() {
+		d.NextCheckAt = time.Time{}
+		return
+	}
 
-	// When all nameservers are OK the domain has less chance to be selected for the
-	// scan, because the random range will be bigger
+	// When all nameservers are OK the domain can wait longer until the next check
+	maxDays := maxErrorVerificationDays
 	if d.allNameserversOK() && d.allDSSetOK() {
 		maxDays = maxOKVerificationDays
-	} else {
-		maxDays = maxErrorVerificationDays
 	}
 
-	// The longer the last check occurred, better are the chances to select the domain
-	// for the scan
-	daysSinceLastCheck := d.daysSinceLastCheck()
-	selectedDay := 1 + (domainRandomSelector.Int() * maxDays / math.MaxInt64)
+	d.NextCheckAt = lastCheckAt.Add(time.Duration(maxDays*24) * time.Hour)
 
-	// If the domain is configured with DNSSEC and is near the expiration date, we
-	// must check even if it's not selected by the random algorithm
-	if !d.isNearDNSSECExpirationDate(maxExpirationAlertDays) &&
-		selectedDay > daysSinceLastCheck {
-		return false
+	// If the domain is configured with DNSSEC, we must check it as soon as the signatures
+	// enter the alert period. When the domain is already in the alert period the next
+	// check date is in the past, so it will be checked in every scan
+	expiresAt := d.dnssecExpiresAt()
+	if expiresAt.IsZero() {
+		return
 	}
 
-	return true
+	alertAt := expiresAt.Add(-time.Duration(maxExpirationAlertDays*24) * time.Hour)
+	if alertAt.Before(d.NextCheckAt) {
+		d.NextCheckAt = alertAt
+	}
 }
 
-// Check if all nameservers are configured correctly with DNS
+// Check if all nameservers are configured correctly with DNS. Warnings don't break the
+// DNS resolution, so they are also accepted
 func (d Domain) allNameserversOK() bool {
 	for i := 0; i < len(d.Nameservers); i++ {
-		if d.Nameservers[i].LastStatus != NameserverStatusOK {
+		if d.Nameservers[i].LastStatus != NameserverStatusOK &&
+			!IsNameserverStatusWarning(d.Nameservers[i].LastStatus) {
+
 			return false
 		}
 	}
@@ -73,10 +80,11 @@ func (d Domain) allNameserversOK() bool {
 	return true
 }
 
-// Check if all DS set is configured correctly with DNSSEC
+// Check if all DS set is configured correctly with DNSSEC. Warnings don't break the chain
+// of trust, so they are also accepted
 func (d Domain) allDSSetOK() bool {
 	for i := 0; i < len(d.DSSet); i++ {
-		if d.DSSet[i].LastStatus != DSStatusOK {
+		if d.DSSet[i].LastStatus != DSStatusOK && !IsDSStatusWarning(d.DSSet[i].LastStatus) {
 			return false
 		}
 	}
@@ -84,10 +92,9 @@ func (d Domain) allDSSetOK() bool {
 	return true
 }
 
-// DaysSinceLastCheck returns the number of days since the last check in the nameservers
-// or in the DS records
-func (d Domain) daysSinceLastCheck() int {
-	// We need to retrieve the most recent check date from the objects of the domain
+// Return the most recent check date of the nameservers and of the DS records. When the
+// domain was never checked a zero date is returned
+func (d Domain) lastCheckAt() time.Time {
 	var lastCheckAt time.Time
 
 	// Check within the nameservers
@@ -104,38 +111,155 @@ func (d Domain) daysSinceLastCheck() int {
 		}
 	}
 
-	// Now that we have the most recent date, let's see how long it was. For better
-	// precision lets round the duration to convert it to integer
-	hours := math.Ceil(time.Since(lastCheckAt).Hours())
-	return int(hours) / int(24*time.Hour.Hours())
+	return lastCheckAt
 }
 
-// Check the DS set to see if the expiration date of the DNSKEYs signatures are near. The
-// alert period is defined by the parameter daysBefore, that is the number of days before
-// the expiration date that we will consider near
-func (d Domain) isNearDNSSECExpirationDate(daysBefore int) bool {
-	// Lets look for the oldest expiration date of the DS set, the it's probably the most
-	// problematic one
+// Return the oldest expiration date of the DNSKEYs signatures in the DS set, as it's
+// probably the most problematic one. When there's no DS or the DS records weren't checked
+// yet a zero date is returned
+func (d Domain) dnssecExpiresAt() time.Time {
 	var expiresAt time.Time
 
 	for i := 0; i < len(d.DSSet); i++ {
-		// If is the first iteration we don't compare to expiresAt, because we do compare it
-		// will be always less than any other date. And we also check if the expiration date
-		// of the DS record was initialized to avoid replacing a real date for a not
-		// initialized date
-		if expiresAt.IsZero() || (!d.DSSet[i].ExpiresAt.IsZero() &&
-			d.DSSet[i].ExpiresAt.Before(expiresAt)) {
+		// We check if the expiration date of the DS record was initialized to avoid replacing
+		// a real date for a not initialized date
+		if !d.DSSet[i].ExpiresAt.IsZero() &&
+			(expiresAt.IsZero() || d.DSSet[i].ExpiresAt.Before(expiresAt)) {
 
 			expiresAt = d.DSSet[i].ExpiresAt
 		}
 	}
 
-	// When there's no DS, we don't have an expiration date and shouldn't care about it
-	if expiresAt.IsZero() {
+	return expiresAt
+}
+
+// Replace the DS set with the update requested by the child zone (CDS and CDNSKEY
+// records), when the update was found in enough consecutive scans. The previous DS set is
+// stored in the audit trail, so that we can notify the owners and track the changes in
+// the chain of trust. Returns true if the DS set was updated
+func (d *Domain) ApplyCDS(minStableScans int) bool {
+	if !d.CDS.IsStable(minStableScans) {
 		return false
 	}
 
-	// Now we can check if this expiration date is already in the alert period. The alert
-	// period is defined by the function parameter in days
-	return time.Now().Add(time.Duration(daysBefore*24) * time.Hour).After(expiresAt)
+	var newDSSet []DS
+	for _, ds := range d.CDS.DSSet {
+		newDSSet = append(newDSSet, DS{
+			Keytag:     ds.Keytag,
+			Algorithm:  ds.Algorithm,
+			Digest:     ds.Digest,
+			DigestType: ds.DigestType,
+		})
+	}
+
+	d.DSUpdates = append(d.DSUpdates, DSUpdate{
+		OldDSSet:  d.DSSet,
+		NewDSSet:  newDSSet,
+		AppliedAt: time.Now(),
+	})
+
+	d.DSSet = newDSSet
+
+	// The update was already applied, so we start looking for a new one
+	d.CDS = CDS{}
+	return true
+}
+
+// List the DS updates applied automatically that weren't notified to the owners yet
+func (d Domain) PendingDSUpdates() []DSUpdate {
+	var updates []DSUpdate
+	for _, update := range d.DSUpdates {
+		if update.NotifiedAt.IsZero() {
+			updates = append(updates, update)
+		}
+	}
+	return updates
+}
+
+// Mark all DS updates applied automatically as notified to the owners
+func (d *Domain) NotifiedDSUpdates() {
+	now := time.Now()
+	for index := range d.DSUpdates {
+		if d.DSUpdates[index].NotifiedAt.IsZero() {
+			d.DSUpdates[index].NotifiedAt = now
+		}
+	}
+}
+
+// Replace the nameservers of the domain, keeping the results of the last checks of the
+// nameservers that were already registered. Only the glue records of these nameservers
+// are updated
+func (d *Domain) MergeNameservers(nameservers []Nameserver) {
+	merged := make([]Nameserver, len(nameservers))
+	copy(merged, nameservers)
+
+	for index, newNameserver := range merged {
+		for _, nameserver := range d.Nameservers {
+			if nameserver.Host == newNameserver.Host {
+				// Found the same nameserver in the domain object, maybe the glue records
+				// changed
+				nameserver.IPv4 = newNameserver.IPv4
+				nameserver.IPv6 = newNameserver.IPv6
+				merged[index] = nameserver
+				break
+			}
+		}
+	}
+
+	d.Nameservers = merged
+}
+
+// Replace the nameservers with the update requested by the child zone (CSYNC record), when
+// the child zone allows the update to be applied immediately. The previous nameservers
+// are stored in the audit trail, so that we can notify the owners and track the changes
+// in the delegation. Returns true if the nameservers were updated
+func (d *Domain) ApplyCSYNC() bool {
+	if d.CSYNC.LastStatus != CSYNCStatusValid || !d.CSYNC.IsImmediate() ||
+		len(d.CSYNC.Nameservers) == 0 {
+
+		return false
+	}
+
+	var newNameservers []Nameserver
+	for _, nameserver := range d.CSYNC.Nameservers {
+		newNameservers = append(newNameservers, Nameserver{
+			Host: nameserver.Host,
+			IPv4: nameserver.IPv4,
+			IPv6: nameserver.IPv6,
+		})
+	}
+
+	d.NameserverUpdates = append(d.NameserverUpdates, NameserverUpdate{
+		OldNameservers: d.Nameservers,
+		NewNameservers: newNameservers,
+		AppliedAt:      time.Now(),
+	})
+
+	d.MergeNameservers(newNameservers)
+
+	// The update was already applied, so we start looking for a new one
+	d.CSYNC = CSYNC{}
+	return true
+}
+
+// List the nameserver updates applied automatically that weren't notified to the owners
+// yet
+func (d Domain) PendingNameserverUpdates() []NameserverUpdate {
+	var updates []NameserverUpdate
+	for _, update := range d.NameserverUpdates {
+		if update.NotifiedAt.IsZero() {
+			updates = append(updates, update)
+		}
+	}
+	return updates
+}
+
+// Mark all nameserver updates applied automatically as notified to the owners
+func (d *Domain) NotifiedNameserverUpdates() {
+	now := time.Now()
+	for index := range d.NameserverUpdates {
+		if d.NameserverUpdates[index].NotifiedAt.IsZero() {
+			d.NameserverUpdates[index].NotifiedAt = now
+		}
+	}
 }
@@ -6,135 +6,103 @@
 package model
 
 import (
-	"strconv"
+	"net"
 	"testing"
 	"time"
 )
 
-func TestShouldBeScanned(t *testing.T) {
+func TestScheduleNextCheck(t *testing.T) {
 	var (
 		maxOKVerificationDays    = 7
 		maxErrorVerificationDays = 3
 		maxExpirationAlertDays   = 10
 	)
 
-	maxErrorVerificationDuration, _ :=
-		time.ParseDuration("-" + strconv.Itoa(maxErrorVerificationDays*24) + "h")
-	maxOKVerificationDuration, _ :=
-		time.ParseDuration("-" + strconv.Itoa(maxOKVerificationDays*24) + "h")
-	maxExpirationAlertDuration, _ :=
-		time.ParseDuration("-" + strconv.Itoa(maxExpirationAlertDays*24) + "h")
+	day := 24 * time.Hour
+	lastCheckAt := time.Now().Add(-day)
 
 	d := Domain{
 		Nameservers: []Nameserver{
 			{
 				LastStatus:  NameserverStatusServerFailure,
-				LastCheckAt: time.Now().Add(maxErrorVerificationDuration),
+				LastCheckAt: lastCheckAt,
 			},
 			{
 				LastStatus:  NameserverStatusOK,
-				LastCheckAt: time.Now().Add(maxErrorVerificationDuration),
+				LastCheckAt: lastCheckAt.Add(-day),
 			},
 		},
 	}
 
-	if !d.ShouldBeScanned(maxOKVerificationDays,
-		maxErrorVerificationDays, maxExpirationAlertDays) {
-
-		t.Error("Did not select a domain with DNS errors and in the limit " +
-			"of errors max verification period")
+	d.ScheduleNextCheck(maxOKVerificationDays, maxErrorVerificationDays, maxExpirationAlertDays)
+	if !d.NextCheckAt.Equal(lastCheckAt.Add(3 * day)) {
+		t.Errorf("Not scheduling a domain with DNS errors in the errors verification "+
+			"period. Got %s", d.NextCheckAt)
 	}
 
 	d = Domain{
 		DSSet: []DS{
 			{
 				LastStatus:  DSStatusTimeout,
-				LastCheckAt: time.Now().Add(maxErrorVerificationDuration),
-			},
-			{
-				LastStatus:  DSStatusTimeout,
-				LastCheckAt: time.Now().Add(maxErrorVerificationDuration),
+				LastCheckAt: lastCheckAt,
 			},
 		},
 	}
 
-	if !d.ShouldBeScanned(maxOKVerificationDays,
-		maxErrorVerificationDays, maxExpirationAlertDays) {
-
-		t.Error("Did not select a domain with DNSSEC errors and in the limit " +
-			"of errors max verification period")
+	d.ScheduleNextCheck(maxOKVerificationDays, maxErrorVerificationDays, maxExpirationAlertDays)
+	if !d.NextCheckAt.Equal(lastCheckAt.Add(3 * day)) {
+		t.Errorf("Not scheduling a domain with DNSSEC errors in the errors verification "+
+			"period. Got %s", d.NextCheckAt)
 	}
 
 	d = Domain{
 		Nameservers: []Nameserver{
 			{
 				LastStatus:  NameserverStatusOK,
-				LastCheckAt: time.Now().Add(maxOKVerificationDuration),
+				LastCheckAt: lastCheckAt,
 			},
+		},
+		DSSet: []DS{
 			{
-				LastStatus:  NameserverStatusOK,
-				LastCheckAt: time.Now().Add(maxOKVerificationDuration),
+				LastStatus:  DSStatusOK,
+				LastCheckAt: lastCheckAt,
 			},
 		},
 	}
 
-	if !d.ShouldBeScanned(maxOKVerificationDays,
-		maxErrorVerificationDays, maxExpirationAlertDays) {
-
-		t.Error("Did not select a domain configured correctly but in the limit " +
-			"of ok max verification period")
+	d.ScheduleNextCheck(maxOKVerificationDays, maxErrorVerificationDays, maxExpirationAlertDays)
+	if !d.NextCheckAt.Equal(lastCheckAt.Add(7 * day)) {
+		t.Errorf("Not scheduling a domain configured correctly in the ok verification "+
+			"period. Got %s", d.NextCheckAt)
 	}
 
-	d = Domain{
-		Nameservers: []Nameserver{
-			{
-				LastStatus:  NameserverStatusOK,
-				LastCheckAt: time.Now(),
-			},
-			{
-				LastStatus:  NameserverStatusOK,
-				LastCheckAt: time.Now(),
-			},
-		},
+	expiresAt := lastCheckAt.Add(15 * day)
+	d.DSSet[0].ExpiresAt = expiresAt
 
-		DSSet: []DS{
-			{
-				LastStatus:  DSStatusOK,
-				LastCheckAt: time.Now(),
-				ExpiresAt:   time.Now().Add(maxExpirationAlertDuration),
-			},
-			{
-				LastStatus:  DSStatusOK,
-				LastCheckAt: time.Now(),
-			},
-		},
+	d.ScheduleNextCheck(maxOKVerificationDays, maxErrorVerificationDays, maxExpirationAlertDays)
+	if !d.NextCheckAt.Equal(expiresAt.Add(-10 * day)) {
+		t.Errorf("Not scheduling a domain before the DNSSEC signatures alert period. "+
+			"Got %s", d.NextCheckAt)
 	}
 
-	if !d.ShouldBeScanned(maxOKVerificationDays,
-		maxErrorVerificationDays, maxExpirationAlertDays) {
+	d.DSSet[0].ExpiresAt = time.Now().Add(day)
 
-		t.Error("Did not select a domain with DNSSEC signatures near expiration")
+	d.ScheduleNextCheck(maxOKVerificationDays, maxErrorVerificationDays, maxExpirationAlertDays)
+	if d.NextCheckAt.After(time.Now()) {
+		t.Error("Not scheduling to the next scan a domain with DNSSEC signatures " +
+			"near expiration")
 	}
 
 	d = Domain{
+		NextCheckAt: time.Now(),
 		Nameservers: []Nameserver{
-			{
-				LastStatus:  NameserverStatusOK,
-				LastCheckAt: time.Now(),
-			},
-			{
-				LastStatus:  NameserverStatusOK,
-				LastCheckAt: time.Now(),
-			},
+			{LastStatus: NameserverStatusNotChecked},
 		},
 	}
 
-	if d.ShouldBeScanned(maxOKVerificationDays,
-		maxErrorVerificationDays, maxExpirationAlertDays) {
-
-		t.Error("Selected a domain configured correctly and checked now, " +
-			"this shouldn't happen, or this domain is very lucky, we should " +
-			"take a look in the algorithm")
+	d.ScheduleNextCheck(maxOKVerificationDays, maxErrorVerificationDays, maxExpirationAlertDays)
+	if !d.NextCheckAt.IsZero() {
+		t.Error("Not scheduling to the next scan a domain that was never checked")
 	}
 }
 
@@ -176,6 +144,12 @@ func TestAllNameserversOK(t *testing.T) {
 	if !d.allNameserversOK() {
 		t.Error("Fail to inform that all nameservers are OK")
 	}
+
+	d.Nameservers[1].LastStatus = NameserverStatusOpenRecursion
+
+	if !d.allNameserversOK() {
+		t.Error("Fail to accept nameservers with warnings")
+	}
 }
 
 func TestAllDSSetOK(t *testing.T) {
@@ -216,9 +190,15 @@ func TestAllDSSetOK(t *testing.T) {
 	if !d.allDSSetOK() {
 		t.Error("Fail to inform that all DS set are OK")
 	}
+
+	d.DSSet[1].LastStatus = DSStatusWeakKey
+
+	if !d.allDSSetOK() {
+		t.Error("Fail to accept DS records with warnings")
+	}
 }
 
-func TestDaysSinceLastCheck(t *testing.T) {
+func TestLastCheckAt(t *testing.T) {
 	twoDays, _ := time.ParseDuration("48h")
 	threeDays, _ := time.ParseDuration("72h")
 	fourDays, _ := time.ParseDuration("96h")
@@ -246,9 +226,8 @@ func TestDaysSinceLastCheck(t *testing.T) {
 		},
 	}
 
-	if days := d.daysSinceLastCheck(); days != 2 {
-		t.Errorf("Not counting correctly the number of days since last "+
-			"check for nameservers. Should be 2, but got %d", days)
+	if !d.lastCheckAt().Equal(d.Nameservers[2].LastCheckAt) {
+		t.Error("Not retrieving correctly the last check date of the nameservers")
 	}
 
 	d = Domain{
@@ -273,52 +252,189 @@ func TestDaysSinceLastCheck(t *testing.T) {
 		},
 	}
 
-	if days := d.daysSinceLastCheck(); days != 3 {
-		t.Errorf("Not counting correctly the number of days since last "+
-			"check for DS set. Should be 3, but got %d", days)
+	if !d.lastCheckAt().Equal(d.DSSet[1].LastCheckAt) {
+		t.Error("Not retrieving correctly the last check date of the DS set")
 	}
 
 	d = Domain{}
 
-	if d.daysSinceLastCheck() < 365 {
-		t.Errorf("Not counting correctly the number of days since last check " +
-			"when the object is empty")
+	if !d.lastCheckAt().IsZero() {
+		t.Error("Not retrieving correctly the last check date when the object is empty")
 	}
 }
 
-func TestNearDNSSECExpirationDate(t *testing.T) {
+func TestDNSSECExpiresAt(t *testing.T) {
 	tenDays, _ := time.ParseDuration("240h")
 	elevenDays, _ := time.ParseDuration("264h")
 
 	d := Domain{
 		DSSet: []DS{
+			{},
+			{
+				ExpiresAt: time.Now().Add(elevenDays),
+			},
 			{
 				ExpiresAt: time.Now().Add(tenDays),
 			},
 		},
 	}
 
-	if !d.isNearDNSSECExpirationDate(11) {
-		t.Error("Could not detect when the DNSSEC expiration is near")
+	if !d.dnssecExpiresAt().Equal(d.DSSet[2].ExpiresAt) {
+		t.Error("Not retrieving the oldest DNSSEC expiration date")
 	}
 
 	d = Domain{
+		DSSet: []DS{},
+	}
+
+	if !d.dnssecExpiresAt().IsZero() {
+		t.Error("Could not detect when there's no expiration date")
+	}
+}
+
+func TestApplyCDS(t *testing.T) {
+	domain := Domain{
+		FQDN: "example.com.br.",
 		DSSet: []DS{
-			{
-				ExpiresAt: time.Now().Add(elevenDays),
-			},
+			{Keytag: 1, Algorithm: DSAlgorithmRSASHA256, DigestType: DSDigestTypeSHA256,
+				Digest: "AA", LastStatus: DSStatusOK},
 		},
 	}
 
-	if d.isNearDNSSECExpirationDate(10) {
-		t.Error("Could not detect when the DNSSEC expiration is far")
+	if domain.ApplyCDS(1) {
+		t.Error("Applying a DS update that wasn't found")
 	}
 
-	d = Domain{
-		DSSet: []DS{},
+	newDSSet := []DS{
+		{Keytag: 2, Algorithm: DSAlgorithmECDSASHA256, DigestType: DSDigestTypeSHA256, Digest: "BB"},
 	}
 
-	if d.isNearDNSSECExpirationDate(10) {
-		t.Error("Could not detect when there's no expiration date")
+	domain.CDS.ChangeStatus(CDSStatusValid, newDSSet)
+
+	if domain.ApplyCDS(2) {
+		t.Error("Applying a DS update that isn't stable")
+	}
+
+	if !domain.ApplyCDS(1) {
+		t.Fatal("Not applying a stable DS update")
+	}
+
+	if len(domain.DSSet) != 1 || domain.DSSet[0].Keytag != 2 ||
+		domain.DSSet[0].LastStatus != DSStatusNotChecked {
+		t.Error("Not replacing the DS set with the DS update")
+	}
+
+	if domain.CDS.LastStatus != CDSStatusNotChecked || domain.CDS.StableScans != 0 {
+		t.Error("Not restarting the CDS check after applying the DS update")
+	}
+
+	if len(domain.DSUpdates) != 1 ||
+		domain.DSUpdates[0].OldDSSet[0].Keytag != 1 ||
+		domain.DSUpdates[0].NewDSSet[0].Keytag != 2 ||
+		domain.DSUpdates[0].AppliedAt.IsZero() {
+		t.Error("Not storing the DS update in the audit trail")
+	}
+
+	if len(domain.PendingDSUpdates()) != 1 {
+		t.Error("Not listing the DS updates that weren't notified")
+	}
+
+	domain.NotifiedDSUpdates()
+
+	if len(domain.PendingDSUpdates()) != 0 || domain.DSUpdates[0].NotifiedAt.IsZero() {
+		t.Error("Not marking the DS updates as notified")
+	}
+
+	domain.CDS.ChangeStatus(CDSStatusDelete, nil)
+
+	if !domain.ApplyCDS(1) || len(domain.DSSet) != 0 || len(domain.DSUpdates) != 2 {
+		t.Error("Not removing the DS set with the delete signal")
+	}
+}
+
+func TestMergeNameservers(t *testing.T) {
+	domain := Domain{
+		FQDN: "example.com.br.",
+		Nameservers: []Nameserver{
+			{Host: "ns1.example.com.br.", IPv4: net.ParseIP("192.0.2.1"), LastStatus: NameserverStatusOK},
+			{Host: "ns2.example.net.", LastStatus: NameserverStatusTimeout},
+		},
+	}
+
+	domain.MergeNameservers([]Nameserver{
+		{Host: "ns1.example.com.br.", IPv4: net.ParseIP("192.0.2.10")},
+		{Host: "ns3.example.net."},
+	})
+
+	if len(domain.Nameservers) != 2 {
+		t.Fatal("Not replacing the nameservers")
+	}
+
+	if domain.Nameservers[0].LastStatus != NameserverStatusOK ||
+		!domain.Nameservers[0].IPv4.Equal(net.ParseIP("192.0.2.10")) {
+		t.Error("Not keeping the last check of an existing nameserver with the new glue")
+	}
+
+	if domain.Nameservers[1].Host != "ns3.example.net." ||
+		domain.Nameservers[1].LastStatus != NameserverStatusNotChecked {
+		t.Error("Not adding the new nameserver")
+	}
+}
+
+func TestApplyCSYNC(t *testing.T) {
+	domain := Domain{
+		FQDN: "example.com.br.",
+		Nameservers: []Nameserver{
+			{Host: "ns1.example.com.br.", IPv4: net.ParseIP("192.0.2.1"), LastStatus: NameserverStatusOK},
+		},
+	}
+
+	if domain.ApplyCSYNC() {
+		t.Error("Applying a nameserver update that wasn't found")
+	}
+
+	newNameservers := []Nameserver{
+		{Host: "ns1.example.com.br.", IPv4: net.ParseIP("192.0.2.10")},
+		{Host: "ns2.example.net."},
+	}
+
+	domain.CSYNC.ChangeStatus(CSYNCStatusValid, 2015010101, 0, newNameservers)
+
+	if domain.ApplyCSYNC() {
+		t.Error("Applying a nameserver update without the immediate flag")
+	}
+
+	domain.CSYNC.ChangeStatus(CSYNCStatusValid, 2015010101, CSYNCFlagImmediate, newNameservers)
+
+	if !domain.ApplyCSYNC() {
+		t.Fatal("Not applying a nameserver update with the immediate flag")
+	}
+
+	if len(domain.Nameservers) != 2 ||
+		!domain.Nameservers[0].IPv4.Equal(net.ParseIP("192.0.2.10")) ||
+		domain.Nameservers[0].LastStatus != NameserverStatusOK {
+		t.Error("Not merging the nameserver update")
+	}
+
+	if domain.CSYNC.LastStatus != CSYNCStatusNotChecked {
+		t.Error("Not restarting the CSYNC check after applying the nameserver update")
+	}
+
+	if len(domain.NameserverUpdates) != 1 ||
+		!domain.NameserverUpdates[0].OldNameservers[0].IPv4.Equal(net.ParseIP("192.0.2.1")) ||
+		len(domain.NameserverUpdates[0].NewNameservers) != 2 ||
+		domain.NameserverUpdates[0].AppliedAt.IsZero() {
+		t.Error("Not storing the nameserver update in the audit trail")
+	}
+
+	if len(domain.PendingNameserverUpdates()) != 1 {
+		t.Error("Not listing the nameserver updates that weren't notified")
+	}
+
+	domain.NotifiedNameserverUpdates()
+
+	if len(domain.PendingNameserverUpdates()) != 0 ||
+		domain.NameserverUpdates[0].NotifiedAt.IsZero() {
+		t.Error("Not marking the nameserver updates as notified")
 	}
 }
@@ -58,6 +58,23 @@ func IsValidDSAlgorithm(algorithm uint8) bool {
 	}
 }
 
+// Algorithms that must not or are not recommended to be used for DNSSEC signing anymore,
+// according to RFC 8624 - section 3.1. This is used to alert the domain's owners that
+// are still using weak cryptography
+func IsDeprecatedDSAlgorithm(algorithm DSAlgorithm) bool {
+	switch algorithm {
+	case DSAlgorithmRSAMD5,
+		DSAlgorithmDSASHA1,
+		DSAlgorithmDSASHA1NSEC3,
+		DSAlgorithmRSASHA1,
+		DSAlgorithmRSASHA1NSEC3,
+		DSAlgorithmECCGOST:
+		return true
+	}
+
+	return false
+}
+
 // List of possible digest types according to RFCs 3658, 4034, 4035
 const (
 	DSDigestTypeReserved DSDigestType = 0
@@ -91,17 +108,46 @@ func IsValidDSDigestType(digestType uint8) bool {
 	}
 }
 
+// Digest types that must not be used for generating DS records anymore, according to
+// RFC 8624 - section 3.3
+func IsDeprecatedDSDigestType(digestType DSDigestType) bool {
+	switch digestType {
+	case DSDigestTypeSHA1,
+		DSDigestTypeGOST94:
+		return true
+	}
+
+	return false
+}
+
 // List of possible DS status
 const (
-	DSStatusNotChecked       = iota // DS record not checked yet
-	DSStatusOK                      // DNSSEC configuration for this DS is OK
-	DSStatusTimeout                 // Network timeout while trying to retrieve the DNSKEY
-	DSStatusNoSignature             // No RRSIG records found for the related DNSKEY
-	DSStatusExpiredSignature        // At least one RRSIG record was expired
-	DSStatusNoKey                   // No DNSKEY was found with the keytag of the DS
-	DSStatusNoSEP                   // DNSKEY related to DS does not have the bit SEP on
-	DSStatusSignatureError          // Error while checking DNSKEY signatures
-	DSStatusDNSError                // DNS error (check nameserver status)
+	DSStatusNotChecked            = iota // DS record not checked yet
+	DSStatusOK                           // DNSSEC configuration for this DS is OK
+	DSStatusTimeout                      // Network timeout while trying to retrieve the DNSKEY
+	DSStatusNoSignature                  // No RRSIG records found for the related DNSKEY
+	DSStatusExpiredSignature             // At least one RRSIG record was expired
+	DSStatusNoKey                        // No DNSKEY was found with the keytag of the DS
+	DSStatusNoSEP                        // DNSKEY related to DS does not have the bit SEP on
+	DSStatusSignatureError               // Error while checking DNSKEY signatures
+	DSStatusDNSError                     // DNS error (check nameserver status)
+	DSStatusNotPublished                 // DS record registered but not published in the parent zone
+	DSStatusNotRegistered                // Parent zone publishes DS records that are not registered
+	DSStatusNoDenialProof                // Negative answer without NSEC or NSEC3 records
+	DSStatusDenialProofError             // NSEC or NSEC3 records don't prove that the name doesn't exist
+	DSStatusDenialSignatureError         // NSEC or NSEC3 records without a valid signature
+	DSStatusNSEC3Parameters              // Warning: NSEC3 extra iterations or salt not recommended (RFC 9276)
+	DSStatusAlgorithmMismatch            // DS algorithm is different from the related DNSKEY algorithm
+	DSStatusNoAlgorithmSignature         // Zone is not signed with all algorithms of the DS set
+	DSStatusDeprecatedAlgorithm          // Warning: DS algorithm deprecated (RFC 8624)
+	DSStatusDeprecatedDigestType         // Warning: DS digest type deprecated (RFC 8624)
+	DSStatusWeakKey                      // Warning: RSA DNSKEY related to DS is too small
+	DSStatusFragmentation                // DNSKEY responses above a size are lost (fragmentation or path MTU)
+	DSStatusRRSetSignatureError          // SOA or NS RRset without a valid signature
+	DSStatusRRSetExpiredSignature        // SOA or NS RRset signature expired
+	DSStatusSignatureTTL                 // Warning: RRset TTL longer than the remaining signature validity
+	DSStatusDNSKEYInconsistent           // Warning: nameservers answer different DNSKEY RRsets
+	DSStatusNSEC3Iterations              // NSEC3 iterations above the limit of the validators (RFC 9276)
 )
 
 // DSStatus is a number that represents one of the possible DS status listed in the
@@ -129,24 +175,88 @@ func DSStatusToString(status DSStatus) string {
 		return "SIGERR"
 	case DSStatusDNSError:
 		return "DNSERR"
+	case DSStatusNotPublished:
+		return "NOTPUB"
+	case DSStatusNotRegistered:
+		return "NOTREG"
+	case DSStatusNoDenialProof:
+		return "NODENIAL"
+	case DSStatusDenialProofError:
+		return "DENIALERR"
+	case DSStatusDenialSignatureError:
+		return "DENIALSIGERR"
+	case DSStatusNSEC3Parameters:
+		return "NSEC3PARAM"
+	case DSStatusAlgorithmMismatch:
+		return "ALGERR"
+	case DSStatusNoAlgorithmSignature:
+		return "NOALGSIG"
+	case DSStatusDeprecatedAlgorithm:
+		return "DEPALG"
+	case DSStatusDeprecatedDigestType:
+		return "DEPDIGEST"
+	case DSStatusWeakKey:
+		return "WEAKKEY"
+	case DSStatusFragmentation:
+		return "FRAGMENT"
+	case DSStatusRRSetSignatureError:
+		return "RRSETSIGERR"
+	case DSStatusRRSetExpiredSignature:
+		return "RRSETEXPSIG"
+	case DSStatusSignatureTTL:
+		return "SIGTTL"
+	case DSStatusDNSKEYInconsistent:
+		return "KEYDIFF"
+	case DSStatusNSEC3Iterations:
+		return "NSEC3ITER"
 	}
 
 	return ""
 }
 
+// Warning status are problems that don't break the DNSSEC chain of trust, but that the
+// domain's owner should fix. A DS with a warning status is still considered OK
+func IsDSStatusWarning(status DSStatus) bool {
+	switch status {
+	case DSStatusDeprecatedAlgorithm,
+		DSStatusDeprecatedDigestType,
+		DSStatusWeakKey,
+		DSStatusSignatureTTL,
+		DSStatusDNSKEYInconsistent,
+		DSStatusNSEC3Parameters:
+		return true
+	}
+
+	return false
+}
+
 // DS store the information necessary to validate if a domain is configured correctly with
 // DNSSEC, and it also stores the results of the validations. When the hosts have multiple
 // DNSSEC problems, the worst problem (using a priority algorithm) will be stored in the
 // DS
 type DS struct {
-	Keytag      uint16       // DNSKEY's identification number
-	Algorithm   DSAlgorithm  // DNSKEY's algorithm
-	Digest      string       // Hash of the DNSKEY content
-	DigestType  DSDigestType // Hash type decided by user when generating the DS
-	ExpiresAt   time.Time    // DNSKEY's signature expiration date
-	LastStatus  DSStatus     // Result of the last configuration check
-	LastCheckAt time.Time    // Time of the last configuration check
-	LastOKAt    time.Time    // Last time that the DNSSEC configuration was OK
+	Keytag      uint16         // DNSKEY's identification number
+	Algorithm   DSAlgorithm    // DNSKEY's algorithm
+	Digest      string         // Hash of the DNSKEY content
+	DigestType  DSDigestType   // Hash type decided by user when generating the DS
+	ExpiresAt   time.Time      // DNSKEY's signature expiration date
+	Nameservers []DSNameserver // Result of the last configuration check in each nameserver
+	Findings    []Finding      // Problems detected in the last check, with their severities
+	Diagnostic  Diagnostic     // Details of the problem of the nameserver with the worst result
+	LastStatus  DSStatus       // Result of the last configuration check
+	LastCheckAt time.Time      // Time of the last configuration check
+	LastOKAt    time.Time      // Last time that the DNSSEC configuration was OK
+}
+
+// DSNameserver store the result of the DNSSEC configuration check of the DS in one of the
+// nameservers of the domain. The nameservers can answer different versions of the zone,
+// so each one of them can have a different result
+type DSNameserver struct {
+	Host        string     // Nameserver's name
+	ExpiresAt   time.Time  // DNSKEY's signature expiration date found in the nameserver
+	Diagnostic  Diagnostic // Details of the problem detected in the nameserver
+	LastStatus  DSStatus   // Result of the last configuration check
+	LastCheckAt time.Time  // Time of the last configuration check
 }
 
 // ChangeStatus is a easy way to change the status of a DS because it also updates the
@@ -155,7 +265,36 @@ func (d *DS) ChangeStatus(status DSStatus) {
 	d.LastStatus = status
 	d.LastCheckAt = time.Now()
 
-	if status == DSStatusOK {
+	if status == DSStatusOK || IsDSStatusWarning(status) {
 		d.LastOKAt = d.LastCheckAt
 	}
 }
+
+// ChangeNameserverStatus store the result of the configuration check in one of the
+// nameservers, replacing the last result of the same nameserver. The summary status of the
+// DS isn't changed, as it depends on the results of all nameservers. The diagnostic is
+// only stored when the nameserver has a problem
+func (d *DS) ChangeNameserverStatus(host string, status DSStatus, expiresAt time.Time,
+	diagnostic Diagnostic) {
+
+	if status == DSStatusOK {
+		diagnostic = Diagnostic{}
+	}
+
+	result := DSNameserver{
+		Host:        host,
+		ExpiresAt:   expiresAt,
+		Diagnostic:  diagnostic,
+		LastStatus:  status,
+		LastCheckAt: time.Now(),
+	}
+
+	for index, nameserver := range d.Nameservers {
+		if nameserver.Host == host {
+			d.Nameservers[index] = result
+			return
+		}
+	}
+
+	d.Nameservers = append(d.Nameservers, result)
+}
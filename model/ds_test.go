@@ -33,6 +33,57 @@ func TestDSChangeStatus(t *testing.T) {
 	}
 }
 
+func TestDSChangeNameserverStatus(t *testing.T) {
+	ds := DS{
+		LastStatus: DSStatusOK,
+	}
+
+	ds.ChangeNameserverStatus("ns1.example.com.br.", DSStatusOK, time.Time{}, Diagnostic{
+		Error: "Old problem",
+	})
+	ds.ChangeNameserverStatus("ns2.example.com.br.", DSStatusTimeout, time.Time{}, Diagnostic{
+		Address: "192.0.2.2:53",
+		Error:   "i/o timeout",
+	})
+	ds.ChangeNameserverStatus("ns1.example.com.br.", DSStatusNoKey, time.Time{}, Diagnostic{
+		Error: "No DNSKEY with keytag 41674",
+	})
+
+	if len(ds.Nameservers) != 2 {
+		t.Fatalf("Not replacing the result of the same nameserver. Expected 2 results "+
+			"and got %d", len(ds.Nameservers))
+	}
+
+	if ds.Nameservers[0].Host != "ns1.example.com.br." ||
+		ds.Nameservers[0].LastStatus != DSStatusNoKey ||
+		ds.Nameservers[0].LastCheckAt.IsZero() {
+
+		t.Error("Not storing the last result of the nameserver")
+	}
+
+	if ds.Nameservers[0].Diagnostic.Error != "No DNSKEY with keytag 41674" {
+		t.Error("Not storing the diagnostic of the nameserver")
+	}
+
+	if ds.Nameservers[1].LastStatus != DSStatusTimeout ||
+		ds.Nameservers[1].Diagnostic.Address != "192.0.2.2:53" {
+
+		t.Error("Not keeping the result of other nameservers")
+	}
+
+	ds.ChangeNameserverStatus("ns2.example.com.br.", DSStatusOK, time.Time{}, Diagnostic{
+		Error: "Old problem",
+	})
+
+	if !ds.Nameservers[1].Diagnostic.Empty() {
+		t.Error("Storing a diagnostic for a nameserver without problems")
+	}
+
+	if ds.LastStatus != DSStatusOK || !ds.LastCheckAt.IsZero() {
+		t.Error("Changing the summary status when storing a nameserver result")
+	}
+}
+
 func TestDSStatusToString(t *testing.T) {
 	if DSStatusToString(DSStatusNotChecked) != "NOTCHECKED" {
 		t.Error("DS status NOTCHECKED not converting correctly to string")
@@ -70,6 +121,74 @@ func TestDSStatusToString(t *testing.T) {
 		t.Error("DS status DNSERR not converting correctly to string")
 	}
 
+	if DSStatusToString(DSStatusNotPublished) != "NOTPUB" {
+		t.Error("DS status NOTPUB not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusNotRegistered) != "NOTREG" {
+		t.Error("DS status NOTREG not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusNoDenialProof) != "NODENIAL" {
+		t.Error("DS status NODENIAL not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusDenialProofError) != "DENIALERR" {
+		t.Error("DS status DENIALERR not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusDenialSignatureError) != "DENIALSIGERR" {
+		t.Error("DS status DENIALSIGERR not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusNSEC3Parameters) != "NSEC3PARAM" {
+		t.Error("DS status NSEC3PARAM not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusAlgorithmMismatch) != "ALGERR" {
+		t.Error("DS status ALGERR not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusNoAlgorithmSignature) != "NOALGSIG" {
+		t.Error("DS status NOALGSIG not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusDeprecatedAlgorithm) != "DEPALG" {
+		t.Error("DS status DEPALG not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusDeprecatedDigestType) != "DEPDIGEST" {
+		t.Error("DS status DEPDIGEST not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusWeakKey) != "WEAKKEY" {
+		t.Error("DS status WEAKKEY not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusFragmentation) != "FRAGMENT" {
+		t.Error("DS status FRAGMENT not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusRRSetSignatureError) != "RRSETSIGERR" {
+		t.Error("DS status RRSETSIGERR not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusRRSetExpiredSignature) != "RRSETEXPSIG" {
+		t.Error("DS status RRSETEXPSIG not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusSignatureTTL) != "SIGTTL" {
+		t.Error("DS status SIGTTL not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusDNSKEYInconsistent) != "KEYDIFF" {
+		t.Error("DS status KEYDIFF not converting correctly to string")
+	}
+
+	if DSStatusToString(DSStatusNSEC3Iterations) != "NSEC3ITER" {
+		t.Error("DS status NSEC3ITER not converting correctly to string")
+	}
+
 	if DSStatusToString(999999) != "" {
 		t.Error("Unknown DS status associated to some existing status")
 	}
@@ -94,3 +213,42 @@ func TestValidDSDigestType(t *testing.T) {
 		t.Error("Accepting invalid digest type")
 	}
 }
+
+func TestDSChangeStatusWarning(t *testing.T) {
+	ds := DS{
+		LastStatus: DSStatusDNSError,
+	}
+
+	ds.ChangeStatus(DSStatusWeakKey)
+
+	if ds.LastOKAt.IsZero() || !ds.LastOKAt.Equal(ds.LastCheckAt) {
+		t.Error("ChangeStatus method did not update the last OK date for a warning status")
+	}
+
+	if !IsDSStatusWarning(DSStatusDeprecatedAlgorithm) || !IsDSStatusWarning(DSStatusSignatureTTL) ||
+		!IsDSStatusWarning(DSStatusNSEC3Parameters) || IsDSStatusWarning(DSStatusNSEC3Iterations) ||
+		IsDSStatusWarning(DSStatusNoKey) || IsDSStatusWarning(DSStatusOK) {
+		t.Error("Not identifying warning DS status correctly")
+	}
+}
+
+func TestDeprecatedDSAlgorithm(t *testing.T) {
+	if !IsDeprecatedDSAlgorithm(DSAlgorithmRSAMD5) || !IsDeprecatedDSAlgorithm(DSAlgorithmDSASHA1) ||
+		!IsDeprecatedDSAlgorithm(DSAlgorithmECCGOST) || !IsDeprecatedDSAlgorithm(DSAlgorithmRSASHA1) {
+		t.Error("Not detecting deprecated DS algorithms")
+	}
+
+	if IsDeprecatedDSAlgorithm(DSAlgorithmRSASHA256) || IsDeprecatedDSAlgorithm(DSAlgorithmECDSASHA256) {
+		t.Error("Detecting recommended DS algorithms as deprecated")
+	}
+}
+
+func TestDeprecatedDSDigestType(t *testing.T) {
+	if !IsDeprecatedDSDigestType(DSDigestTypeSHA1) || !IsDeprecatedDSDigestType(DSDigestTypeGOST94) {
+		t.Error("Not detecting deprecated DS digest types")
+	}
+
+	if IsDeprecatedDSDigestType(DSDigestTypeSHA256) || IsDeprecatedDSDigestType(DSDigestTypeSHA384) {
+		t.Error("Detecting recommended DS digest types as deprecated")
+	}
+}
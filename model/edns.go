@@ -0,0 +1,104 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"time"
+)
+
+// List of possible EDNS compliance probes, based on the ISC EDNS compliance tests
+const (
+	EDNSProbeVersion    = iota // Query with an unknown EDNS version
+	EDNSProbeOption            // Query with an EDNS option that the nameserver doesn't know
+	EDNSProbeFlag              // Query with an unknown EDNS flag
+	EDNSProbeDO                // Query with the DO bit on
+	EDNSProbeTruncation        // Query with a small UDP size to check the truncation behavior
+)
+
+// EDNSProbe is a number that represents one of the possible EDNS compliance probes listed
+// in the constant group above
+type EDNSProbe int
+
+// Convert the EDNS probe enum to text for printing in reports or debugging
+func EDNSProbeToString(probe EDNSProbe) string {
+	switch probe {
+	case EDNSProbeVersion:
+		return "VERSION"
+	case EDNSProbeOption:
+		return "OPTION"
+	case EDNSProbeFlag:
+		return "FLAG"
+	case EDNSProbeDO:
+		return "DO"
+	case EDNSProbeTruncation:
+		return "TRUNCATION"
+	}
+
+	return ""
+}
+
+// List of possible EDNS compliance status
+const (
+	EDNSStatusNotChecked = iota // EDNS probe not checked yet
+	EDNSStatusOK                // Nameserver answered the EDNS probe correctly
+	EDNSStatusTimeout           // Network timeout while sending the EDNS probe
+	EDNSStatusNoOPT             // Response without the OPT record
+	EDNSStatusRcode             // Unexpected return code for the EDNS probe
+	EDNSStatusVersion           // Response with an unexpected EDNS version
+	EDNSStatusFlags             // Unknown EDNS flags copied to the response
+	EDNSStatusOption            // Unknown EDNS option copied to the response
+	EDNSStatusDO                // DO bit wasn't copied to the response
+	EDNSStatusTruncation        // Response bigger than the UDP size without the TC flag
+	EDNSStatusError             // Generic error found while sending the EDNS probe
+)
+
+// EDNSStatus is a number that represents one of the possible EDNS compliance status listed
+// in the constant group above
+type EDNSStatus int
+
+// Convert the EDNS status enum to text for printing in reports or debugging
+func EDNSStatusToString(status EDNSStatus) string {
+	switch status {
+	case EDNSStatusNotChecked:
+		return "NOTCHECKED"
+	case EDNSStatusOK:
+		return "OK"
+	case EDNSStatusTimeout:
+		return "TIMEOUT"
+	case EDNSStatusNoOPT:
+		return "NOOPT"
+	case EDNSStatusRcode:
+		return "RCODE"
+	case EDNSStatusVersion:
+		return "VERSION"
+	case EDNSStatusFlags:
+		return "FLAGS"
+	case EDNSStatusOption:
+		return "OPTION"
+	case EDNSStatusDO:
+		return "DOBIT"
+	case EDNSStatusTruncation:
+		return "TRUNCATION"
+	case EDNSStatusError:
+		return "ERROR"
+	}
+
+	return ""
+}
+
+// EDNSTest store the result of an EDNS compliance probe sent to a nameserver
+type EDNSTest struct {
+	Probe       EDNSProbe  // EDNS compliance probe sent to the nameserver
+	LastStatus  EDNSStatus // Result of the last EDNS compliance probe
+	LastCheckAt time.Time  // Time of the last EDNS compliance probe
+}
+
+// ChangeStatus is a easy way to change the status of an EDNS test because it also updates
+// the last check date
+func (e *EDNSTest) ChangeStatus(status EDNSStatus) {
+	e.LastStatus = status
+	e.LastCheckAt = time.Now()
+}
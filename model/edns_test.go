@@ -0,0 +1,103 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"testing"
+)
+
+func TestEDNSProbeToString(t *testing.T) {
+	if EDNSProbeToString(EDNSProbeVersion) != "VERSION" {
+		t.Error("EDNS probe VERSION not converting correctly to string")
+	}
+
+	if EDNSProbeToString(EDNSProbeOption) != "OPTION" {
+		t.Error("EDNS probe OPTION not converting correctly to string")
+	}
+
+	if EDNSProbeToString(EDNSProbeFlag) != "FLAG" {
+		t.Error("EDNS probe FLAG not converting correctly to string")
+	}
+
+	if EDNSProbeToString(EDNSProbeDO) != "DO" {
+		t.Error("EDNS probe DO not converting correctly to string")
+	}
+
+	if EDNSProbeToString(EDNSProbeTruncation) != "TRUNCATION" {
+		t.Error("EDNS probe TRUNCATION not converting correctly to string")
+	}
+
+	if EDNSProbeToString(999999) != "" {
+		t.Error("Unknown EDNS probe associated to some existing probe")
+	}
+}
+
+func TestEDNSStatusToString(t *testing.T) {
+	if EDNSStatusToString(EDNSStatusNotChecked) != "NOTCHECKED" {
+		t.Error("EDNS status NOTCHECKED not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusOK) != "OK" {
+		t.Error("EDNS status OK not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusTimeout) != "TIMEOUT" {
+		t.Error("EDNS status TIMEOUT not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusNoOPT) != "NOOPT" {
+		t.Error("EDNS status NOOPT not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusRcode) != "RCODE" {
+		t.Error("EDNS status RCODE not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusVersion) != "VERSION" {
+		t.Error("EDNS status VERSION not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusFlags) != "FLAGS" {
+		t.Error("EDNS status FLAGS not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusOption) != "OPTION" {
+		t.Error("EDNS status OPTION not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusDO) != "DOBIT" {
+		t.Error("EDNS status DOBIT not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusTruncation) != "TRUNCATION" {
+		t.Error("EDNS status TRUNCATION not converting correctly to string")
+	}
+
+	if EDNSStatusToString(EDNSStatusError) != "ERROR" {
+		t.Error("EDNS status ERROR not converting correctly to string")
+	}
+
+	if EDNSStatusToString(999999) != "" {
+		t.Error("Unknown EDNS status associated to some existing status")
+	}
+}
+
+func TestEDNSTestChangeStatus(t *testing.T) {
+	test := EDNSTest{
+		Probe:      EDNSProbeDO,
+		LastStatus: EDNSStatusNotChecked,
+	}
+
+	test.ChangeStatus(EDNSStatusDO)
+
+	if test.LastStatus != EDNSStatusDO {
+		t.Error("ChangeStatus method did not change EDNS test attribute")
+	}
+
+	if test.LastCheckAt.IsZero() {
+		t.Error("ChangeStatus method did not update the last check date")
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// List of possible severities of a finding, from the less to the most important one
+const (
+	SeverityInfo    Severity = iota // Informational, nothing needs to be done now
+	SeverityWarning                 // Works, but the domain's owner should fix it
+	SeverityError                   // Breaks the DNS or DNSSEC resolution of the domain
+)
+
+// Severity is a number that represents one of the possible severities listed in the
+// constant group above
+type Severity int
+
+// Convert the severity enum to text for printing in reports or debugging
+func SeverityToString(severity Severity) string {
+	switch severity {
+	case SeverityInfo:
+		return "INFO"
+	case SeverityWarning:
+		return "WARNING"
+	case SeverityError:
+		return "ERROR"
+	}
+
+	return ""
+}
+
+// Convert the severity from text into enum. The text is case insensitive and spaces
+// around it are ignored. Returns false when the text isn't a known severity
+func SeverityFromString(value string) (Severity, bool) {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "INFO":
+		return SeverityInfo, true
+	case "WARNING":
+		return SeverityWarning, true
+	case "ERROR":
+		return SeverityError, true
+	}
+
+	return SeverityInfo, false
+}
+
+// Finding store a problem, or just something interesting, detected in the last
+// configuration check of a nameserver or DS record. Different from the status, that
+// stores only the worst problem, an object can have many findings
+type Finding struct {
+	Code        string            // Identification of the finding (e.g. NOKEY, NEAREXP)
+	Severity    Severity          // How important is the finding
+	Parameters  map[string]string // Details to describe the finding to the domain's owner
+	FirstSeenAt time.Time         // First time that the finding was detected
+}
+
+// Retrieve the most important severity of a list of findings. Returns false when there's
+// no finding in the list
+func MaxSeverity(findings []Finding) (Severity, bool) {
+	if len(findings) == 0 {
+		return SeverityInfo, false
+	}
+
+	severity := findings[0].Severity
+	for _, finding := range findings[1:] {
+		if finding.Severity > severity {
+			severity = finding.Severity
+		}
+	}
+
+	return severity, true
+}
+
+// Keep the first detection date of the findings that were already detected in the last
+// configuration check, so that we can know for how long the problem exists. Useful to
+// merge the findings of a check that was executed in a copy of a stored object
+func KeepFirstSeen(oldFindings, newFindings []Finding) []Finding {
+	now := time.Now()
+
+	for index, finding := range newFindings {
+		newFindings[index].FirstSeenAt = now
+
+		for _, oldFinding := range oldFindings {
+			if oldFinding.Code == finding.Code && !oldFinding.FirstSeenAt.IsZero() {
+				newFindings[index].FirstSeenAt = oldFinding.FirstSeenAt
+				break
+			}
+		}
+	}
+
+	return newFindings
+}
+
+// Build the findings of the nameserver from the results of the last configuration check.
+// Besides the status, each warning detected in the check is a finding, as the status
+// stores only the worst problem
+func (n *Nameserver) UpdateFindings() {
+	var findings []Finding
+
+	if n.LastStatus != NameserverStatusOK && n.LastStatus != NameserverStatusNotChecked {
+		finding := n.statusFinding(n.LastStatus)
+
+		var addresses []string
+		for _, address := range n.FailedAddresses() {
+			addresses = append(addresses, address.IP.String())
+		}
+
+		if len(addresses) > 0 {
+			finding.Parameters["addresses"] = strings.Join(addresses, ", ")
+		}
+
+		findings = append(findings, finding)
+	}
+
+	for _, warning := range n.Warnings {
+		duplicated := false
+		for _, finding := range findings {
+			if finding.Code == NameserverStatusToString(warning) {
+				duplicated = true
+				break
+			}
+		}
+
+		if !duplicated {
+			findings = append(findings, n.statusFinding(warning))
+		}
+	}
+
+	// The nameserver is behind the other nameservers, but not for long enough to be
+	// considered not synchronized. Probably a zone transfer in progress
+	if !n.SOASerialBehindSince.IsZero() && n.LastStatus != NameserverStatusNotSynchronized {
+		findings = append(findings, Finding{
+			Code:     "SERIALBEHIND",
+			Severity: SeverityInfo,
+			Parameters: map[string]string{
+				"serial": fmt.Sprintf("%d", n.SOASerial),
+				"since":  n.SOASerialBehindSince.UTC().Format(time.RFC3339),
+			},
+		})
+	}
+
+	n.Findings = KeepFirstSeen(n.Findings, findings)
+}
+
+// Build the finding of a status of the nameserver, with the details that describe the
+// problem
+func (n Nameserver) statusFinding(status NameserverStatus) Finding {
+	finding := Finding{
+		Code:       NameserverStatusToString(status),
+		Severity:   SeverityError,
+		Parameters: make(map[string]string),
+	}
+
+	if IsNameserverStatusWarning(status) {
+		finding.Severity = SeverityWarning
+	}
+
+	switch status {
+	case NameserverStatusSlow:
+		finding.Parameters["rtt"] = n.LastRTT.String()
+	case NameserverStatusNotSynchronized:
+		finding.Parameters["serial"] = fmt.Sprintf("%d", n.SOASerial)
+	}
+
+	return finding
+}
+
+// Build the findings of the DS record from the results of the last configuration check.
+// The signatures that expire in less than maxExpirationAlertDays are reported as near
+// expiration
+func (d *DS) UpdateFindings(maxExpirationAlertDays int) {
+	var findings []Finding
+
+	if d.LastStatus != DSStatusOK && d.LastStatus != DSStatusNotChecked {
+		finding := Finding{
+			Code:       DSStatusToString(d.LastStatus),
+			Severity:   SeverityError,
+			Parameters: make(map[string]string),
+		}
+
+		if IsDSStatusWarning(d.LastStatus) {
+			finding.Severity = SeverityWarning
+		}
+
+		var hosts []string
+		for _, nameserver := range d.Nameservers {
+			if nameserver.LastStatus == d.LastStatus {
+				hosts = append(hosts, nameserver.Host)
+			}
+		}
+
+		if len(hosts) > 0 {
+			finding.Parameters["nameservers"] = strings.Join(hosts, ", ")
+		}
+
+		findings = append(findings, finding)
+	}
+
+	// The DS status stores only the worst problem, so weak cryptography could be hidden by
+	// other problems
+	if IsDeprecatedDSAlgorithm(d.Algorithm) && d.LastStatus != DSStatusDeprecatedAlgorithm {
+		findings = append(findings, Finding{
+			Code:     DSStatusToString(DSStatusDeprecatedAlgorithm),
+			Severity: SeverityWarning,
+			Parameters: map[string]string{
+				"algorithm": fmt.Sprintf("%d", d.Algorithm),
+			},
+		})
+	}
+
+	if IsDeprecatedDSDigestType(d.DigestType) && d.LastStatus != DSStatusDeprecatedDigestType {
+		findings = append(findings, Finding{
+			Code:     DSStatusToString(DSStatusDeprecatedDigestType),
+			Severity: SeverityWarning,
+			Parameters: map[string]string{
+				"digestType": fmt.Sprintf("%d", d.DigestType),
+			},
+		})
+	}
+
+	expirationAlert := time.Now().Add(time.Duration(maxExpirationAlertDays*24) * time.Hour)
+	if !d.ExpiresAt.IsZero() && d.ExpiresAt.Before(expirationAlert) &&
+		d.LastStatus != DSStatusExpiredSignature {
+
+		findings = append(findings, Finding{
+			Code:     "NEAREXP",
+			Severity: SeverityWarning,
+			Parameters: map[string]string{
+				"expiresAt": d.ExpiresAt.UTC().Format(time.RFC3339),
+			},
+		})
+	}
+
+	d.Findings = KeepFirstSeen(d.Findings, findings)
+}
+
+// Build the findings of all nameservers and DS records of the domain. Should be called
+// after each configuration check of the domain
+func (d *Domain) UpdateFindings(maxExpirationAlertDays int) {
+	for index := range d.Nameservers {
+		d.Nameservers[index].UpdateFindings()
+	}
+
+	for index := range d.DSSet {
+		d.DSSet[index].UpdateFindings(maxExpirationAlertDays)
+	}
+}
+
+// List all findings of the nameservers and DS records of the domain
+func (d Domain) Findings() []Finding {
+	var findings []Finding
+	for _, nameserver := range d.Nameservers {
+		findings = append(findings, nameserver.Findings...)
+	}
+
+	for _, ds := range d.DSSet {
+		findings = append(findings, ds.Findings...)
+	}
+
+	return findings
+}
@@ -0,0 +1,218 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSeverityToString(t *testing.T) {
+	if SeverityToString(SeverityInfo) != "INFO" {
+		t.Error("Severity INFO not converting correctly to string")
+	}
+
+	if SeverityToString(SeverityWarning) != "WARNING" {
+		t.Error("Severity WARNING not converting correctly to string")
+	}
+
+	if SeverityToString(SeverityError) != "ERROR" {
+		t.Error("Severity ERROR not converting correctly to string")
+	}
+
+	if SeverityToString(999999) != "" {
+		t.Error("Unknown severity associated to some existing severity")
+	}
+}
+
+func TestSeverityFromString(t *testing.T) {
+	if severity, ok := SeverityFromString(" warning "); !ok || severity != SeverityWarning {
+		t.Error("Not converting a valid severity")
+	}
+
+	if _, ok := SeverityFromString("critical"); ok {
+		t.Error("Accepting an unknown severity")
+	}
+}
+
+func TestMaxSeverity(t *testing.T) {
+	if _, ok := MaxSeverity(nil); ok {
+		t.Error("Returning a severity without findings")
+	}
+
+	severity, ok := MaxSeverity([]Finding{
+		{Code: "SERIALBEHIND", Severity: SeverityInfo},
+		{Code: "TIMEOUT", Severity: SeverityError},
+		{Code: "SLOW", Severity: SeverityWarning},
+	})
+
+	if !ok || severity != SeverityError {
+		t.Error("Not returning the most important severity")
+	}
+}
+
+func TestNameserverUpdateFindings(t *testing.T) {
+	nameserver := Nameserver{
+		Host:       "ns1.example.com.br.",
+		LastStatus: NameserverStatusTimeout,
+		Addresses: []NameserverAddress{
+			{IP: net.ParseIP("192.0.2.1"), LastStatus: NameserverStatusOK},
+			{IP: net.ParseIP("2001:db8::1"), LastStatus: NameserverStatusTimeout},
+		},
+	}
+
+	nameserver.UpdateFindings()
+
+	if len(nameserver.Findings) != 1 {
+		t.Fatalf("Expected 1 finding and got %d", len(nameserver.Findings))
+	}
+
+	finding := nameserver.Findings[0]
+	if finding.Code != "TIMEOUT" || finding.Severity != SeverityError ||
+		finding.Parameters["addresses"] != "2001:db8::1" || finding.FirstSeenAt.IsZero() {
+
+		t.Error("Not building the finding of the nameserver status correctly")
+	}
+
+	firstSeenAt := time.Now().Add(-48 * time.Hour)
+	nameserver.Findings[0].FirstSeenAt = firstSeenAt
+	nameserver.LastStatus = NameserverStatusSlow
+	nameserver.LastRTT = 3 * time.Second
+	nameserver.Addresses = nil
+	nameserver.SOASerialBehindSince = time.Now()
+	nameserver.UpdateFindings()
+
+	if len(nameserver.Findings) != 2 {
+		t.Fatalf("Expected 2 findings and got %d", len(nameserver.Findings))
+	}
+
+	if nameserver.Findings[0].Code != "SLOW" ||
+		nameserver.Findings[0].Severity != SeverityWarning ||
+		nameserver.Findings[0].Parameters["rtt"] != "3s" {
+
+		t.Error("Not building the finding of a warning status correctly")
+	}
+
+	if nameserver.Findings[0].FirstSeenAt.Equal(firstSeenAt) {
+		t.Error("Keeping the first detection date of a different finding")
+	}
+
+	if nameserver.Findings[1].Code != "SERIALBEHIND" ||
+		nameserver.Findings[1].Severity != SeverityInfo {
+
+		t.Error("Not detecting informational findings")
+	}
+
+	firstSeenAt = nameserver.Findings[0].FirstSeenAt
+	nameserver.UpdateFindings()
+
+	if !nameserver.Findings[0].FirstSeenAt.Equal(firstSeenAt) {
+		t.Error("Not keeping the first detection date of the finding")
+	}
+
+	nameserver.LastStatus = NameserverStatusSOATimers
+	nameserver.Warnings = []NameserverStatus{
+		NameserverStatusSOATimers,
+		NameserverStatusOpenRecursion,
+		NameserverStatusSlow,
+	}
+	nameserver.SOASerialBehindSince = time.Time{}
+	nameserver.UpdateFindings()
+
+	if len(nameserver.Findings) != 3 {
+		t.Fatalf("Expected 3 findings and got %d", len(nameserver.Findings))
+	}
+
+	if nameserver.Findings[0].Code != "SOATIMERS" ||
+		nameserver.Findings[1].Code != "OPENREC" ||
+		nameserver.Findings[2].Code != "SLOW" ||
+		nameserver.Findings[2].Parameters["rtt"] != "3s" {
+
+		t.Error("Not building a finding for each warning of the check")
+	}
+
+	if !nameserver.Findings[2].FirstSeenAt.Equal(firstSeenAt) {
+		t.Error("Not keeping the first detection date of a warning finding")
+	}
+
+	nameserver.LastStatus = NameserverStatusOK
+	nameserver.Warnings = nil
+	nameserver.UpdateFindings()
+
+	if len(nameserver.Findings) != 0 {
+		t.Error("Keeping findings of problems that were fixed")
+	}
+}
+
+func TestDSUpdateFindings(t *testing.T) {
+	ds := DS{
+		Algorithm:  DSAlgorithmRSASHA1,
+		DigestType: DSDigestTypeSHA256,
+		ExpiresAt:  time.Now().Add(24 * time.Hour),
+		LastStatus: DSStatusNoKey,
+		Nameservers: []DSNameserver{
+			{Host: "ns1.example.com.br.", LastStatus: DSStatusOK},
+			{Host: "ns2.example.com.br.", LastStatus: DSStatusNoKey},
+		},
+	}
+
+	ds.UpdateFindings(7)
+
+	if len(ds.Findings) != 3 {
+		t.Fatalf("Expected 3 findings and got %d", len(ds.Findings))
+	}
+
+	if ds.Findings[0].Code != "NOKEY" || ds.Findings[0].Severity != SeverityError ||
+		ds.Findings[0].Parameters["nameservers"] != "ns2.example.com.br." {
+
+		t.Error("Not building the finding of the DS status correctly")
+	}
+
+	if ds.Findings[1].Code != "DEPALG" || ds.Findings[1].Severity != SeverityWarning {
+		t.Error("Not detecting deprecated algorithm hidden by other problems")
+	}
+
+	if ds.Findings[2].Code != "NEAREXP" || ds.Findings[2].Severity != SeverityWarning {
+		t.Error("Not detecting signatures near expiration")
+	}
+
+	ds.Algorithm = DSAlgorithmRSASHA256
+	ds.ExpiresAt = time.Now().Add(30 * 24 * time.Hour)
+	ds.LastStatus = DSStatusOK
+	ds.UpdateFindings(7)
+
+	if len(ds.Findings) != 0 {
+		t.Error("Keeping findings of problems that were fixed")
+	}
+}
+
+func TestDomainFindings(t *testing.T) {
+	domain := Domain{
+		Nameservers: []Nameserver{
+			{Host: "ns1.example.com.br.", LastStatus: NameserverStatusSlow},
+			{Host: "ns2.example.com.br.", LastStatus: NameserverStatusOK},
+		},
+		DSSet: []DS{
+			{
+				Algorithm:  DSAlgorithmRSASHA256,
+				DigestType: DSDigestTypeSHA256,
+				LastStatus: DSStatusTimeout,
+			},
+		},
+	}
+
+	domain.UpdateFindings(7)
+
+	findings := domain.Findings()
+	if len(findings) != 2 {
+		t.Fatalf("Expected 2 findings and got %d", len(findings))
+	}
+
+	if severity, ok := MaxSeverity(findings); !ok || severity != SeverityError {
+		t.Error("Not retrieving the findings of the DS records")
+	}
+}
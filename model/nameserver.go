@@ -18,13 +18,25 @@ const (
 	NameserverStatusTimeout                  // Network timeout while trying to reach the nameserver
 	NameserverStatusNoAuthority              // Nameserver does not have authority for this domain
 	NameserverStatusUnknownDomainName        // Domain does not exists for this nameserver
-	NameserverStatusUnknownHost              // Could not resolve nameserver (no glue)
+	NameserverStatusUnknownHost              // Could not resolve nameserver (no glue and the name doesn't exist)
 	NameserverStatusServerFailure            // Nameserver configuration problem
 	NameserverStatusQueryRefused             // DNS request rejected
 	NameserverStatusConnectionRefused        // Connection refused by firewall or nameserver
 	NameserverStatusCanonicalName            // Domain name is a link in the zone APEX
 	NameserverStatusNotSynchronized          // Nameservers of this domain have a different version of the zone files
 	NameserverStatusError                    // Generic error found in the nameserver
+	NameserverStatusNSMissing                // NS answer of the nameserver omits registered nameservers
+	NameserverStatusNSExtra                  // NS answer of the nameserver lists nameservers that aren't registered
+	NameserverStatusGlueMismatch             // Nameserver addresses in the zone differ from the registered glue
+	NameserverStatusEDNSNotCompliant         // Nameserver failed in some EDNS compliance probes
+	NameserverStatusTCPUnreachable           // Nameserver doesn't answer DNS requests over TCP
+	NameserverStatusTCPMismatch              // Nameserver answers differently over UDP and TCP
+	NameserverStatusOpenRecursion            // Warning: Nameserver answers recursive queries for anyone
+	NameserverStatusOpenTransfer             // Warning: Nameserver allows anyone to transfer the zone
+	NameserverStatusSOATimers                // Warning: SOA timers are out of the recommended ranges
+	NameserverStatusSOANames                 // Warning: SOA MNAME or RNAME fields have an invalid syntax
+	NameserverStatusSlow                     // Warning: Nameserver takes too long to answer the DNS requests
+	NameserverStatusResolutionFailure        // Could not resolve nameserver because the resolvers failed (e.g. SERVFAIL)
 )
 
 // NameserverStatus is a number that represents one of the possible nameserver status
@@ -58,22 +70,133 @@ func NameserverStatusToString(status NameserverStatus) string {
 		return "NOTSYNCH"
 	case NameserverStatusError:
 		return "ERROR"
+	case NameserverStatusNSMissing:
+		return "NSMISSING"
+	case NameserverStatusNSExtra:
+		return "NSEXTRA"
+	case NameserverStatusGlueMismatch:
+		return "GLUEERR"
+	case NameserverStatusEDNSNotCompliant:
+		return "EDNS"
+	case NameserverStatusTCPUnreachable:
+		return "NOTCP"
+	case NameserverStatusTCPMismatch:
+		return "TCPDIFF"
+	case NameserverStatusOpenRecursion:
+		return "OPENREC"
+	case NameserverStatusOpenTransfer:
+		return "OPENAXFR"
+	case NameserverStatusSOATimers:
+		return "SOATIMERS"
+	case NameserverStatusSOANames:
+		return "SOANAMES"
+	case NameserverStatusSlow:
+		return "SLOW"
+	case NameserverStatusResolutionFailure:
+		return "RESOLVFAIL"
 	}
 
 	return ""
 }
 
+// Warning status are security or configuration problems that don't break the DNS
+// resolution of the domain, but that the nameserver's operator should fix. A nameserver
+// with a warning status is still considered OK
+func IsNameserverStatusWarning(status NameserverStatus) bool {
+	switch status {
+	case NameserverStatusOpenRecursion,
+		NameserverStatusOpenTransfer,
+		NameserverStatusSOATimers,
+		NameserverStatusSOANames,
+		NameserverStatusSlow:
+		return true
+	}
+
+	return false
+}
+
 // Nameserver store the information necessary to send the requests for a specific host and
 // store the results of this requests
 type Nameserver struct {
-	Host        string           // Nameserver's name
-	IPv4        net.IP           // Host's IPv4 (optional when don't need glue)
-	IPv6        net.IP           // Host's IPv6 (optional)
+	Host                 string              // Nameserver's name
+	IPv4                 net.IP              // Host's IPv4 (optional when don't need glue)
+	IPv6                 net.IP              // Host's IPv6 (optional)
+	Addresses            []NameserverAddress // Result of the last configuration check of each address
+	EDNSTests            []EDNSTest          // Result of the last EDNS compliance probes
+	SOASerial            uint32              // Version of the zone (SOA serial) found in the last check
+	SOASerialBehindSince time.Time           // Since when the zone version is behind the other nameservers
+	LastRTT              time.Duration       // Round trip time of the slowest address in the last check
+	UDPPayloadLimit      uint16              // Largest DNSKEY response size delivered over UDP when there's loss
+	DNSKEYTags           []uint16            // Keytags of the DNSKEY RRset found in the last check
+	Warnings             []NameserverStatus  // Warning results of all checks in the last check, as the status stores only one
+	Findings             []Finding           // Problems detected in the last check, with their severities
+	Diagnostic           Diagnostic          // Details of the problem detected in the last check
+	LastStatus           NameserverStatus    // Result of the last configuration check
+	LastCheckAt          time.Time           // Time of the last configuration check
+	LastOKAt             time.Time           // Last time that the DNS configuration was OK
+}
+
+// NameserverAddress store the result of the configuration check for one of the addresses
+// (IPv4 or IPv6) of the nameserver. A nameserver can have many addresses, and only one of
+// them could be misconfigured
+type NameserverAddress struct {
+	IP          net.IP           // Address that received the DNS requests
+	Diagnostic  Diagnostic       // Details of the problem detected in the last check
 	LastStatus  NameserverStatus // Result of the last configuration check
 	LastCheckAt time.Time        // Time of the last configuration check
 	LastOKAt    time.Time        // Last time that the DNS configuration was OK
 }
 
+// ChangeStatus is a easy way to change the status of a nameserver address because it also
+// updates the last check date
+func (n *NameserverAddress) ChangeStatus(status NameserverStatus) {
+	n.LastStatus = status
+	n.LastCheckAt = time.Now()
+
+	if status == NameserverStatusOK {
+		n.LastOKAt = n.LastCheckAt
+	}
+}
+
+// Retrieve the last check result of a given address of the nameserver. If the address
+// wasn't checked before, a new address object is returned, so that we don't lose the last
+// OK date of the addresses that are checked again
+func (n Nameserver) Address(ip net.IP) NameserverAddress {
+	for _, address := range n.Addresses {
+		if address.IP.Equal(ip) {
+			return address
+		}
+	}
+
+	return NameserverAddress{
+		IP: ip,
+	}
+}
+
+// List the EDNS compliance probes that the nameserver failed in the last check
+func (n Nameserver) FailedEDNSTests() []EDNSTest {
+	var failedTests []EDNSTest
+	for _, test := range n.EDNSTests {
+		if test.LastStatus != EDNSStatusOK && test.LastStatus != EDNSStatusNotChecked {
+			failedTests = append(failedTests, test)
+		}
+	}
+	return failedTests
+}
+
+// List the addresses of the nameserver that had problems in the last configuration check
+func (n Nameserver) FailedAddresses() []NameserverAddress {
+	var failedAddresses []NameserverAddress
+	for _, address := range n.Addresses {
+		if address.LastStatus != NameserverStatusOK &&
+			address.LastStatus != NameserverStatusNotChecked {
+
+			failedAddresses = append(failedAddresses, address)
+		}
+	}
+	return failedAddresses
+}
+
 // Method to check if the nameserver needs glue for a given domain name. A namerserver
 // needs glue when the name of the domain is inside the nameserver (example: domain
 // test.com.br and nameserver ns1.tes.com.br)
@@ -89,7 +212,7 @@ func (n *Nameserver) ChangeStatus(status NameserverStatus) {
 	n.LastStatus = status
 	n.LastCheckAt = time.Now()
 
-	if status == NameserverStatusOK {
+	if status == NameserverStatusOK || IsNameserverStatusWarning(status) {
 		n.LastOKAt = n.LastCheckAt
 	}
 }
@@ -6,6 +6,7 @@
 package model
 
 import (
+	"net"
 	"testing"
 	"time"
 )
@@ -73,6 +74,93 @@ func TestNameserverChangeStatus(t *testing.T) {
 	}
 }
 
+func TestNameserverChangeStatusWarning(t *testing.T) {
+	nameserver := Nameserver{
+		LastStatus: NameserverStatusTimeout,
+	}
+
+	nameserver.ChangeStatus(NameserverStatusOpenTransfer)
+
+	if nameserver.LastOKAt.IsZero() || !nameserver.LastOKAt.Equal(nameserver.LastCheckAt) {
+		t.Error("ChangeStatus method did not update the last OK date for a warning status")
+	}
+
+	if !IsNameserverStatusWarning(NameserverStatusOpenRecursion) ||
+		!IsNameserverStatusWarning(NameserverStatusSOATimers) ||
+		IsNameserverStatusWarning(NameserverStatusTimeout) ||
+		IsNameserverStatusWarning(NameserverStatusOK) {
+		t.Error("Not identifying warning nameserver status correctly")
+	}
+}
+
+func TestNameserverAddressChangeStatus(t *testing.T) {
+	address := NameserverAddress{
+		IP:         net.ParseIP("192.0.2.1"),
+		LastStatus: NameserverStatusTimeout,
+	}
+
+	address.ChangeStatus(NameserverStatusOK)
+
+	if address.LastStatus != NameserverStatusOK || address.LastCheckAt.IsZero() ||
+		!address.LastOKAt.Equal(address.LastCheckAt) {
+		t.Error("ChangeStatus method did not update the nameserver address")
+	}
+
+	lastOKAt := address.LastOKAt
+	address.ChangeStatus(NameserverStatusTimeout)
+
+	if address.LastStatus != NameserverStatusTimeout || !address.LastOKAt.Equal(lastOKAt) {
+		t.Error("ChangeStatus method changed the last OK date of a failed address")
+	}
+}
+
+func TestNameserverAddress(t *testing.T) {
+	lastOKAt := time.Now().Add(-1 * time.Hour)
+
+	nameserver := Nameserver{
+		Addresses: []NameserverAddress{
+			{
+				IP:         net.ParseIP("192.0.2.1"),
+				LastStatus: NameserverStatusOK,
+				LastOKAt:   lastOKAt,
+			},
+			{
+				IP:         net.ParseIP("2001:db8::1"),
+				LastStatus: NameserverStatusTimeout,
+			},
+		},
+	}
+
+	if address := nameserver.Address(net.ParseIP("192.0.2.1")); !address.LastOKAt.Equal(lastOKAt) {
+		t.Error("Not retrieving an address that was already checked")
+	}
+
+	address := nameserver.Address(net.ParseIP("192.0.2.2"))
+	if !address.IP.Equal(net.ParseIP("192.0.2.2")) || !address.LastOKAt.IsZero() {
+		t.Error("Not creating a new address when it wasn't checked before")
+	}
+
+	failedAddresses := nameserver.FailedAddresses()
+	if len(failedAddresses) != 1 || !failedAddresses[0].IP.Equal(net.ParseIP("2001:db8::1")) {
+		t.Error("Not listing the failed addresses of the nameserver")
+	}
+}
+
+func TestNameserverFailedEDNSTests(t *testing.T) {
+	nameserver := Nameserver{
+		EDNSTests: []EDNSTest{
+			{Probe: EDNSProbeVersion, LastStatus: EDNSStatusOK},
+			{Probe: EDNSProbeOption, LastStatus: EDNSStatusNotChecked},
+			{Probe: EDNSProbeDO, LastStatus: EDNSStatusDO},
+		},
+	}
+
+	failedTests := nameserver.FailedEDNSTests()
+	if len(failedTests) != 1 || failedTests[0].Probe != EDNSProbeDO {
+		t.Error("Not listing the failed EDNS tests of the nameserver")
+	}
+}
+
 func TestNameserverStatusToString(t *testing.T) {
 	if NameserverStatusToString(NameserverStatusNotChecked) != "NOTCHECKED" {
 		t.Error("Nameserver status NOTCHECKED not converting correctly to string")
@@ -122,6 +210,54 @@ func TestNameserverStatusToString(t *testing.T) {
 		t.Error("Nameserver status ERROR not converting correctly to string")
 	}
 
+	if NameserverStatusToString(NameserverStatusNSMissing) != "NSMISSING" {
+		t.Error("Nameserver status NSMISSING not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusNSExtra) != "NSEXTRA" {
+		t.Error("Nameserver status NSEXTRA not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusGlueMismatch) != "GLUEERR" {
+		t.Error("Nameserver status GLUEERR not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusEDNSNotCompliant) != "EDNS" {
+		t.Error("Nameserver status EDNS not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusTCPUnreachable) != "NOTCP" {
+		t.Error("Nameserver status NOTCP not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusTCPMismatch) != "TCPDIFF" {
+		t.Error("Nameserver status TCPDIFF not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusOpenRecursion) != "OPENREC" {
+		t.Error("Nameserver status OPENREC not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusOpenTransfer) != "OPENAXFR" {
+		t.Error("Nameserver status OPENAXFR not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusSOATimers) != "SOATIMERS" {
+		t.Error("Nameserver status SOATIMERS not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusSOANames) != "SOANAMES" {
+		t.Error("Nameserver status SOANAMES not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusSlow) != "SLOW" {
+		t.Error("Nameserver status SLOW not converting correctly to string")
+	}
+
+	if NameserverStatusToString(NameserverStatusResolutionFailure) != "RESOLVFAIL" {
+		t.Error("Nameserver status RESOLVFAIL not converting correctly to string")
+	}
+
 	if NameserverStatusToString(999999) != "" {
 		t.Error("Unknown nameserver status associated to some existing status")
 	}
@@ -0,0 +1,44 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"time"
+)
+
+// ParentDS store the result of the comparison between the DS set registered in the system
+// and the DS RRset that the parent zone publishes for the domain. The result belongs to
+// the domain and not to a DS record, because the parent zone can publish DS records for a
+// domain without registered DS records, making the zone bogus for the validating
+// resolvers. The possible status are DSStatusOK, DSStatusNotPublished (registered DS
+// records missing in the parent zone), DSStatusNotRegistered (parent zone publishes DS
+// records that aren't registered) and DSStatusNotChecked, when the parent zone couldn't
+// be queried
+type ParentDS struct {
+	ExtraDSSet  []DS       // DS records published by the parent zone that aren't registered
+	Diagnostic  Diagnostic // Details of the problem detected in the last check
+	LastStatus  DSStatus   // Result of the last comparison with the parent zone
+	LastCheckAt time.Time  // Time of the last comparison with the parent zone
+	LastOKAt    time.Time  // Last time that the parent zone published the registered DS set
+}
+
+// ChangeStatus is a easy way to change the status of the parent zone comparison because
+// it also updates the last check date and the last OK date. A check that couldn't query
+// the parent zone keeps the DS records found in the last check
+func (p *ParentDS) ChangeStatus(status DSStatus, extraDSSet []DS, diagnostic Diagnostic) {
+	p.LastStatus = status
+	p.LastCheckAt = time.Now()
+	p.Diagnostic = diagnostic
+
+	if status == DSStatusNotChecked {
+		return
+	}
+
+	p.ExtraDSSet = extraDSSet
+	if status == DSStatusOK {
+		p.LastOKAt = p.LastCheckAt
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"testing"
+)
+
+func TestParentDSChangeStatus(t *testing.T) {
+	var parentDS ParentDS
+
+	extraDSSet := []DS{
+		{Keytag: 45966, Algorithm: DSAlgorithmRSASHA1NSEC3, DigestType: DSDigestTypeSHA1},
+	}
+
+	parentDS.ChangeStatus(DSStatusNotRegistered, extraDSSet, Diagnostic{
+		Error: "DS record of the parent zone isn't registered",
+	})
+
+	if parentDS.LastStatus != DSStatusNotRegistered || len(parentDS.ExtraDSSet) != 1 ||
+		parentDS.LastCheckAt.IsZero() || !parentDS.LastOKAt.IsZero() {
+
+		t.Error("Not storing the DS records published only in the parent zone")
+	}
+
+	parentDS.ChangeStatus(DSStatusNotChecked, nil, Diagnostic{
+		Error: "Could not find the parent zone nameservers",
+	})
+
+	if parentDS.LastStatus != DSStatusNotChecked || len(parentDS.ExtraDSSet) != 1 ||
+		parentDS.Diagnostic.Error != "Could not find the parent zone nameservers" {
+
+		t.Error("Not keeping the last result when the parent zone couldn't be queried")
+	}
+
+	parentDS.ChangeStatus(DSStatusOK, nil, Diagnostic{})
+
+	if parentDS.LastStatus != DSStatusOK || len(parentDS.ExtraDSSet) != 0 ||
+		!parentDS.Diagnostic.Empty() || !parentDS.LastOKAt.Equal(parentDS.LastCheckAt) {
+
+		t.Error("Not storing the last time that the parent zone published the DS set")
+	}
+}
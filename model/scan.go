@@ -8,6 +8,8 @@ package model
 import (
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2/bson"
 	"github.com/rafaeljusto/shelter/scheduler"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,14 +22,19 @@ var (
 	shelterCurrentScanLock sync.Mutex  // Make current scan thread safe
 )
 
-// List of possible values of a scan status. The status ScanStatusWaitingExecution,
-// ScanStatusLoadingData, ScanStatusRunning will only be visible in a CurrentScan struct
+// List of possible values of a scan status. The status ScanStatusWaitingExecution will
+// only be visible in a CurrentScan struct. The status ScanStatusLoadingData,
+// ScanStatusRunning and ScanStatusPaused are also stored while the scan is executing, so
+// that a scan interrupted by a crash or restart can be resumed
 const (
 	ScanStatusWaitingExecution   ScanStatus = 0 // The scan is going to be executed in the future
 	ScanStatusLoadingData        ScanStatus = 1 // Loading the domains objects for Scan
 	ScanStatusRunning            ScanStatus = 2 // The scan is current scanning the system
 	ScanStatusExecuted           ScanStatus = 3 // Scan alredy finished succesfully
 	ScanStatusExecutedWithErrors ScanStatus = 4 // Scan had problems during the execution
+	ScanStatusAborted            ScanStatus = 5 // Scan interrupted by a crash or restart and not resumed
+	ScanStatusPaused             ScanStatus = 6 // Scan paused by the user, no domain is being checked
+	ScanStatusCanceled           ScanStatus = 7 // Scan canceled by the user before checking all domains
 )
 
 // We keep a state from the scan to identify scans that had problem or not, and the current
@@ -47,6 +54,12 @@ func ScanStatusToString(status ScanStatus) string {
 		return "EXECUTED"
 	case ScanStatusExecutedWithErrors:
 		return "EXECUTEDWITHERRORS"
+	case ScanStatusAborted:
+		return "ABORTED"
+	case ScanStatusPaused:
+		return "PAUSED"
+	case ScanStatusCanceled:
+		return "CANCELED"
 	}
 
 	return ""
@@ -56,26 +69,150 @@ func ScanStatusToString(status ScanStatus) string {
 // ENUM format because we cannot have a non-string key in the JSON format when saving into the
 // database
 type Scan struct {
-	Id                       bson.ObjectId     `bson:"_id"` // Database identification
-	Revision                 int               // Version of the object
-	Status                   ScanStatus        // Status of the scan
-	StartedAt                time.Time         // Date and time that the scan started
-	FinishedAt               time.Time         // Date and time that the scan finished
-	LastModifiedAt           time.Time         // Last time the object was modified
-	DomainsScanned           uint64            // Number of domains scanned
-	DomainsWithDNSSECScanned uint64            // Number of domains with DS recods scanned
-	NameserverStatistics     map[string]uint64 // Statistics from nameserver status (text format) in number of hosts
-	DSStatistics             map[string]uint64 // Statistics from DS records' status (text format) in number of DS records
+	Id                       bson.ObjectId      `bson:"_id"` // Database identification
+	Revision                 int                // Version of the object
+	Status                   ScanStatus         // Status of the scan
+	StartedAt                time.Time          // Date and time that the scan started
+	FinishedAt               time.Time          // Date and time that the scan finished
+	LastModifiedAt           time.Time          // Last time the object was modified
+	DomainsScanned           uint64             // Number of domains scanned
+	DomainsWithDNSSECScanned uint64             // Number of domains with DS recods scanned
+	DomainsPostponed         uint64             // Number of times that domains were postponed by the hosts' rate limit
+	DomainsDropped           uint64             // Number of domains not checked after too many postponements
+	NameserverStatistics     map[string]uint64  // Statistics from nameserver status (text format) in number of hosts
+	DSStatistics             map[string]uint64  // Statistics from DS records' status (text format) in number of DS records
+	SeverityStatistics       map[string]uint64  // Statistics from findings' severity (text format) in number of findings
+	RTTStatistics            RTTStatistics      // Latency statistics from the nameservers that answered
+	RTTHistogram             RTTHistogram       // Round trip times used to build the RTT statistics
+	StatusChanges            []ScanStatusChange // Pauses, resumes and cancellation requested by the user
+	BatchesCreated           bool               // All domains of a distributed scan were split into batches
 }
 
+// ScanStatusChange stores a change of the scan status requested by the user while the scan
+// was executing, so that the reports show why a scan took longer or didn't check all
+// domains
+type ScanStatusChange struct {
+	Status    ScanStatus // New status of the scan
+	ChangedAt time.Time  // When the status was changed
+}
+
+// RTTStatistics store the distribution of the nameservers' round trip times in a scan.
+// With the percentiles we can detect nameservers that are degrading before they start
+// timing out
+type RTTStatistics struct {
+	Samples uint64        // Number of nameservers that answered
+	Average time.Duration // Average round trip time
+	P50     time.Duration // Round trip time of 50% of the nameservers (median)
+	P90     time.Duration // Round trip time of 90% of the nameservers
+	P99     time.Duration // Round trip time of 99% of the nameservers
+	Max     time.Duration // Slowest round trip time
+}
+
+// RTTHistogram count the nameservers' round trip times in buckets of one millisecond. We
+// use a histogram instead of storing all round trip times to keep the memory usage low
+// when scanning many domains, and the precision is enough for the percentiles. The
+// histogram is stored with the scan, so that the statistics of an interrupted scan can be
+// built again when the scan is resumed
+type RTTHistogram map[int64]uint64
+
+// Convert the histogram to a document with text keys before saving into the database, as
+// we cannot have a non-string key in the JSON format
+func (h RTTHistogram) GetBSON() (interface{}, error) {
+	buckets := make(map[string]uint64)
+	for bucket, samples := range h {
+		buckets[strconv.FormatInt(bucket, 10)] = samples
+	}
+
+	return buckets, nil
+}
+
+// Build the histogram from the document with text keys loaded from the database. Buckets
+// that aren't numbers are ignored, as we can't know the round trip time
+func (h *RTTHistogram) SetBSON(raw bson.Raw) error {
+	var buckets map[string]uint64
+	if err := raw.Unmarshal(&buckets); err != nil {
+		return err
+	}
+
+	*h = make(RTTHistogram)
+	for bucket, samples := range buckets {
+		if rtt, err := strconv.ParseInt(bucket, 10, 64); err == nil {
+			(*h)[rtt] += samples
+		}
+	}
+
+	return nil
+}
+
+// Count a round trip time in the histogram. Zero values are ignored because they
+// represent nameservers that didn't answer
+func (h RTTHistogram) Add(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+
+	h[int64(rtt/time.Millisecond)] += 1
+}
+
+// Build the latency statistics from the round trip times counted in the histogram
+func (h RTTHistogram) Statistics() RTTStatistics {
+	var statistics RTTStatistics
+
+	var buckets []int64
+	var total int64
+	for bucket, samples := range h {
+		buckets = append(buckets, bucket)
+		statistics.Samples += samples
+		total += bucket * int64(samples)
+	}
+
+	if statistics.Samples == 0 {
+		return statistics
+	}
+
+	sort.Sort(int64Slice(buckets))
+
+	statistics.Average = time.Duration(total/int64(statistics.Samples)) * time.Millisecond
+	statistics.P50 = h.percentile(buckets, statistics.Samples, 50)
+	statistics.P90 = h.percentile(buckets, statistics.Samples, 90)
+	statistics.P99 = h.percentile(buckets, statistics.Samples, 99)
+	statistics.Max = time.Duration(buckets[len(buckets)-1]) * time.Millisecond
+	return statistics
+}
+
+// Find the smallest round trip time that is greater or equal than the given percentage of
+// the samples (nearest rank method). The buckets must be sorted
+func (h RTTHistogram) percentile(buckets []int64, samples uint64, percentage uint64) time.Duration {
+	rank := (samples*percentage + 99) / 100
+
+	var count uint64
+	for _, bucket := range buckets {
+		count += h[bucket]
+		if count >= rank {
+			return time.Duration(bucket) * time.Millisecond
+		}
+	}
+
+	return time.Duration(buckets[len(buckets)-1]) * time.Millisecond
+}
+
+// int64Slice was created only to sort the histogram buckets, as the sort package doesn't
+// have a helper for int64 values
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 // CurrentScan is a Scan that is the next to be executed or is executing at this moment. The data
 // from this struct is not stored until the scan is finished and become only a Scan struct. This
 // should be used to tell the user (using a service) how is a progress of a scan on-the-fly
 type CurrentScan struct {
-	Scan                         // CurrentScan is a Scan
-	ScheduledAt        time.Time // Initial date and time that the scan was schedule to execute
-	DomainsToBeScanned uint64    // Domains selected to be scanned
-	LastModifiedAt     time.Time // Last time that the object changed
+	Scan                          // CurrentScan is a Scan
+	ScheduledAt        time.Time  // Initial date and time that the scan was schedule to execute
+	DomainsToBeScanned uint64     // Domains selected to be scanned
+	LastModifiedAt     time.Time  // Last time that the object changed
+	pausedStatus       ScanStatus // Status restored when the paused scan continues
 }
 
 // Function to fill current scan variable for the first time. Should run after the
@@ -92,6 +229,7 @@ func InitializeCurrentScan() error {
 			Status:               ScanStatusWaitingExecution,
 			NameserverStatistics: make(map[string]uint64),
 			DSStatistics:         make(map[string]uint64),
+			SeverityStatistics:   make(map[string]uint64),
 		},
 		ScheduledAt:    nextExecution,
 		LastModifiedAt: time.Now(),
@@ -114,11 +252,70 @@ func StartNewScan() {
 			StartedAt:            time.Now().UTC(),
 			NameserverStatistics: make(map[string]uint64),
 			DSStatistics:         make(map[string]uint64),
+			SeverityStatistics:   make(map[string]uint64),
 		},
 		LastModifiedAt: time.Now(),
 	}
 }
 
+// Function to continue a scan that was interrupted before finishing, restoring the
+// counters and statistics stored in the last checkpoint. The domains already checked
+// aren't loaded again, so they are counted here as domains to be scanned to estimate the
+// progress
+func ResumeScan(scan Scan) {
+	shelterCurrentScanLock.Lock()
+	defer shelterCurrentScanLock.Unlock()
+
+	scan.Status = ScanStatusLoadingData
+
+	if scan.NameserverStatistics == nil {
+		scan.NameserverStatistics = make(map[string]uint64)
+	}
+
+	if scan.DSStatistics == nil {
+		scan.DSStatistics = make(map[string]uint64)
+	}
+
+	if scan.SeverityStatistics == nil {
+		scan.SeverityStatistics = make(map[string]uint64)
+	}
+
+	shelterCurrentScan = CurrentScan{
+		Scan:               scan,
+		DomainsToBeScanned: scan.DomainsScanned,
+		LastModifiedAt:     time.Now(),
+	}
+}
+
+// CheckpointScan stores the progress of the scan being executed, so that the scan can be
+// resumed if the process stops before the scan finishes. The counters are copied, so the
+// scan doesn't stop while saving. When there's no scan being executed nothing is saved
+func CheckpointScan(f func(*Scan) error) error {
+	shelterCurrentScanLock.Lock()
+	defer shelterCurrentScanLock.Unlock()
+
+	if !isExecuting(shelterCurrentScan.Status) {
+		return nil
+	}
+
+	scan := shelterCurrentScan.Scan
+	scan.DomainsScanned = atomic.LoadUint64(&shelterCurrentScan.DomainsScanned)
+	scan.DomainsWithDNSSECScanned = atomic.LoadUint64(&shelterCurrentScan.DomainsWithDNSSECScanned)
+	scan.DomainsPostponed = atomic.LoadUint64(&shelterCurrentScan.DomainsPostponed)
+	scan.DomainsDropped = atomic.LoadUint64(&shelterCurrentScan.DomainsDropped)
+
+	if err := f(&scan); err != nil {
+		return err
+	}
+
+	// Keep the database control attributes, so that the next checkpoints and the end of the
+	// scan update the same entry
+	shelterCurrentScan.Id = scan.Id
+	shelterCurrentScan.Revision = scan.Revision
+	shelterCurrentScan.Scan.LastModifiedAt = scan.LastModifiedAt
+	return nil
+}
+
 // FinishAndSaveScan was created to alert that the scan being executed finished. This
 // function is necessary to garantee concurrency acces for the current scan information.
 // Will set all necessary information and save the scan into the database for future
@@ -128,10 +325,14 @@ func FinishAndSaveScan(hadErrors bool, f func(*Scan) error) error {
 	shelterCurrentScanLock.Lock()
 	defer shelterCurrentScanLock.Unlock()
 
-	if hadErrors {
-		shelterCurrentScan.Status = ScanStatusExecutedWithErrors
-	} else {
-		shelterCurrentScan.Status = ScanStatusExecuted
+	// A canceled scan keeps the status, so that the reports show that not all domains were
+	// checked
+	if shelterCurrentScan.Status != ScanStatusCanceled {
+		if hadErrors {
+			shelterCurrentScan.Status = ScanStatusExecutedWithErrors
+		} else {
+			shelterCurrentScan.Status = ScanStatusExecuted
+		}
 	}
 
 	shelterCurrentScan.FinishedAt = time.Now()
@@ -145,6 +346,7 @@ func FinishAndSaveScan(hadErrors bool, f func(*Scan) error) error {
 			Status:               ScanStatusWaitingExecution,
 			NameserverStatistics: make(map[string]uint64),
 			DSStatistics:         make(map[string]uint64),
+			SeverityStatistics:   make(map[string]uint64),
 		},
 		LastModifiedAt: time.Now(),
 	}
@@ -183,10 +385,92 @@ func FinishLoadingDomainsForScan() {
 	shelterCurrentScanLock.Lock()
 	defer shelterCurrentScanLock.Unlock()
 
-	shelterCurrentScan.Status = ScanStatusRunning
+	// The status of a paused scan is only changed when the scan continues, and a canceled
+	// scan keeps the status until the end
+	if shelterCurrentScan.Status == ScanStatusPaused {
+		shelterCurrentScan.pausedStatus = ScanStatusRunning
+
+	} else if shelterCurrentScan.Status != ScanStatusCanceled {
+		shelterCurrentScan.Status = ScanStatusRunning
+	}
+
 	shelterCurrentScan.LastModifiedAt = time.Now()
 }
 
+// Function to record that the user paused the scan being executed. Returns false when
+// there's no scan being executed or when the scan can't be paused
+func PauseScan() bool {
+	shelterCurrentScanLock.Lock()
+	defer shelterCurrentScanLock.Unlock()
+
+	if shelterCurrentScan.Status != ScanStatusLoadingData &&
+		shelterCurrentScan.Status != ScanStatusRunning {
+
+		return false
+	}
+
+	shelterCurrentScan.pausedStatus = shelterCurrentScan.Status
+	changeScanStatus(ScanStatusPaused)
+	return true
+}
+
+// Function to record that the user asked to continue the paused scan. Returns false when
+// the scan isn't paused
+func ContinuePausedScan() bool {
+	shelterCurrentScanLock.Lock()
+	defer shelterCurrentScanLock.Unlock()
+
+	if shelterCurrentScan.Status != ScanStatusPaused {
+		return false
+	}
+
+	changeScanStatus(shelterCurrentScan.pausedStatus)
+	return true
+}
+
+// Function to record that the user canceled the scan being executed. The scan is still
+// saved at the end, with the domains checked until the cancellation. Returns false when
+// there's no scan being executed or when the scan was already canceled
+func CancelScan() bool {
+	shelterCurrentScanLock.Lock()
+	defer shelterCurrentScanLock.Unlock()
+
+	if !isExecuting(shelterCurrentScan.Status) ||
+		shelterCurrentScan.Status == ScanStatusCanceled {
+
+		return false
+	}
+
+	changeScanStatus(ScanStatusCanceled)
+	return true
+}
+
+// Change the status of the current scan, recording the change to be saved with the scan.
+// The lock must be acquired by the caller
+func changeScanStatus(status ScanStatus) {
+	now := time.Now()
+
+	shelterCurrentScan.Status = status
+	shelterCurrentScan.StatusChanges = append(shelterCurrentScan.StatusChanges,
+		ScanStatusChange{
+			Status:    status,
+			ChangedAt: now,
+		})
+
+	shelterCurrentScan.LastModifiedAt = now
+}
+
+// Check if the scan is being executed, even if it is paused or finishing after a
+// cancellation
+func isExecuting(status ScanStatus) bool {
+	switch status {
+	case ScanStatusLoadingData, ScanStatusRunning, ScanStatusPaused, ScanStatusCanceled:
+		return true
+	}
+
+	return false
+}
+
 // When the collector receives a domain it tells the scan information structure to help
 // predicting when the scan will ends
 func FinishAnalyzingDomainForScan(withDNSSEC bool) {
@@ -197,19 +481,64 @@ func FinishAnalyzingDomainForScan(withDNSSEC bool) {
 	}
 }
 
-// Function to store scan result statistics. It can be accessed concurrently because it
-// use a general lock to access the global structure
-func StoreStatisticsOfTheScan(nameserverStatistics map[string]uint64,
-	dsStatistics map[string]uint64) {
+// When a querier postpones a domain because a host received too many queries, it tells the
+// scan information structure. A domain can be postponed many times in the same scan
+func PostponedDomainForScan() {
+	atomic.AddUint64(&shelterCurrentScan.DomainsPostponed, 1)
+}
+
+// When a querier gives up checking a domain after too many postponements, it tells the
+// scan information structure. The dropped domain isn't saved, so it will be selected again
+// in the next scan
+func DroppedDomainForScan() {
+	atomic.AddUint64(&shelterCurrentScan.DomainsDropped, 1)
+}
+
+// Function to add the statistics of the domains saved since the last call to the scan
+// result statistics, so that the checkpoints store the statistics of the scan so far. It
+// can be accessed concurrently because it use a general lock to access the global
+// structure. New maps are created, because the maps of the scan information returned
+// before can still be in use
+func AddStatisticsOfTheScan(nameserverStatistics map[string]uint64,
+	dsStatistics map[string]uint64, severityStatistics map[string]uint64,
+	rttHistogram RTTHistogram) {
 
 	shelterCurrentScanLock.Lock()
 	defer shelterCurrentScanLock.Unlock()
 
-	shelterCurrentScan.NameserverStatistics = nameserverStatistics
-	shelterCurrentScan.DSStatistics = dsStatistics
+	shelterCurrentScan.NameserverStatistics =
+		addStatistics(shelterCurrentScan.NameserverStatistics, nameserverStatistics)
+	shelterCurrentScan.DSStatistics =
+		addStatistics(shelterCurrentScan.DSStatistics, dsStatistics)
+	shelterCurrentScan.SeverityStatistics =
+		addStatistics(shelterCurrentScan.SeverityStatistics, severityStatistics)
+
+	histogram := make(RTTHistogram)
+	for bucket, samples := range shelterCurrentScan.RTTHistogram {
+		histogram[bucket] += samples
+	}
+	for bucket, samples := range rttHistogram {
+		histogram[bucket] += samples
+	}
+
+	shelterCurrentScan.RTTHistogram = histogram
+	shelterCurrentScan.RTTStatistics = histogram.Statistics()
 	shelterCurrentScan.LastModifiedAt = time.Now()
 }
 
+// Sum the statistics of two maps in a new map
+func addStatistics(statistics, added map[string]uint64) map[string]uint64 {
+	result := make(map[string]uint64)
+	for key, count := range statistics {
+		result[key] += count
+	}
+	for key, count := range added {
+		result[key] += count
+	}
+
+	return result
+}
+
 // Function to copy the global variable and return it to allow other parts of the system
 // to read it. It is necessary because the global variable needs locks for read/write
 // access
@@ -0,0 +1,184 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2/bson"
+	"strconv"
+	"time"
+)
+
+// List of possible values of a scan batch status
+const (
+	ScanBatchStatusPending   ScanBatchStatus = 0 // Waiting for a worker to scan the domains
+	ScanBatchStatusLeased    ScanBatchStatus = 1 // A worker is scanning the domains
+	ScanBatchStatusCommitted ScanBatchStatus = 2 // Domains scanned and statistics stored
+	ScanBatchStatusFailed    ScanBatchStatus = 3 // Coordinator gave up waiting for the workers
+)
+
+// ScanBatchStatus controls which worker of a distributed scan is responsible for the
+// domains of a batch
+type ScanBatchStatus int
+
+// Convert the scan batch status enum to text for printing in reports or debugging
+func ScanBatchStatusToString(status ScanBatchStatus) string {
+	switch status {
+	case ScanBatchStatusPending:
+		return "PENDING"
+	case ScanBatchStatusLeased:
+		return "LEASED"
+	case ScanBatchStatusCommitted:
+		return "COMMITTED"
+	case ScanBatchStatusFailed:
+		return "FAILED"
+	}
+
+	return ""
+}
+
+// ScanBatch is a part of a distributed scan. The coordinator splits the domains that are
+// due into batches, and each worker leases a batch, scans the domains and commits the
+// statistics. When the lease expires before the commit, probably because the worker
+// crashed, another worker can lease the batch again
+type ScanBatch struct {
+	Id             bson.ObjectId       `bson:"_id"` // Database identification
+	Revision       int                 // Version of the object
+	LastModifiedAt time.Time           // Last time the object was modified
+	ScanStartedAt  time.Time           // Start of the scan that created the batch
+	Sequence       int                 // Order of the batch, the most urgent domains are in the first batches
+	FQDNs          []string            // Domains that are going to be scanned
+	Status         ScanBatchStatus     // Status of the batch
+	Worker         string              // Identification of the worker that leased the batch
+	LeasedUntil    time.Time           // When another worker can lease the batch again
+	Leases         int                 // Number of times that the batch was leased
+	Statistics     ScanBatchStatistics // Results of the scan, filled on commit
+}
+
+// ScanBatchStatistics stores the statistics of the scan of a batch, so that the
+// coordinator can merge the statistics of all workers in one scan. The round trip times
+// are stored instead of the RTT statistics, because percentiles can't be merged. The
+// statistics attributes cannot use the ENUM format because we cannot have a non-string key
+// in the JSON format when saving into the database
+type ScanBatchStatistics struct {
+	HadErrors                bool              // Errors detected while scanning the batch
+	DomainsScanned           uint64            // Number of domains scanned
+	DomainsWithDNSSECScanned uint64            // Number of domains with DS recods scanned
+	DomainsPostponed         uint64            // Number of times that domains were postponed by the hosts' rate limit
+	DomainsDropped           uint64            // Number of domains not checked after too many postponements
+	NameserverStatistics     map[string]uint64 // Statistics from nameserver status (text format) in number of hosts
+	DSStatistics             map[string]uint64 // Statistics from DS records' status (text format) in number of DS records
+	SeverityStatistics       map[string]uint64 // Statistics from findings' severity (text format) in number of findings
+	RTTHistogram             map[string]uint64 // Round trip times in milliseconds (text format) in number of nameservers
+}
+
+// Build the statistics of a batch from the current scan of the worker that scanned it
+func NewScanBatchStatistics(currentScan CurrentScan, hadErrors bool) ScanBatchStatistics {
+	statistics := ScanBatchStatistics{
+		HadErrors:                hadErrors,
+		DomainsScanned:           currentScan.DomainsScanned,
+		DomainsWithDNSSECScanned: currentScan.DomainsWithDNSSECScanned,
+		DomainsPostponed:         currentScan.DomainsPostponed,
+		DomainsDropped:           currentScan.DomainsDropped,
+		NameserverStatistics:     currentScan.NameserverStatistics,
+		DSStatistics:             currentScan.DSStatistics,
+		SeverityStatistics:       currentScan.SeverityStatistics,
+		RTTHistogram:             make(map[string]uint64),
+	}
+
+	for bucket, samples := range currentScan.RTTHistogram {
+		statistics.RTTHistogram[strconv.FormatInt(bucket, 10)] = samples
+	}
+
+	return statistics
+}
+
+// Function to alert that the scan of a batch finished in a worker of a distributed scan.
+// The current scan is returned to build the statistics of the batch, and is reseted to
+// wait for the next batch. Nothing is saved, because the scan is saved by the coordinator
+func FinishScanBatch() CurrentScan {
+	shelterCurrentScanLock.Lock()
+	defer shelterCurrentScanLock.Unlock()
+
+	currentScan := shelterCurrentScan
+	currentScan.FinishedAt = time.Now()
+
+	shelterCurrentScan = CurrentScan{
+		Scan: Scan{
+			Status:               ScanStatusWaitingExecution,
+			NameserverStatistics: make(map[string]uint64),
+			DSStatistics:         make(map[string]uint64),
+			SeverityStatistics:   make(map[string]uint64),
+		},
+		LastModifiedAt: time.Now(),
+	}
+
+	return currentScan
+}
+
+// Function used by the coordinator of a distributed scan to record that all domains that
+// are due were split into batches. The information is stored in the checkpoints, so that
+// a coordinator interrupted while creating the batches continues creating them when the
+// scan is resumed
+func FinishCreatingScanBatches() {
+	shelterCurrentScanLock.Lock()
+	defer shelterCurrentScanLock.Unlock()
+
+	shelterCurrentScan.BatchesCreated = true
+	shelterCurrentScan.LastModifiedAt = time.Now()
+}
+
+// Function used by the coordinator of a distributed scan to replace the statistics of the
+// current scan with the statistics of the batches already committed by the workers. As
+// all committed batches are merged every time, the function can be called many times
+// while the workers are scanning. Returns true if errors were detected in any batch
+func StoreScanBatchesStatistics(batchesStatistics []ScanBatchStatistics) bool {
+	shelterCurrentScanLock.Lock()
+	defer shelterCurrentScanLock.Unlock()
+
+	hadErrors := false
+
+	scan := &shelterCurrentScan
+	scan.DomainsScanned = 0
+	scan.DomainsWithDNSSECScanned = 0
+	scan.DomainsPostponed = 0
+	scan.DomainsDropped = 0
+	scan.NameserverStatistics = make(map[string]uint64)
+	scan.DSStatistics = make(map[string]uint64)
+	scan.SeverityStatistics = make(map[string]uint64)
+	scan.RTTHistogram = make(RTTHistogram)
+
+	for _, statistics := range batchesStatistics {
+		hadErrors = hadErrors || statistics.HadErrors
+
+		scan.DomainsScanned += statistics.DomainsScanned
+		scan.DomainsWithDNSSECScanned += statistics.DomainsWithDNSSECScanned
+		scan.DomainsPostponed += statistics.DomainsPostponed
+		scan.DomainsDropped += statistics.DomainsDropped
+
+		for status, count := range statistics.NameserverStatistics {
+			scan.NameserverStatistics[status] += count
+		}
+
+		for status, count := range statistics.DSStatistics {
+			scan.DSStatistics[status] += count
+		}
+
+		for severity, count := range statistics.SeverityStatistics {
+			scan.SeverityStatistics[severity] += count
+		}
+
+		for bucket, samples := range statistics.RTTHistogram {
+			// Buckets that aren't numbers are ignored, as we can't know the round trip time
+			if rtt, err := strconv.ParseInt(bucket, 10, 64); err == nil {
+				scan.RTTHistogram[rtt] += samples
+			}
+		}
+	}
+
+	scan.RTTStatistics = scan.RTTHistogram.Statistics()
+	scan.LastModifiedAt = time.Now()
+	return hadErrors
+}
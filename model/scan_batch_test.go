@@ -0,0 +1,145 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package model describes the objects of the system
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanBatchStatusToString(t *testing.T) {
+	if ScanBatchStatusToString(ScanBatchStatusPending) != "PENDING" {
+		t.Error("Scan batch status pending not converted correctly")
+	}
+
+	if ScanBatchStatusToString(ScanBatchStatusLeased) != "LEASED" {
+		t.Error("Scan batch status leased not converted correctly")
+	}
+
+	if ScanBatchStatusToString(ScanBatchStatusCommitted) != "COMMITTED" {
+		t.Error("Scan batch status committed not converted correctly")
+	}
+
+	if ScanBatchStatusToString(ScanBatchStatusFailed) != "FAILED" {
+		t.Error("Scan batch status failed not converted correctly")
+	}
+
+	if ScanBatchStatusToString(ScanBatchStatus(9999)) != "" {
+		t.Error("Unknown scan batch status not converted correctly")
+	}
+}
+
+func TestFinishScanBatch(t *testing.T) {
+	StartNewScan()
+
+	LoadedDomainForScan()
+	FinishLoadingDomainsForScan()
+	FinishAnalyzingDomainForScan(true)
+	PostponedDomainForScan()
+
+	AddStatisticsOfTheScan(
+		map[string]uint64{NameserverStatusToString(NameserverStatusOK): 2},
+		map[string]uint64{DSStatusToString(DSStatusOK): 1},
+		map[string]uint64{},
+		RTTHistogram{15: 2},
+	)
+
+	currentScan := FinishScanBatch()
+	if currentScan.DomainsScanned != 1 || currentScan.FinishedAt.IsZero() {
+		t.Error("Not returning the scan of the batch")
+	}
+
+	if shelterCurrentScan.Status != ScanStatusWaitingExecution ||
+		shelterCurrentScan.DomainsScanned != 0 {
+
+		t.Error("Not waiting for the next batch")
+	}
+
+	statistics := NewScanBatchStatistics(currentScan, true)
+	if !statistics.HadErrors ||
+		statistics.DomainsScanned != 1 ||
+		statistics.DomainsWithDNSSECScanned != 1 ||
+		statistics.DomainsPostponed != 1 ||
+		statistics.NameserverStatistics["OK"] != 2 ||
+		statistics.RTTHistogram["15"] != 2 {
+
+		t.Errorf("Not building the statistics of the batch correctly: %+v", statistics)
+	}
+}
+
+func TestFinishCreatingScanBatches(t *testing.T) {
+	StartNewScan()
+
+	if shelterCurrentScan.BatchesCreated {
+		t.Error("Starting a scan with the batches already created")
+	}
+
+	FinishCreatingScanBatches()
+
+	if !shelterCurrentScan.BatchesCreated {
+		t.Error("Not recording that the batches were created")
+	}
+}
+
+func TestStoreScanBatchesStatistics(t *testing.T) {
+	StartNewScan()
+
+	batchesStatistics := []ScanBatchStatistics{
+		{
+			DomainsScanned:           10,
+			DomainsWithDNSSECScanned: 2,
+			DomainsDropped:           1,
+			NameserverStatistics:     map[string]uint64{"OK": 18, "TIMEOUT": 2},
+			DSStatistics:             map[string]uint64{"OK": 2},
+			SeverityStatistics:       map[string]uint64{"ERROR": 2},
+			RTTHistogram:             map[string]uint64{"10": 9, "100": 9},
+		},
+		{
+			HadErrors:            true,
+			DomainsScanned:       5,
+			DomainsPostponed:     3,
+			NameserverStatistics: map[string]uint64{"OK": 10},
+			RTTHistogram:         map[string]uint64{"10": 1, "100": 1, "xxx": 5},
+		},
+	}
+
+	if !StoreScanBatchesStatistics(batchesStatistics) {
+		t.Error("Not detecting errors in the batches")
+	}
+
+	// The statistics are replaced and not added, so we can merge the batches many times
+	StoreScanBatchesStatistics(batchesStatistics)
+
+	currentScan := GetCurrentScan()
+
+	if currentScan.DomainsScanned != 15 ||
+		currentScan.DomainsWithDNSSECScanned != 2 ||
+		currentScan.DomainsPostponed != 3 ||
+		currentScan.DomainsDropped != 1 {
+
+		t.Errorf("Not merging the counters of the batches: %+v", currentScan.Scan)
+	}
+
+	if currentScan.NameserverStatistics["OK"] != 28 ||
+		currentScan.NameserverStatistics["TIMEOUT"] != 2 ||
+		currentScan.DSStatistics["OK"] != 2 ||
+		currentScan.SeverityStatistics["ERROR"] != 2 {
+
+		t.Error("Not merging the statistics of the batches")
+	}
+
+	if currentScan.RTTStatistics.Samples != 20 ||
+		currentScan.RTTStatistics.P50 != 10*time.Millisecond ||
+		currentScan.RTTStatistics.Max != 100*time.Millisecond {
+
+		t.Errorf("Not merging the round trip times of the batches: %+v",
+			currentScan.RTTStatistics)
+	}
+
+	if StoreScanBatchesStatistics(batchesStatistics[:1]) {
+		t.Error("Detecting errors in batches without errors")
+	}
+}
@@ -7,6 +7,7 @@ package model
 
 import (
 	"errors"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2/bson"
 	"github.com/rafaeljusto/shelter/scheduler"
 	"sync"
 	"testing"
@@ -177,7 +178,33 @@ func TestFinishAnalyzingDomainForScan(t *testing.T) {
 	}
 }
 
-func TestStoreStatisticsOfTheScan(t *testing.T) {
+func TestPostponedAndDroppedDomainForScan(t *testing.T) {
+	StartNewScan()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			PostponedDomainForScan()
+			if i%4 == 0 {
+				DroppedDomainForScan()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if shelterCurrentScan.DomainsPostponed != 1000 {
+		t.Error("Not counting correctly the domains postponed in a concurrent enviroment")
+	}
+
+	if shelterCurrentScan.DomainsDropped != 250 {
+		t.Error("Not counting correctly the domains dropped in a concurrent enviroment")
+	}
+}
+
+func TestAddStatisticsOfTheScan(t *testing.T) {
 	StartNewScan()
 
 	nameserverStatistics := make(map[string]uint64)
@@ -189,7 +216,14 @@ func TestStoreStatisticsOfTheScan(t *testing.T) {
 	dsStatistics[DSStatusToString(DSStatusOK)] = 32
 	dsStatistics[DSStatusToString(DSStatusExpiredSignature)] = 7
 
-	StoreStatisticsOfTheScan(nameserverStatistics, dsStatistics)
+	severityStatistics := make(map[string]uint64)
+	severityStatistics[SeverityToString(SeverityError)] = 26
+	severityStatistics[SeverityToString(SeverityWarning)] = 4
+
+	rttHistogram := RTTHistogram{20: 300, 30: 256}
+
+	AddStatisticsOfTheScan(nameserverStatistics, dsStatistics, severityStatistics,
+		rttHistogram)
 
 	if len(shelterCurrentScan.NameserverStatistics) != 3 {
 		t.Error("Not storing namserver statistics")
@@ -198,6 +232,245 @@ func TestStoreStatisticsOfTheScan(t *testing.T) {
 	if len(shelterCurrentScan.DSStatistics) != 2 {
 		t.Error("Not storing DS statistics")
 	}
+
+	if len(shelterCurrentScan.SeverityStatistics) != 2 {
+		t.Error("Not storing severity statistics")
+	}
+
+	if shelterCurrentScan.RTTStatistics.Samples != 556 ||
+		shelterCurrentScan.RTTStatistics.P50 != 20*time.Millisecond {
+
+		t.Error("Not storing RTT statistics")
+	}
+
+	if len(shelterCurrentScan.RTTHistogram) != 2 {
+		t.Error("Not storing the RTT histogram")
+	}
+
+	currentScan := GetCurrentScan()
+
+	AddStatisticsOfTheScan(
+		map[string]uint64{NameserverStatusToString(NameserverStatusOK): 1},
+		map[string]uint64{},
+		map[string]uint64{},
+		RTTHistogram{20: 1},
+	)
+
+	if shelterCurrentScan.NameserverStatistics[NameserverStatusToString(NameserverStatusOK)] != 535 ||
+		shelterCurrentScan.RTTHistogram[20] != 301 ||
+		shelterCurrentScan.RTTStatistics.Samples != 557 {
+
+		t.Error("Not adding the statistics to the statistics already stored")
+	}
+
+	if currentScan.NameserverStatistics[NameserverStatusToString(NameserverStatusOK)] != 534 {
+		t.Error("Changing the statistics of a scan information already returned")
+	}
+}
+
+func TestCheckpointScan(t *testing.T) {
+	StartNewScan()
+
+	LoadedDomainForScan()
+	FinishAnalyzingDomainForScan(true)
+
+	if err := CheckpointScan(func(s *Scan) error {
+		if s.Status != ScanStatusLoadingData ||
+			s.DomainsScanned != 1 ||
+			s.DomainsWithDNSSECScanned != 1 {
+
+			t.Error("Not saving the progress of the scan")
+		}
+
+		s.Id = bson.NewObjectId()
+		s.Revision += 1
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(shelterCurrentScan.Id.Hex()) == 0 || shelterCurrentScan.Revision != 1 {
+		t.Error("Not keeping the database control attributes of the checkpoint")
+	}
+
+	if err := CheckpointScan(func(s *Scan) error {
+		s.Revision += 1
+		return errors.New("Error saving scan!")
+	}); err == nil {
+		t.Error("Not returning err detected by save method")
+	}
+
+	if shelterCurrentScan.Revision != 1 {
+		t.Error("Keeping the database control attributes of a checkpoint not saved")
+	}
+
+	FinishLoadingDomainsForScan()
+	FinishAndSaveScan(false, func(s *Scan) error {
+		if s.Revision != 1 {
+			t.Error("Not saving the scan in the same entry of the checkpoints")
+		}
+		return nil
+	})
+
+	if err := CheckpointScan(func(s *Scan) error {
+		t.Error("Saving the progress when there's no scan being executed")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResumeScan(t *testing.T) {
+	scan := Scan{
+		Id:             bson.NewObjectId(),
+		Revision:       3,
+		Status:         ScanStatusRunning,
+		StartedAt:      time.Now().Add(-time.Hour),
+		DomainsScanned: 10,
+		RTTHistogram:   RTTHistogram{10: 20},
+	}
+
+	ResumeScan(scan)
+
+	if shelterCurrentScan.Status != ScanStatusLoadingData ||
+		shelterCurrentScan.Id != scan.Id ||
+		shelterCurrentScan.Revision != 3 ||
+		!shelterCurrentScan.StartedAt.Equal(scan.StartedAt) ||
+		shelterCurrentScan.DomainsScanned != 10 ||
+		shelterCurrentScan.DomainsToBeScanned != 10 {
+
+		t.Error("Not restoring the scan information from the checkpoint")
+	}
+
+	if shelterCurrentScan.NameserverStatistics == nil ||
+		shelterCurrentScan.DSStatistics == nil ||
+		shelterCurrentScan.SeverityStatistics == nil {
+
+		t.Fatal("Not initializing the statistics of the resumed scan")
+	}
+
+	AddStatisticsOfTheScan(nil, nil, nil, RTTHistogram{10: 5})
+	if shelterCurrentScan.RTTStatistics.Samples != 25 {
+		t.Error("Not adding the statistics to the statistics of the checkpoint")
+	}
+}
+
+func TestPauseContinueAndCancelScan(t *testing.T) {
+	shelterCurrentScan = CurrentScan{}
+	if PauseScan() || ContinuePausedScan() || CancelScan() {
+		t.Fatal("Changing the status of a scan that isn't executing")
+	}
+
+	StartNewScan()
+
+	if !PauseScan() || shelterCurrentScan.Status != ScanStatusPaused {
+		t.Fatal("Not pausing the scan")
+	}
+
+	if PauseScan() {
+		t.Error("Pausing a scan that is already paused")
+	}
+
+	// The status of the paused scan is only changed when the scan continues
+	FinishLoadingDomainsForScan()
+	if shelterCurrentScan.Status != ScanStatusPaused {
+		t.Error("Not keeping the paused status when the domains are loaded")
+	}
+
+	if !ContinuePausedScan() || shelterCurrentScan.Status != ScanStatusRunning {
+		t.Error("Not continuing the paused scan with the status before the pause")
+	}
+
+	if ContinuePausedScan() {
+		t.Error("Continuing a scan that isn't paused")
+	}
+
+	if !CancelScan() || shelterCurrentScan.Status != ScanStatusCanceled {
+		t.Fatal("Not canceling the scan")
+	}
+
+	if CancelScan() || PauseScan() {
+		t.Error("Changing the status of a canceled scan")
+	}
+
+	if len(shelterCurrentScan.StatusChanges) != 3 ||
+		shelterCurrentScan.StatusChanges[0].Status != ScanStatusPaused ||
+		shelterCurrentScan.StatusChanges[1].Status != ScanStatusRunning ||
+		shelterCurrentScan.StatusChanges[2].Status != ScanStatusCanceled ||
+		shelterCurrentScan.StatusChanges[2].ChangedAt.IsZero() {
+
+		t.Error("Not recording the status changes of the scan")
+	}
+
+	scheduler.Register(scheduler.Job{
+		Type:          scheduler.JobTypeScan,
+		NextExecution: time.Now().Add(10 * time.Minute),
+		Task:          func() {},
+	})
+	defer scheduler.Clear()
+
+	if err := FinishAndSaveScan(false, func(s *Scan) error {
+		if s.Status != ScanStatusCanceled || len(s.StatusChanges) != 3 {
+			t.Error("Not saving the canceled scan with the status changes")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRTTHistogramBSON(t *testing.T) {
+	data, err := bson.Marshal(Scan{
+		Id:           bson.NewObjectId(),
+		RTTHistogram: RTTHistogram{10: 2, 250: 1},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scan Scan
+	if err := bson.Unmarshal(data, &scan); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scan.RTTHistogram) != 2 ||
+		scan.RTTHistogram[10] != 2 ||
+		scan.RTTHistogram[250] != 1 {
+
+		t.Errorf("Not storing the RTT histogram: %v", scan.RTTHistogram)
+	}
+}
+
+func TestRTTHistogram(t *testing.T) {
+	histogram := make(RTTHistogram)
+
+	if statistics := histogram.Statistics(); statistics.Samples != 0 {
+		t.Error("Building statistics without round trip times")
+	}
+
+	histogram.Add(0)
+	for i := 1; i <= 100; i++ {
+		histogram.Add(time.Duration(i)*time.Millisecond + 300*time.Microsecond)
+	}
+
+	statistics := histogram.Statistics()
+
+	if statistics.Samples != 100 {
+		t.Errorf("Wrong number of samples. Expected 100 and got %d", statistics.Samples)
+	}
+
+	if statistics.P50 != 50*time.Millisecond ||
+		statistics.P90 != 90*time.Millisecond ||
+		statistics.P99 != 99*time.Millisecond ||
+		statistics.Max != 100*time.Millisecond {
+
+		t.Errorf("Wrong percentiles: %+v", statistics)
+	}
+
+	if statistics.Average != 50*time.Millisecond {
+		t.Errorf("Wrong average. Expected 50ms and got %s", statistics.Average)
+	}
 }
 
 func TestGetCurrentScan(t *testing.T) {
@@ -246,6 +519,18 @@ func TestScanStatusToString(t *testing.T) {
 		t.Error("Scan status WAITINGEXECUTION not converting correctly to string")
 	}
 
+	if ScanStatusToString(ScanStatusAborted) != "ABORTED" {
+		t.Error("Scan status ABORTED not converting correctly to string")
+	}
+
+	if ScanStatusToString(ScanStatusPaused) != "PAUSED" {
+		t.Error("Scan status PAUSED not converting correctly to string")
+	}
+
+	if ScanStatusToString(ScanStatusCanceled) != "CANCELED" {
+		t.Error("Scan status CANCELED not converting correctly to string")
+	}
+
 	if ScanStatusToString(999999) != "" {
 		t.Error("Unknown scan status associated to some existing status")
 	}
@@ -151,7 +151,10 @@ func (h *DomainVerificationHandler) Put(w http.ResponseWriter, r *http.Request)
 	if dbDomain, err := domainDAO.FindByFQDN(domain.FQDN); err == nil {
 		update := true
 
-		// Check if we have the same nameservers, and if so update the last status
+		// Check if we have the same nameservers, and if so update the last status and the
+		// results of each address and probe, so that the stored domain has the same details
+		// of a domain checked by the scan. The findings keep the first detection date of the
+		// stored ones
 		if len(dbDomain.Nameservers) == len(domain.Nameservers) {
 			for i := range dbDomain.Nameservers {
 				dbNameserver := dbDomain.Nameservers[i]
@@ -162,6 +165,16 @@ func (h *DomainVerificationHandler) Put(w http.ResponseWriter, r *http.Request)
 					dbNameserver.IPv6.Equal(nameserver.IPv6) {
 
 					dbDomain.Nameservers[i].ChangeStatus(nameserver.LastStatus)
+					dbDomain.Nameservers[i].Diagnostic = nameserver.Diagnostic
+					dbDomain.Nameservers[i].Addresses = nameserver.Addresses
+					dbDomain.Nameservers[i].EDNSTests = nameserver.EDNSTests
+					dbDomain.Nameservers[i].SOASerial = nameserver.SOASerial
+					dbDomain.Nameservers[i].LastRTT = nameserver.LastRTT
+					dbDomain.Nameservers[i].UDPPayloadLimit = nameserver.UDPPayloadLimit
+					dbDomain.Nameservers[i].DNSKEYTags = nameserver.DNSKEYTags
+					dbDomain.Nameservers[i].Warnings = nameserver.Warnings
+					dbDomain.Nameservers[i].Findings = model.KeepFirstSeen(
+						dbNameserver.Findings, nameserver.Findings)
 
 				} else {
 					update = false
@@ -173,7 +186,8 @@ func (h *DomainVerificationHandler) Put(w http.ResponseWriter, r *http.Request)
 			update = false
 		}
 
-		// Check if we have the same DS set, and if so update the last status
+		// Check if we have the same DS set, and if so update the last status and the results
+		// of each nameserver
 		if len(dbDomain.DSSet) == len(domain.DSSet) {
 			for i := range dbDomain.DSSet {
 				dbDS := dbDomain.DSSet[i]
@@ -185,6 +199,10 @@ func (h *DomainVerificationHandler) Put(w http.ResponseWriter, r *http.Request)
 					dbDS.Digest == ds.Digest {
 
 					dbDomain.DSSet[i].ChangeStatus(ds.LastStatus)
+					dbDomain.DSSet[i].Diagnostic = ds.Diagnostic
+					dbDomain.DSSet[i].ExpiresAt = ds.ExpiresAt
+					dbDomain.DSSet[i].Nameservers = ds.Nameservers
+					dbDomain.DSSet[i].Findings = model.KeepFirstSeen(dbDS.Findings, ds.Findings)
 
 				} else {
 					update = false
@@ -196,6 +214,13 @@ func (h *DomainVerificationHandler) Put(w http.ResponseWriter, r *http.Request)
 			update = false
 		}
 
+		// The comparison with the parent zone doesn't depend on the registered DS set, as the
+		// parent zone can publish DS records for unsigned domains
+		if !domain.ParentDS.LastCheckAt.IsZero() {
+			dbDomain.ParentDS.ChangeStatus(domain.ParentDS.LastStatus,
+				domain.ParentDS.ExtraDSSet, domain.ParentDS.Diagnostic)
+		}
+
 		if update {
 			// We don't care about errors resulted here, because the main idea of this service is to scan
 			// a domaion, not persist the results
@@ -13,6 +13,7 @@ import (
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2"
 	"github.com/rafaeljusto/shelter/dao"
 	"github.com/rafaeljusto/shelter/log"
+	"github.com/rafaeljusto/shelter/model"
 	"github.com/rafaeljusto/shelter/net/http/rest/interceptor"
 	"github.com/rafaeljusto/shelter/net/http/rest/messages"
 	"github.com/rafaeljusto/shelter/net/http/rest/protocol"
@@ -106,6 +107,7 @@ func (h *DomainsHandler) retrieveDomains(w http.ResponseWriter, r *http.Request)
 	var pagination dao.DomainDAOPagination
 	expand := false
 	filter := ""
+	var severities []model.Severity
 
 	for key, values := range r.URL.Query() {
 		key = strings.TrimSpace(key)
@@ -213,6 +215,26 @@ func (h *DomainsHandler) retrieveDomains(w http.ResponseWriter, r *http.Request)
 
 			case "filter":
 				filter = value
+
+			case "severity":
+				// Severity parameter will store the severities of the findings that the domains
+				// must have to be in the result set, separated by comma (e.g. warning,error)
+				severities = nil
+				for _, severityText := range strings.Split(value, ",") {
+					severity, ok := model.SeverityFromString(severityText)
+					if !ok {
+						if err := h.MessageResponse("invalid-query-severity", ""); err == nil {
+							w.WriteHeader(http.StatusBadRequest)
+
+						} else {
+							log.Println("Error while writing response. Details:", err)
+							w.WriteHeader(http.StatusInternalServerError)
+						}
+						return
+					}
+
+					severities = append(severities, severity)
+				}
 			}
 		}
 	}
@@ -221,14 +243,15 @@ func (h *DomainsHandler) retrieveDomains(w http.ResponseWriter, r *http.Request)
 		Database: h.GetDatabase(),
 	}
 
-	domains, err := domainDAO.FindAll(&pagination, expand, filter)
+	domains, err := domainDAO.FindAll(&pagination, expand, filter, severities)
 	if err != nil {
 		log.Println("Error while filtering domains objects. Details:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	domainsResponse := protocol.ToDomainsResponse(domains, pagination, expand, filter)
+	domainsResponse := protocol.ToDomainsResponse(domains, pagination, expand, filter,
+		severities)
 	h.Response = &domainsResponse
 
 	// Last-Modified is going to be the most recent date of the list
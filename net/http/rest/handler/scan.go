@@ -8,10 +8,12 @@ package handler
 import (
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/rafaeljusto/handy"
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2"
+	"github.com/rafaeljusto/shelter/log"
 	"github.com/rafaeljusto/shelter/model"
 	"github.com/rafaeljusto/shelter/net/http/rest/interceptor"
 	"github.com/rafaeljusto/shelter/net/http/rest/messages"
 	"github.com/rafaeljusto/shelter/net/http/rest/protocol"
+	"github.com/rafaeljusto/shelter/net/scan"
 	"net/http"
 	"strconv"
 	"time"
@@ -23,15 +25,20 @@ func init() {
 	})
 }
 
-// ScanHandler is responsable for keeping the state of a /scan/{started-at} resource
+// ScanHandler is responsable for keeping the state of a /scan/{started-at} resource. The
+// scan in progress is identified by the special word "current" instead of the start date,
+// and it's the only scan that can be changed by the user
 type ScanHandler struct {
 	handy.DefaultHandler                           // Inject the HTTP methods that this resource does not implement
 	database             *mgo.Database             // Database connection of the MongoDB session
 	databaseSession      *mgo.Session              // MongoDB session
 	scan                 model.Scan                // Scan object related to the resource
+	currentScan          model.CurrentScan         // Scan in progress, when requested by the user
+	current              bool                      // Flag that indicates that the scan in progress was requested
 	language             *messages.LanguagePack    // User preferred language based on HTTP header
 	StartedAt            string                    `param:"started-at"` // Scan start date in the URI
-	Response             *protocol.ScanResponse    `response:"get"`     // Scan response sent back to the user
+	Request              protocol.ScanRequest      `request:"put"`      // Scan changes sent by the user
+	Response             *protocol.ScanResponse    `response:"get,put"` // Scan response sent back to the user
 	Message              *protocol.MessageResponse `error`              // Message on error sent to the user
 }
 
@@ -55,11 +62,24 @@ func (h *ScanHandler) SetScan(scan model.Scan) {
 	h.scan = scan
 }
 
+func (h *ScanHandler) SetCurrentScan(currentScan model.CurrentScan) {
+	h.currentScan = currentScan
+	h.current = true
+}
+
 func (h *ScanHandler) GetLastModifiedAt() time.Time {
+	if h.current {
+		return h.currentScan.LastModifiedAt
+	}
+
 	return h.scan.LastModifiedAt
 }
 
 func (h *ScanHandler) GetETag() string {
+	if h.current {
+		return strconv.Itoa(h.currentScan.Revision)
+	}
+
 	return strconv.Itoa(h.scan.Revision)
 }
 
@@ -93,12 +113,103 @@ func (h *ScanHandler) Head(w http.ResponseWriter, r *http.Request) {
 	h.retrieveScan(w, r)
 }
 
+// Pause, resume or cancel the scan in progress, and change the number of queriers or the
+// queries per second while the scan is executing. The scans that already finished can't be
+// changed
+func (h *ScanHandler) Put(w http.ResponseWriter, r *http.Request) {
+	if !h.current {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// We check all the changes before applying them, so that an invalid request doesn't
+	// change the scan partially
+	messageId := ""
+
+	switch h.Request.Action {
+	case "", protocol.ScanActionPause, protocol.ScanActionResume, protocol.ScanActionCancel:
+	default:
+		messageId = "invalid-scan-action"
+	}
+
+	if h.Request.NumberOfQueriers != nil && *h.Request.NumberOfQueriers <= 0 {
+		messageId = "invalid-number-of-queriers"
+	}
+
+	if h.Request.QueriesPerSecond != nil && *h.Request.QueriesPerSecond < 0 {
+		messageId = "invalid-queries-per-second"
+	}
+
+	if len(messageId) > 0 {
+		if err := h.MessageResponse(messageId, r.URL.RequestURI()); err == nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+		} else {
+			log.Println("Error while writing response. Details:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := h.changeScan(); err == scan.ErrScanNotExecuting {
+		if err := h.MessageResponse("scan-not-executing", r.URL.RequestURI()); err == nil {
+			w.WriteHeader(http.StatusConflict)
+
+		} else {
+			log.Println("Error while writing response. Details:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+
+	} else if err != nil {
+		log.Println("Error while changing the current scan. Details:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Return the scan with the new status, so that the user can follow the changes
+	h.currentScan = model.GetCurrentScan()
+	h.retrieveScan(w, r)
+}
+
+// Apply the changes requested by the user in the scan in progress
+func (h *ScanHandler) changeScan() error {
+	if h.Request.NumberOfQueriers != nil {
+		if err := scan.ChangeNumberOfQueriers(*h.Request.NumberOfQueriers); err != nil {
+			return err
+		}
+	}
+
+	if h.Request.QueriesPerSecond != nil {
+		if err := scan.ChangeQueriesPerSecond(*h.Request.QueriesPerSecond); err != nil {
+			return err
+		}
+	}
+
+	switch h.Request.Action {
+	case protocol.ScanActionPause:
+		return scan.PauseScan()
+	case protocol.ScanActionResume:
+		return scan.ContinueScan()
+	case protocol.ScanActionCancel:
+		return scan.CancelScan()
+	}
+
+	return nil
+}
+
 func (h *ScanHandler) retrieveScan(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("ETag", strconv.Itoa(h.scan.Revision))
-	w.Header().Add("Last-Modified", h.scan.LastModifiedAt.Format(time.RFC1123))
+	w.Header().Add("ETag", h.GetETag())
+	w.Header().Add("Last-Modified", h.GetLastModifiedAt().Format(time.RFC1123))
 	w.WriteHeader(http.StatusOK)
 
-	scanResponse := protocol.ScanToScanResponse(h.scan)
+	var scanResponse protocol.ScanResponse
+	if h.current {
+		scanResponse = protocol.CurrentScanToScanResponse(h.currentScan)
+	} else {
+		scanResponse = protocol.ScanToScanResponse(h.scan)
+	}
+
 	h.Response = &scanResponse
 }
 
@@ -27,6 +27,7 @@ type ScanHandler interface {
 	DatabaseHandler
 	GetStartedAt() string
 	SetScan(scan model.Scan)
+	SetCurrentScan(currentScan model.CurrentScan)
 	MessageResponse(string, string) error
 }
 
@@ -40,6 +41,13 @@ func NewScan(h ScanHandler) *Scan {
 }
 
 func (i *Scan) Before(w http.ResponseWriter, r *http.Request) {
+	// The scan in progress isn't identified by a date yet, so it's retrieved from the scan
+	// information structure instead of the database
+	if isCurrentScan.MatchString(i.scanHandler.GetStartedAt()) {
+		i.scanHandler.SetCurrentScan(model.GetCurrentScan())
+		return
+	}
+
 	date, err := time.Parse(time.RFC3339Nano, strings.ToUpper(i.scanHandler.GetStartedAt()))
 
 	if err != nil {
@@ -0,0 +1,37 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package protocol describes the REST protocol
+package protocol
+
+import (
+	"github.com/rafaeljusto/shelter/model"
+)
+
+// Diagnostic object used in the protocol to show the details of the problem detected in
+// the last configuration check of a nameserver or DS record
+type DiagnosticResponse struct {
+	Address string   `json:"address,omitempty"` // Address of the nameserver that received the query
+	Query   string   `json:"query,omitempty"`   // Question of the query that detected the problem
+	Rcode   string   `json:"rcode,omitempty"`   // Response code of the answer
+	Error   string   `json:"error,omitempty"`   // Description of the problem
+	Records []string `json:"records,omitempty"` // Records of the answer related to the problem
+}
+
+// Convert a diagnostic of the system into a format with limited information to return it
+// to the user. Returns nil when the diagnostic is empty, so that it's omitted from the
+// response
+func toDiagnosticResponse(diagnostic model.Diagnostic) *DiagnosticResponse {
+	if diagnostic.Empty() {
+		return nil
+	}
+
+	return &DiagnosticResponse{
+		Address: diagnostic.Address,
+		Query:   diagnostic.Query,
+		Rcode:   diagnostic.Rcode,
+		Error:   diagnostic.Error,
+		Records: diagnostic.Records,
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package protocol describes the REST protocol
+package protocol
+
+import (
+	"github.com/rafaeljusto/shelter/model"
+	"testing"
+)
+
+func TestToDiagnosticResponse(t *testing.T) {
+	if toDiagnosticResponse(model.Diagnostic{}) != nil {
+		t.Error("Converting an empty diagnostic")
+	}
+
+	diagnosticResponse := toDiagnosticResponse(model.Diagnostic{
+		Address: "192.0.2.1:53",
+		Query:   "example.com.br. IN SOA",
+		Rcode:   "SERVFAIL",
+		Error:   "Response with the SERVFAIL code",
+		Records: []string{"example.com.br.\t86400\tIN\tCNAME\texample.net.br."},
+	})
+
+	if diagnosticResponse == nil {
+		t.Fatal("Not converting the diagnostic")
+	}
+
+	if diagnosticResponse.Address != "192.0.2.1:53" ||
+		diagnosticResponse.Query != "example.com.br. IN SOA" ||
+		diagnosticResponse.Rcode != "SERVFAIL" ||
+		diagnosticResponse.Error != "Response with the SERVFAIL code" ||
+		len(diagnosticResponse.Records) != 1 {
+
+		t.Error("Fail to convert diagnostic")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/code.google.com/p/go.net/idna"
 	"github.com/rafaeljusto/shelter/model"
 	"strings"
+	"time"
 )
 
 // List of possible errors that can occur when calling methods from this object. Other
@@ -55,19 +56,9 @@ func Merge(domain model.Domain, domainRequest DomainRequest) (model.Domain, erro
 		return domain, err
 	}
 
-	for index, userNameserver := range nameservers {
-		for _, nameserver := range domain.Nameservers {
-			if nameserver.Host == userNameserver.Host {
-				// Found the same nameserver in the user domain object, maybe the user updated the
-				// IP addresses
-				nameserver.IPv4 = userNameserver.IPv4
-				nameserver.IPv6 = userNameserver.IPv6
-				nameservers[index] = nameserver
-				break
-			}
-		}
-	}
-	domain.Nameservers = nameservers
+	// Keep the results of the last checks of the nameservers that the user didn't remove,
+	// maybe the user only updated the IP addresses
+	domain.MergeNameservers(nameservers)
 
 	dsSet, err := toDSSetModel(domainRequest.DSSet)
 	if err != nil {
@@ -94,6 +85,10 @@ func Merge(domain model.Domain, domainRequest DomainRequest) (model.Domain, erro
 	}
 	domain.DSSet = dsSet
 
+	// The user could have changed the nameservers or the DS set, so the domain is checked
+	// again in the next scan with the new configuration
+	domain.NextCheckAt = time.Time{}
+
 	// We can replace the whole structure of the e-mail every time that a new UPDATE arrives
 	// because there's no extra information in server side that we need to keep
 	domain.Owners, err = toOwnersModel(domainRequest.Owners)
@@ -109,10 +104,17 @@ func Merge(domain model.Domain, domainRequest DomainRequest) (model.Domain, erro
 // revision (ETag)
 type DomainResponse struct {
 	FQDN        string               `json:"fqdn"`                  // Actual domain name
+	Severity    string               `json:"severity,omitempty"`    // Most important severity of the findings
 	Nameservers []NameserverResponse `json:"nameservers,omitempty"` // Nameservers that asnwer with authority for this domain
 	DSSet       []DSResponse         `json:"dsset,omitempty"`       // Records for the DNS tree chain of trust
+	ParentDS    *ParentDSResponse    `json:"parentDS,omitempty"`    // Comparison of the DS set with the parent zone
 	Owners      []OwnerResponse      `json:"owners,omitempty"`      // E-mails that will be alerted on any problem
 	Links       []Link               `json:"links,omitempty"`       // Links to manipulate object
+	NextCheckAt time.Time            `json:"nextCheckAt,omitempty"` // When the domain is going to be checked again
+
+	// Nameserver update requested by the child zone (CSYNC record) that wasn't applied
+	// automatically. The owners can approve it updating the domain with these nameservers
+	ProposedNameservers []NameserverResponse `json:"proposedNameservers,omitempty"`
 }
 
 // Convert the domain system object to a limited information user format. We have a persisted flag
@@ -145,11 +147,25 @@ func ToDomainResponse(domain model.Domain, persisted bool) DomainResponse {
 		fqdn = strings.ToLower(domain.FQDN)
 	}
 
+	var proposedNameservers []NameserverResponse
+	if domain.CSYNC.LastStatus == model.CSYNCStatusValid {
+		proposedNameservers = toNameserversResponse(domain.CSYNC.Nameservers)
+	}
+
+	severity := ""
+	if maxSeverity, ok := model.MaxSeverity(domain.Findings()); ok {
+		severity = model.SeverityToString(maxSeverity)
+	}
+
 	return DomainResponse{
-		FQDN:        fqdn,
-		Nameservers: toNameserversResponse(domain.Nameservers),
-		DSSet:       toDSSetResponse(domain.DSSet),
-		Owners:      toOwnersResponse(domain.Owners),
-		Links:       links,
+		FQDN:                fqdn,
+		Severity:            severity,
+		Nameservers:         toNameserversResponse(domain.Nameservers),
+		DSSet:               toDSSetResponse(domain.DSSet),
+		ParentDS:            toParentDSResponse(domain.ParentDS),
+		Owners:              toOwnersResponse(domain.Owners),
+		Links:               links,
+		NextCheckAt:         domain.NextCheckAt,
+		ProposedNameservers: proposedNameservers,
 	}
 }
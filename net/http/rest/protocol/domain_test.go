@@ -10,6 +10,7 @@ import (
 	"net/mail"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rafaeljusto/shelter/model"
 )
@@ -95,6 +96,7 @@ func TestMerge(t *testing.T) {
 				Language: fmt.Sprintf("%s-%s", model.LanguageTypePT, model.RegionTypeBR),
 			},
 		},
+		NextCheckAt: time.Now().Add(24 * time.Hour),
 	}
 
 	domain, err = Merge(domain, domainRequest)
@@ -123,6 +125,10 @@ func TestMerge(t *testing.T) {
 		t.Error("Fail to replace owners")
 	}
 
+	if !domain.NextCheckAt.IsZero() {
+		t.Error("Not scheduling the updated domain to the next scan")
+	}
+
 	domainRequest = DomainRequest{
 		FQDN: strings.Repeat("x", 65536) + "\uff00", // int32 overflow
 	}
@@ -257,6 +263,7 @@ func TestToDomainResponse(t *testing.T) {
 		},
 	}
 
+	domain.NextCheckAt = time.Now().Add(24 * time.Hour)
 	domainResponse := ToDomainResponse(domain, true)
 
 	if domainResponse.FQDN != "exâmplé.com.br." {
@@ -282,6 +289,38 @@ func TestToDomainResponse(t *testing.T) {
 		t.Error("Wrong number of links")
 	}
 
+	if !domainResponse.NextCheckAt.Equal(domain.NextCheckAt) {
+		t.Error("Fail to convert the next check date")
+	}
+
+	if len(domainResponse.ProposedNameservers) != 0 {
+		t.Error("Returning proposed nameservers without a CSYNC record")
+	}
+
+	if len(domainResponse.Severity) != 0 {
+		t.Error("Returning a severity without findings")
+	}
+
+	domain.Nameservers[0].Findings = []model.Finding{
+		{Code: "SLOW", Severity: model.SeverityWarning},
+	}
+	domainResponse = ToDomainResponse(domain, true)
+
+	if domainResponse.Severity != "WARNING" ||
+		len(domainResponse.Nameservers[0].Findings) != 1 {
+		t.Error("Fail to convert the findings of the domain")
+	}
+
+	domain.CSYNC.ChangeStatus(model.CSYNCStatusValid, 2015010101, 0, []model.Nameserver{
+		{Host: "ns2.example.com.br."},
+	})
+	domainResponse = ToDomainResponse(domain, true)
+
+	if len(domainResponse.ProposedNameservers) != 1 ||
+		domainResponse.ProposedNameservers[0].Host != "ns2.example.com.br." {
+		t.Error("Fail to convert the nameservers proposed by the CSYNC record")
+	}
+
 	domainResponse = ToDomainResponse(domain, false)
 
 	if len(domainResponse.Links) != 0 {
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"github.com/rafaeljusto/shelter/dao"
 	"github.com/rafaeljusto/shelter/model"
+	"strings"
 )
 
 // DomainsResponse store multiple domains objects with pagination support
@@ -27,6 +28,7 @@ func ToDomainsResponse(
 	pagination dao.DomainDAOPagination,
 	expand bool,
 	filter string,
+	severities []model.Severity,
 ) DomainsResponse {
 
 	var domainsResponses []DomainResponse
@@ -51,6 +53,16 @@ func ToDomainsResponse(
 		expandParameter = "&expand"
 	}
 
+	if len(severities) > 0 {
+		var severitiesText []string
+		for _, severity := range severities {
+			severitiesText = append(severitiesText,
+				strings.ToLower(model.SeverityToString(severity)))
+		}
+
+		expandParameter += "&severity=" + strings.Join(severitiesText, ",")
+	}
+
 	// Add pagination managment links to the response. The URI is hard coded, I didn't have
 	// any idea on how can we do this dynamically yet. We cannot get the URI from the
 	// handler because we are going to have a cross-reference problem
@@ -8,6 +8,7 @@ package protocol
 import (
 	"github.com/rafaeljusto/shelter/dao"
 	"github.com/rafaeljusto/shelter/model"
+	"strings"
 	"testing"
 )
 
@@ -47,7 +48,7 @@ func TestToDomainsResponse(t *testing.T) {
 		NumberOfPages: len(domains) / 10,
 	}
 
-	domainsResponse := ToDomainsResponse(domains, pagination, true, "example")
+	domainsResponse := ToDomainsResponse(domains, pagination, true, "example", nil)
 
 	if len(domainsResponse.Domains) != len(domains) {
 		t.Error("Not converting domain model objects properly")
@@ -111,7 +112,7 @@ func TestToDomainsResponseLinks(t *testing.T) {
 		NumberOfPages: 3,
 	}
 
-	domainsResponse := ToDomainsResponse(domains, pagination, true, "example")
+	domainsResponse := ToDomainsResponse(domains, pagination, true, "example", nil)
 
 	// Show all actions when navigating in the middle of the pagination
 	if len(domainsResponse.Links) != 4 {
@@ -135,7 +136,7 @@ func TestToDomainsResponseLinks(t *testing.T) {
 		NumberOfPages: 3,
 	}
 
-	domainsResponse = ToDomainsResponse(domains, pagination, true, "example")
+	domainsResponse = ToDomainsResponse(domains, pagination, true, "example", nil)
 
 	// Don't show previous or fast backward when we are in the first page
 	if len(domainsResponse.Links) != 2 {
@@ -159,10 +160,19 @@ func TestToDomainsResponseLinks(t *testing.T) {
 		NumberOfPages: 3,
 	}
 
-	domainsResponse = ToDomainsResponse(domains, pagination, true, "example")
+	domainsResponse = ToDomainsResponse(domains, pagination, true, "example", nil)
 
 	// Don't show next or fast foward when we are in the last page
 	if len(domainsResponse.Links) != 2 {
 		t.Error("Response not adding the necessary links when we are in the last page")
 	}
+
+	domainsResponse = ToDomainsResponse(domains, pagination, true, "example",
+		[]model.Severity{model.SeverityWarning, model.SeverityError})
+
+	if len(domainsResponse.Links) == 0 ||
+		!strings.HasSuffix(domainsResponse.Links[0].HRef, "&expand&severity=warning,error") {
+
+		t.Error("Response not keeping the severity filter in the links")
+	}
 }
@@ -70,25 +70,52 @@ func toDSSetModel(dsSetRequest []DSRequest) ([]model.DS, error) {
 // DS object used in the protocol to determinate what the user can see. The status was
 // converted to text format for easy interpretation
 type DSResponse struct {
-	Keytag      uint16    `json:"keytag,omitempty"`      // DNSKEY's identification number
-	Algorithm   uint8     `json:"algorithm,omitempty"`   // DNSKEY's algorithm
-	Digest      string    `json:"digest,omitempty"`      // Hash of the DNSKEY content
-	DigestType  uint8     `json:"digestType,omitempty"`  // Hash type decided by user when generating the DS
-	ExpiresAt   time.Time `json:"expiresAt,omitempty"`   // DNSKEY's signature expiration date
-	LastStatus  string    `json:"lastStatus,omitempty"`  // Result of the last configuration check
-	LastCheckAt time.Time `json:"lastCheckAt,omitempty"` // Time of the last configuration check
-	LastOKAt    time.Time `json:"lastOKAt,omitempty"`    // Last time that the DNSSEC configuration was OK
+	Keytag      uint16                 `json:"keytag,omitempty"`      // DNSKEY's identification number
+	Algorithm   uint8                  `json:"algorithm,omitempty"`   // DNSKEY's algorithm
+	Digest      string                 `json:"digest,omitempty"`      // Hash of the DNSKEY content
+	DigestType  uint8                  `json:"digestType,omitempty"`  // Hash type decided by user when generating the DS
+	ExpiresAt   time.Time              `json:"expiresAt,omitempty"`   // DNSKEY's signature expiration date
+	Nameservers []DSNameserverResponse `json:"nameservers,omitempty"` // Result of the last configuration check in each nameserver
+	Findings    []FindingResponse      `json:"findings,omitempty"`    // Problems detected in the last check
+	Diagnostic  *DiagnosticResponse    `json:"diagnostic,omitempty"`  // Details of the problem of the nameserver with the worst result
+	LastStatus  string                 `json:"lastStatus,omitempty"`  // Result of the last configuration check
+	LastCheckAt time.Time              `json:"lastCheckAt,omitempty"` // Time of the last configuration check
+	LastOKAt    time.Time              `json:"lastOKAt,omitempty"`    // Last time that the DNSSEC configuration was OK
+}
+
+// DS nameserver object used in the protocol to show the result of the DNSSEC configuration
+// check in each nameserver of the domain
+type DSNameserverResponse struct {
+	Host        string              `json:"host,omitempty"`        // Nameserver's name
+	ExpiresAt   time.Time           `json:"expiresAt,omitempty"`   // DNSKEY's signature expiration date found in the nameserver
+	Diagnostic  *DiagnosticResponse `json:"diagnostic,omitempty"`  // Details of the problem detected in the nameserver
+	LastStatus  string              `json:"lastStatus,omitempty"`  // Result of the last configuration check
+	LastCheckAt time.Time           `json:"lastCheckAt,omitempty"` // Time of the last configuration check
 }
 
 // Convert a DS of the system into a format with limited information to return it to the
 // user
 func toDSResponse(ds model.DS) DSResponse {
+	var nameservers []DSNameserverResponse
+	for _, nameserver := range ds.Nameservers {
+		nameservers = append(nameservers, DSNameserverResponse{
+			Host:        nameserver.Host,
+			ExpiresAt:   nameserver.ExpiresAt,
+			Diagnostic:  toDiagnosticResponse(nameserver.Diagnostic),
+			LastStatus:  model.DSStatusToString(nameserver.LastStatus),
+			LastCheckAt: nameserver.LastCheckAt,
+		})
+	}
+
 	return DSResponse{
 		Keytag:      ds.Keytag,
 		Algorithm:   uint8(ds.Algorithm),
 		Digest:      ds.Digest,
 		DigestType:  uint8(ds.DigestType),
 		ExpiresAt:   ds.ExpiresAt,
+		Nameservers: nameservers,
+		Findings:    toFindingsResponse(ds.Findings),
+		Diagnostic:  toDiagnosticResponse(ds.Diagnostic),
 		LastStatus:  model.DSStatusToString(ds.LastStatus),
 		LastCheckAt: ds.LastCheckAt,
 		LastOKAt:    ds.LastOKAt,
@@ -104,3 +131,40 @@ func toDSSetResponse(dsSet []model.DS) []DSResponse {
 	}
 	return dsSetResponse
 }
+
+// Result of the comparison between the registered DS set and the DS records published by
+// the parent zone for the domain
+type ParentDSResponse struct {
+	ExtraDSSet  []DSResponse        `json:"extraDSSet,omitempty"`  // DS records published by the parent zone that aren't registered
+	Diagnostic  *DiagnosticResponse `json:"diagnostic,omitempty"`  // Details of the problem detected in the last check
+	LastStatus  string              `json:"lastStatus,omitempty"`  // Result of the last comparison with the parent zone
+	LastCheckAt time.Time           `json:"lastCheckAt,omitempty"` // Time of the last comparison with the parent zone
+	LastOKAt    time.Time           `json:"lastOKAt,omitempty"`    // Last time that the parent zone published the registered DS set
+}
+
+// Convert the parent zone comparison of the system into a format with limited information
+// to return it to the user. A domain that was never compared with the parent zone doesn't
+// have this information
+func toParentDSResponse(parentDS model.ParentDS) *ParentDSResponse {
+	if parentDS.LastCheckAt.IsZero() {
+		return nil
+	}
+
+	var extraDSSet []DSResponse
+	for _, ds := range parentDS.ExtraDSSet {
+		extraDSSet = append(extraDSSet, DSResponse{
+			Keytag:     ds.Keytag,
+			Algorithm:  uint8(ds.Algorithm),
+			Digest:     ds.Digest,
+			DigestType: uint8(ds.DigestType),
+		})
+	}
+
+	return &ParentDSResponse{
+		ExtraDSSet:  extraDSSet,
+		Diagnostic:  toDiagnosticResponse(parentDS.Diagnostic),
+		LastStatus:  model.DSStatusToString(parentDS.LastStatus),
+		LastCheckAt: parentDS.LastCheckAt,
+		LastOKAt:    parentDS.LastOKAt,
+	}
+}
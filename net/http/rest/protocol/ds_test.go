@@ -111,10 +111,23 @@ func TestToDSResponse(t *testing.T) {
 	now := time.Now()
 
 	ds := model.DS{
-		Keytag:      41674,
-		Algorithm:   model.DSAlgorithmRSASHA1,
-		Digest:      "eaa0978f38879db70a53f9ff1acf21d046a98b5c",
-		DigestType:  model.DSDigestTypeSHA1,
+		Keytag:     41674,
+		Algorithm:  model.DSAlgorithmRSASHA1,
+		Digest:     "eaa0978f38879db70a53f9ff1acf21d046a98b5c",
+		DigestType: model.DSDigestTypeSHA1,
+		Nameservers: []model.DSNameserver{
+			{
+				Host:        "ns1.example.com.br.",
+				LastStatus:  model.DSStatusNoKey,
+				LastCheckAt: now,
+				Diagnostic: model.Diagnostic{
+					Address: "192.0.2.1:53",
+					Query:   "example.com.br. IN DNSKEY",
+					Rcode:   "NOERROR",
+					Error:   "No DNSKEY with keytag 41674",
+				},
+			},
+		},
 		LastStatus:  model.DSStatusOK,
 		LastCheckAt: now,
 		LastOKAt:    now,
@@ -147,6 +160,20 @@ func TestToDSResponse(t *testing.T) {
 
 		t.Error("Fail to convert dates")
 	}
+
+	if len(dsResponse.Nameservers) != 1 ||
+		dsResponse.Nameservers[0].Host != "ns1.example.com.br." ||
+		dsResponse.Nameservers[0].LastStatus != model.DSStatusToString(model.DSStatusNoKey) ||
+		dsResponse.Nameservers[0].LastCheckAt.Unix() != now.Unix() {
+
+		t.Error("Fail to convert the nameserver results")
+	}
+
+	if dsResponse.Nameservers[0].Diagnostic == nil ||
+		dsResponse.Nameservers[0].Diagnostic.Query != "example.com.br. IN DNSKEY" {
+
+		t.Error("Fail to convert the diagnostic of the nameserver results")
+	}
 }
 
 func TestToDSSetResponse(t *testing.T) {
@@ -177,3 +204,39 @@ func TestToDSSetResponse(t *testing.T) {
 		t.Error("Fail to convert a DS set")
 	}
 }
+
+func TestToParentDSResponse(t *testing.T) {
+	if toParentDSResponse(model.ParentDS{}) != nil {
+		t.Error("Returning the parent zone comparison of a domain that was never checked")
+	}
+
+	parentDS := model.ParentDS{
+		ExtraDSSet: []model.DS{
+			{
+				Keytag:     41674,
+				Algorithm:  model.DSAlgorithmRSASHA1,
+				Digest:     "EAA0978F38879DB70A53F9FF1ACF21D046A98B5C",
+				DigestType: model.DSDigestTypeSHA1,
+			},
+		},
+		Diagnostic: model.Diagnostic{
+			Error: "DS records of the parent zone aren't registered",
+		},
+		LastStatus:  model.DSStatusNotRegistered,
+		LastCheckAt: time.Now(),
+	}
+
+	parentDSResponse := toParentDSResponse(parentDS)
+
+	if parentDSResponse == nil {
+		t.Fatal("Not returning the parent zone comparison")
+	}
+
+	if parentDSResponse.LastStatus != "NOTREG" ||
+		len(parentDSResponse.ExtraDSSet) != 1 ||
+		parentDSResponse.ExtraDSSet[0].Keytag != 41674 ||
+		parentDSResponse.Diagnostic == nil {
+
+		t.Error("Not converting the parent zone comparison properly")
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package protocol describes the REST protocol
+package protocol
+
+import (
+	"github.com/rafaeljusto/shelter/model"
+	"time"
+)
+
+// Finding object used in the protocol to show the problems detected in a nameserver or DS
+// record. The severity was converted to text format for easy interpretation
+type FindingResponse struct {
+	Code        string            `json:"code"`                  // Identification of the finding
+	Severity    string            `json:"severity"`              // How important is the finding
+	Parameters  map[string]string `json:"parameters,omitempty"`  // Details of the finding
+	FirstSeenAt time.Time         `json:"firstSeenAt,omitempty"` // First time that the finding was detected
+}
+
+// Convert a list of findings of the system into a format with limited information to
+// return it to the user
+func toFindingsResponse(findings []model.Finding) []FindingResponse {
+	var findingsResponse []FindingResponse
+	for _, finding := range findings {
+		findingsResponse = append(findingsResponse, FindingResponse{
+			Code:        finding.Code,
+			Severity:    model.SeverityToString(finding.Severity),
+			Parameters:  finding.Parameters,
+			FirstSeenAt: finding.FirstSeenAt,
+		})
+	}
+	return findingsResponse
+}
@@ -0,0 +1,43 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package protocol describes the REST protocol
+package protocol
+
+import (
+	"github.com/rafaeljusto/shelter/model"
+	"testing"
+	"time"
+)
+
+func TestToFindingsResponse(t *testing.T) {
+	now := time.Now()
+
+	findingsResponse := toFindingsResponse([]model.Finding{
+		{
+			Code:     "SLOW",
+			Severity: model.SeverityWarning,
+			Parameters: map[string]string{
+				"rtt": "3s",
+			},
+			FirstSeenAt: now,
+		},
+	})
+
+	if len(findingsResponse) != 1 {
+		t.Fatalf("Expected 1 finding and got %d", len(findingsResponse))
+	}
+
+	if findingsResponse[0].Code != "SLOW" ||
+		findingsResponse[0].Severity != "WARNING" ||
+		findingsResponse[0].Parameters["rtt"] != "3s" ||
+		!findingsResponse[0].FirstSeenAt.Equal(now) {
+
+		t.Error("Fail to convert finding")
+	}
+
+	if toFindingsResponse(nil) != nil {
+		t.Error("Converting findings from an empty list")
+	}
+}
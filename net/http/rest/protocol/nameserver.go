@@ -78,12 +78,28 @@ func toNameserversModel(nameserversRequest []NameserverRequest) ([]model.Nameser
 // Namerserver object used in the protocol to determinate what the user can see. The
 // status was converted to text format for easy interpretation
 type NameserverResponse struct {
-	Host        string    `json:"host,omitempty"`        // Nameserver's name
-	IPv4        string    `json:"ipv4,omitempty"`        // Host's IPv4 (optional when don't need glue)
-	IPv6        string    `json:"ipv6,omitempty"`        // Host's IPv6 (optional)
-	LastStatus  string    `json:"lastStatus,omitempty"`  // Result of the last configuration check
-	LastCheckAt time.Time `json:"lastCheckAt,omitempty"` // Time of the last configuration check
-	LastOKAt    time.Time `json:"lastOKAt,omitempty"`    // Last time that the DNS configuration was OK
+	Host        string                      `json:"host,omitempty"`        // Nameserver's name
+	IPv4        string                      `json:"ipv4,omitempty"`        // Host's IPv4 (optional when don't need glue)
+	IPv6        string                      `json:"ipv6,omitempty"`        // Host's IPv6 (optional)
+	Addresses   []NameserverAddressResponse `json:"addresses,omitempty"`   // Result of the last configuration check of each address
+	Findings    []FindingResponse           `json:"findings,omitempty"`    // Problems detected in the last check
+	Diagnostic  *DiagnosticResponse         `json:"diagnostic,omitempty"`  // Details of the problem detected in the last check
+	SOASerial   uint32                      `json:"soaSerial,omitempty"`   // Version of the zone found in the last check
+	DNSKEYTags  []uint16                    `json:"dnskeyTags,omitempty"`  // Keytags of the DNSKEY RRset found in the last check
+	LastRTT     int64                       `json:"lastRTT,omitempty"`     // Round trip time (milliseconds) of the slowest address in the last check
+	LastStatus  string                      `json:"lastStatus,omitempty"`  // Result of the last configuration check
+	LastCheckAt time.Time                   `json:"lastCheckAt,omitempty"` // Time of the last configuration check
+	LastOKAt    time.Time                   `json:"lastOKAt,omitempty"`    // Last time that the DNS configuration was OK
+}
+
+// Nameserver address object used in the protocol to show the result of the configuration
+// check of each address of the nameserver
+type NameserverAddressResponse struct {
+	Address     string              `json:"address,omitempty"`     // Nameserver's IPv4 or IPv6
+	Diagnostic  *DiagnosticResponse `json:"diagnostic,omitempty"`  // Details of the problem detected in the last check
+	LastStatus  string              `json:"lastStatus,omitempty"`  // Result of the last configuration check
+	LastCheckAt time.Time           `json:"lastCheckAt,omitempty"` // Time of the last configuration check
+	LastOKAt    time.Time           `json:"lastOKAt,omitempty"`    // Last time that the DNS configuration was OK
 }
 
 // Convert a nameserver of the system into a format with limited information to return it
@@ -99,10 +115,27 @@ func toNameserverResponse(nameserver model.Nameserver) NameserverResponse {
 		ipv6 = nameserver.IPv6.String()
 	}
 
+	var addresses []NameserverAddressResponse
+	for _, address := range nameserver.Addresses {
+		addresses = append(addresses, NameserverAddressResponse{
+			Address:     address.IP.String(),
+			Diagnostic:  toDiagnosticResponse(address.Diagnostic),
+			LastStatus:  model.NameserverStatusToString(address.LastStatus),
+			LastCheckAt: address.LastCheckAt,
+			LastOKAt:    address.LastOKAt,
+		})
+	}
+
 	return NameserverResponse{
 		Host:        nameserver.Host,
 		IPv4:        ipv4,
 		IPv6:        ipv6,
+		Addresses:   addresses,
+		Findings:    toFindingsResponse(nameserver.Findings),
+		Diagnostic:  toDiagnosticResponse(nameserver.Diagnostic),
+		SOASerial:   nameserver.SOASerial,
+		DNSKEYTags:  nameserver.DNSKEYTags,
+		LastRTT:     int64(nameserver.LastRTT / time.Millisecond),
 		LastStatus:  model.NameserverStatusToString(nameserver.LastStatus),
 		LastCheckAt: nameserver.LastCheckAt,
 		LastOKAt:    nameserver.LastOKAt,
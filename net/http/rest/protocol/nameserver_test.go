@@ -111,9 +111,28 @@ func TestToNameserverResponse(t *testing.T) {
 	now := time.Now()
 
 	nameserver := model.Nameserver{
-		Host:        "ns1.example.com.br.",
-		IPv4:        net.ParseIP("127.0.0.1"),
-		IPv6:        net.ParseIP("::1"),
+		Host: "ns1.example.com.br.",
+		IPv4: net.ParseIP("127.0.0.1"),
+		IPv6: net.ParseIP("::1"),
+		Addresses: []model.NameserverAddress{
+			{
+				IP:          net.ParseIP("127.0.0.1"),
+				LastStatus:  model.NameserverStatusOK,
+				LastCheckAt: now,
+				LastOKAt:    now,
+			},
+			{
+				IP:          net.ParseIP("::1"),
+				LastStatus:  model.NameserverStatusTimeout,
+				LastCheckAt: now,
+				Diagnostic: model.Diagnostic{
+					Address: "[::1]:53",
+					Error:   "i/o timeout",
+				},
+			},
+		},
+		SOASerial:   2013112600,
+		LastRTT:     42 * time.Millisecond,
 		LastStatus:  model.NameserverStatusOK,
 		LastCheckAt: now,
 		LastOKAt:    now,
@@ -133,6 +152,34 @@ func TestToNameserverResponse(t *testing.T) {
 		t.Error("Fail to convert IPv6")
 	}
 
+	if len(nameserverResponse.Addresses) != 2 ||
+		nameserverResponse.Addresses[0].Address != "127.0.0.1" ||
+		nameserverResponse.Addresses[0].LastOKAt.Unix() != now.Unix() ||
+		nameserverResponse.Addresses[1].Address != "::1" ||
+		nameserverResponse.Addresses[1].LastStatus != "TIMEOUT" {
+
+		t.Error("Fail to convert addresses")
+	}
+
+	if nameserverResponse.Addresses[0].Diagnostic != nil ||
+		nameserverResponse.Addresses[1].Diagnostic == nil ||
+		nameserverResponse.Addresses[1].Diagnostic.Error != "i/o timeout" {
+
+		t.Error("Fail to convert the diagnostics of the addresses")
+	}
+
+	if nameserverResponse.Diagnostic != nil {
+		t.Error("Returning a diagnostic for a nameserver without problems")
+	}
+
+	if nameserverResponse.SOASerial != 2013112600 {
+		t.Error("Fail to convert SOA serial")
+	}
+
+	if nameserverResponse.LastRTT != 42 {
+		t.Error("Fail to convert the round trip time")
+	}
+
 	if nameserverResponse.LastStatus !=
 		model.NameserverStatusToString(model.NameserverStatusOK) {
 
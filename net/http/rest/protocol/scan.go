@@ -11,20 +11,59 @@ import (
 	"time"
 )
 
+// List of possible actions that the user can request on the scan being executed
+const (
+	ScanActionPause  = "pause"  // Stop checking domains until the scan is resumed
+	ScanActionResume = "resume" // Continue checking the domains of a paused scan
+	ScanActionCancel = "cancel" // Stop the scan without checking the remaining domains
+)
+
+// ScanRequest structure represents the changes that the user can request on the scan being
+// executed. The fields that aren't defined are not changed
+type ScanRequest struct {
+	Action           string `json:"action,omitempty"`           // Pause, resume or cancel the scan
+	NumberOfQueriers *int   `json:"numberOfQueriers,omitempty"` // Number of queriers that check the domains concurrently
+	QueriesPerSecond *int   `json:"queriesPerSecond,omitempty"` // Number of queries per second that each nameserver host can receive
+}
+
 // ScanResponse structure represents the system Scan object to be returned via protocol. With this
 // object the user can retrieve information about executed scans or current progress of a specific
 // scan
 type ScanResponse struct {
-	Status                   string            `json:"status"`                             // Current scan situation
-	ScheduledAt              PreciseTime       `json:"scheduledAt,omitempty"`              // Scheduled date and time that the scan will be executed
-	StartedAt                PreciseTime       `json:"startedAt,omitempty"`                // Start date and time of the scan, is also used to identify the scan
-	FinishedAt               PreciseTime       `json:"finishedAt,omitempty"`               // Finish date and time of the scan
-	DomainsToBeScanned       uint64            `json:"domainsToBeScanned,omitempty"`       // Number of domains to verify (scan is executing)
-	DomainsScanned           uint64            `json:"domainsScanned,omitempty"`           // Number of domains already verified
-	DomainsWithDNSSECScanned uint64            `json:"domainsWithDNSSECScanned,omitempty"` // Number of domains with DNSSEC already verified
-	NameserverStatistics     map[string]uint64 `json:"nameserverStatistics,omitempty"`     // Domains' nameservers statistics (status and quantity)
-	DSStatistics             map[string]uint64 `json:"dsStatistics,omitempty"`             // Domains' DS records statistics (status and quantity)
-	Links                    []Link            `json:"links,omitempty"`                    // Links to move around the scans
+	Status                   string                     `json:"status"`                             // Current scan situation
+	ScheduledAt              PreciseTime                `json:"scheduledAt,omitempty"`              // Scheduled date and time that the scan will be executed
+	StartedAt                PreciseTime                `json:"startedAt,omitempty"`                // Start date and time of the scan, is also used to identify the scan
+	FinishedAt               PreciseTime                `json:"finishedAt,omitempty"`               // Finish date and time of the scan
+	DomainsToBeScanned       uint64                     `json:"domainsToBeScanned,omitempty"`       // Number of domains to verify (scan is executing)
+	DomainsScanned           uint64                     `json:"domainsScanned,omitempty"`           // Number of domains already verified
+	DomainsWithDNSSECScanned uint64                     `json:"domainsWithDNSSECScanned,omitempty"` // Number of domains with DNSSEC already verified
+	DomainsPostponed         uint64                     `json:"domainsPostponed,omitempty"`         // Number of times that domains were postponed by the hosts' rate limit
+	DomainsDropped           uint64                     `json:"domainsDropped,omitempty"`           // Number of domains not verified after too many postponements
+	NameserverStatistics     map[string]uint64          `json:"nameserverStatistics,omitempty"`     // Domains' nameservers statistics (status and quantity)
+	DSStatistics             map[string]uint64          `json:"dsStatistics,omitempty"`             // Domains' DS records statistics (status and quantity)
+	SeverityStatistics       map[string]uint64          `json:"severityStatistics,omitempty"`       // Findings statistics (severity and quantity)
+	RTTStatistics            *RTTStatistics             `json:"rttStatistics,omitempty"`            // Nameservers' latency statistics
+	StatusChanges            []ScanStatusChangeResponse `json:"statusChanges,omitempty"`            // Pauses, resumes and cancellation requested by the user
+	Links                    []Link                     `json:"links,omitempty"`                    // Links to move around the scans
+}
+
+// RTTStatistics structure represents the nameservers' latency statistics of a scan. All
+// round trip times are in milliseconds, that is easier to read than the nanoseconds used
+// internally
+type RTTStatistics struct {
+	Samples uint64 `json:"samples"` // Number of nameservers that answered
+	Average int64  `json:"average"` // Average round trip time
+	P50     int64  `json:"p50"`     // Round trip time of 50% of the nameservers (median)
+	P90     int64  `json:"p90"`     // Round trip time of 90% of the nameservers
+	P99     int64  `json:"p99"`     // Round trip time of 99% of the nameservers
+	Max     int64  `json:"max"`     // Slowest round trip time
+}
+
+// ScanStatusChangeResponse structure represents a change of the scan status requested by
+// the user while the scan was executing
+type ScanStatusChangeResponse struct {
+	Status    string      `json:"status"`    // New status of the scan
+	ChangedAt PreciseTime `json:"changedAt"` // Date and time of the change
 }
 
 // Convert a scan object data of the system into a format easy to interpret by the user
@@ -36,8 +75,13 @@ func ScanToScanResponse(scan model.Scan) ScanResponse {
 		DomainsToBeScanned:       0,
 		DomainsScanned:           scan.DomainsScanned,
 		DomainsWithDNSSECScanned: scan.DomainsWithDNSSECScanned,
+		DomainsPostponed:         scan.DomainsPostponed,
+		DomainsDropped:           scan.DomainsDropped,
 		NameserverStatistics:     scan.NameserverStatistics,
 		DSStatistics:             scan.DSStatistics,
+		SeverityStatistics:       scan.SeverityStatistics,
+		RTTStatistics:            toRTTStatistics(scan.RTTStatistics),
+		StatusChanges:            toScanStatusChangesResponse(scan.StatusChanges),
 		Links: []Link{
 			{
 				Types: []LinkType{LinkTypeSelf},
@@ -58,8 +102,13 @@ func CurrentScanToScanResponse(currentScan model.CurrentScan) ScanResponse {
 		DomainsToBeScanned:       currentScan.DomainsToBeScanned,
 		DomainsScanned:           currentScan.DomainsScanned,
 		DomainsWithDNSSECScanned: currentScan.DomainsWithDNSSECScanned,
+		DomainsPostponed:         currentScan.DomainsPostponed,
+		DomainsDropped:           currentScan.DomainsDropped,
 		NameserverStatistics:     currentScan.NameserverStatistics,
 		DSStatistics:             currentScan.DSStatistics,
+		SeverityStatistics:       currentScan.SeverityStatistics,
+		RTTStatistics:            toRTTStatistics(currentScan.RTTStatistics),
+		StatusChanges:            toScanStatusChangesResponse(currentScan.StatusChanges),
 		Links: []Link{
 			{
 				Types: []LinkType{LinkTypeSelf},
@@ -72,3 +121,35 @@ func CurrentScanToScanResponse(currentScan model.CurrentScan) ScanResponse {
 		},
 	}
 }
+
+// Convert the latency statistics of the system into milliseconds. When no nameserver
+// answered (or the scan was executed before the statistics existed) nil is returned, so
+// that the field is omitted
+func toRTTStatistics(rttStatistics model.RTTStatistics) *RTTStatistics {
+	if rttStatistics.Samples == 0 {
+		return nil
+	}
+
+	return &RTTStatistics{
+		Samples: rttStatistics.Samples,
+		Average: int64(rttStatistics.Average / time.Millisecond),
+		P50:     int64(rttStatistics.P50 / time.Millisecond),
+		P90:     int64(rttStatistics.P90 / time.Millisecond),
+		P99:     int64(rttStatistics.P99 / time.Millisecond),
+		Max:     int64(rttStatistics.Max / time.Millisecond),
+	}
+}
+
+// Convert the status changes requested by the user into a format easy to interpret by the
+// user
+func toScanStatusChangesResponse(statusChanges []model.ScanStatusChange) []ScanStatusChangeResponse {
+	var statusChangesResponse []ScanStatusChangeResponse
+	for _, statusChange := range statusChanges {
+		statusChangesResponse = append(statusChangesResponse, ScanStatusChangeResponse{
+			Status:    model.ScanStatusToString(statusChange.Status),
+			ChangedAt: PreciseTime{statusChange.ChangedAt},
+		})
+	}
+
+	return statusChangesResponse
+}
@@ -19,6 +19,8 @@ func TestScanToScanResponse(t *testing.T) {
 		FinishedAt:               time.Now().Add(-30 * time.Minute),
 		DomainsScanned:           10,
 		DomainsWithDNSSECScanned: 4,
+		DomainsPostponed:         7,
+		DomainsDropped:           1,
 		NameserverStatistics: map[string]uint64{
 			model.NameserverStatusToString(model.NameserverStatusOK):      16,
 			model.NameserverStatusToString(model.NameserverStatusTimeout): 4,
@@ -27,6 +29,22 @@ func TestScanToScanResponse(t *testing.T) {
 			model.DSStatusToString(model.DSStatusOK):               3,
 			model.DSStatusToString(model.DSStatusExpiredSignature): 1,
 		},
+		SeverityStatistics: map[string]uint64{
+			model.SeverityToString(model.SeverityError):   5,
+			model.SeverityToString(model.SeverityWarning): 2,
+		},
+		RTTStatistics: model.RTTStatistics{
+			Samples: 16,
+			Average: 35 * time.Millisecond,
+			P50:     20 * time.Millisecond,
+			P90:     80 * time.Millisecond,
+			P99:     150 * time.Millisecond,
+			Max:     150 * time.Millisecond,
+		},
+		StatusChanges: []model.ScanStatusChange{
+			{Status: model.ScanStatusPaused, ChangedAt: time.Now().Add(-50 * time.Minute)},
+			{Status: model.ScanStatusRunning, ChangedAt: time.Now().Add(-40 * time.Minute)},
+		},
 	}
 
 	scanResponse := ScanToScanResponse(scan)
@@ -47,6 +65,10 @@ func TestScanToScanResponse(t *testing.T) {
 		t.Error("Domains with DNSSEC scanned field was not converted correctly")
 	}
 
+	if scanResponse.DomainsPostponed != 7 || scanResponse.DomainsDropped != 1 {
+		t.Error("Domains postponed and dropped fields were not converted correctly")
+	}
+
 	if !scanResponse.StartedAt.Equal(scan.StartedAt) {
 		t.Error("Started time was not converted correctly")
 	}
@@ -65,6 +87,28 @@ func TestScanToScanResponse(t *testing.T) {
 		t.Error("DS statistics weren't converted correctly")
 	}
 
+	if scanResponse.SeverityStatistics["ERROR"] != 5 ||
+		scanResponse.SeverityStatistics["WARNING"] != 2 {
+		t.Error("Severity statistics weren't converted correctly")
+	}
+
+	if scanResponse.RTTStatistics == nil ||
+		scanResponse.RTTStatistics.Samples != 16 ||
+		scanResponse.RTTStatistics.Average != 35 ||
+		scanResponse.RTTStatistics.P50 != 20 ||
+		scanResponse.RTTStatistics.P90 != 80 ||
+		scanResponse.RTTStatistics.P99 != 150 ||
+		scanResponse.RTTStatistics.Max != 150 {
+		t.Error("RTT statistics weren't converted correctly")
+	}
+
+	if len(scanResponse.StatusChanges) != 2 ||
+		scanResponse.StatusChanges[0].Status != "PAUSED" ||
+		!scanResponse.StatusChanges[0].ChangedAt.Equal(scan.StatusChanges[0].ChangedAt) ||
+		scanResponse.StatusChanges[1].Status != "RUNNING" {
+		t.Error("Status changes weren't converted correctly")
+	}
+
 	if len(scanResponse.Links) != 1 ||
 		scanResponse.Links[0].HRef != fmt.Sprintf("/scan/%s", scan.StartedAt.Format(time.RFC3339Nano)) {
 		t.Error("Links weren't added correctly")
@@ -83,6 +83,17 @@ func Notify() {
 		Database: database,
 	}
 
+	severityAlertDays := make(map[model.Severity]int)
+	for severityText, alertDays := range config.ShelterConfig.Notification.SeverityAlertDays {
+		severity, ok := model.SeverityFromString(severityText)
+		if !ok {
+			log.Printf("Unknown severity %s in the notification configuration", severityText)
+			continue
+		}
+
+		severityAlertDays[severity] = alertDays
+	}
+
 	domainChannel, err := domainDAO.FindAllAsyncToBeNotified(
 		config.ShelterConfig.Notification.NameserverErrorAlertDays,
 		config.ShelterConfig.Notification.NameserverTimeoutAlertDays,
@@ -92,6 +103,8 @@ func Notify() {
 		// TODO: Should we move this configuration parameter to a place were both modules can
 		// access it. This sounds better for configuration deployment
 		config.ShelterConfig.Scan.VerificationIntervals.MaxExpirationAlertDays,
+
+		severityAlertDays,
 	)
 
 	if err != nil {
@@ -118,6 +131,19 @@ func Notify() {
 
 		if err := notifyDomain(domainResult.Domain); err != nil {
 			log.Println("Error notifying a domain. Details:", err)
+			continue
+		}
+
+		// The owners were notified about the DS and nameserver updates applied
+		// automatically, so we don't need to notify them again in the next notification job
+		if len(domainResult.Domain.PendingDSUpdates()) > 0 ||
+			len(domainResult.Domain.PendingNameserverUpdates()) > 0 {
+
+			domainResult.Domain.NotifiedDSUpdates()
+			domainResult.Domain.NotifiedNameserverUpdates()
+			if err := domainDAO.Save(domainResult.Domain); err != nil {
+				log.Println("Error storing the notified updates of a domain. Details:", err)
+			}
 		}
 	}
 }
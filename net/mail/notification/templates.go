@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
@@ -86,6 +87,13 @@ func LoadTemplates() error {
 		t, err := template.New("notification").Funcs(template.FuncMap{
 			"nsStatusEq":           nameserverStatusEquals,
 			"dsStatusEq":           dsStatusEquals,
+			"nsFailedAddresses":    nameserverFailedAddresses,
+			"ednsFailedProbes":     nameserverFailedEDNSProbes,
+			"udpPayloadLimits":     udpPayloadLimits,
+			"dsSetText":            dsSetText,
+			"nameserversText":      nameserversText,
+			"findingsText":         findingsText,
+			"diagnosticText":       diagnosticText,
 			"isNearExpiration":     isNearExpirationDS,
 			"fqdnToUnicode":        fqdnToUnicode,
 			"normalizeEmailHeader": normalizeEmailHeader,
@@ -141,6 +149,161 @@ func nameserverStatusEquals(nameserverStatus model.NameserverStatus,
 		strings.TrimSpace(strings.ToLower(expectedNameserverTextStatus))
 }
 
+// Auxiliary function for template that lists the addresses of the nameserver with
+// problems, separated by comma. Useful to identify which address (IPv4 or IPv6) of the
+// nameserver needs attention
+func nameserverFailedAddresses(nameserver model.Nameserver) string {
+	var addresses []string
+	for _, address := range nameserver.FailedAddresses() {
+		addresses = append(addresses, address.IP.String())
+	}
+	return strings.Join(addresses, ", ")
+}
+
+// Auxiliary function for template that lists the EDNS compliance probes that the
+// nameserver failed with the detected problem, separated by comma (e.g. "DO (DOBIT)")
+func nameserverFailedEDNSProbes(nameserver model.Nameserver) string {
+	var probes []string
+	for _, test := range nameserver.FailedEDNSTests() {
+		probes = append(probes, fmt.Sprintf("%s (%s)",
+			model.EDNSProbeToString(test.Probe), model.EDNSStatusToString(test.LastStatus)))
+	}
+	return strings.Join(probes, ", ")
+}
+
+// Auxiliary function for template that lists the nameservers of the domain that lose big
+// DNSKEY responses, with the largest response size that each one delivered (e.g.
+// "ns1.example.com. (1232 bytes)")
+func udpPayloadLimits(domain model.Domain) string {
+	var limits []string
+	for _, nameserver := range domain.Nameservers {
+		if nameserver.UDPPayloadLimit > 0 {
+			limits = append(limits, fmt.Sprintf("%s (%d bytes)",
+				nameserver.Host, nameserver.UDPPayloadLimit))
+		}
+	}
+	return strings.Join(limits, ", ")
+}
+
+// Auxiliary function for template that lists the DS records of a DS set in the
+// presentation format without the owner name (e.g. "12345 8 2 A1B2..."), separated by
+// comma. An empty DS set is represented by a dash
+func dsSetText(dsSet []model.DS) string {
+	if len(dsSet) == 0 {
+		return "-"
+	}
+
+	var records []string
+	for _, ds := range dsSet {
+		records = append(records, fmt.Sprintf("%d %d %d %s",
+			ds.Keytag, ds.Algorithm, ds.DigestType, ds.Digest))
+	}
+	return strings.Join(records, ", ")
+}
+
+// Auxiliary function for template that lists the nameservers with their glue records,
+// separated by comma (e.g. "ns1.example.com. (192.0.2.1), ns2.example.net."). An empty
+// list is represented by a dash
+func nameserversText(nameservers []model.Nameserver) string {
+	if len(nameservers) == 0 {
+		return "-"
+	}
+
+	var hosts []string
+	for _, nameserver := range nameservers {
+		var addresses []string
+		if nameserver.IPv4 != nil {
+			addresses = append(addresses, nameserver.IPv4.String())
+		}
+		if nameserver.IPv6 != nil {
+			addresses = append(addresses, nameserver.IPv6.String())
+		}
+
+		if len(addresses) == 0 {
+			hosts = append(hosts, nameserver.Host)
+		} else {
+			hosts = append(hosts, fmt.Sprintf("%s (%s)",
+				nameserver.Host, strings.Join(addresses, ", ")))
+		}
+	}
+	return strings.Join(hosts, ", ")
+}
+
+// Auxiliary function for template that lists the findings of a given severity (case
+// insensitive) with their parameters, separated by comma (e.g. "SERIALBEHIND (serial=1,
+// since=2015-01-01T00:00:00Z)")
+func findingsText(findings []model.Finding, severityText string) string {
+	severity, ok := model.SeverityFromString(severityText)
+	if !ok {
+		return ""
+	}
+
+	var texts []string
+	for _, finding := range findings {
+		if finding.Severity != severity {
+			continue
+		}
+
+		var keys []string
+		for key := range finding.Parameters {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var parameters []string
+		for _, key := range keys {
+			parameters = append(parameters, fmt.Sprintf("%s=%s", key, finding.Parameters[key]))
+		}
+
+		if len(parameters) == 0 {
+			texts = append(texts, finding.Code)
+		} else {
+			texts = append(texts, fmt.Sprintf("%s (%s)",
+				finding.Code, strings.Join(parameters, ", ")))
+		}
+	}
+	return strings.Join(texts, ", ")
+}
+
+// Auxiliary function for template that describes the details of a problem in only one
+// line, where the records are separated by "|" (e.g. "Response with the SERVFAIL code
+// (address=192.0.2.1:53, query=example.com.br. IN SOA, rcode=SERVFAIL)"). Returns an
+// empty text when there's no problem
+func diagnosticText(diagnostic model.Diagnostic) string {
+	if diagnostic.Empty() {
+		return ""
+	}
+
+	var details []string
+	if len(diagnostic.Address) > 0 {
+		details = append(details, "address="+diagnostic.Address)
+	}
+
+	if len(diagnostic.Query) > 0 {
+		details = append(details, "query="+diagnostic.Query)
+	}
+
+	if len(diagnostic.Rcode) > 0 {
+		details = append(details, "rcode="+diagnostic.Rcode)
+	}
+
+	if len(diagnostic.Records) > 0 {
+		// The records in presentation format are separated by tabs, that could break the
+		// alignment of the e-mail
+		var records []string
+		for _, record := range diagnostic.Records {
+			records = append(records, strings.Join(strings.Fields(record), " "))
+		}
+		details = append(details, "records="+strings.Join(records, " | "))
+	}
+
+	if len(details) == 0 {
+		return diagnostic.Error
+	}
+
+	return fmt.Sprintf("%s (%s)", diagnostic.Error, strings.Join(details, ", "))
+}
+
 // Auxiliary function for template that compares two DS status (case insensitive)
 func dsStatusEquals(dsStatus model.DSStatus, expectedDSTextStatus string) bool {
 	return strings.ToLower(model.DSStatusToString(dsStatus)) ==
@@ -7,6 +7,7 @@ package notification
 
 import (
 	"io/ioutil"
+	"net"
 	"os"
 	"testing"
 	"text/template"
@@ -115,6 +116,168 @@ func TestNameserverStatusEquals(t *testing.T) {
 	}
 }
 
+func TestNameserverFailedAddresses(t *testing.T) {
+	nameserver := model.Nameserver{
+		Addresses: []model.NameserverAddress{
+			{
+				IP:         net.ParseIP("192.0.2.1"),
+				LastStatus: model.NameserverStatusOK,
+			},
+			{
+				IP:         net.ParseIP("2001:db8::1"),
+				LastStatus: model.NameserverStatusTimeout,
+			},
+			{
+				IP:         net.ParseIP("2001:db8::2"),
+				LastStatus: model.NameserverStatusQueryRefused,
+			},
+		},
+	}
+
+	if addresses := nameserverFailedAddresses(nameserver); addresses != "2001:db8::1, 2001:db8::2" {
+		t.Errorf("Not listing the failed addresses of the nameserver. Found '%s'", addresses)
+	}
+
+	if addresses := nameserverFailedAddresses(model.Nameserver{}); addresses != "" {
+		t.Errorf("Listing failed addresses for a nameserver without addresses. Found '%s'", addresses)
+	}
+}
+
+func TestNameserverFailedEDNSProbes(t *testing.T) {
+	nameserver := model.Nameserver{
+		EDNSTests: []model.EDNSTest{
+			{Probe: model.EDNSProbeVersion, LastStatus: model.EDNSStatusRcode},
+			{Probe: model.EDNSProbeOption, LastStatus: model.EDNSStatusOK},
+			{Probe: model.EDNSProbeDO, LastStatus: model.EDNSStatusDO},
+		},
+	}
+
+	if probes := nameserverFailedEDNSProbes(nameserver); probes != "VERSION (RCODE), DO (DOBIT)" {
+		t.Errorf("Not listing the failed EDNS probes of the nameserver. Found '%s'", probes)
+	}
+}
+
+func TestUDPPayloadLimits(t *testing.T) {
+	domain := model.Domain{
+		Nameservers: []model.Nameserver{
+			{Host: "ns1.example.com.br.", UDPPayloadLimit: 1232},
+			{Host: "ns2.example.com.br."},
+			{Host: "ns3.example.com.br.", UDPPayloadLimit: 512},
+		},
+	}
+
+	if limits := udpPayloadLimits(domain); limits !=
+		"ns1.example.com.br. (1232 bytes), ns3.example.com.br. (512 bytes)" {
+		t.Errorf("Not listing the UDP payload limits of the nameservers. Found '%s'", limits)
+	}
+}
+
+func TestDSSetText(t *testing.T) {
+	if text := dsSetText(nil); text != "-" {
+		t.Errorf("Not representing an empty DS set. Found '%s'", text)
+	}
+
+	text := dsSetText([]model.DS{
+		{
+			Keytag:     12345,
+			Algorithm:  model.DSAlgorithmRSASHA256,
+			DigestType: model.DSDigestTypeSHA256,
+			Digest:     "AABB",
+		},
+		{
+			Keytag:     54321,
+			Algorithm:  model.DSAlgorithmECDSASHA256,
+			DigestType: model.DSDigestTypeSHA256,
+			Digest:     "CCDD",
+		},
+	})
+
+	if text != "12345 8 2 AABB, 54321 13 2 CCDD" {
+		t.Errorf("Not listing the DS records of the DS set. Found '%s'", text)
+	}
+}
+
+func TestNameserversText(t *testing.T) {
+	if text := nameserversText(nil); text != "-" {
+		t.Errorf("Not representing an empty nameserver list. Found '%s'", text)
+	}
+
+	text := nameserversText([]model.Nameserver{
+		{
+			Host: "ns1.example.com.br.",
+			IPv4: net.ParseIP("192.0.2.1"),
+			IPv6: net.ParseIP("2001:db8::1"),
+		},
+		{
+			Host: "ns2.example.net.",
+		},
+	})
+
+	if text != "ns1.example.com.br. (192.0.2.1, 2001:db8::1), ns2.example.net." {
+		t.Errorf("Not listing the nameservers with the glue records. Found '%s'", text)
+	}
+}
+
+func TestFindingsText(t *testing.T) {
+	findings := []model.Finding{
+		{
+			Code:     "SERIALBEHIND",
+			Severity: model.SeverityInfo,
+			Parameters: map[string]string{
+				"since":  "2015-01-01T00:00:00Z",
+				"serial": "2015010101",
+			},
+		},
+		{
+			Code:     "SLOW",
+			Severity: model.SeverityWarning,
+		},
+		{
+			Code:     "TIMEOUT",
+			Severity: model.SeverityError,
+		},
+	}
+
+	if text := findingsText(findings, "info"); text !=
+		"SERIALBEHIND (serial=2015010101, since=2015-01-01T00:00:00Z)" {
+
+		t.Errorf("Not listing the findings correctly. Got '%s'", text)
+	}
+
+	if text := findingsText(findings, "WARNING"); text != "SLOW" {
+		t.Errorf("Not listing the findings without parameters correctly. Got '%s'", text)
+	}
+
+	if text := findingsText(findings, "critical"); text != "" {
+		t.Error("Listing findings of an unknown severity")
+	}
+}
+
+func TestDiagnosticText(t *testing.T) {
+	if text := diagnosticText(model.Diagnostic{}); text != "" {
+		t.Errorf("Describing an empty diagnostic. Got '%s'", text)
+	}
+
+	diagnostic := model.Diagnostic{
+		Address: "192.0.2.1:53",
+		Query:   "example.com.br. IN SOA",
+		Rcode:   "NOERROR",
+		Error:   "CNAME record in the zone apex",
+		Records: []string{"example.com.br.\t86400\tIN\tCNAME\texample.net.br."},
+	}
+
+	if text := diagnosticText(diagnostic); text != "CNAME record in the zone apex "+
+		"(address=192.0.2.1:53, query=example.com.br. IN SOA, rcode=NOERROR, "+
+		"records=example.com.br. 86400 IN CNAME example.net.br.)" {
+
+		t.Errorf("Not describing the diagnostic correctly. Got '%s'", text)
+	}
+
+	if text := diagnosticText(model.Diagnostic{Error: "i/o timeout"}); text != "i/o timeout" {
+		t.Errorf("Not describing the diagnostic without details correctly. Got '%s'", text)
+	}
+}
+
 func TestDSStatusEquals(t *testing.T) {
 	if !dsStatusEquals(model.DSStatusNoKey, "noKey   ") {
 		t.Error("Not comparing correctly when DS status are equal")
@@ -0,0 +1,344 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package cdspolicy store the policies for the CDS and CDNSKEY records (RFC 7344 and RFC
+// 8078), that the child zones use to ask for DS updates in the parent zone
+package cdspolicy
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/dnsutils"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
+	"strings"
+)
+
+const (
+	// Identification of the CDS and CDNSKEY checks in the policy registry. The records are
+	// only retrieved when the policy is enabled for the domain
+	Policy = "ds.cds"
+
+	// Number of consecutive scans that must find the same DS update before it can be
+	// applied. The "minStableScans" parameter of the "ds.cds" policy replaces this value
+	MinStableScans = 3
+)
+
+func init() {
+	policy.Register(policy.Standalone{Identifier: Policy})
+}
+
+// Number of consecutive scans that must find the same DS update before it can be applied,
+// using the parameters of the "ds.cds" policy for the domain
+func StableScans(parameters policy.Parameters) int {
+	return parameters.Int("minStableScans", MinStableScans)
+}
+
+// DomainCDSPolicy store the domain object and the CDS, CDNSKEY and DNSKEY responses of
+// each nameserver of the domain. The DS update is only accepted when all nameservers
+// agree
+type DomainCDSPolicy struct {
+	domain    *model.Domain // Domain object with the current DS set (chain of trust)
+	responses [][]*dns.Msg  // CDS, CDNSKEY and DNSKEY responses of each nameserver
+}
+
+// This function initialize a DomainCDSPolicy object, it was created to force the
+// programmer to initialize the domain object, so we don't need to check if domain is nil
+// inside each method
+func NewDomainCDSPolicy(domain *model.Domain) DomainCDSPolicy {
+	return DomainCDSPolicy{
+		domain: domain,
+	}
+}
+
+// Build the queries that must be sent to each nameserver, in the order: CDS, CDNSKEY and
+// DNSKEY. The DNSKEY set is necessary to check the signatures of the CDS and CDNSKEY sets
+func (d *DomainCDSPolicy) Requests(udpMaxSize uint16) []*dns.Msg {
+	var requests []*dns.Msg
+	for _, rrType := range []uint16{dns.TypeCDS, dns.TypeCDNSKEY, dns.TypeDNSKEY} {
+		var dnsRequestMessage dns.Msg
+		dnsRequestMessage.SetQuestion(dns.Fqdn(d.domain.FQDN), rrType)
+		dnsRequestMessage.RecursionDesired = false
+		dnsRequestMessage.SetEdns0(udpMaxSize, true)
+		requests = append(requests, &dnsRequestMessage)
+	}
+	return requests
+}
+
+// Store the responses of one nameserver, in the same order of the requests. A nil
+// response represents a network error
+func (d *DomainCDSPolicy) AddResponses(dnsResponseMessages []*dns.Msg) {
+	d.responses = append(d.responses, dnsResponseMessages)
+}
+
+// Method responsable for checking the responses of all nameservers and storing the DS
+// update requested by the child zone in the domain object. The update is only accepted
+// when all nameservers answer the same CDS and CDNSKEY records, signed by a key of the
+// current chain of trust, and proposing only published keys (RFC 7344 - section 4.1)
+func (d *DomainCDSPolicy) Run() {
+	if len(d.responses) == 0 {
+		d.domain.CDS.ChangeStatus(model.CDSStatusDNSError, nil)
+		return
+	}
+
+	var statuses []model.CDSStatus
+	var dsSets [][]model.DS
+
+	for _, responses := range d.responses {
+		status, dsSet := d.checkNameserver(responses)
+
+		// Any problem in a nameserver blocks the DS update, as a DS update with problems
+		// can break the chain of trust
+		if status != model.CDSStatusValid && status != model.CDSStatusDelete &&
+			status != model.CDSStatusNotFound {
+
+			d.domain.CDS.ChangeStatus(status, nil)
+			return
+		}
+
+		statuses = append(statuses, status)
+		dsSets = append(dsSets, dsSet)
+	}
+
+	for i := 1; i < len(statuses); i++ {
+		if statuses[i] != statuses[0] || !model.SameDSSet(dsSets[i], dsSets[0]) {
+			d.domain.CDS.ChangeStatus(model.CDSStatusInconsistent, nil)
+			return
+		}
+	}
+
+	if statuses[0] == model.CDSStatusValid && model.SameDSSet(dsSets[0], d.domain.DSSet) {
+		d.domain.CDS.ChangeStatus(model.CDSStatusUnchanged, nil)
+		return
+	}
+
+	d.domain.CDS.ChangeStatus(statuses[0], dsSets[0])
+}
+
+// Check the CDS and CDNSKEY records of one nameserver, returning the DS set that the child
+// zone asks for
+func (d *DomainCDSPolicy) checkNameserver(responses []*dns.Msg) (model.CDSStatus, []model.DS) {
+	if len(responses) != 3 {
+		return model.CDSStatusDNSError, nil
+	}
+
+	for _, response := range responses {
+		if response == nil || response.Rcode != dns.RcodeSuccess {
+			return model.CDSStatusDNSError, nil
+		}
+	}
+
+	cdsResponse, cdnskeyResponse, dnskeyResponse := responses[0], responses[1], responses[2]
+
+	cdsRRs := dnsutils.FilterRRs(cdsResponse.Answer, dns.TypeCDS)
+	cdnskeyRRs := dnsutils.FilterRRs(cdnskeyResponse.Answer, dns.TypeCDNSKEY)
+
+	if len(cdsRRs) == 0 && len(cdnskeyRRs) == 0 {
+		return model.CDSStatusNotFound, nil
+	}
+
+	dnskeys := dnsutils.FilterRRs(dnskeyResponse.Answer, dns.TypeDNSKEY)
+
+	if len(cdsRRs) > 0 && !dnsutils.VerifyRRSet(cdsRRs,
+		dnsutils.FilterRRs(cdsResponse.Answer, dns.TypeRRSIG), dnskeys, d.domain.DSSet) {
+
+		return model.CDSStatusNoSignature, nil
+	}
+
+	if len(cdnskeyRRs) > 0 && !dnsutils.VerifyRRSet(cdnskeyRRs,
+		dnsutils.FilterRRs(cdnskeyResponse.Answer, dns.TypeRRSIG), dnskeys, d.domain.DSSet) {
+
+		return model.CDSStatusNoSignature, nil
+	}
+
+	cdsSet, cdsDelete, ok := fromCDS(cdsRRs)
+	if !ok {
+		return model.CDSStatusInvalid, nil
+	}
+
+	cdnskeySet, cdnskeyDelete, ok := fromCDNSKEY(cdnskeyRRs)
+	if !ok {
+		return model.CDSStatusInvalid, nil
+	}
+
+	// When the child zone publishes both record types, they must represent the same keys
+	// (RFC 7344 - section 4)
+	if len(cdsRRs) > 0 && len(cdnskeyRRs) > 0 {
+		if cdsDelete != cdnskeyDelete || !sameKeys(cdsSet, cdnskeySet) {
+			return model.CDSStatusInconsistent, nil
+		}
+	}
+
+	if cdsDelete || cdnskeyDelete {
+		return model.CDSStatusDelete, nil
+	}
+
+	dsSet := cdsSet
+	if len(cdsRRs) == 0 {
+		dsSet = cdnskeySet
+	}
+
+	if !publishedKeys(dsSet, dnskeyResponse) {
+		return model.CDSStatusUnpublished, nil
+	}
+
+	return model.CDSStatusValid, dsSet
+}
+
+// Check if every proposed DS record matches a key of the DNSKEY set that also signs the
+// DNSKEY set, otherwise the DS update would break the chain of trust (RFC 7344 - section
+// 4.1)
+func publishedKeys(dsSet []model.DS, dnskeyResponse *dns.Msg) bool {
+	dnskeys := dnsutils.FilterRRs(dnskeyResponse.Answer, dns.TypeDNSKEY)
+	rrsigs := dnsutils.FilterRRs(dnskeyResponse.Answer, dns.TypeRRSIG)
+
+	for _, ds := range dsSet {
+		if !dnsutils.VerifyRRSet(dnskeys, rrsigs, dnskeys, []model.DS{ds}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Convert the CDS records into DS records. The delete signal (algorithm 0) is returned in
+// the second value, and must be the only record of the set (RFC 8078 - section 4). The
+// last value is false when the records are malformed
+func fromCDS(rrs []dns.RR) ([]model.DS, bool, bool) {
+	var dsSet []model.DS
+	deleteSignal := false
+
+	for _, rr := range rrs {
+		cds, ok := rr.(*dns.CDS)
+		if !ok {
+			return nil, false, false
+		}
+
+		if cds.Algorithm == 0 {
+			deleteSignal = true
+			continue
+		}
+
+		if !model.IsValidDSAlgorithm(cds.Algorithm) ||
+			!model.IsValidDSDigestType(cds.DigestType) {
+
+			return nil, false, false
+		}
+
+		dsSet = append(dsSet, model.DS{
+			Keytag:     cds.KeyTag,
+			Algorithm:  model.DSAlgorithm(cds.Algorithm),
+			DigestType: model.DSDigestType(cds.DigestType),
+			Digest:     strings.ToUpper(cds.Digest),
+		})
+	}
+
+	if deleteSignal && len(dsSet) > 0 {
+		return nil, false, false
+	}
+
+	return dsSet, deleteSignal, true
+}
+
+// Convert the CDNSKEY records into DS records using the SHA-256 digest. The delete signal
+// (algorithm 0) is returned in the second value, and must be the only record of the set
+// (RFC 8078 - section 4). The last value is false when the records are malformed
+func fromCDNSKEY(rrs []dns.RR) ([]model.DS, bool, bool) {
+	var dsSet []model.DS
+	deleteSignal := false
+
+	for _, rr := range rrs {
+		dnskey, ok := cdnskeyToDNSKEY(rr)
+		if !ok {
+			return nil, false, false
+		}
+
+		if dnskey.Algorithm == 0 {
+			deleteSignal = true
+			continue
+		}
+
+		if !model.IsValidDSAlgorithm(dnskey.Algorithm) {
+			return nil, false, false
+		}
+
+		dsRecord := dnskey.ToDS(dns.SHA256)
+		if dsRecord == nil {
+			return nil, false, false
+		}
+
+		dsSet = append(dsSet, model.DS{
+			Keytag:     dsRecord.KeyTag,
+			Algorithm:  model.DSAlgorithm(dsRecord.Algorithm),
+			DigestType: model.DSDigestTypeSHA256,
+			Digest:     strings.ToUpper(dsRecord.Digest),
+		})
+	}
+
+	if deleteSignal && len(dsSet) > 0 {
+		return nil, false, false
+	}
+
+	return dsSet, deleteSignal, true
+}
+
+// The DNS library doesn't decode the CDNSKEY records, they are returned as unknown
+// records (RFC 3597) with the raw data in hexadecimal. As the CDNSKEY record has the same
+// format of the DNSKEY record, we decode it as a DNSKEY
+func cdnskeyToDNSKEY(rr dns.RR) (*dns.DNSKEY, bool) {
+	switch record := rr.(type) {
+	case *dns.CDNSKEY:
+		dnskey := record.DNSKEY
+		return &dnskey, true
+
+	case *dns.RFC3597:
+		rdata, err := hex.DecodeString(record.Rdata)
+		if err != nil || len(rdata) < 4 {
+			return nil, false
+		}
+
+		return &dns.DNSKEY{
+			Hdr: dns.RR_Header{
+				Name:   record.Hdr.Name,
+				Rrtype: dns.TypeDNSKEY,
+				Class:  record.Hdr.Class,
+				Ttl:    record.Hdr.Ttl,
+			},
+			Flags:     uint16(rdata[0])<<8 | uint16(rdata[1]),
+			Protocol:  rdata[2],
+			Algorithm: rdata[3],
+			PublicKey: base64.StdEncoding.EncodeToString(rdata[4:]),
+		}, true
+	}
+
+	return nil, false
+}
+
+// Check if two DS sets represent the same keys, comparing only the keytags and
+// algorithms, because the CDS and CDNSKEY records can use different digest types
+func sameKeys(dsSet1, dsSet2 []model.DS) bool {
+	contains := func(dsSet []model.DS, ds model.DS) bool {
+		for _, other := range dsSet {
+			if other.Keytag == ds.Keytag && other.Algorithm == ds.Algorithm {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, ds := range dsSet1 {
+		if !contains(dsSet2, ds) {
+			return false
+		}
+	}
+
+	for _, ds := range dsSet2 {
+		if !contains(dsSet1, ds) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,547 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package cdspolicy store the policies for the CDS and CDNSKEY records (RFC 7344 and RFC
+// 8078), that the child zones use to ask for DS updates in the parent zone
+package cdspolicy
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequests(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "test.br.",
+	}
+
+	domainCDSPolicy := NewDomainCDSPolicy(domain)
+	requests := domainCDSPolicy.Requests(4096)
+
+	if len(requests) != 3 {
+		t.Fatalf("Not building the CDS, CDNSKEY and DNSKEY requests. Found %d", len(requests))
+	}
+
+	expectedTypes := []uint16{dns.TypeCDS, dns.TypeCDNSKEY, dns.TypeDNSKEY}
+	for i, request := range requests {
+		if request.Question[0].Qtype != expectedTypes[i] ||
+			request.Question[0].Name != "test.br." {
+			t.Errorf("Wrong question in request %d", i)
+		}
+
+		if request.RecursionDesired {
+			t.Errorf("Asking for recursion in request %d", i)
+		}
+
+		opt := request.IsEdns0()
+		if opt == nil || !opt.Do() || opt.UDPSize() != 4096 {
+			t.Errorf("Not asking for DNSSEC records in request %d", i)
+		}
+	}
+}
+
+func TestRunValidCDS(t *testing.T) {
+	currentKey, currentPrivateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, newPrivateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		FQDN:  "test.br.",
+		DSSet: []model.DS{toModelDS(currentKey, dns.SHA1)},
+	}
+
+	cds := toCDS(newKey, dns.SHA256)
+	cdsRRSIG, err := signRRSet(currentKey, currentPrivateKey, []dns.RR{cds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dnskeys := signedDNSKEYSet(t, newKey, newPrivateKey, currentKey, newKey)
+
+	domainCDSPolicy := NewDomainCDSPolicy(domain)
+	for i := 0; i < 2; i++ {
+		domainCDSPolicy.AddResponses([]*dns.Msg{
+			{Answer: []dns.RR{cds, cdsRRSIG}},
+			{},
+			dnskeys,
+		})
+	}
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusValid {
+		t.Fatalf("Not accepting a valid CDS. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+
+	if !model.SameDSSet(domain.CDS.DSSet, []model.DS{toModelDS(newKey, dns.SHA256)}) {
+		t.Error("Not storing the DS update requested by the CDS records")
+	}
+
+	if domain.CDS.StableScans != 1 {
+		t.Error("Not counting the scan that found the DS update")
+	}
+
+	// Same DS update in the next scan
+	domainCDSPolicy = NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds, cdsRRSIG}},
+		{},
+		dnskeys,
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.StableScans != 2 {
+		t.Error("Not counting the consecutive scans that found the same DS update")
+	}
+}
+
+func TestRunValidCDNSKEY(t *testing.T) {
+	currentKey, currentPrivateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		FQDN:  "test.br.",
+		DSSet: []model.DS{toModelDS(currentKey, dns.SHA1)},
+	}
+
+	cdnskey := toCDNSKEY(currentKey)
+	cdnskeyRRSIG, err := signRRSet(currentKey, currentPrivateKey, []dns.RR{cdnskey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domainCDSPolicy := NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{},
+		{Answer: []dns.RR{cdnskey, cdnskeyRRSIG}},
+		signedDNSKEYSet(t, currentKey, currentPrivateKey, currentKey),
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusValid {
+		t.Fatalf("Not accepting a valid CDNSKEY. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+
+	// The same key with a different digest type is a DS update
+	if !model.SameDSSet(domain.CDS.DSSet, []model.DS{toModelDS(currentKey, dns.SHA256)}) {
+		t.Error("Not converting the CDNSKEY records into DS records using SHA-256")
+	}
+}
+
+func TestRunUnchanged(t *testing.T) {
+	currentKey, currentPrivateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		FQDN:  "test.br.",
+		DSSet: []model.DS{toModelDS(currentKey, dns.SHA256)},
+	}
+
+	cds := toCDS(currentKey, dns.SHA256)
+	cdsRRSIG, err := signRRSet(currentKey, currentPrivateKey, []dns.RR{cds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cdnskey := toCDNSKEY(currentKey)
+	cdnskeyRRSIG, err := signRRSet(currentKey, currentPrivateKey, []dns.RR{cdnskey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domainCDSPolicy := NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds, cdsRRSIG}},
+		{Answer: []dns.RR{cdnskey, cdnskeyRRSIG}},
+		signedDNSKEYSet(t, currentKey, currentPrivateKey, currentKey),
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusUnchanged || domain.CDS.DSSet != nil {
+		t.Errorf("Not detecting CDS records equal to the current DS set. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+}
+
+func TestRunDelete(t *testing.T) {
+	currentKey, currentPrivateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		FQDN:  "test.br.",
+		DSSet: []model.DS{toModelDS(currentKey, dns.SHA256)},
+	}
+
+	// RFC 8078 - section 4: "CDS 0 0 0 00"
+	cds := &dns.CDS{
+		DS: dns.DS{
+			Hdr:    dns.RR_Header{Name: "test.br.", Rrtype: dns.TypeCDS, Class: dns.ClassINET, Ttl: 900},
+			Digest: "00",
+		},
+	}
+
+	cdsRRSIG, err := signRRSet(currentKey, currentPrivateKey, []dns.RR{cds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domainCDSPolicy := NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds, cdsRRSIG}},
+		{},
+		{Answer: []dns.RR{currentKey}},
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusDelete || len(domain.CDS.DSSet) != 0 ||
+		domain.CDS.StableScans != 1 {
+
+		t.Errorf("Not detecting the delete signal. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+
+	// Delete signal mixed with keys
+	newCDS := toCDS(currentKey, dns.SHA256)
+	cdsRRSIG, err = signRRSet(currentKey, currentPrivateKey, []dns.RR{cds, newCDS})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domainCDSPolicy = NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds, newCDS, cdsRRSIG}},
+		{},
+		{Answer: []dns.RR{currentKey}},
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusInvalid || domain.CDS.StableScans != 0 {
+		t.Errorf("Accepting the delete signal mixed with other records. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+}
+
+func TestRunNoSignature(t *testing.T) {
+	currentKey, _, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, otherPrivateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		FQDN:  "test.br.",
+		DSSet: []model.DS{toModelDS(currentKey, dns.SHA256)},
+	}
+
+	cds := toCDS(otherKey, dns.SHA256)
+
+	domainCDSPolicy := NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds}},
+		{},
+		{Answer: []dns.RR{currentKey, otherKey}},
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusNoSignature {
+		t.Errorf("Accepting CDS records without signature. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+
+	// Signed with a key that isn't in the chain of trust
+	cdsRRSIG, err := signRRSet(otherKey, otherPrivateKey, []dns.RR{cds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domainCDSPolicy = NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds, cdsRRSIG}},
+		{},
+		{Answer: []dns.RR{currentKey, otherKey}},
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusNoSignature {
+		t.Errorf("Accepting CDS records signed by a key outside the chain of trust. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+}
+
+func TestRunUnpublished(t *testing.T) {
+	currentKey, currentPrivateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, _, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		FQDN:  "test.br.",
+		DSSet: []model.DS{toModelDS(currentKey, dns.SHA256)},
+	}
+
+	cds := toCDS(newKey, dns.SHA256)
+	cdsRRSIG, err := signRRSet(currentKey, currentPrivateKey, []dns.RR{cds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CDS pointing to a key that isn't in the DNSKEY set
+	domainCDSPolicy := NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds, cdsRRSIG}},
+		{},
+		signedDNSKEYSet(t, currentKey, currentPrivateKey, currentKey),
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusUnpublished || domain.CDS.DSSet != nil {
+		t.Errorf("Accepting CDS records pointing to an unpublished key. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+
+	// CDS pointing to a published key that doesn't sign the DNSKEY set
+	domainCDSPolicy = NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds, cdsRRSIG}},
+		{},
+		signedDNSKEYSet(t, currentKey, currentPrivateKey, currentKey, newKey),
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusUnpublished || domain.CDS.DSSet != nil {
+		t.Errorf("Accepting CDS records pointing to a key that doesn't sign the DNSKEY set. "+
+			"Found %s", model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+}
+
+func TestRunInconsistent(t *testing.T) {
+	currentKey, currentPrivateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, newPrivateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		FQDN:  "test.br.",
+		DSSet: []model.DS{toModelDS(currentKey, dns.SHA256)},
+	}
+
+	cds := toCDS(newKey, dns.SHA256)
+	cdsRRSIG, err := signRRSet(currentKey, currentPrivateKey, []dns.RR{cds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cdnskey := toCDNSKEY(currentKey)
+	cdnskeyRRSIG, err := signRRSet(currentKey, currentPrivateKey, []dns.RR{cdnskey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dnskeys := signedDNSKEYSet(t, newKey, newPrivateKey, currentKey, newKey)
+
+	// CDS and CDNSKEY records with different keys
+	domainCDSPolicy := NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds, cdsRRSIG}},
+		{Answer: []dns.RR{cdnskey, cdnskeyRRSIG}},
+		dnskeys,
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusInconsistent {
+		t.Errorf("Not detecting CDS and CDNSKEY records with different keys. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+
+	// Nameservers with different records
+	domainCDSPolicy = NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{cds, cdsRRSIG}},
+		{},
+		dnskeys,
+	})
+	domainCDSPolicy.AddResponses([]*dns.Msg{
+		{},
+		{},
+		dnskeys,
+	})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusInconsistent {
+		t.Errorf("Not detecting nameservers with different CDS records. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+}
+
+func TestRunNotFoundAndDNSError(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "test.br.",
+		DSSet: []model.DS{
+			{Keytag: 1234, Algorithm: model.DSAlgorithmRSASHA256, DigestType: model.DSDigestTypeSHA256},
+		},
+	}
+
+	domainCDSPolicy := NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{{}, {}, {}})
+	domainCDSPolicy.AddResponses([]*dns.Msg{{}, {}, {}})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusNotFound {
+		t.Errorf("Not detecting zones without CDS records. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+
+	domainCDSPolicy = NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{{}, {}, {}})
+	domainCDSPolicy.AddResponses([]*dns.Msg{nil, {}, {}})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusDNSError {
+		t.Errorf("Not detecting network errors. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+
+	domainCDSPolicy = NewDomainCDSPolicy(domain)
+	domainCDSPolicy.AddResponses([]*dns.Msg{{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeServerFailure}}, {}, {}})
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusDNSError {
+		t.Errorf("Not detecting DNS errors. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+
+	domainCDSPolicy = NewDomainCDSPolicy(domain)
+	domainCDSPolicy.Run()
+
+	if domain.CDS.LastStatus != model.CDSStatusDNSError {
+		t.Errorf("Not detecting domains without responses. Found %s",
+			model.CDSStatusToString(domain.CDS.LastStatus))
+	}
+}
+
+func generateKey(zone string) (*dns.DNSKEY, dns.PrivateKey, error) {
+	dnskey := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.RSASHA256,
+	}
+
+	privateKey, err := dnskey.Generate(1024)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dnskey, privateKey, nil
+}
+
+func signRRSet(dnskey *dns.DNSKEY, privateKey dns.PrivateKey, rrset []dns.RR) (*dns.RRSIG, error) {
+	rrsig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name:   rrset[0].Header().Name,
+			Rrtype: dns.TypeRRSIG,
+			Class:  dns.ClassINET,
+			Ttl:    rrset[0].Header().Ttl,
+		},
+		TypeCovered: rrset[0].Header().Rrtype,
+		Algorithm:   dnskey.Algorithm,
+		Expiration:  uint32(time.Now().Add(10 * time.Second).Unix()),
+		Inception:   uint32(time.Now().Unix()),
+		KeyTag:      dnskey.KeyTag(),
+		SignerName:  dnskey.Hdr.Name,
+	}
+
+	if err := rrsig.Sign(privateKey, rrset); err != nil {
+		return nil, err
+	}
+
+	return rrsig, nil
+}
+
+// Build the DNSKEY response with the DNSKEY set signed by the given key, as only the keys
+// that sign the DNSKEY set can be proposed in a DS update
+func signedDNSKEYSet(t *testing.T, dnskey *dns.DNSKEY, privateKey dns.PrivateKey,
+	dnskeys ...dns.RR) *dns.Msg {
+
+	rrsig, err := signRRSet(dnskey, privateKey, dnskeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &dns.Msg{
+		Answer: append(dnskeys, rrsig),
+	}
+}
+
+func toModelDS(dnskey *dns.DNSKEY, digestType uint8) model.DS {
+	ds := dnskey.ToDS(digestType)
+	return model.DS{
+		Keytag:     ds.KeyTag,
+		Algorithm:  model.DSAlgorithm(ds.Algorithm),
+		DigestType: model.DSDigestType(ds.DigestType),
+		Digest:     strings.ToUpper(ds.Digest),
+	}
+}
+
+func toCDS(dnskey *dns.DNSKEY, digestType uint8) *dns.CDS {
+	ds := dnskey.ToDS(digestType)
+	ds.Hdr.Rrtype = dns.TypeCDS
+	return &dns.CDS{DS: *ds}
+}
+
+// The DNS library decodes the CDNSKEY records received from the network as unknown
+// records, so we build them in the same way
+func toCDNSKEY(dnskey *dns.DNSKEY) *dns.RFC3597 {
+	publicKey, _ := base64.StdEncoding.DecodeString(dnskey.PublicKey)
+	rdata := append([]byte{
+		byte(dnskey.Flags >> 8), byte(dnskey.Flags), dnskey.Protocol, dnskey.Algorithm,
+	}, publicKey...)
+
+	return &dns.RFC3597{
+		Hdr: dns.RR_Header{
+			Name:   dnskey.Hdr.Name,
+			Rrtype: dns.TypeCDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    dnskey.Hdr.Ttl,
+		},
+		Rdata: hex.EncodeToString(rdata),
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2"
+	"github.com/rafaeljusto/shelter/config"
+	"github.com/rafaeljusto/shelter/dao"
+	"github.com/rafaeljusto/shelter/database/mongodb"
+	"github.com/rafaeljusto/shelter/log"
+	"github.com/rafaeljusto/shelter/model"
+)
+
+const (
+	// Time between each save of the scan progress in the database, used when the
+	// configuration doesn't define it
+	defaultCheckpointInterval = 1 * time.Minute
+)
+
+var (
+	// Only one scan is executed at a time, as the scan information is global. A scheduled
+	// scan waits while an interrupted scan is resumed
+	scanLock sync.Mutex
+)
+
+// Store the progress of the current scan in the database periodically, until the
+// returned function is called. The first checkpoint is stored immediately, so that the
+// scan is in the database since the beginning. The returned function only returns after
+// the last checkpoint is stored, so that no checkpoint is stored after the scan finishes
+func checkpointScan(database *mgo.Database) func() {
+	scanDAO := dao.ScanDAO{
+		Database: database,
+	}
+
+	checkpoint := func() {
+		if err := model.CheckpointScan(scanDAO.Save); err != nil {
+			log.Println("Error while saving the scan progress. Details:", err)
+		}
+	}
+
+	checkpoint()
+
+	stop := make(chan bool)
+	stopped := make(chan bool)
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(checkpointInterval())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				checkpoint()
+
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// Time between each save of the scan progress in the database, from the configuration
+func checkpointInterval() time.Duration {
+	if config.ShelterConfig.Scan.Checkpoint.IntervalSeconds > 0 {
+		return time.Duration(config.ShelterConfig.Scan.Checkpoint.IntervalSeconds) * time.Second
+	}
+
+	return defaultCheckpointInterval
+}
+
+// Function responsible for handling the scans interrupted by a crash or restart, that are
+// stored in the database with the progress of the last checkpoint. The most recent
+// interrupted scan is resumed when configured, and the others are marked as aborted. A
+// scan canceled by the user is never resumed. It should run when Shelter starts, after
+// the current scan information is initialized
+func RecoverInterruptedScans() {
+	defer func() {
+		// Something went really wrong while recovering the scans. Log the error stacktrace
+		// and move out
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Printf("Panic detected while recovering interrupted scans. Details: %v\n%s", r, buf)
+		}
+	}()
+
+	scanLock.Lock()
+	defer scanLock.Unlock()
+
+	database, databaseSession, err := mongodb.Open(
+		config.ShelterConfig.Database.URIs,
+		config.ShelterConfig.Database.Name,
+		config.ShelterConfig.Database.Auth.Enabled,
+		config.ShelterConfig.Database.Auth.Username,
+		config.ShelterConfig.Database.Auth.Password,
+	)
+
+	if err != nil {
+		log.Println("Error while initializing database. Details:", err)
+		return
+	}
+	defer databaseSession.Close()
+
+	scanDAO := dao.ScanDAO{
+		Database: database,
+	}
+
+	scans, err := scanDAO.FindAllUnfinished()
+	if err != nil {
+		log.Println("Error while looking for interrupted scans. Details:", err)
+		return
+	}
+
+	for index, scan := range scans {
+		if index == len(scans)-1 && config.ShelterConfig.Scan.Checkpoint.Resume &&
+			scan.Status != model.ScanStatusCanceled {
+
+			log.Infof("Resuming the scan started at %s", scan.StartedAt)
+			resumeInterruptedScan(database, scan)
+
+		} else {
+			log.Infof("Aborting the scan started at %s", scan.StartedAt)
+			abortScan(database, scan)
+		}
+	}
+}
+
+// Continue an interrupted scan from the last checkpoint. The domains already checked by
+// the scan aren't checked again, and the statistics of the new checks are added to the
+// statistics stored in the checkpoint
+func resumeInterruptedScan(database *mgo.Database, scan model.Scan) {
+	model.ResumeScan(scan)
+
+	// In a distributed scan the workers keep scanning the batches while the coordinator is
+	// down, so we only need to finish creating the batches and wait for them
+	if config.ShelterConfig.Scan.Distributed.Enabled {
+		resumeCoordinatedScan(database, scan.StartedAt)
+		return
+	}
+
+	executeScan(database)
+}
+
+// Store an interrupted scan as aborted, keeping the progress of the last checkpoint. As
+// far as we know the scan stopped in the last checkpoint. A scan that was canceled by the
+// user keeps the status, as it would stop anyway. The batches of an interrupted
+// distributed scan are also removed, so that the workers don't scan them anymore
+func abortScan(database *mgo.Database, scan model.Scan) {
+	if scan.Status != model.ScanStatusCanceled {
+		scan.Status = model.ScanStatusAborted
+	}
+
+	scan.FinishedAt = scan.LastModifiedAt
+
+	scanDAO := dao.ScanDAO{
+		Database: database,
+	}
+
+	if err := scanDAO.Save(&scan); err != nil {
+		log.Println("Error while aborting the interrupted scan. Details:", err)
+	}
+
+	if config.ShelterConfig.Scan.Distributed.Enabled {
+		scanBatchDAO := dao.ScanBatchDAO{
+			Database: database,
+		}
+
+		if err := scanBatchDAO.RemoveAllByScan(scan.StartedAt); err != nil {
+			log.Println("Error while removing the scan batches. Details:", err)
+		}
+	}
+}
@@ -8,23 +8,35 @@ package scan
 import (
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2"
 	"github.com/rafaeljusto/shelter/dao"
+	"github.com/rafaeljusto/shelter/log"
 	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/cdspolicy"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
 	"sync"
+	"time"
 )
 
 // Collector is responsable for persisting all domains with their new status into the
 // database. For faster approach the collector waits until it has many domains to save
 // them at once in the database
 type Collector struct {
-	Database   *mgo.Database // Low level database connection
-	SaveAtOnce int           // Number of domains to save at once
+	Database                 *mgo.Database   // Low level database connection
+	SaveAtOnce               int             // Number of domains to save at once
+	ApplyCDS                 bool            // Apply the stable DS updates asked by the child zones
+	ApplyCSYNC               bool            // Apply the nameserver updates asked by the child zones
+	MaxOKVerificationDays    int             // Maximum number of days to verify a domain configured correctly with DNS/DNSSEC
+	MaxErrorVerificationDays int             // Maximum number of days to verify a domain with problems
+	MaxExpirationAlertDays   int             // Days before the signature expiration to report it
+	ScanStartedAt            time.Time       // Start of the scan, stored in the domains to resume an interrupted scan
+	PriorityWeights          PriorityWeights // Weights used to rank the domains in the next scans
 }
 
 // Return a new Collector object with the necessary fields for the scan filled
 func NewCollector(database *mgo.Database, saveAtOnce int) *Collector {
 	return &Collector{
-		Database:   database,
-		SaveAtOnce: saveAtOnce,
+		Database:        database,
+		SaveAtOnce:      saveAtOnce,
+		PriorityWeights: defaultPriorityWeights(),
 	}
 }
 
@@ -54,6 +66,8 @@ func (c *Collector) Start(scanGroup *sync.WaitGroup,
 		finished := false
 		nameserverStatistics := make(map[string]uint64)
 		dsStatistics := make(map[string]uint64)
+		severityStatistics := make(map[string]uint64)
+		rttHistogram := make(model.RTTHistogram)
 
 		for {
 			// Using make for faster allocation
@@ -76,6 +90,7 @@ func (c *Collector) Start(scanGroup *sync.WaitGroup,
 				for _, nameserver := range domain.Nameservers {
 					status := model.NameserverStatusToString(nameserver.LastStatus)
 					nameserverStatistics[status] += 1
+					rttHistogram.Add(nameserver.LastRTT)
 				}
 
 				// Keep track of DS statistics
@@ -84,6 +99,53 @@ func (c *Collector) Start(scanGroup *sync.WaitGroup,
 					dsStatistics[status] += 1
 				}
 
+				// Keep track of the findings of the nameservers and DS records, so that we can
+				// know how many problems of each severity the scan found
+				domain.UpdateFindings(c.MaxExpirationAlertDays)
+				for _, finding := range domain.Findings() {
+					severityStatistics[model.SeverityToString(finding.Severity)] += 1
+				}
+
+				// Apply the DS update that the child zone asks for, when it was found in enough
+				// consecutive scans. The update is stored in the domain for auditing and to
+				// notify the owners. The number of scans is a parameter of the CDS policy, that
+				// can be disabled for the domain's zone
+				domainUpdated := false
+				cdsExecution, cdsEnabled := policy.Find(policy.Enabled(domain.FQDN),
+					cdspolicy.Policy)
+
+				if c.ApplyCDS && cdsEnabled &&
+					domain.ApplyCDS(cdspolicy.StableScans(cdsExecution.Parameters)) {
+
+					log.Infof("DS set of domain %s updated using CDS/CDNSKEY records", domain.FQDN)
+					domainUpdated = true
+				}
+
+				// Apply the nameserver update that the child zone asks for, when the child zone
+				// allows it to be applied immediately
+				if c.ApplyCSYNC && domain.ApplyCSYNC() {
+					log.Infof("Nameservers of domain %s updated using CSYNC record", domain.FQDN)
+					domainUpdated = true
+				}
+
+				// Decide when the domain is going to be checked again, so that the injector
+				// selects it directly in the database. An updated domain wasn't checked with the
+				// new configuration yet, so it goes to the next scan
+				domain.ScheduleNextCheck(c.MaxOKVerificationDays, c.MaxErrorVerificationDays,
+					c.MaxExpirationAlertDays)
+
+				if domainUpdated {
+					domain.NextCheckAt = time.Time{}
+				}
+
+				// Rank the domain for the scan that will check it again, so that the injector
+				// selects the most important domains first
+				schedulePriority(domain, c.PriorityWeights)
+
+				// Mark the domain as checked by this scan, so that the domain isn't checked again
+				// if the scan is interrupted and resumed
+				domain.LastScanStartedAt = c.ScanStartedAt
+
 				domains = append(domains, domain)
 			}
 
@@ -98,9 +160,18 @@ func (c *Collector) Start(scanGroup *sync.WaitGroup,
 				}
 			}
 
+			// Add the statistics of the saved domains to the scan information, so that the
+			// progress stored in the database has the statistics of the domains already saved
+			model.AddStatisticsOfTheScan(nameserverStatistics, dsStatistics,
+				severityStatistics, rttHistogram)
+
+			nameserverStatistics = make(map[string]uint64)
+			dsStatistics = make(map[string]uint64)
+			severityStatistics = make(map[string]uint64)
+			rttHistogram = make(model.RTTHistogram)
+
 			// Now that everything is done, check if we received a poison pill
 			if finished {
-				model.StoreStatisticsOfTheScan(nameserverStatistics, dsStatistics)
 				scanGroup.Done()
 				return
 			}
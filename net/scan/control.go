@@ -0,0 +1,290 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rafaeljusto/shelter/log"
+	"github.com/rafaeljusto/shelter/model"
+)
+
+var (
+	// Error returned when the user tries to change the scan, but there's no scan checking
+	// domains in this instance. The coordinator of a distributed scan doesn't check
+	// domains, so the scan must be changed in the workers
+	ErrScanNotExecuting = errors.New("No scan being executed")
+
+	// Error returned when the user tries to run the scan without queriers
+	ErrInvalidNumberOfQueriers = errors.New("Number of queriers must be greater than zero")
+
+	// Error returned when the user defines a negative number of queries per second
+	ErrInvalidQueriesPerSecond = errors.New("Number of queries per second cannot be negative")
+)
+
+var (
+	// Control of the scan that is checking domains in this instance, nil when there's no
+	// scan being executed
+	activeControl     *scanControl
+	activeControlLock sync.Mutex
+)
+
+// scanControl allows the user to interfere in the scan being executed, pausing,
+// continuing or canceling the checks and changing the number of queriers. The parts of the
+// scan check the control between the domains, so the changes aren't immediate. All
+// methods can be called on a nil control, for the checks executed outside a scan
+type scanControl struct {
+	sync.Mutex
+	running          chan bool // Closed while the scan isn't paused
+	canceled         chan bool // Closed when the scan is canceled
+	numberOfQueriers int       // Number of queriers defined by the user, zero when not changed
+}
+
+// Return a new control for a scan that is running
+func newScanControl() *scanControl {
+	running := make(chan bool)
+	close(running)
+
+	return &scanControl{
+		running:  running,
+		canceled: make(chan bool),
+	}
+}
+
+// Block while the scan is paused. A canceled scan doesn't block, so that the parts of the
+// scan can finish
+func (c *scanControl) waitWhilePaused() {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	running := c.running
+	c.Unlock()
+
+	select {
+	case <-running:
+	case <-c.canceled:
+	}
+}
+
+// Check if the user paused the scan. A canceled scan isn't paused
+func (c *scanControl) isPaused() bool {
+	if c == nil || c.isCanceled() {
+		return false
+	}
+
+	c.Lock()
+	running := c.running
+	c.Unlock()
+
+	select {
+	case <-running:
+		return false
+	default:
+		return true
+	}
+}
+
+// Check if the user canceled the scan
+func (c *scanControl) isCanceled() bool {
+	if c == nil {
+		return false
+	}
+
+	select {
+	case <-c.canceled:
+		return true
+	default:
+		return false
+	}
+}
+
+// Channel closed when the user cancels the scan, so that the parts of the scan can wait for
+// the cancellation together with other events. A nil control is never canceled, so a nil
+// channel, that never receives, is returned
+func (c *scanControl) done() <-chan bool {
+	if c == nil {
+		return nil
+	}
+
+	return c.canceled
+}
+
+// Number of queriers that should check the domains. When the user didn't change the
+// number of queriers, the current number is kept
+func (c *scanControl) queriers(current int) int {
+	if c == nil {
+		return current
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.numberOfQueriers > 0 {
+		return c.numberOfQueriers
+	}
+
+	return current
+}
+
+// Pause the scan, returning false when the scan was already paused or canceled. The
+// status change is recorded in the scan information
+func (c *scanControl) pause() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.isCanceled() || !model.PauseScan() {
+		return false
+	}
+
+	c.running = make(chan bool)
+	return true
+}
+
+// Continue the paused scan, returning false when the scan wasn't paused. The status
+// change is recorded in the scan information
+func (c *scanControl) proceed() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.isCanceled() || !model.ContinuePausedScan() {
+		return false
+	}
+
+	close(c.running)
+	return true
+}
+
+// Cancel the scan, returning false when the scan was already canceled. The status change
+// is recorded in the scan information
+func (c *scanControl) cancel() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.isCanceled() {
+		return false
+	}
+
+	model.CancelScan()
+	close(c.canceled)
+	return true
+}
+
+// Register the control of the scan that started checking domains in this instance, or
+// remove it using nil when the scan finished
+func setActiveControl(control *scanControl) {
+	activeControlLock.Lock()
+	defer activeControlLock.Unlock()
+
+	activeControl = control
+
+	// The queries per second defined by the user are only used in the scan that was
+	// executing, the next scans use the configuration again
+	if control == nil {
+		atomic.StoreInt64(&queriesPerSecondOverride, -1)
+	}
+}
+
+// Retrieve the control of the scan that is checking domains in this instance. An error is
+// returned when there's no scan being executed
+func getActiveControl() (*scanControl, error) {
+	activeControlLock.Lock()
+	defer activeControlLock.Unlock()
+
+	if activeControl == nil {
+		return nil, ErrScanNotExecuting
+	}
+
+	return activeControl, nil
+}
+
+// PauseScan stops sending queries to the nameservers until the scan continues. The
+// queriers finish the domain that they are checking before pausing
+func PauseScan() error {
+	control, err := getActiveControl()
+	if err != nil {
+		return err
+	}
+
+	if control.pause() {
+		log.Info("Scan paused by the user")
+	}
+
+	return nil
+}
+
+// ContinueScan resumes a scan paused by the user
+func ContinueScan() error {
+	control, err := getActiveControl()
+	if err != nil {
+		return err
+	}
+
+	if control.proceed() {
+		log.Info("Scan continued by the user")
+	}
+
+	return nil
+}
+
+// CancelScan stops the scan without checking the remaining domains. The domains already
+// checked are saved, and the scan is saved with the canceled status. The domains that
+// weren't checked are selected again in the next scan. In a worker of a distributed scan
+// only the batch being scanned is canceled
+func CancelScan() error {
+	control, err := getActiveControl()
+	if err != nil {
+		return err
+	}
+
+	if control.cancel() {
+		log.Info("Scan canceled by the user")
+	}
+
+	return nil
+}
+
+// ChangeNumberOfQueriers defines the number of queriers that check the domains
+// concurrently in the scan being executed. The next scans use the configuration again
+func ChangeNumberOfQueriers(numberOfQueriers int) error {
+	if numberOfQueriers <= 0 {
+		return ErrInvalidNumberOfQueriers
+	}
+
+	control, err := getActiveControl()
+	if err != nil {
+		return err
+	}
+
+	control.Lock()
+	control.numberOfQueriers = numberOfQueriers
+	control.Unlock()
+
+	log.Infof("Number of queriers changed to %d by the user", numberOfQueriers)
+	return nil
+}
+
+// ChangeQueriesPerSecond defines the number of queries per second that each nameserver
+// host can receive in the scan being executed. Zero disables the rate limit. The next
+// scans use the configuration again
+func ChangeQueriesPerSecond(queriesPerSecond int) error {
+	if queriesPerSecond < 0 {
+		return ErrInvalidQueriesPerSecond
+	}
+
+	if _, err := getActiveControl(); err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(&queriesPerSecondOverride, int64(queriesPerSecond))
+
+	log.Infof("Number of queries per second per host changed to %d by the user",
+		queriesPerSecond)
+	return nil
+}
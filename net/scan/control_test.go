@@ -0,0 +1,213 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"github.com/rafaeljusto/shelter/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScanControlPause(t *testing.T) {
+	model.StartNewScan()
+	control := newScanControl()
+
+	if !control.pause() {
+		t.Fatal("Not pausing the scan")
+	}
+
+	waiting := make(chan bool)
+	go func() {
+		control.waitWhilePaused()
+		close(waiting)
+	}()
+
+	select {
+	case <-waiting:
+		t.Fatal("Not waiting while the scan is paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if !control.proceed() {
+		t.Fatal("Not continuing the scan")
+	}
+
+	select {
+	case <-waiting:
+	case <-time.After(time.Second):
+		t.Fatal("Still waiting after the scan continued")
+	}
+
+	if model.GetCurrentScan().Status != model.ScanStatusRunning &&
+		model.GetCurrentScan().Status != model.ScanStatusLoadingData {
+
+		t.Error("Not restoring the scan status after continuing")
+	}
+}
+
+func TestScanControlCancel(t *testing.T) {
+	model.StartNewScan()
+	control := newScanControl()
+	control.pause()
+
+	waiting := make(chan bool)
+	go func() {
+		control.waitWhilePaused()
+		close(waiting)
+	}()
+
+	if !control.cancel() || !control.isCanceled() {
+		t.Fatal("Not canceling the scan")
+	}
+
+	select {
+	case <-waiting:
+	case <-time.After(time.Second):
+		t.Fatal("Still waiting after the paused scan was canceled")
+	}
+
+	if control.cancel() || control.pause() || control.proceed() {
+		t.Error("Changing a canceled scan")
+	}
+
+	if model.GetCurrentScan().Status != model.ScanStatusCanceled {
+		t.Error("Not recording the cancellation in the scan information")
+	}
+}
+
+func TestScanControlNotExecuting(t *testing.T) {
+	setActiveControl(nil)
+
+	if PauseScan() != ErrScanNotExecuting ||
+		ContinueScan() != ErrScanNotExecuting ||
+		CancelScan() != ErrScanNotExecuting ||
+		ChangeNumberOfQueriers(2) != ErrScanNotExecuting ||
+		ChangeQueriesPerSecond(2) != ErrScanNotExecuting {
+
+		t.Error("Changing a scan that isn't executing")
+	}
+
+	if ChangeNumberOfQueriers(0) != ErrInvalidNumberOfQueriers {
+		t.Error("Accepting a scan without queriers")
+	}
+
+	if ChangeQueriesPerSecond(-1) != ErrInvalidQueriesPerSecond {
+		t.Error("Accepting a negative number of queries per second")
+	}
+
+	// A nil control is used when checking domains outside a scan
+	var control *scanControl
+	control.waitWhilePaused()
+	if control.isCanceled() || control.done() != nil || control.queriers(3) != 3 {
+		t.Error("Not ignoring a nil control")
+	}
+}
+
+func TestChangeQueriersAndQueriesPerSecond(t *testing.T) {
+	control := newScanControl()
+	setActiveControl(control)
+	defer setActiveControl(nil)
+
+	if control.queriers(3) != 3 {
+		t.Error("Changing the number of queriers without a request")
+	}
+
+	if err := ChangeNumberOfQueriers(5); err != nil {
+		t.Fatal(err)
+	}
+
+	if control.queriers(3) != 5 {
+		t.Error("Not changing the number of queriers")
+	}
+
+	if err := ChangeQueriesPerSecond(10); err != nil {
+		t.Fatal(err)
+	}
+
+	rateLimit := RateLimit{QueriesPerSecond: 500}
+	if maxQPSPerHost(rateLimit) != 10 {
+		t.Error("Not changing the number of queries per second")
+	}
+
+	// Zero disables the rate limit, as in the configuration
+	if err := ChangeQueriesPerSecond(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if maxQPSPerHost(rateLimit) != 0 {
+		t.Error("Not disabling the rate limit")
+	}
+
+	setActiveControl(nil)
+	if maxQPSPerHost(rateLimit) != rateLimit.QueriesPerSecond {
+		t.Error("Not restoring the configured queries per second after the scan")
+	}
+}
+
+func TestQuerierStop(t *testing.T) {
+	model.StartNewScan()
+	control := newScanControl()
+
+	// The querier is busy while the scan is paused, so its channel can be full when the
+	// dispatcher removes it
+	if !control.pause() {
+		t.Fatal("Not pausing the scan")
+	}
+
+	querier := newQuerier(4096, time.Second, time.Second, time.Second, 1, "")
+	querier.control = control
+
+	var queriers sync.WaitGroup
+	stop := make(chan bool)
+	domainsToSaveChannel := make(chan *model.Domain, querierDomainsQueueSize+1)
+	querierChannel := querier.start(&queriers, domainsToSaveChannel, stop)
+
+	for i := 0; i < querierDomainsQueueSize+1; i++ {
+		querierChannel <- &model.Domain{FQDN: "example.com.br."}
+	}
+
+	close(stop)
+
+	if !control.proceed() {
+		t.Fatal("Not continuing the scan")
+	}
+
+	queriers.Wait()
+
+	if len(domainsToSaveChannel) != querierDomainsQueueSize+1 {
+		t.Errorf("Not checking the domains received before the querier was stopped. "+
+			"Checked %d", len(domainsToSaveChannel))
+	}
+}
+
+func TestQuerierDispatcherCanceled(t *testing.T) {
+	model.StartNewScan()
+	control := newScanControl()
+	control.cancel()
+
+	querierDispatcher := NewQuerierDispatcher(2, 10, 4096, time.Second, time.Second,
+		time.Second, 1, "")
+	querierDispatcher.control = control
+
+	var scanGroup sync.WaitGroup
+	domainsToQueryChannel := make(chan *model.Domain)
+	domainsToSaveChannel := querierDispatcher.Start(&scanGroup, domainsToQueryChannel)
+
+	for i := 0; i < 5; i++ {
+		domainsToQueryChannel <- &model.Domain{
+			FQDN:        "example.com.br.",
+			Nameservers: []model.Nameserver{{Host: "ns1.example.com.br."}},
+		}
+	}
+	domainsToQueryChannel <- nil // Poison pill
+
+	if domain := <-domainsToSaveChannel; domain != nil {
+		t.Error("Checking domains of a canceled scan")
+	}
+
+	scanGroup.Wait()
+}
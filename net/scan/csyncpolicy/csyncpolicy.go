@@ -0,0 +1,410 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package csyncpolicy store the policies for the CSYNC record (RFC 7477), that the child
+// zones use to ask for nameserver and glue updates in the parent zone
+package csyncpolicy
+
+import (
+	"encoding/hex"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/dnsutils"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
+	"net"
+	"strings"
+)
+
+const (
+	// Identification of the CSYNC checks in the policy registry. The CSYNC records are only
+	// retrieved when the policy is enabled for the domain
+	Policy = "ns.csync"
+
+	// The DNS library doesn't know the CSYNC record type yet, so we define it here (RFC 7477
+	// - section 2)
+	typeCSYNC uint16 = 62
+)
+
+func init() {
+	policy.Register(policy.Standalone{Identifier: Policy})
+}
+
+// CSYNC record data decoded from the wire format (RFC 7477 - section 2.1.1)
+type csyncRecord struct {
+	serial uint32          // SOA serial of the zone when the record was published
+	flags  uint16          // Immediate and soaminimum flags
+	types  map[uint16]bool // Record types that should be synchronized
+}
+
+// DomainCSYNCPolicy store the domain object and the responses of each nameserver of the
+// domain. The nameserver update is only accepted when all nameservers agree
+type DomainCSYNCPolicy struct {
+	domain    *model.Domain // Domain object with the current delegation and chain of trust
+	responses [][]*dns.Msg  // Responses of each nameserver
+}
+
+// This function initialize a DomainCSYNCPolicy object, it was created to force the
+// programmer to initialize the domain object, so we don't need to check if domain is nil
+// inside each method
+func NewDomainCSYNCPolicy(domain *model.Domain) DomainCSYNCPolicy {
+	return DomainCSYNCPolicy{
+		domain: domain,
+	}
+}
+
+// Build the first queries that must be sent to each nameserver, in the order: SOA, CSYNC,
+// NS and DNSKEY. After them the glue queries must be sent (GlueRequests) and at last the
+// SOA query again, to detect zone changes during the check (RFC 7477 - section 4.3)
+func (d *DomainCSYNCPolicy) Requests(udpMaxSize uint16) []*dns.Msg {
+	var requests []*dns.Msg
+	for _, rrType := range []uint16{dns.TypeSOA, typeCSYNC, dns.TypeNS, dns.TypeDNSKEY} {
+		requests = append(requests, newRequest(d.domain.FQDN, rrType, udpMaxSize))
+	}
+	return requests
+}
+
+// Build the A and AAAA queries for the nameservers inside the domain (glue records), using
+// the NS records of the responses when the child zone asks to synchronize them, or the
+// registered nameservers otherwise. Only the record types listed in the CSYNC record are
+// queried
+func (d *DomainCSYNCPolicy) GlueRequests(responses []*dns.Msg, udpMaxSize uint16) []*dns.Msg {
+	if len(responses) < 3 || responses[1] == nil || responses[2] == nil {
+		return nil
+	}
+
+	csync, ok := findCSYNC(responses[1].Answer)
+	if !ok {
+		return nil
+	}
+
+	var requests []*dns.Msg
+	for _, host := range d.hosts(csync, responses[2]) {
+		if !dns.IsSubDomain(dns.Fqdn(d.domain.FQDN), host) {
+			continue
+		}
+
+		for _, rrType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if csync.types[rrType] {
+				requests = append(requests, newRequest(host, rrType, udpMaxSize))
+			}
+		}
+	}
+	return requests
+}
+
+// Store the responses of one nameserver, in the same order of the requests. A nil
+// response represents a network error
+func (d *DomainCSYNCPolicy) AddResponses(dnsResponseMessages []*dns.Msg) {
+	d.responses = append(d.responses, dnsResponseMessages)
+}
+
+// Method responsable for checking the responses of all nameservers and storing the
+// nameserver update requested by the child zone in the domain object. The update is only
+// accepted when all nameservers answer the same records, signed by a key of the current
+// chain of trust (RFC 7477 - section 4.1)
+func (d *DomainCSYNCPolicy) Run() {
+	if len(d.responses) == 0 {
+		d.domain.CSYNC.ChangeStatus(model.CSYNCStatusDNSError, 0, 0, nil)
+		return
+	}
+
+	var statuses []model.CSYNCStatus
+	var nameserversList [][]model.Nameserver
+	var csync csyncRecord
+
+	for _, responses := range d.responses {
+		status, record, nameservers := d.checkNameserver(responses)
+		csync = record
+
+		// Any problem in a nameserver blocks the update, as a wrong delegation can make the
+		// domain unreachable
+		if status != model.CSYNCStatusValid && status != model.CSYNCStatusNotFound {
+			d.domain.CSYNC.ChangeStatus(status, csync.serial, csync.flags, nil)
+			return
+		}
+
+		statuses = append(statuses, status)
+		nameserversList = append(nameserversList, nameservers)
+	}
+
+	for i := 1; i < len(statuses); i++ {
+		if statuses[i] != statuses[0] ||
+			!model.SameNameservers(nameserversList[i], nameserversList[0]) {
+
+			d.domain.CSYNC.ChangeStatus(model.CSYNCStatusInconsistent,
+				csync.serial, csync.flags, nil)
+			return
+		}
+	}
+
+	if statuses[0] == model.CSYNCStatusValid &&
+		model.SameNameservers(nameserversList[0], d.domain.Nameservers) {
+
+		d.domain.CSYNC.ChangeStatus(model.CSYNCStatusUnchanged, csync.serial, csync.flags, nil)
+		return
+	}
+
+	d.domain.CSYNC.ChangeStatus(statuses[0], csync.serial, csync.flags, nameserversList[0])
+}
+
+// Check the CSYNC record of one nameserver, returning the nameservers that the child zone
+// asks for
+func (d *DomainCSYNCPolicy) checkNameserver(
+	responses []*dns.Msg,
+) (model.CSYNCStatus, csyncRecord, []model.Nameserver) {
+
+	// SOA, CSYNC, NS, DNSKEY, glue responses and the SOA again
+	if len(responses) < 5 {
+		return model.CSYNCStatusDNSError, csyncRecord{}, nil
+	}
+
+	for _, response := range responses {
+		if response == nil || response.Rcode != dns.RcodeSuccess {
+			return model.CSYNCStatusDNSError, csyncRecord{}, nil
+		}
+	}
+
+	soaResponse, csyncResponse, nsResponse, dnskeyResponse :=
+		responses[0], responses[1], responses[2], responses[3]
+	glueResponses := responses[4 : len(responses)-1]
+	lastSOAResponse := responses[len(responses)-1]
+
+	csyncRRs := dnsutils.FilterRRs(csyncResponse.Answer, typeCSYNC)
+	if len(csyncRRs) == 0 {
+		return model.CSYNCStatusNotFound, csyncRecord{}, nil
+	}
+
+	csync, ok := findCSYNC(csyncRRs)
+	if !ok || len(csyncRRs) > 1 {
+		return model.CSYNCStatusInvalid, csyncRecord{}, nil
+	}
+
+	soa, ok := dnsutils.FilterFirstRR(soaResponse.Answer, dns.TypeSOA).(*dns.SOA)
+	if !ok {
+		return model.CSYNCStatusDNSError, csync, nil
+	}
+
+	// The zone changed while we were checking it, so the records could be from different
+	// versions of the zone (RFC 7477 - section 4.3)
+	lastSOA, ok := dnsutils.FilterFirstRR(lastSOAResponse.Answer, dns.TypeSOA).(*dns.SOA)
+	if !ok || lastSOA.Serial != soa.Serial {
+		return model.CSYNCStatusInconsistent, csync, nil
+	}
+
+	if csync.flags&model.CSYNCFlagSOAMinimum != 0 &&
+		dnsutils.CompareSerial(soa.Serial, csync.serial) < 0 {
+
+		return model.CSYNCStatusSOAMinimum, csync, nil
+	}
+
+	// Only the DNSKEY set is checked against the DS set, the other records can be signed
+	// by any key of it, like a ZSK that isn't in the parent zone
+	dnskeys := dnsutils.TrustedDNSKEYSet(
+		dnsutils.FilterRRs(dnskeyResponse.Answer, dns.TypeDNSKEY),
+		dnsutils.FilterRRs(dnskeyResponse.Answer, dns.TypeRRSIG),
+		d.domain.DSSet,
+	)
+
+	if dnskeys == nil || !dnsutils.VerifyRRSetWithDNSKEYs(csyncRRs,
+		dnsutils.FilterRRs(csyncResponse.Answer, dns.TypeRRSIG), dnskeys) {
+
+		return model.CSYNCStatusNoSignature, csync, nil
+	}
+
+	if csync.types[dns.TypeNS] {
+		nsRRs := dnsutils.FilterRRs(nsResponse.Answer, dns.TypeNS)
+		if len(nsRRs) == 0 {
+			return model.CSYNCStatusInvalid, csync, nil
+		}
+
+		if !dnsutils.VerifyRRSetWithDNSKEYs(nsRRs,
+			dnsutils.FilterRRs(nsResponse.Answer, dns.TypeRRSIG), dnskeys) {
+
+			return model.CSYNCStatusNoSignature, csync, nil
+		}
+	}
+
+	var nameservers []model.Nameserver
+	for _, host := range d.hosts(csync, nsResponse) {
+		nameserver := d.registeredNameserver(host)
+
+		if dns.IsSubDomain(dns.Fqdn(d.domain.FQDN), host) {
+			for _, rrType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				if !csync.types[rrType] {
+					continue
+				}
+
+				address, status := d.glue(host, rrType, glueResponses, dnskeys)
+				if status != model.CSYNCStatusValid {
+					return status, csync, nil
+				}
+
+				if rrType == dns.TypeA {
+					nameserver.IPv4 = address
+				} else {
+					nameserver.IPv6 = address
+				}
+			}
+
+			// A nameserver inside the domain can't be reached without glue records
+			if nameserver.IPv4 == nil && nameserver.IPv6 == nil {
+				return model.CSYNCStatusInvalid, csync, nil
+			}
+		}
+
+		nameservers = append(nameservers, nameserver)
+	}
+
+	return model.CSYNCStatusValid, csync, nameservers
+}
+
+// List the nameserver hosts that will be in the delegation. When the child zone asks to
+// synchronize the NS records we use the hosts from the NS response, otherwise we keep the
+// registered nameservers
+func (d *DomainCSYNCPolicy) hosts(csync csyncRecord, nsResponse *dns.Msg) []string {
+	var hosts []string
+
+	if !csync.types[dns.TypeNS] {
+		for _, nameserver := range d.domain.Nameservers {
+			hosts = append(hosts, nameserver.Host)
+		}
+		return hosts
+	}
+
+	for _, rr := range dnsutils.FilterRRs(nsResponse.Answer, dns.TypeNS) {
+		if ns, ok := rr.(*dns.NS); ok {
+			hosts = append(hosts, strings.ToLower(ns.Ns))
+		}
+	}
+	return hosts
+}
+
+// Retrieve the registered nameserver with the given host, so that we keep the registered
+// addresses of the nameservers outside the domain, that don't need glue records. When the
+// nameserver isn't registered a new one is returned
+func (d *DomainCSYNCPolicy) registeredNameserver(host string) model.Nameserver {
+	for _, nameserver := range d.domain.Nameservers {
+		if strings.EqualFold(nameserver.Host, host) {
+			return model.Nameserver{
+				Host: nameserver.Host,
+				IPv4: nameserver.IPv4,
+				IPv6: nameserver.IPv6,
+			}
+		}
+	}
+
+	return model.Nameserver{
+		Host: host,
+	}
+}
+
+// Find the glue record of the nameserver in the responses, checking the signature. A
+// nameserver without an address of the given type is valid, as long as it has an address
+// of the other type
+func (d *DomainCSYNCPolicy) glue(host string, rrType uint16, responses []*dns.Msg,
+	dnskeys []dns.RR) (net.IP, model.CSYNCStatus) {
+
+	for _, response := range responses {
+		if len(response.Question) == 0 || response.Question[0].Qtype != rrType ||
+			!strings.EqualFold(response.Question[0].Name, host) {
+			continue
+		}
+
+		rrs := dnsutils.FilterRRs(response.Answer, rrType)
+		if len(rrs) == 0 {
+			return nil, model.CSYNCStatusValid
+		}
+
+		if !dnsutils.VerifyRRSetWithDNSKEYs(rrs,
+			dnsutils.FilterRRs(response.Answer, dns.TypeRRSIG), dnskeys) {
+
+			return nil, model.CSYNCStatusNoSignature
+		}
+
+		switch rr := rrs[0].(type) {
+		case *dns.A:
+			return rr.A, model.CSYNCStatusValid
+		case *dns.AAAA:
+			return rr.AAAA, model.CSYNCStatusValid
+		}
+
+		return nil, model.CSYNCStatusInvalid
+	}
+
+	// The response of the glue query is missing
+	return nil, model.CSYNCStatusDNSError
+}
+
+// Build a DNS query with the DNSSEC records, without recursion as we are asking the
+// authoritative nameservers
+func newRequest(name string, rrType uint16, udpMaxSize uint16) *dns.Msg {
+	var dnsRequestMessage dns.Msg
+	dnsRequestMessage.SetQuestion(dns.Fqdn(name), rrType)
+	dnsRequestMessage.RecursionDesired = false
+	dnsRequestMessage.SetEdns0(udpMaxSize, true)
+	return &dnsRequestMessage
+}
+
+// Find and decode the first CSYNC record. The DNS library doesn't decode the CSYNC
+// records, they are returned as unknown records (RFC 3597) with the raw data in
+// hexadecimal
+func findCSYNC(rrs []dns.RR) (csyncRecord, bool) {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != typeCSYNC {
+			continue
+		}
+
+		record, ok := rr.(*dns.RFC3597)
+		if !ok {
+			return csyncRecord{}, false
+		}
+
+		rdata, err := hex.DecodeString(record.Rdata)
+		if err != nil {
+			return csyncRecord{}, false
+		}
+
+		return decodeCSYNC(rdata)
+	}
+
+	return csyncRecord{}, false
+}
+
+// Decode the CSYNC record data: SOA serial (4 bytes), flags (2 bytes) and the type bit
+// map, that uses the same format of the NSEC record (RFC 4034 - section 4.1.2)
+func decodeCSYNC(rdata []byte) (csyncRecord, bool) {
+	if len(rdata) < 6 {
+		return csyncRecord{}, false
+	}
+
+	csync := csyncRecord{
+		serial: uint32(rdata[0])<<24 | uint32(rdata[1])<<16 | uint32(rdata[2])<<8 | uint32(rdata[3]),
+		flags:  uint16(rdata[4])<<8 | uint16(rdata[5]),
+		types:  make(map[uint16]bool),
+	}
+
+	bitmap := rdata[6:]
+	for len(bitmap) > 0 {
+		if len(bitmap) < 2 {
+			return csyncRecord{}, false
+		}
+
+		window, length := uint16(bitmap[0]), int(bitmap[1])
+		if length == 0 || length > 32 || len(bitmap) < 2+length {
+			return csyncRecord{}, false
+		}
+
+		for i, b := range bitmap[2 : 2+length] {
+			for bit := uint16(0); bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					csync.types[window*256+uint16(i)*8+bit] = true
+				}
+			}
+		}
+
+		bitmap = bitmap[2+length:]
+	}
+
+	return csync, true
+}
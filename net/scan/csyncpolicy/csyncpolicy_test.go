@@ -0,0 +1,500 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package csyncpolicy store the policies for the CSYNC record (RFC 7477), that the child
+// zones use to ask for nameserver and glue updates in the parent zone
+package csyncpolicy
+
+import (
+	"encoding/hex"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeCSYNC(t *testing.T) {
+	csync, ok := decodeCSYNC(csyncRData(2015010101, model.CSYNCFlagImmediate,
+		dns.TypeA, dns.TypeNS, dns.TypeAAAA))
+
+	if !ok {
+		t.Fatal("Not decoding a valid CSYNC record")
+	}
+
+	if csync.serial != 2015010101 || csync.flags != model.CSYNCFlagImmediate {
+		t.Error("Not decoding the serial and flags of the CSYNC record")
+	}
+
+	if len(csync.types) != 3 || !csync.types[dns.TypeA] || !csync.types[dns.TypeNS] ||
+		!csync.types[dns.TypeAAAA] {
+		t.Error("Not decoding the type bit map of the CSYNC record")
+	}
+
+	if _, ok := decodeCSYNC([]byte{0, 0, 0, 1, 0}); ok {
+		t.Error("Accepting a truncated CSYNC record")
+	}
+
+	if _, ok := decodeCSYNC([]byte{0, 0, 0, 1, 0, 0, 0, 4, 0x60}); ok {
+		t.Error("Accepting a CSYNC record with a truncated type bit map")
+	}
+}
+
+func TestGlueRequests(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "example.com.br.",
+	}
+
+	domainCSYNCPolicy := NewDomainCSYNCPolicy(domain)
+
+	if len(domainCSYNCPolicy.Requests(4096)) != 4 {
+		t.Error("Not building the SOA, CSYNC, NS and DNSKEY requests")
+	}
+
+	requests := domainCSYNCPolicy.GlueRequests([]*dns.Msg{
+		{},
+		{Answer: []dns.RR{newCSYNC(2015010101, 0, dns.TypeNS, dns.TypeA)}},
+		{Answer: []dns.RR{
+			newNS("ns1.example.com.br."),
+			newNS("ns2.example.net."),
+		}},
+	}, 4096)
+
+	if len(requests) != 1 || requests[0].Question[0].Name != "ns1.example.com.br." ||
+		requests[0].Question[0].Qtype != dns.TypeA {
+		t.Error("Not asking only for the glue records listed in the CSYNC record")
+	}
+}
+
+func TestRunValid(t *testing.T) {
+	dnskey, privateKey, err := generateKey("example.com.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := newDomain(dnskey)
+
+	csync := newCSYNC(2015010101, model.CSYNCFlagImmediate|model.CSYNCFlagSOAMinimum,
+		dns.TypeNS, dns.TypeA, dns.TypeAAAA)
+	ns1, ns3 := newNS("ns1.example.com.br."), newNS("ns3.example.net.")
+	a := newA("ns1.example.com.br.", "192.0.2.10")
+
+	responses := []*dns.Msg{
+		{Answer: []dns.RR{newSOA(2015010102)}},
+		signedResponse(t, dnskey, privateKey, csync),
+		signedResponse(t, dnskey, privateKey, ns1, ns3),
+		signedResponse(t, dnskey, privateKey, dnskey),
+		signedResponse(t, dnskey, privateKey, a),
+		newQuestion("ns1.example.com.br.", dns.TypeAAAA),
+		{Answer: []dns.RR{newSOA(2015010102)}},
+	}
+	responses[4].SetQuestion("ns1.example.com.br.", dns.TypeA)
+
+	domainCSYNCPolicy := NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses(responses)
+	domainCSYNCPolicy.AddResponses(responses)
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusValid {
+		t.Fatalf("Not accepting a valid CSYNC record. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+
+	if domain.CSYNC.Serial != 2015010101 || !domain.CSYNC.IsImmediate() {
+		t.Error("Not storing the CSYNC serial and flags")
+	}
+
+	expected := []model.Nameserver{
+		{Host: "ns1.example.com.br.", IPv4: net.ParseIP("192.0.2.10")},
+		{Host: "ns3.example.net."},
+	}
+
+	if !model.SameNameservers(domain.CSYNC.Nameservers, expected) {
+		t.Errorf("Not storing the nameserver update. Found %v", domain.CSYNC.Nameservers)
+	}
+}
+
+func TestRunKSKAndZSK(t *testing.T) {
+	ksk, kskPrivateKey, err := generateKey("example.com.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zsk, zskPrivateKey, err := generateKey("example.com.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zsk.Flags = 256
+
+	// Only the KSK is in the parent zone, the ZSK signs the records of the zone
+	domain := newDomain(ksk)
+
+	csync := newCSYNC(2015010101, model.CSYNCFlagImmediate, dns.TypeNS, dns.TypeA)
+	ns1, ns3 := newNS("ns1.example.com.br."), newNS("ns3.example.net.")
+	a := newA("ns1.example.com.br.", "192.0.2.10")
+
+	responses := []*dns.Msg{
+		{Answer: []dns.RR{newSOA(2015010101)}},
+		signedResponse(t, zsk, zskPrivateKey, csync),
+		signedResponse(t, zsk, zskPrivateKey, ns1, ns3),
+		signedResponse(t, ksk, kskPrivateKey, ksk, zsk),
+		signedResponse(t, zsk, zskPrivateKey, a),
+		{Answer: []dns.RR{newSOA(2015010101)}},
+	}
+	responses[4].SetQuestion("ns1.example.com.br.", dns.TypeA)
+
+	domainCSYNCPolicy := NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses(responses)
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusValid {
+		t.Fatalf("Not accepting records signed by a ZSK of a trusted DNSKEY set. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+
+	// DNSKEY set signed only by the ZSK, that isn't in the parent zone
+	responses[3] = signedResponse(t, zsk, zskPrivateKey, ksk, zsk)
+
+	domainCSYNCPolicy = NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses(responses)
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusNoSignature {
+		t.Errorf("Accepting a DNSKEY set outside the chain of trust. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+}
+
+func TestRunUnchanged(t *testing.T) {
+	dnskey, privateKey, err := generateKey("example.com.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := newDomain(dnskey)
+
+	// Only the NS records are synchronized, so the registered glue records are kept
+	csync := newCSYNC(2015010101, 0, dns.TypeNS)
+	ns1, ns2 := newNS("ns1.example.com.br."), newNS("ns2.example.net.")
+
+	domainCSYNCPolicy := NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{newSOA(2015010101)}},
+		signedResponse(t, dnskey, privateKey, csync),
+		signedResponse(t, dnskey, privateKey, ns1, ns2),
+		signedResponse(t, dnskey, privateKey, dnskey),
+		{Answer: []dns.RR{newSOA(2015010101)}},
+	})
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusUnchanged || domain.CSYNC.Nameservers != nil {
+		t.Errorf("Not detecting a CSYNC record without changes. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+}
+
+func TestRunNoSignature(t *testing.T) {
+	dnskey, privateKey, err := generateKey("example.com.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := newDomain(dnskey)
+
+	csync := newCSYNC(2015010101, model.CSYNCFlagImmediate, dns.TypeNS)
+
+	// NS records without signature
+	domainCSYNCPolicy := NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{newSOA(2015010101)}},
+		signedResponse(t, dnskey, privateKey, csync),
+		{Answer: []dns.RR{newNS("ns1.attacker.net.")}},
+		signedResponse(t, dnskey, privateKey, dnskey),
+		{Answer: []dns.RR{newSOA(2015010101)}},
+	})
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusNoSignature {
+		t.Errorf("Accepting NS records without signature. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+
+	// CSYNC record without signature
+	domainCSYNCPolicy = NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{newSOA(2015010101)}},
+		{Answer: []dns.RR{csync}},
+		{Answer: []dns.RR{newNS("ns1.example.com.br.")}},
+		signedResponse(t, dnskey, privateKey, dnskey),
+		{Answer: []dns.RR{newSOA(2015010101)}},
+	})
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusNoSignature {
+		t.Errorf("Accepting a CSYNC record without signature. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+}
+
+func TestRunSOAMinimum(t *testing.T) {
+	dnskey, privateKey, err := generateKey("example.com.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := newDomain(dnskey)
+
+	csync := newCSYNC(2015010105, model.CSYNCFlagSOAMinimum, dns.TypeNS)
+
+	domainCSYNCPolicy := NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{newSOA(2015010101)}},
+		signedResponse(t, dnskey, privateKey, csync),
+		signedResponse(t, dnskey, privateKey, newNS("ns1.example.net.")),
+		signedResponse(t, dnskey, privateKey, dnskey),
+		{Answer: []dns.RR{newSOA(2015010101)}},
+	})
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusSOAMinimum {
+		t.Errorf("Not honoring the soaminimum flag. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+}
+
+func TestRunInconsistent(t *testing.T) {
+	dnskey, privateKey, err := generateKey("example.com.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := newDomain(dnskey)
+
+	csync := newCSYNC(2015010101, 0, dns.TypeNS)
+
+	// Zone changed during the check
+	domainCSYNCPolicy := NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{newSOA(2015010101)}},
+		signedResponse(t, dnskey, privateKey, csync),
+		signedResponse(t, dnskey, privateKey, newNS("ns1.example.net.")),
+		signedResponse(t, dnskey, privateKey, dnskey),
+		{Answer: []dns.RR{newSOA(2015010102)}},
+	})
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusInconsistent {
+		t.Errorf("Not detecting a zone change during the check. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+
+	// Nameservers with different NS records
+	domainCSYNCPolicy = NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{newSOA(2015010101)}},
+		signedResponse(t, dnskey, privateKey, csync),
+		signedResponse(t, dnskey, privateKey, newNS("ns1.example.net.")),
+		signedResponse(t, dnskey, privateKey, dnskey),
+		{Answer: []dns.RR{newSOA(2015010101)}},
+	})
+	domainCSYNCPolicy.AddResponses([]*dns.Msg{
+		{Answer: []dns.RR{newSOA(2015010101)}},
+		signedResponse(t, dnskey, privateKey, csync),
+		signedResponse(t, dnskey, privateKey, newNS("ns2.example.net.")),
+		signedResponse(t, dnskey, privateKey, dnskey),
+		{Answer: []dns.RR{newSOA(2015010101)}},
+	})
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusInconsistent {
+		t.Errorf("Not detecting nameservers with different records. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+}
+
+func TestRunNotFoundAndDNSError(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "example.com.br.",
+	}
+
+	domainCSYNCPolicy := NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses([]*dns.Msg{{}, {}, {}, {}, {}})
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusNotFound {
+		t.Errorf("Not detecting zones without CSYNC record. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+
+	domainCSYNCPolicy = NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.AddResponses([]*dns.Msg{{}, nil, {}, {}, {}})
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusDNSError {
+		t.Errorf("Not detecting network errors. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+
+	domainCSYNCPolicy = NewDomainCSYNCPolicy(domain)
+	domainCSYNCPolicy.Run()
+
+	if domain.CSYNC.LastStatus != model.CSYNCStatusDNSError {
+		t.Errorf("Not detecting domains without responses. Found %s",
+			model.CSYNCStatusToString(domain.CSYNC.LastStatus))
+	}
+}
+
+func newDomain(dnskey *dns.DNSKEY) *model.Domain {
+	ds := dnskey.ToDS(dns.SHA256)
+	return &model.Domain{
+		FQDN: "example.com.br.",
+		Nameservers: []model.Nameserver{
+			{Host: "ns1.example.com.br.", IPv4: net.ParseIP("192.0.2.1")},
+			{Host: "ns2.example.net."},
+		},
+		DSSet: []model.DS{
+			{
+				Keytag:     ds.KeyTag,
+				Algorithm:  model.DSAlgorithm(ds.Algorithm),
+				DigestType: model.DSDigestTypeSHA256,
+				Digest:     strings.ToUpper(ds.Digest),
+			},
+		},
+	}
+}
+
+func newQuestion(name string, rrType uint16) *dns.Msg {
+	var dnsResponseMessage dns.Msg
+	dnsResponseMessage.SetQuestion(name, rrType)
+	return &dnsResponseMessage
+}
+
+func newSOA(serial uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   "example.com.br.",
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		Ns:     "ns1.example.com.br.",
+		Mbox:   "hostmaster.example.com.br.",
+		Serial: serial,
+	}
+}
+
+func newNS(host string) *dns.NS {
+	return &dns.NS{
+		Hdr: dns.RR_Header{
+			Name:   "example.com.br.",
+			Rrtype: dns.TypeNS,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		Ns: host,
+	}
+}
+
+func newA(host, address string) *dns.A {
+	return &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   host,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		A: net.ParseIP(address),
+	}
+}
+
+// The DNS library decodes the CSYNC records received from the network as unknown records,
+// so we build them in the same way
+func newCSYNC(serial uint32, flags uint16, types ...uint16) *dns.RFC3597 {
+	return &dns.RFC3597{
+		Hdr: dns.RR_Header{
+			Name:   "example.com.br.",
+			Rrtype: typeCSYNC,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		Rdata: hex.EncodeToString(csyncRData(serial, flags, types...)),
+	}
+}
+
+// Build the CSYNC record data with the type bit map of the window zero
+func csyncRData(serial uint32, flags uint16, types ...uint16) []byte {
+	bitmap := make([]byte, 32)
+	length := 0
+	for _, rrType := range types {
+		bitmap[rrType/8] |= 0x80 >> (rrType % 8)
+		if int(rrType/8)+1 > length {
+			length = int(rrType/8) + 1
+		}
+	}
+
+	rdata := []byte{
+		byte(serial >> 24), byte(serial >> 16), byte(serial >> 8), byte(serial),
+		byte(flags >> 8), byte(flags),
+		0, byte(length),
+	}
+	return append(rdata, bitmap[:length]...)
+}
+
+func signedResponse(t *testing.T, dnskey *dns.DNSKEY, privateKey dns.PrivateKey,
+	rrset ...dns.RR) *dns.Msg {
+
+	rrsig, err := signRRSet(dnskey, privateKey, rrset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &dns.Msg{
+		Answer: append(rrset, rrsig),
+	}
+}
+
+func generateKey(zone string) (*dns.DNSKEY, dns.PrivateKey, error) {
+	dnskey := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.RSASHA256,
+	}
+
+	privateKey, err := dnskey.Generate(1024)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dnskey, privateKey, nil
+}
+
+func signRRSet(dnskey *dns.DNSKEY, privateKey dns.PrivateKey, rrset []dns.RR) (*dns.RRSIG, error) {
+	rrsig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name:   rrset[0].Header().Name,
+			Rrtype: dns.TypeRRSIG,
+			Class:  dns.ClassINET,
+			Ttl:    rrset[0].Header().Ttl,
+		},
+		TypeCovered: rrset[0].Header().Rrtype,
+		Algorithm:   dnskey.Algorithm,
+		Expiration:  uint32(time.Now().Add(10 * time.Second).Unix()),
+		Inception:   uint32(time.Now().Unix()),
+		KeyTag:      dnskey.KeyTag(),
+		SignerName:  dnskey.Hdr.Name,
+	}
+
+	if err := rrsig.Sign(privateKey, rrset); err != nil {
+		return nil, err
+	}
+
+	return rrsig, nil
+}
@@ -0,0 +1,460 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2"
+	"github.com/rafaeljusto/shelter/config"
+	"github.com/rafaeljusto/shelter/dao"
+	"github.com/rafaeljusto/shelter/database/mongodb"
+	"github.com/rafaeljusto/shelter/log"
+	"github.com/rafaeljusto/shelter/model"
+)
+
+// Default settings of the distributed scan, used when the configuration doesn't define
+// them
+const (
+	defaultBatchSize     = 1000
+	defaultLeaseDuration = 5 * time.Minute
+	defaultPollInterval  = 10 * time.Second
+)
+
+const (
+	// Number of lease durations that the coordinator waits for the workers without any
+	// progress in the batches before giving up the batches that weren't committed. A
+	// worker renews the lease while it scans a batch, so after this time probably there's
+	// no worker alive
+	maxLeasesWithoutProgress = 3
+)
+
+// distribution store the settings of a distributed scan, loaded from the configuration
+// when the coordinator or the worker starts a scan, so that a configuration reload doesn't
+// change the settings of a running scan
+type distribution struct {
+	workerID      string        // Identification of this worker in the leases, unique between the workers
+	batchSize     int           // Number of domains in each batch
+	leaseDuration time.Duration // Time that a worker has to scan a batch before another worker can lease it
+	pollInterval  time.Duration // Time between checks of the batches by the workers and the coordinator
+}
+
+// Load the settings of the distributed scan from the configuration, using the default
+// value of each setting that isn't configured
+func loadDistribution() distribution {
+	settings := distribution{
+		workerID:      config.ShelterConfig.Scan.Distributed.WorkerID,
+		batchSize:     defaultBatchSize,
+		leaseDuration: defaultLeaseDuration,
+		pollInterval:  defaultPollInterval,
+	}
+
+	if len(settings.workerID) == 0 {
+		settings.workerID = defaultWorkerID()
+	}
+
+	if config.ShelterConfig.Scan.Distributed.BatchSize > 0 {
+		settings.batchSize = config.ShelterConfig.Scan.Distributed.BatchSize
+	}
+
+	if config.ShelterConfig.Scan.Distributed.LeaseMinutes > 0 {
+		settings.leaseDuration =
+			time.Duration(config.ShelterConfig.Scan.Distributed.LeaseMinutes) * time.Minute
+	}
+
+	if config.ShelterConfig.Scan.Distributed.PollSeconds > 0 {
+		settings.pollInterval =
+			time.Duration(config.ShelterConfig.Scan.Distributed.PollSeconds) * time.Second
+	}
+
+	return settings
+}
+
+// Function responsible for coordinating a distributed scan. The domains that are due are
+// split into batches stored in the database, and the function waits until the workers
+// commit all batches, merging their statistics in the current scan. The scan is saved at
+// the end, in the same way of a scan executed by only one instance
+func coordinateScan(database *mgo.Database) {
+	scanBatchDAO := dao.ScanBatchDAO{
+		Database: database,
+	}
+
+	settings := loadDistribution()
+
+	// Create a new scan information
+	model.StartNewScan()
+	scanStartedAt := model.GetCurrentScan().StartedAt
+	stopCheckpoints := checkpointScan(database)
+
+	errorDetected := false
+	if err := createScanBatches(database, scanStartedAt, settings, nil); err != nil {
+		log.Println("Error while creating the scan batches. Details:", err)
+		errorDetected = true
+	}
+
+	if waitScanBatches(scanBatchDAO, scanStartedAt, settings) {
+		errorDetected = true
+	}
+
+	stopCheckpoints()
+	finishCoordinatedScan(database, scanStartedAt, errorDetected)
+}
+
+// Continue coordinating a distributed scan that was interrupted, with the scan
+// information already resumed from the last checkpoint. The workers don't depend on the
+// coordinator, so the batches created before the interruption were scanned or are still
+// being scanned, and we only need to wait for them. When the interruption happened while
+// the batches were created, the domains that weren't split into batches yet are split now,
+// continuing the sequence of the batches
+func resumeCoordinatedScan(database *mgo.Database, scanStartedAt time.Time) {
+	scanBatchDAO := dao.ScanBatchDAO{
+		Database: database,
+	}
+
+	settings := loadDistribution()
+	stopCheckpoints := checkpointScan(database)
+
+	errorDetected := false
+	batches, err := scanBatchDAO.FindAllByScan(scanStartedAt, true)
+	if err != nil {
+		log.Println("Error while loading the scan batches. Details:", err)
+		errorDetected = true
+	}
+
+	// The domains of the committed batches are already counted in the scan information
+	// restored from the checkpoint
+	for _, batch := range batches {
+		if batch.Status != model.ScanBatchStatusCommitted {
+			for i := 0; i < len(batch.FQDNs); i++ {
+				model.LoadedDomainForScan()
+			}
+		}
+	}
+
+	if err != nil || model.GetCurrentScan().BatchesCreated {
+		model.FinishLoadingDomainsForScan()
+
+	} else if err := createScanBatches(database, scanStartedAt, settings, batches); err != nil {
+		log.Println("Error while creating the scan batches. Details:", err)
+		errorDetected = true
+	}
+
+	if waitScanBatches(scanBatchDAO, scanStartedAt, settings) {
+		errorDetected = true
+	}
+
+	stopCheckpoints()
+	finishCoordinatedScan(database, scanStartedAt, errorDetected)
+}
+
+// Save the scan information of a distributed scan for future reports and remove the
+// batches of the scan, that aren't necessary anymore
+func finishCoordinatedScan(database *mgo.Database, scanStartedAt time.Time,
+	errorDetected bool) {
+
+	scanDAO := dao.ScanDAO{
+		Database: database,
+	}
+
+	scanBatchDAO := dao.ScanBatchDAO{
+		Database: database,
+	}
+
+	// Save the scan information for future reports
+	if err := model.FinishAndSaveScan(errorDetected, scanDAO.Save); err != nil {
+		log.Println("Error while saving scan information. Details:", err)
+	}
+
+	if err := scanBatchDAO.RemoveAllByScan(scanStartedAt); err != nil {
+		log.Println("Error while removing the scan batches. Details:", err)
+	}
+}
+
+// Split the domains that are due into batches for the workers. The domains are loaded in
+// the order that they should be checked, so the first batches have the most urgent
+// domains. The domains are counted in the scan information to estimate the scan progress.
+// When resuming the creation of the batches, the domains of the batches already created
+// are ignored and the sequence continues after the last batch. When all domains were split
+// into batches it's recorded in the scan information
+func createScanBatches(database *mgo.Database, scanStartedAt time.Time,
+	settings distribution, createdBatches []model.ScanBatch) error {
+
+	defer model.FinishLoadingDomainsForScan()
+
+	domainDAO := dao.DomainDAO{
+		Database: database,
+	}
+
+	scanBatchDAO := dao.ScanBatchDAO{
+		Database: database,
+	}
+
+	until := time.Now().Add(time.Duration(config.ShelterConfig.Scan.IntervalHours) * time.Hour)
+	domainChannel, err := domainDAO.FindAllAsyncToBeScanned(until, scanStartedAt, nil)
+	if err != nil {
+		return err
+	}
+
+	sequence := 0
+	batched := make(map[string]bool)
+	for _, batch := range createdBatches {
+		if batch.Sequence > sequence {
+			sequence = batch.Sequence
+		}
+
+		for _, fqdn := range batch.FQDNs {
+			batched[fqdn] = true
+		}
+	}
+
+	var fqdns []string
+
+	saveBatch := func() error {
+		sequence += 1
+		batch := model.ScanBatch{
+			ScanStartedAt: scanStartedAt,
+			Sequence:      sequence,
+			FQDNs:         fqdns,
+			Status:        model.ScanBatchStatusPending,
+		}
+
+		fqdns = nil
+		return scanBatchDAO.Save(&batch)
+	}
+
+	for {
+		domainResult := <-domainChannel
+
+		// We don't have domains anymore, store the last batch. The error is only returned
+		// after all domains were sent by the database
+		if domainResult.Error != nil || domainResult.Domain == nil {
+			if err == nil && len(fqdns) > 0 {
+				err = saveBatch()
+			}
+
+			if domainResult.Error != nil {
+				return domainResult.Error
+			}
+
+			if err == nil {
+				model.FinishCreatingScanBatches()
+			}
+
+			return err
+		}
+
+		// After an error we keep reading the domains, so that the database go routine can
+		// finish, but we don't create more batches
+		if err != nil || batched[domainResult.Domain.FQDN] {
+			continue
+		}
+
+		fqdns = append(fqdns, domainResult.Domain.FQDN)
+
+		// Count domain for the scan information to estimate the scan progress
+		model.LoadedDomainForScan()
+
+		if len(fqdns) >= settings.batchSize {
+			err = saveBatch()
+		}
+	}
+}
+
+// Wait until the workers commit all batches of the scan, merging the statistics of the
+// committed batches in the current scan to show the progress. When the batches don't
+// change for too long, or when the next scan should already start, the batches that
+// weren't committed are marked as failed, as the workers are probably down. Returns true
+// if errors were detected in any batch or while checking the batches
+func waitScanBatches(scanBatchDAO dao.ScanBatchDAO, scanStartedAt time.Time,
+	settings distribution) bool {
+
+	errorDetected := false
+
+	var deadline time.Time
+	if config.ShelterConfig.Scan.IntervalHours > 0 {
+		deadline = scanStartedAt.Add(
+			time.Duration(config.ShelterConfig.Scan.IntervalHours) * time.Hour)
+	}
+
+	// The workers update the batches when they lease, renew or commit them, so any
+	// modification in the batches is a progress of the scan
+	var lastModifiedAt time.Time
+	lastProgressAt := time.Now()
+
+	for {
+		batches, err := scanBatchDAO.FindAllByScan(scanStartedAt, false)
+		if err != nil {
+			// The workers don't depend on the coordinator, so we keep waiting for them
+			log.Println("Error while checking the scan batches. Details:", err)
+			errorDetected = true
+
+		} else {
+			var batchesStatistics []model.ScanBatchStatistics
+			for _, batch := range batches {
+				if batch.Status == model.ScanBatchStatusCommitted {
+					batchesStatistics = append(batchesStatistics, batch.Statistics)
+				}
+
+				if batch.LastModifiedAt.After(lastModifiedAt) {
+					lastModifiedAt = batch.LastModifiedAt
+					lastProgressAt = time.Now()
+				}
+			}
+
+			if model.StoreScanBatchesStatistics(batchesStatistics) {
+				errorDetected = true
+			}
+
+			if len(batchesStatistics) == len(batches) {
+				return errorDetected
+			}
+		}
+
+		stalled := time.Since(lastProgressAt) > maxLeasesWithoutProgress*settings.leaseDuration
+		expired := !deadline.IsZero() && time.Now().After(deadline)
+
+		if stalled || expired {
+			log.Println("Giving up the scan batches that weren't committed by the workers")
+			if err := scanBatchDAO.FailAllByScan(scanStartedAt); err != nil {
+				log.Println("Error while giving up the scan batches. Details:", err)
+			}
+
+			return true
+		}
+
+		time.Sleep(settings.pollInterval)
+	}
+}
+
+// Function responsible for running a worker of a distributed scan. The worker leases the
+// batches created by the coordinator, scans the domains and commits the statistics, until
+// there's no batch left. Then the worker waits and looks for batches again. This function
+// never returns
+func WorkOnScanBatches() {
+	log.Info("Start scan worker")
+
+	for {
+		settings := loadDistribution()
+		scanBatches(settings)
+		time.Sleep(settings.pollInterval)
+	}
+}
+
+// Lease and scan batches until there's no batch left to lease
+func scanBatches(settings distribution) {
+	defer func() {
+		// Something went really wrong while scanning the batches. Log the error stacktrace
+		// and move out, the batch lease will expire and another worker will scan it
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Printf("Panic detected while scanning batches. Details: %v\n%s", r, buf)
+		}
+	}()
+
+	database, databaseSession, err := mongodb.Open(
+		config.ShelterConfig.Database.URIs,
+		config.ShelterConfig.Database.Name,
+		config.ShelterConfig.Database.Auth.Enabled,
+		config.ShelterConfig.Database.Auth.Username,
+		config.ShelterConfig.Database.Auth.Password,
+	)
+
+	if err != nil {
+		log.Println("Error while initializing database. Details:", err)
+		return
+	}
+	defer databaseSession.Close()
+
+	scanBatchDAO := dao.ScanBatchDAO{
+		Database: database,
+	}
+
+	for {
+		batch, err := scanBatchDAO.Lease(settings.workerID, settings.leaseDuration)
+		if err == mgo.ErrNotFound {
+			return
+
+		} else if err != nil {
+			log.Println("Error while leasing a scan batch. Details:", err)
+			return
+		}
+
+		log.Infof("Scanning batch %d (%d domains) of the scan started at %s",
+			batch.Sequence, len(batch.FQDNs), batch.ScanStartedAt)
+
+		scanBatch(database, scanBatchDAO, batch, settings)
+	}
+}
+
+// Scan the domains of a leased batch and commit the statistics. The lease is renewed while
+// the domains are scanned, so that another worker only leases the batch when this worker
+// crashed. When the lease is lost the scan of the batch is canceled and nothing is
+// committed, as another worker is responsible for the batch now
+func scanBatch(database *mgo.Database, scanBatchDAO dao.ScanBatchDAO, batch model.ScanBatch,
+	settings distribution) {
+
+	control := newScanControl()
+	stopRenewal := make(chan bool)
+	leaseLost := make(chan bool)
+
+	go func(batch model.ScanBatch) {
+		ticker := time.NewTicker(settings.leaseDuration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				err := scanBatchDAO.Renew(&batch, settings.leaseDuration)
+				if err == dao.ErrScanBatchDAOLeaseLost {
+					log.Printf("Lease of the scan batch %d lost, canceling the scan of the batch",
+						batch.Sequence)
+
+					close(leaseLost)
+					control.cancel()
+					return
+
+				} else if err != nil {
+					log.Println("Error while renewing the scan batch lease. Details:", err)
+				}
+
+			case <-stopRenewal:
+				return
+			}
+		}
+	}(batch)
+
+	model.StartNewScan()
+	errorDetected := runScan(database, batch.FQDNs, control)
+	close(stopRenewal)
+
+	select {
+	case <-leaseLost:
+		model.FinishScanBatch()
+		return
+	default:
+	}
+
+	batch.Statistics = model.NewScanBatchStatistics(model.FinishScanBatch(), errorDetected)
+	if err := scanBatchDAO.Commit(&batch); err != nil {
+		log.Println("Error while committing the scan batch. Details:", err)
+	}
+}
+
+// Identify the worker by the host name and the process id, so that many workers can run
+// in the same host
+func defaultWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
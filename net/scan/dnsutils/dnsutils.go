@@ -6,7 +6,12 @@
 package dnsutils
 
 import (
+	"encoding/base64"
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Useful function to retrieve all records of a specific type from the DNS response
@@ -33,3 +38,371 @@ func FilterFirstRR(rrs []dns.RR, rrType uint16) dns.RR {
 	}
 	return nil
 }
+
+// Compare two domain names using the canonical DNS name order defined in RFC 4034 -
+// section 6.1. The names are compared label by label from the right to the left, case
+// insensitive. Returns a negative number when name1 comes before name2, zero when they
+// are equal and a positive number otherwise
+func CompareCanonical(name1, name2 string) int {
+	labels1 := dns.SplitDomainName(strings.ToLower(name1))
+	labels2 := dns.SplitDomainName(strings.ToLower(name2))
+
+	for i, j := len(labels1)-1, len(labels2)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if labels1[i] < labels2[j] {
+			return -1
+		} else if labels1[i] > labels2[j] {
+			return 1
+		}
+	}
+
+	return len(labels1) - len(labels2)
+}
+
+// Check if a name is inside the interval (owner, next) of a NSEC record, using the
+// canonical DNS name order. The last NSEC record of the zone points back to the zone
+// apex, so when next is before the owner the interval wraps around
+func NSECCovers(nsec *dns.NSEC, name string) bool {
+	owner := nsec.Hdr.Name
+	if CompareCanonical(owner, nsec.NextDomain) < 0 {
+		return CompareCanonical(owner, name) < 0 && CompareCanonical(name, nsec.NextDomain) < 0
+	}
+
+	return CompareCanonical(owner, name) < 0 || CompareCanonical(name, nsec.NextDomain) < 0
+}
+
+// Check if the hash of a name is inside the interval (owner hash, next hash) of a NSEC3
+// record. As in NSEC, the last NSEC3 record of the zone wraps around to the first one
+func NSEC3Covers(nsec3 *dns.NSEC3, name string) bool {
+	hash := NSEC3Hash(nsec3, name)
+	if len(hash) == 0 {
+		return false
+	}
+
+	owner := NSEC3OwnerHash(nsec3)
+	next := strings.ToUpper(nsec3.NextDomain)
+
+	if owner < next {
+		return owner < hash && hash < next
+	}
+
+	return owner < hash || hash < next
+}
+
+// Check if the hash of a name is the owner of a NSEC3 record
+func NSEC3Matches(nsec3 *dns.NSEC3, name string) bool {
+	hash := NSEC3Hash(nsec3, name)
+	return len(hash) > 0 && hash == NSEC3OwnerHash(nsec3)
+}
+
+// Generate the hash of a name using the NSEC3 record parameters. The empty salt can
+// appear as "-" when the record comes from a text format
+func NSEC3Hash(nsec3 *dns.NSEC3, name string) string {
+	salt := nsec3.Salt
+	if salt == "-" {
+		salt = ""
+	}
+
+	return dns.HashName(name, nsec3.Hash, nsec3.Iterations, salt)
+}
+
+// Retrieve the hash from the first label of the NSEC3 owner name in upper case, that is
+// the same format of the next hashed owner name
+func NSEC3OwnerHash(nsec3 *dns.NSEC3) string {
+	labels := dns.SplitDomainName(nsec3.Hdr.Name)
+	if len(labels) == 0 {
+		return ""
+	}
+
+	return strings.ToUpper(labels[0])
+}
+
+// Compare two SOA serials using the serial number arithmetic defined in RFC 1982. Returns
+// a negative number when serial1 is before serial2, zero when they are equal or when the
+// comparison is undefined (distance of exactly 2^31) and a positive number otherwise
+func CompareSerial(serial1, serial2 uint32) int {
+	if serial1 == serial2 {
+		return 0
+	}
+
+	distance := serial2 - serial1
+	if distance == 1<<31 {
+		return 0
+	} else if distance < 1<<31 {
+		return -1
+	}
+
+	return 1
+}
+
+// Check if a name is a valid hostname (RFC 952 and RFC 1123), where each label has only
+// letters, digits and hyphens, and doesn't start or end with a hyphen
+func IsHostname(name string) bool {
+	if _, ok := dns.IsDomainName(name); !ok {
+		return false
+	}
+
+	labels := dns.SplitDomainName(name)
+	if len(labels) == 0 {
+		return false
+	}
+
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 ||
+			strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') &&
+				!(c >= '0' && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Check if two lists of resource records have the same records, ignoring the order of
+// the records and the case of the owner names
+func SameRRs(rrs1, rrs2 []dns.RR) bool {
+	if len(rrs1) != len(rrs2) {
+		return false
+	}
+
+	records := make(map[string]int)
+	for _, rr := range rrs1 {
+		records[strings.ToLower(rr.String())]++
+	}
+
+	for _, rr := range rrs2 {
+		record := strings.ToLower(rr.String())
+		if records[record] == 0 {
+			return false
+		}
+		records[record]--
+	}
+
+	return true
+}
+
+// Retrieve the keytags of the DNSKEY records in ascending order. The keytags are a short
+// way to identify a DNSKEY RRset, useful to compare the keys published by the nameservers
+func KeyTags(rrs []dns.RR) []uint16 {
+	var keytags []uint16
+	for _, rr := range FilterRRs(rrs, dns.TypeDNSKEY) {
+		if dnskey, ok := rr.(*dns.DNSKEY); ok {
+			keytags = append(keytags, dnskey.KeyTag())
+		}
+	}
+
+	sort.Sort(uint16Slice(keytags))
+	return keytags
+}
+
+// uint16Slice was created only to sort the keytags, as the sort package doesn't have a
+// helper for uint16 values
+type uint16Slice []uint16
+
+func (k uint16Slice) Len() int           { return len(k) }
+func (k uint16Slice) Less(i, j int) bool { return k[i] < k[j] }
+func (k uint16Slice) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+
+// Convert the resource records to the presentation format, so that they can be stored in
+// the diagnostic of a configuration check
+func RRsToString(rrs []dns.RR) []string {
+	var records []string
+	for _, rr := range rrs {
+		records = append(records, rr.String())
+	}
+	return records
+}
+
+// Convert the question of the DNS message to the presentation format (name, class and
+// type). Returns an empty string when the message doesn't have a question
+func QuestionToString(dnsMessage *dns.Msg) string {
+	if dnsMessage == nil || len(dnsMessage.Question) == 0 {
+		return ""
+	}
+
+	question := dnsMessage.Question[0]
+	return question.Name + " " + dns.ClassToString[question.Qclass] + " " +
+		dns.TypeToString[question.Qtype]
+}
+
+// Complete the diagnostic built by the policies with the address (host:port) that received
+// the request, the question of the request and the response code of the answer. The
+// question is kept when the policies already defined it, as some problems are detected in
+// the responses of other queries. The request and the response can be nil when they
+// aren't related to the problem, or when the nameserver didn't answer
+func FillDiagnostic(diagnostic model.Diagnostic, host string,
+	dnsRequestMessage, dnsResponseMessage *dns.Msg) model.Diagnostic {
+
+	diagnostic.Address = host
+
+	if len(diagnostic.Query) == 0 {
+		diagnostic.Query = QuestionToString(dnsRequestMessage)
+	}
+
+	if dnsResponseMessage != nil {
+		diagnostic.Rcode = dns.RcodeToString[dnsResponseMessage.Rcode]
+	}
+
+	return diagnostic
+}
+
+// Retrieve the size in bits of the modulus of a RSA DNSKEY. The public key format is
+// defined in RFC 3110 - section 2, where the first byte is the exponent length, or zero
+// followed by two bytes with the exponent length. Returns zero when the key isn't RSA or
+// the public key could not be decoded
+func RSAKeySize(dnskey *dns.DNSKEY) int {
+	switch dnskey.Algorithm {
+	case dns.RSAMD5, dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512:
+	default:
+		return 0
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(
+		strings.Replace(dnskey.PublicKey, " ", "", -1))
+	if err != nil || len(publicKey) < 3 {
+		return 0
+	}
+
+	exponentLength, offset := int(publicKey[0]), 1
+	if exponentLength == 0 {
+		exponentLength, offset = int(publicKey[1])<<8|int(publicKey[2]), 3
+	}
+
+	if offset+exponentLength >= len(publicKey) {
+		return 0
+	}
+
+	modulus := publicKey[offset+exponentLength:]
+
+	// Leading zeros don't count in the key size
+	for len(modulus) > 0 && modulus[0] == 0 {
+		modulus = modulus[1:]
+	}
+
+	if len(modulus) == 0 {
+		return 0
+	}
+
+	return (len(modulus)-1)*8 + bitLength(modulus[0])
+}
+
+// Number of significant bits in a byte
+func bitLength(b byte) int {
+	length := 0
+	for ; b > 0; b >>= 1 {
+		length++
+	}
+	return length
+}
+
+// Check if the record set was signed with a key that is represented in both the DNSKEY
+// and DS sets, so that only who controls the current chain of trust can publish the
+// records. Useful for the records that the child zone uses to ask for changes in the
+// parent zone (CDS, CDNSKEY and CSYNC)
+func VerifyRRSet(rrset, rrsigs, dnskeys []dns.RR, dsSet []model.DS) bool {
+	return verifyRRSet(rrset, rrsigs, func(rrsig *dns.RRSIG) *dns.DNSKEY {
+		return TrustedDNSKEY(dnskeys, rrsig.KeyTag, dsSet)
+	})
+}
+
+// Check if the record set was signed with any key of the DNSKEY set. The DNSKEY set must
+// be validated before (TrustedDNSKEYSet), so that zones with a KSK in the DS set and a
+// ZSK signing the other records are accepted
+func VerifyRRSetWithDNSKEYs(rrset, rrsigs, dnskeys []dns.RR) bool {
+	return verifyRRSet(rrset, rrsigs, func(rrsig *dns.RRSIG) *dns.DNSKEY {
+		for _, rr := range dnskeys {
+			dnskey, ok := rr.(*dns.DNSKEY)
+			if !ok {
+				continue
+			}
+
+			// The base64 decode method don't deal very well with spaces inside the public key
+			// raw data. So we replace it before calculating the KeyTag
+			dnskey.PublicKey = strings.Replace(dnskey.PublicKey, " ", "", -1)
+
+			if dnskey.KeyTag() == rrsig.KeyTag && dnskey.Algorithm == rrsig.Algorithm {
+				return dnskey
+			}
+		}
+
+		return nil
+	})
+}
+
+// Returns the DNSKEY set when it was signed with a key that is represented in the DS set,
+// following the chain of trust from the parent zone. Returns nil if the DNSKEY set can't
+// be trusted
+func TrustedDNSKEYSet(dnskeys, rrsigs []dns.RR, dsSet []model.DS) []dns.RR {
+	if VerifyRRSet(dnskeys, rrsigs, dnskeys, dsSet) {
+		return dnskeys
+	}
+
+	return nil
+}
+
+// Check if one of the signatures of the record set is valid, using the key returned by
+// the given function for each signature
+func verifyRRSet(rrset, rrsigs []dns.RR, dnskey func(*dns.RRSIG) *dns.DNSKEY) bool {
+	if len(rrset) == 0 {
+		return false
+	}
+
+	for _, rr := range rrsigs {
+		rrsig, ok := rr.(*dns.RRSIG)
+		if !ok || rrsig.TypeCovered != rrset[0].Header().Rrtype {
+			continue
+		}
+
+		rrsig.Signature = strings.Replace(rrsig.Signature, " ", "", -1)
+
+		key := dnskey(rrsig)
+		if key == nil || !rrsig.ValidityPeriod(time.Now()) {
+			continue
+		}
+
+		if err := rrsig.Verify(key, rrset); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Find the DNSKEY with the given keytag that is also represented in the DS set. Returns
+// nil if there's no such key
+func TrustedDNSKEY(dnskeys []dns.RR, keytag uint16, dsSet []model.DS) *dns.DNSKEY {
+	for _, rr := range dnskeys {
+		dnskey, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			continue
+		}
+
+		// The base64 decode method don't deal very well with spaces inside the public key raw
+		// data. So we replace it before calculating the KeyTag
+		dnskey.PublicKey = strings.Replace(dnskey.PublicKey, " ", "", -1)
+
+		if dnskey.KeyTag() != keytag {
+			continue
+		}
+
+		for _, ds := range dsSet {
+			if ds.Keytag != keytag || uint8(ds.Algorithm) != dnskey.Algorithm {
+				continue
+			}
+
+			// Hash generated by library is always lower case
+			if dsRecord := dnskey.ToDS(uint8(ds.DigestType)); dsRecord != nil &&
+				dsRecord.Digest == strings.ToLower(ds.Digest) {
+
+				return dnskey
+			}
+		}
+	}
+
+	return nil
+}
@@ -7,7 +7,10 @@ package dnsutils
 
 import (
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestFilterRRs(t *testing.T) {
@@ -75,3 +78,396 @@ func TestFilterFirstRR(t *testing.T) {
 		t.Error("Found a RR that shouldn't exist")
 	}
 }
+
+func TestCompareCanonical(t *testing.T) {
+	// Example from RFC 4034 - section 6.1
+	names := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		"*.z.example.",
+	}
+
+	for i := 0; i < len(names)-1; i++ {
+		if CompareCanonical(names[i], names[i+1]) >= 0 {
+			t.Errorf("Name %s should be before %s in canonical order", names[i], names[i+1])
+		}
+
+		if CompareCanonical(names[i+1], names[i]) <= 0 {
+			t.Errorf("Name %s should be after %s in canonical order", names[i+1], names[i])
+		}
+	}
+
+	if CompareCanonical("Example.COM.", "example.com.") != 0 {
+		t.Error("Canonical order is not case insensitive")
+	}
+}
+
+func TestNSECCovers(t *testing.T) {
+	nsec := &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   "b.example.com.br.",
+			Rrtype: dns.TypeNSEC,
+		},
+		NextDomain: "d.example.com.br.",
+	}
+
+	if !NSECCovers(nsec, "c.example.com.br.") {
+		t.Error("Not detecting a name covered by NSEC")
+	}
+
+	if NSECCovers(nsec, "e.example.com.br.") || NSECCovers(nsec, "b.example.com.br.") {
+		t.Error("Detecting a name that isn't covered by NSEC")
+	}
+
+	// Last NSEC of the zone
+	nsec = &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   "d.example.com.br.",
+			Rrtype: dns.TypeNSEC,
+		},
+		NextDomain: "example.com.br.",
+	}
+
+	if !NSECCovers(nsec, "e.example.com.br.") {
+		t.Error("Not detecting a name covered by the last NSEC of the zone")
+	}
+
+	if NSECCovers(nsec, "c.example.com.br.") {
+		t.Error("Detecting a name that isn't covered by the last NSEC of the zone")
+	}
+}
+
+func TestNSEC3Covers(t *testing.T) {
+	hash := dns.HashName("a.example.com.br.", dns.SHA1, 0, "")
+
+	nsec3 := &dns.NSEC3{
+		Hdr: dns.RR_Header{
+			Name:   hash + ".example.com.br.",
+			Rrtype: dns.TypeNSEC3,
+		},
+		Hash:       dns.SHA1,
+		Iterations: 0,
+		Salt:       "-",
+		NextDomain: hash,
+	}
+
+	if !NSEC3Matches(nsec3, "a.example.com.br.") {
+		t.Error("Not matching the NSEC3 owner name")
+	}
+
+	if NSEC3Matches(nsec3, "b.example.com.br.") {
+		t.Error("Matching the wrong NSEC3 owner name")
+	}
+
+	// A NSEC3 that points to itself is the only one in the zone and covers everything
+	// except the owner name
+	if !NSEC3Covers(nsec3, "b.example.com.br.") {
+		t.Error("Not detecting a name covered by NSEC3")
+	}
+
+	if NSEC3Covers(nsec3, "a.example.com.br.") {
+		t.Error("Detecting the owner name as covered by NSEC3")
+	}
+}
+
+func TestCompareSerial(t *testing.T) {
+	data := []struct {
+		serial1  uint32
+		serial2  uint32
+		expected int
+	}{
+		{serial1: 1, serial2: 1, expected: 0},
+		{serial1: 1, serial2: 2, expected: -1},
+		{serial1: 2, serial2: 1, expected: 1},
+		{serial1: 4294967295, serial2: 0, expected: -1},
+		{serial1: 0, serial2: 4294967295, expected: 1},
+		{serial1: 2014010100, serial2: 2014010199, expected: -1},
+		{serial1: 0, serial2: 1 << 31, expected: 0},
+	}
+
+	for _, item := range data {
+		if result := CompareSerial(item.serial1, item.serial2); result != item.expected {
+			t.Errorf("Wrong comparison between serials %d and %d. Expected %d and got %d",
+				item.serial1, item.serial2, item.expected, result)
+		}
+	}
+}
+
+func TestIsHostname(t *testing.T) {
+	for _, name := range []string{"ns1.example.com.br.", "ns-1.example.com", "A1.example."} {
+		if !IsHostname(name) {
+			t.Errorf("Not accepting the valid hostname %s", name)
+		}
+	}
+
+	for _, name := range []string{"ns_1.example.com.", "-ns1.example.com.", "ns1-.example.",
+		"ns1..example.", "."} {
+
+		if IsHostname(name) {
+			t.Errorf("Accepting the invalid hostname %s", name)
+		}
+	}
+}
+
+func TestSameRRs(t *testing.T) {
+	newSOA := func(name string, serial uint32) dns.RR {
+		return &dns.SOA{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeSOA,
+				Class:  dns.ClassINET,
+			},
+			Ns:     "ns1.example.com.br.",
+			Mbox:   "hostmaster.example.com.br.",
+			Serial: serial,
+		}
+	}
+
+	newNS := func(host string) dns.RR {
+		return &dns.NS{
+			Hdr: dns.RR_Header{
+				Name:   "example.com.br.",
+				Rrtype: dns.TypeNS,
+				Class:  dns.ClassINET,
+			},
+			Ns: host,
+		}
+	}
+
+	if !SameRRs([]dns.RR{newSOA("example.com.br.", 1), newNS("ns1.example.com.br.")},
+		[]dns.RR{newNS("ns1.example.com.br."), newSOA("EXAMPLE.com.br.", 1)}) {
+		t.Error("Not detecting equal records in a different order")
+	}
+
+	if SameRRs([]dns.RR{newSOA("example.com.br.", 1)}, []dns.RR{newSOA("example.com.br.", 2)}) {
+		t.Error("Not detecting different records")
+	}
+
+	if SameRRs([]dns.RR{newSOA("example.com.br.", 1)}, nil) {
+		t.Error("Not detecting lists with a different number of records")
+	}
+
+	if SameRRs([]dns.RR{newNS("ns1.example.com.br."), newNS("ns1.example.com.br.")},
+		[]dns.RR{newNS("ns1.example.com.br."), newNS("ns2.example.com.br.")}) {
+		t.Error("Not detecting duplicated records")
+	}
+}
+
+func TestKeyTags(t *testing.T) {
+	newDNSKEY := func(flags uint16) *dns.DNSKEY {
+		dnskey := &dns.DNSKEY{
+			Hdr: dns.RR_Header{
+				Name:   "example.com.br.",
+				Rrtype: dns.TypeDNSKEY,
+				Class:  dns.ClassINET,
+			},
+			Flags:     flags,
+			Protocol:  3,
+			Algorithm: dns.RSASHA256,
+		}
+
+		if _, err := dnskey.Generate(1024); err != nil {
+			t.Fatal(err)
+		}
+
+		return dnskey
+	}
+
+	ksk, zsk := newDNSKEY(257), newDNSKEY(256)
+	rrs := []dns.RR{
+		ksk,
+		&dns.NS{
+			Hdr: dns.RR_Header{
+				Name:   "example.com.br.",
+				Rrtype: dns.TypeNS,
+				Class:  dns.ClassINET,
+			},
+			Ns: "ns1.example.com.br.",
+		},
+		zsk,
+	}
+
+	keytags := KeyTags(rrs)
+	if len(keytags) != 2 {
+		t.Fatalf("Expected 2 keytags and got %d", len(keytags))
+	}
+
+	if keytags[0] > keytags[1] {
+		t.Error("Keytags are not sorted")
+	}
+
+	if (keytags[0] != ksk.KeyTag() || keytags[1] != zsk.KeyTag()) &&
+		(keytags[0] != zsk.KeyTag() || keytags[1] != ksk.KeyTag()) {
+		t.Error("Not retrieving the keytags of the DNSKEY records")
+	}
+
+	if KeyTags(nil) != nil {
+		t.Error("Returning keytags without DNSKEY records")
+	}
+}
+
+func TestRRsToString(t *testing.T) {
+	if records := RRsToString(nil); len(records) != 0 {
+		t.Error("Converting records that don't exist")
+	}
+
+	rr, err := dns.NewRR("example.com.br. 86400 IN CNAME example.net.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := RRsToString([]dns.RR{rr})
+	if len(records) != 1 || records[0] != rr.String() {
+		t.Error("Not converting the records to the presentation format")
+	}
+}
+
+func TestFillDiagnostic(t *testing.T) {
+	var dnsRequestMessage dns.Msg
+	dnsRequestMessage.SetQuestion("example.com.br.", dns.TypeSOA)
+
+	diagnostic := FillDiagnostic(model.Diagnostic{Error: "i/o timeout"}, "192.0.2.1:53",
+		&dnsRequestMessage, nil)
+
+	if diagnostic.Address != "192.0.2.1:53" || diagnostic.Query != "example.com.br. IN SOA" ||
+		diagnostic.Rcode != "" || diagnostic.Error != "i/o timeout" {
+
+		t.Errorf("Not filling the diagnostic without response correctly: %#v", diagnostic)
+	}
+
+	dnsResponseMessage := new(dns.Msg)
+	dnsResponseMessage.SetRcode(&dnsRequestMessage, dns.RcodeServerFailure)
+
+	diagnostic = FillDiagnostic(model.Diagnostic{}, "192.0.2.1:53", &dnsRequestMessage,
+		dnsResponseMessage)
+
+	if diagnostic.Rcode != "SERVFAIL" {
+		t.Errorf("Not filling the response code correctly: %#v", diagnostic)
+	}
+
+	diagnostic = FillDiagnostic(model.Diagnostic{Query: "ns1.example.com.br. IN A"},
+		"192.0.2.1:53", &dnsRequestMessage, nil)
+
+	if diagnostic.Query != "ns1.example.com.br. IN A" {
+		t.Error("Replacing the question defined by the policies")
+	}
+}
+
+func TestRSAKeySize(t *testing.T) {
+	for _, bits := range []int{1024, 2048} {
+		dnskey := &dns.DNSKEY{
+			Hdr: dns.RR_Header{
+				Name:   "example.com.br.",
+				Rrtype: dns.TypeDNSKEY,
+			},
+			Flags:     257,
+			Protocol:  3,
+			Algorithm: dns.RSASHA256,
+		}
+
+		if _, err := dnskey.Generate(bits); err != nil {
+			t.Fatal(err)
+		}
+
+		if size := RSAKeySize(dnskey); size != bits {
+			t.Errorf("Wrong RSA key size. Expected %d and got %d", bits, size)
+		}
+	}
+
+	dnskey := &dns.DNSKEY{
+		Algorithm: dns.ECDSAP256SHA256,
+		PublicKey: "GojIhhXUN/u4v54ZQqGSnyhWJwaubCvTmeexv7bR6edbkrSqQpF64cYbcB7wNcP+e+MAnLr+Wi9xMWyQLc8NAA==",
+	}
+
+	if RSAKeySize(dnskey) != 0 {
+		t.Error("Returning a RSA key size for a non RSA key")
+	}
+
+	dnskey = &dns.DNSKEY{
+		Algorithm: dns.RSASHA256,
+		PublicKey: "invalid",
+	}
+
+	if RSAKeySize(dnskey) != 0 {
+		t.Error("Returning a RSA key size for an invalid key")
+	}
+}
+
+func TestVerifyRRSet(t *testing.T) {
+	dnskey := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   "example.com.br.",
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.RSASHA256,
+	}
+
+	privateKey, err := dnskey.Generate(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns := &dns.NS{
+		Hdr: dns.RR_Header{
+			Name:   "example.com.br.",
+			Rrtype: dns.TypeNS,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		Ns: "ns1.example.com.br.",
+	}
+
+	rrsig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name:   "example.com.br.",
+			Rrtype: dns.TypeRRSIG,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		TypeCovered: dns.TypeNS,
+		Algorithm:   dnskey.Algorithm,
+		Expiration:  uint32(time.Now().Add(10 * time.Second).Unix()),
+		Inception:   uint32(time.Now().Unix()),
+		KeyTag:      dnskey.KeyTag(),
+		SignerName:  "example.com.br.",
+	}
+
+	if err := rrsig.Sign(privateKey, []dns.RR{ns}); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := dnskey.ToDS(dns.SHA256)
+	dsSet := []model.DS{
+		{
+			Keytag:     ds.KeyTag,
+			Algorithm:  model.DSAlgorithm(ds.Algorithm),
+			DigestType: model.DSDigestTypeSHA256,
+			Digest:     strings.ToUpper(ds.Digest),
+		},
+	}
+
+	if !VerifyRRSet([]dns.RR{ns}, []dns.RR{rrsig}, []dns.RR{dnskey}, dsSet) {
+		t.Error("Not accepting a record set signed by a key of the chain of trust")
+	}
+
+	if VerifyRRSet([]dns.RR{ns}, []dns.RR{rrsig}, []dns.RR{dnskey}, nil) {
+		t.Error("Accepting a record set signed by a key outside the chain of trust")
+	}
+
+	if VerifyRRSet([]dns.RR{ns}, nil, []dns.RR{dnskey}, dsSet) {
+		t.Error("Accepting a record set without signature")
+	}
+
+	ns.Ns = "ns2.example.com.br."
+	if VerifyRRSet([]dns.RR{ns}, []dns.RR{rrsig}, []dns.RR{dnskey}, dsSet) {
+		t.Error("Accepting a record set with an invalid signature")
+	}
+}
@@ -6,28 +6,102 @@
 package dspolicy
 
 import (
+	"fmt"
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
 	"github.com/rafaeljusto/shelter/model"
 	"github.com/rafaeljusto/shelter/net/scan/dnsutils"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
 	"net"
 	"strings"
 	"time"
 )
 
+const (
+	// Identification of the signature checks of the SOA and NS RRsets in the policy
+	// registry. The scan only queries these RRsets when the policy is enabled for the domain
+	RRSetPolicy = "ds.rrset"
+
+	// Identification of the checks that send their own queries in the policy registry. The
+	// scan compares the DS set with the one published by the parent zone (RunParent) and
+	// probes the fragmentation of the DNSKEY responses that timed out (CheckFragmentation)
+	// only when the policy is enabled for the domain
+	ParentPolicy        = "ds.parent"
+	FragmentationPolicy = "ds.fragmentation"
+
+	// Minimum size in bits of a RSA DNSKEY when the "minRSAKeySize" parameter of the
+	// "ds.algorithm" policy isn't defined. Smaller keys will receive a warning status
+	MinRSAKeySize = 2048
+
+	// Maximum NSEC3 iterations when the "maxIterations" parameter of the "ds.denial" policy
+	// isn't defined. RFC 9276 (section 3.2 and appendix A) allows the validators to treat
+	// the responses above a limit as insecure or bogus, and 50 iterations is the limit of
+	// the main validators. Fewer extra iterations receive only a warning status
+	MaxNSEC3Iterations = 50
+)
+
 var (
-	// List of all DS policies that are going to be executed in the order defined here. The
-	// order is important because the policies depends on each other, assuming that
-	// something was already verified
-	dsPolicies = []func(*DomainDSPolicy, *dns.Msg) bool{
-		(*DomainDSPolicy).dnsHeaderPolicy,
-		(*DomainDSPolicy).dnssecPolicy,
+	// List of all DS policies of this package, registered in the policy registry. The
+	// registry executes the policies in the order defined here, after their dependencies.
+	// The dependencies are important because the policies assume that something was
+	// already verified
+	dsPolicies = []dsPolicy{
+		{"ds.header", nil, (*DomainDSPolicy).dnsHeaderPolicy},
+		{"ds.dnssec", []string{"ds.header"}, (*DomainDSPolicy).dnssecPolicy},
+		{"ds.algorithm", []string{"ds.dnssec"}, (*DomainDSPolicy).algorithmPolicy},
+		{RRSetPolicy, []string{"ds.dnssec"}, (*DomainDSPolicy).rrsetPolicy},
+		{"ds.denial", []string{"ds.dnssec"}, (*DomainDSPolicy).denialPolicy},
 	}
+
+	// EDNS buffer sizes used to find the largest DNSKEY response that a nameserver delivers
+	// over UDP without loss. 512 bytes is the limit without EDNS, 1232 bytes is the DNS flag
+	// day 2020 recommendation (IPv6 minimum MTU) and 1480 bytes is the limit of an Ethernet
+	// MTU over IPv4. The configured UDP max size is always probed too
+	FragmentationBufferSizes = []uint16{512, 1232, 1480}
 )
 
+func init() {
+	for _, p := range dsPolicies {
+		policy.Register(p)
+	}
+
+	policy.Register(policy.Standalone{Identifier: ParentPolicy})
+	policy.Register(policy.Standalone{Identifier: FragmentationPolicy})
+}
+
+// dsPolicy is a DS policy of this package in the format of the policy registry. The
+// policies of this package use the state of the DomainDSPolicy (like the denial of
+// existence response), so the Run method executes them directly
+type dsPolicy struct {
+	id           string                               // Identification in the configuration file
+	dependencies []string                             // Policies that must be executed before
+	check        func(*DomainDSPolicy, *dns.Msg) bool // Policy implementation
+}
+
+func (p dsPolicy) ID() string {
+	return p.id
+}
+
+func (p dsPolicy) Dependencies() []string {
+	return p.dependencies
+}
+
+// Execute the policy outside of the Run method. Only the DNSKEY response is available, so
+// the policies that depend on other responses don't detect problems
+func (p dsPolicy) CheckDS(domain *model.Domain, dnsResponseMessage *dns.Msg,
+	parameters policy.Parameters) bool {
+
+	domainDSPolicy := NewDomainDSPolicy(domain)
+	domainDSPolicy.parameters = parameters
+	return p.check(&domainDSPolicy, dnsResponseMessage)
+}
+
 // DomainDSPolicy store the domain object that is going to be updated during the policies
 // executions. The domain object cannot be null
 type DomainDSPolicy struct {
-	domain *model.Domain // Domain object that stores the last state of the DS records
+	domain                *model.Domain     // Domain object that stores the last state of the DS records
+	denialResponseMessage *dns.Msg          // Response for a name that doesn't exist in the zone
+	rrsetResponseMessages []*dns.Msg        // Responses with other signed RRsets of the zone (SOA and NS)
+	parameters            policy.Parameters // Parameters of the policy in execution
 }
 
 // This function initialize a DomainDSPolicy object, it was created to force the
@@ -39,6 +113,20 @@ func NewDomainDSPolicy(domain *model.Domain) DomainDSPolicy {
 	}
 }
 
+// Store the response of a query for a name that doesn't exist in the domain's zone. When
+// this response is defined, the policies will also verify the denial of existence proof
+// (NSEC or NSEC3) of the zone
+func (d *DomainDSPolicy) SetDenialResponse(dnsResponseMessage *dns.Msg) {
+	d.denialResponseMessage = dnsResponseMessage
+}
+
+// Store the responses of queries for other RRsets of the domain's zone, like SOA and NS.
+// When these responses are defined, the policies will also verify the signatures of the
+// RRsets, as a broken zone signing key (ZSK) doesn't affect the DNSKEY RRset signatures
+func (d *DomainDSPolicy) SetRRSetResponses(dnsResponseMessages ...*dns.Msg) {
+	d.rrsetResponseMessages = dnsResponseMessages
+}
+
 // When there's a error while sending a DS request over the network, this method is
 // responsable for detecting any usual problems, something like DNSSEC timeouts. Generic
 // kinds of errors should be visible when checking the nameserver policies
@@ -51,7 +139,7 @@ func (d *DomainDSPolicy) CheckNetworkError(err error) bool {
 	// and firewalls are not configured for big UDP packages, or for DNS over TCP
 	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 		for index, _ := range d.domain.DSSet {
-			d.domain.DSSet[index].ChangeStatus(model.DSStatusTimeout)
+			d.changeStatus(index, model.DSStatusTimeout, newDiagnostic(err.Error(), nil))
 		}
 		return false
 	}
@@ -60,13 +148,56 @@ func (d *DomainDSPolicy) CheckNetworkError(err error) bool {
 	// let's just set a status for the user to fix the DNS configuration to make the DNSSEC
 	// configuration check possible
 	for index, _ := range d.domain.DSSet {
-		d.domain.DSSet[index].ChangeStatus(model.DSStatusDNSError)
+		d.changeStatus(index, model.DSStatusDNSError, newDiagnostic(err.Error(), nil))
 	}
 	return false
 }
 
-// Method responsable for running all DS policies. Each nameserver result query can update
-// all DS records (because of some error), so this method has a different interface of the
+// Build the DNSKEY queries of the fragmentation probe, one for each EDNS buffer size up to
+// the given UDP max size, in ascending order of buffer size
+func (d *DomainDSPolicy) FragmentationRequests(udpMaxSize uint16) []*dns.Msg {
+	var bufferSizes []uint16
+	for _, bufferSize := range FragmentationBufferSizes {
+		if bufferSize < udpMaxSize {
+			bufferSizes = append(bufferSizes, bufferSize)
+		}
+	}
+	bufferSizes = append(bufferSizes, udpMaxSize)
+
+	var requests []*dns.Msg
+	for _, bufferSize := range bufferSizes {
+		var dnsRequestMessage dns.Msg
+		dnsRequestMessage.SetQuestion(dns.Fqdn(d.domain.FQDN), dns.TypeDNSKEY)
+		dnsRequestMessage.RecursionDesired = false
+		dnsRequestMessage.SetEdns0(bufferSize, true)
+		requests = append(requests, &dnsRequestMessage)
+	}
+
+	return requests
+}
+
+// Check the result of the fragmentation probe, where payloadLimit is the size of the
+// largest DNSKEY response delivered without truncation. When only the big responses are
+// lost, the timeout was caused by fragmented UDP packages dropped in the path (or a path
+// MTU problem), so we replace the timeout status with a specific one. Returns true if the
+// problem was detected
+func (d *DomainDSPolicy) CheckFragmentation(payloadLimit, udpMaxSize uint16) bool {
+	if payloadLimit == 0 || payloadLimit >= udpMaxSize {
+		return false
+	}
+
+	diagnostic := newDiagnostic(fmt.Sprintf("DNSKEY responses bigger than %d bytes are lost "+
+		"in the path", payloadLimit), nil)
+
+	for index, _ := range d.domain.DSSet {
+		d.changeStatus(index, model.DSStatusFragmentation, diagnostic)
+	}
+	return true
+}
+
+// Method responsable for running all DS policies enabled for the domain, including the
+// policies registered by other packages. Each nameserver result query can update all DS
+// records (because of some error), so this method has a different interface of the
 // nameserver policies, it updates the DS records directly in the domain object pointer
 // and return true when the DS records are OK or false otherwise
 func (d *DomainDSPolicy) Run(dnsResponseMessage *dns.Msg) bool {
@@ -76,15 +207,265 @@ func (d *DomainDSPolicy) Run(dnsResponseMessage *dns.Msg) bool {
 		return false
 	}
 
-	for _, policy := range dsPolicies {
-		if !policy(d, dnsResponseMessage) {
+	for _, execution := range policy.Enabled(d.domain.FQDN) {
+		success := true
+
+		switch p := execution.Policy.(type) {
+		case dsPolicy:
+			d.parameters = execution.Parameters
+			success = p.check(d, dnsResponseMessage)
+
+		case policy.DSPolicy:
+			success = p.CheckDS(d.domain, dnsResponseMessage, execution.Parameters)
+		}
+
+		if !success {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Method responsable for summarizing the results of each nameserver in the DS records. It
+// must be executed after checking all nameservers of the domain. The status of each DS is
+// the worst result found in the nameservers, so that a problem in one nameserver isn't
+// hidden by the others, and the expiration date is the earliest signature expiration.
+// When the nameservers answer different DNSKEY RRsets, the DS records that are OK get a
+// warning, as the resolvers will see different keys depending on the nameserver
+func (d *DomainDSPolicy) CheckNameservers() {
+	hosts := make(map[string]bool)
+	for _, nameserver := range d.domain.Nameservers {
+		hosts[nameserver.Host] = true
+	}
+
+	for index := range d.domain.DSSet {
+		ds := &d.domain.DSSet[index]
+
+		// Results of nameservers that were removed from the domain are discarded
+		var results []model.DSNameserver
+		for _, result := range ds.Nameservers {
+			if hosts[result.Host] {
+				results = append(results, result)
+			}
+		}
+		ds.Nameservers = results
+
+		if len(results) == 0 {
+			continue
+		}
+
+		worst := results[0]
+		var expiresAt time.Time
+
+		for _, result := range results {
+			if statusSeverity(result.LastStatus) > statusSeverity(worst.LastStatus) {
+				worst = result
+			}
+
+			if isOKStatus(result.LastStatus) && !result.ExpiresAt.IsZero() &&
+				(expiresAt.IsZero() || result.ExpiresAt.Before(expiresAt)) {
+
+				expiresAt = result.ExpiresAt
+			}
+		}
+
+		if !expiresAt.IsZero() {
+			ds.ExpiresAt = expiresAt
+		}
+		ds.ChangeStatus(worst.LastStatus)
+		ds.Diagnostic = worst.Diagnostic
+	}
+
+	if sameDNSKEYs(d.domain.Nameservers) {
+		return
+	}
+
+	var keytags []string
+	for _, nameserver := range d.domain.Nameservers {
+		if len(nameserver.DNSKEYTags) > 0 {
+			keytags = append(keytags, fmt.Sprintf("%s %v", nameserver.Host, nameserver.DNSKEYTags))
+		}
+	}
+
+	diagnostic := newDiagnostic("Different DNSKEY sets in the nameservers: "+
+		strings.Join(keytags, ", "), nil)
+
+	for index, ds := range d.domain.DSSet {
+		if ds.LastStatus == model.DSStatusOK {
+			d.changeStatus(index, model.DSStatusDNSKEYInconsistent, diagnostic)
+		}
+	}
+}
+
+// Method responsable for comparing the DS records registered in the system with the DS
+// RRset that the parent zone publishes for the domain. It must be executed after the
+// nameserver's DNSSEC checks, because it only changes the status of the DS records that
+// are OK, a problem in the child zone is more important to the user. The result of the
+// comparison is also stored in the domain, as the parent zone can publish DS records that
+// aren't registered, even for unsigned domains. Returns true when the parent zone
+// publishes exactly the registered DS set or false otherwise
+func (d *DomainDSPolicy) RunParent(dnsResponseMessage *dns.Msg) bool {
+	// Without a response we can't tell anything about the parent zone, so we don't change
+	// the results from the child zone
+	if dnsResponseMessage == nil {
+		d.domain.ParentDS.ChangeStatus(model.DSStatusNotChecked, nil,
+			newDiagnostic("No answer from the parent zone nameservers", nil))
+		return false
+	}
+
+	var published []dns.RR
+
+	switch dnsResponseMessage.Rcode {
+	case dns.RcodeSuccess:
+		published = dnsutils.FilterRRs(dnsResponseMessage.Answer, dns.TypeDS)
+	case dns.RcodeNameError:
+		// The domain isn't delegated by the parent zone, so none of the DS records are
+		// published
+	default:
+		d.domain.ParentDS.ChangeStatus(model.DSStatusNotChecked, nil,
+			newDiagnostic(fmt.Sprintf("Parent zone nameserver answered with %s",
+				dns.RcodeToString[dnsResponseMessage.Rcode]), nil))
+		return false
+	}
+
+	notPublished := false
+	matched := make([]bool, len(published))
+
+	for index, ds := range d.domain.DSSet {
+		found := false
+		for i, rr := range published {
+			if dsRecord, ok := rr.(*dns.DS); ok && sameDS(ds, dsRecord) {
+				matched[i] = true
+				found = true
+			}
+		}
+
+		if !found {
+			if isOKStatus(ds.LastStatus) {
+				d.changeStatus(index, model.DSStatusNotPublished,
+					newDiagnostic("DS record not published in the parent zone", published))
+			}
+			notPublished = true
+		}
+	}
+
+	// DS records in the parent zone that aren't registered in the system, the registry
+	// provisioning is probably out of sync
+	var extraDSSet []model.DS
+	var extraRRs []dns.RR
+	for i, m := range matched {
+		dsRecord, ok := published[i].(*dns.DS)
+		if m || !ok {
+			continue
+		}
+
+		extraDSSet = append(extraDSSet, model.DS{
+			Keytag:     dsRecord.KeyTag,
+			Algorithm:  model.DSAlgorithm(dsRecord.Algorithm),
+			DigestType: model.DSDigestType(dsRecord.DigestType),
+			Digest:     strings.ToUpper(dsRecord.Digest),
+		})
+		extraRRs = append(extraRRs, dsRecord)
+	}
+
+	switch {
+	case len(extraDSSet) > 0:
+		d.domain.ParentDS.ChangeStatus(model.DSStatusNotRegistered, extraDSSet,
+			newDiagnostic("DS records of the parent zone aren't registered", extraRRs))
+	case notPublished:
+		d.domain.ParentDS.ChangeStatus(model.DSStatusNotPublished, nil,
+			newDiagnostic("Registered DS records not published in the parent zone", published))
+	default:
+		d.domain.ParentDS.ChangeStatus(model.DSStatusOK, nil, model.Diagnostic{})
+	}
+
+	return !notPublished && len(extraDSSet) == 0
+}
+
+// Change the status of the DS record with the given index, storing the details of the
+// problem. The diagnostic is discarded when the DS record is OK
+func (d *DomainDSPolicy) changeStatus(index int, status model.DSStatus,
+	diagnostic model.Diagnostic) {
+
+	if status == model.DSStatusOK {
+		diagnostic = model.Diagnostic{}
+	}
+
+	d.domain.DSSet[index].ChangeStatus(status)
+	d.domain.DSSet[index].Diagnostic = diagnostic
+}
+
+// Build the diagnostic of a problem detected by the policies. The address of the
+// nameserver, the question and the response code aren't known by the policies, so they
+// are filled by the caller
+func newDiagnostic(text string, rrs []dns.RR) model.Diagnostic {
+	return model.Diagnostic{
+		Error:   text,
+		Records: dnsutils.RRsToString(rrs),
+	}
+}
+
+// Severity of the DS status used to choose the worst result between the nameservers. A
+// problem that breaks the chain of trust is worse than a warning, that is worse than an OK
+// status
+func statusSeverity(status model.DSStatus) int {
+	switch {
+	case status == model.DSStatusNotChecked:
+		return 0
+	case status == model.DSStatusOK:
+		return 1
+	case model.IsDSStatusWarning(status):
+		return 2
+	}
+
+	return 3
+}
+
+// Check if all nameservers answered the same DNSKEY RRset in the last check. The
+// nameservers without DNSKEY records (probably because of a network error) are ignored, as
+// their problems are already reported in the nameserver results
+func sameDNSKEYs(nameservers []model.Nameserver) bool {
+	var keytags []uint16
+	for _, nameserver := range nameservers {
+		if len(nameserver.DNSKEYTags) == 0 {
+			continue
+		}
+
+		if keytags == nil {
+			keytags = nameserver.DNSKEYTags
+			continue
+		}
+
+		if len(keytags) != len(nameserver.DNSKEYTags) {
 			return false
 		}
+
+		for i := range keytags {
+			if keytags[i] != nameserver.DNSKEYTags[i] {
+				return false
+			}
+		}
 	}
 
 	return true
 }
 
+// Check if the DS status doesn't break the chain of trust, so it can be replaced by a
+// problem detected in a later check
+func isOKStatus(status model.DSStatus) bool {
+	return status == model.DSStatusOK || model.IsDSStatusWarning(status)
+}
+
+// Check if a DS record from the parent zone is the same DS registered in the system.
+// Digests generated by the library are always lower case
+func sameDS(ds model.DS, dsRecord *dns.DS) bool {
+	return ds.Keytag == dsRecord.KeyTag &&
+		uint8(ds.Algorithm) == dsRecord.Algorithm &&
+		uint8(ds.DigestType) == dsRecord.DigestType &&
+		strings.ToLower(ds.Digest) == strings.ToLower(dsRecord.Digest)
+}
+
 // Policy to check if everything is OK with the DNS package before checking the DNSSEC
 // policies, if something is wrong it probably appeared in the nameserver policies results
 func (d *DomainDSPolicy) dnsHeaderPolicy(dnsResponseMessage *dns.Msg) bool {
@@ -93,11 +474,17 @@ func (d *DomainDSPolicy) dnsHeaderPolicy(dnsResponseMessage *dns.Msg) bool {
 		return true
 	}
 
+	diagnostic := newDiagnostic("Response without the authoritative answer (AA) flag", nil)
+	if dnsResponseMessage.Rcode != dns.RcodeSuccess {
+		diagnostic = newDiagnostic(fmt.Sprintf("Response with the %s code",
+			dns.RcodeToString[dnsResponseMessage.Rcode]), nil)
+	}
+
 	// Authority errors are not a specific problem of DNSSEC configuration, so let's just
 	// set a status for the user to fix the DNS configuration to make the DNSSEC
 	// configuration check possible
 	for index, _ := range d.domain.DSSet {
-		d.domain.DSSet[index].ChangeStatus(model.DSStatusDNSError)
+		d.changeStatus(index, model.DSStatusDNSError, diagnostic)
 	}
 	return false
 }
@@ -113,8 +500,8 @@ func (d *DomainDSPolicy) dnssecPolicy(dnsResponseMessage *dns.Msg) bool {
 
 	success := true
 	for index, ds := range d.domain.DSSet {
-		status, signatureExpiration := d.checkDS(ds, dnskeys, rrsigs)
-		d.domain.DSSet[index].ChangeStatus(status)
+		status, signatureExpiration, diagnostic := d.checkDS(ds, dnskeys, rrsigs)
+		d.changeStatus(index, status, diagnostic)
 		d.domain.DSSet[index].ExpiresAt = signatureExpiration
 
 		if status != model.DSStatusOK {
@@ -124,24 +511,387 @@ func (d *DomainDSPolicy) dnssecPolicy(dnsResponseMessage *dns.Msg) bool {
 	return success
 }
 
+// Check the algorithms used in the zone. According to RFC 6840 - section 5.11 the zone
+// must be signed with every algorithm of the DS set. After that we alert, using warning
+// status, the DS records with deprecated algorithms or digest types (RFC 8624) and the
+// DS records related to small RSA keys
+func (d *DomainDSPolicy) algorithmPolicy(dnsResponseMessage *dns.Msg) bool {
+	dnskeys := dnsutils.FilterRRs(dnsResponseMessage.Answer, dns.TypeDNSKEY)
+	rrsigs := dnsutils.FilterRRs(dnsResponseMessage.Answer, dns.TypeRRSIG)
+
+	signedAlgorithms := make(map[uint8]bool)
+	for _, rr := range rrsigs {
+		if rrsig, ok := rr.(*dns.RRSIG); ok && rrsig.TypeCovered == dns.TypeDNSKEY {
+			signedAlgorithms[rrsig.Algorithm] = true
+		}
+	}
+
+	minRSAKeySize := d.parameters.Int("minRSAKeySize", MinRSAKeySize)
+
+	success := true
+	for index, ds := range d.domain.DSSet {
+		if !signedAlgorithms[uint8(ds.Algorithm)] {
+			d.changeStatus(index, model.DSStatusNoAlgorithmSignature,
+				newDiagnostic(fmt.Sprintf("No signature of the DNSKEY RRset with the algorithm %d",
+					ds.Algorithm), rrsigs))
+			success = false
+		}
+	}
+
+	if !success {
+		return false
+	}
+
+	for index, ds := range d.domain.DSSet {
+		if ds.LastStatus != model.DSStatusOK {
+			continue
+		}
+
+		if model.IsDeprecatedDSAlgorithm(ds.Algorithm) {
+			d.changeStatus(index, model.DSStatusDeprecatedAlgorithm,
+				newDiagnostic(fmt.Sprintf("Deprecated algorithm %d", ds.Algorithm), nil))
+
+		} else if model.IsDeprecatedDSDigestType(ds.DigestType) {
+			d.changeStatus(index, model.DSStatusDeprecatedDigestType,
+				newDiagnostic(fmt.Sprintf("Deprecated digest type %d", ds.DigestType), nil))
+
+		} else if dnskey := d.selectDNSKEY(dnskeys, ds.Keytag); dnskey != nil {
+			if size := dnsutils.RSAKeySize(dnskey); size > 0 && size < minRSAKeySize {
+				d.changeStatus(index, model.DSStatusWeakKey,
+					newDiagnostic(fmt.Sprintf("RSA key with %d bits, below the minimum of %d bits",
+						size, minRSAKeySize), []dns.RR{dnskey}))
+			}
+		}
+	}
+
+	// Warnings don't break the chain of trust, so we can continue with other policies
+	return true
+}
+
+// Verify the signatures of the other RRsets of the zone (SOA and NS) with the zone's
+// DNSKEY set. The DNS response message is the DNSKEY response. As the signatures are from
+// the zone and not from a specific DS, all DS records that are OK will receive the
+// status. The earliest signature expiration is stored in the DS records, so that we can
+// alert before the zone stops resolving
+func (d *DomainDSPolicy) rrsetPolicy(dnsResponseMessage *dns.Msg) bool {
+	dnskeys := dnsutils.FilterRRs(dnsResponseMessage.Answer, dns.TypeDNSKEY)
+
+	var expiration time.Time
+	ttlWarning := false
+
+	for _, rrsetResponseMessage := range d.rrsetResponseMessages {
+		// The RRset queries are optional, network problems with them are detected by the
+		// nameserver policies
+		if rrsetResponseMessage == nil || rrsetResponseMessage.Rcode != dns.RcodeSuccess ||
+			len(rrsetResponseMessage.Question) == 0 {
+			continue
+		}
+
+		rrType := rrsetResponseMessage.Question[0].Qtype
+		rrset := dnsutils.FilterRRs(rrsetResponseMessage.Answer, rrType)
+		if len(rrset) == 0 {
+			continue
+		}
+
+		rrsigs := dnsutils.FilterRRs(rrsetResponseMessage.Answer, dns.TypeRRSIG)
+		status, rrsetExpiration, rrsetTTLWarning := d.checkRRSet(rrset, rrsigs, dnskeys)
+
+		if status != model.DSStatusOK {
+			text := fmt.Sprintf("No valid signature of the %s RRset", dns.TypeToString[rrType])
+			if status == model.DSStatusRRSetExpiredSignature {
+				text = fmt.Sprintf("Expired signatures of the %s RRset", dns.TypeToString[rrType])
+			}
+
+			// Without signatures the RRset itself is the best information that we have
+			diagnostic := newDiagnostic(text, rrsigs)
+			if len(rrsigs) == 0 {
+				diagnostic = newDiagnostic(text, rrset)
+			}
+
+			for index, ds := range d.domain.DSSet {
+				if isOKStatus(ds.LastStatus) {
+					d.changeStatus(index, status, diagnostic)
+				}
+			}
+			return false
+		}
+
+		if expiration.IsZero() || rrsetExpiration.Before(expiration) {
+			expiration = rrsetExpiration
+		}
+
+		ttlWarning = ttlWarning || rrsetTTLWarning
+	}
+
+	for index, ds := range d.domain.DSSet {
+		if !isOKStatus(ds.LastStatus) {
+			continue
+		}
+
+		if !expiration.IsZero() && (ds.ExpiresAt.IsZero() || expiration.Before(ds.ExpiresAt)) {
+			d.domain.DSSet[index].ExpiresAt = expiration
+		}
+
+		if ttlWarning && ds.LastStatus == model.DSStatusOK {
+			d.changeStatus(index, model.DSStatusSignatureTTL,
+				newDiagnostic("TTL of the RRsets longer than the remaining validity of the "+
+					"signatures", nil))
+		}
+	}
+
+	// Warnings don't break the chain of trust, so we can continue with other policies
+	return true
+}
+
+// Check if the RRset has at least one valid signature generated by one of the zone's
+// DNSKEYs. Returns the status, the earliest expiration of the valid signatures and if
+// the TTL of the RRset is longer than the remaining validity of a signature, as
+// resolvers could cache the RRset after the signature expires
+func (d *DomainDSPolicy) checkRRSet(rrset, rrsigs,
+	dnskeys []dns.RR) (model.DSStatus, time.Time, bool) {
+
+	var expiration time.Time
+	expired, verified := false, false
+
+	for _, rr := range rrsigs {
+		rrsig, ok := rr.(*dns.RRSIG)
+		if !ok || rrsig.TypeCovered != rrset[0].Header().Rrtype ||
+			!strings.EqualFold(rrsig.Hdr.Name, rrset[0].Header().Name) {
+			continue
+		}
+
+		rrsig.Signature = strings.Replace(rrsig.Signature, " ", "", -1)
+
+		dnskey := d.selectDNSKEY(dnskeys, rrsig.KeyTag)
+		if dnskey == nil || dnskey.Algorithm != rrsig.Algorithm {
+			continue
+		}
+
+		if !rrsig.ValidityPeriod(time.Now()) {
+			expired = true
+			continue
+		}
+
+		if err := rrsig.Verify(dnskey, rrset); err != nil {
+			continue
+		}
+
+		signatureExpiration := time.Unix(int64(rrsig.Expiration), 0)
+		if !verified || signatureExpiration.Before(expiration) {
+			expiration = signatureExpiration
+		}
+		verified = true
+	}
+
+	if !verified {
+		if expired {
+			return model.DSStatusRRSetExpiredSignature, expiration, false
+		}
+		return model.DSStatusRRSetSignatureError, expiration, false
+	}
+
+	ttl := time.Duration(rrset[0].Header().Ttl) * time.Second
+	return model.DSStatusOK, expiration, time.Now().Add(ttl).After(expiration)
+}
+
+// Verify the NSEC or NSEC3 records that prove that a random name doesn't exist in the
+// zone. The DNS response message is the DNSKEY response, used to check the signatures of
+// the proof. As the proof is from the zone and not from a specific DS, all DS records
+// that are OK will receive the denial of existence status
+func (d *DomainDSPolicy) denialPolicy(dnsResponseMessage *dns.Msg) bool {
+	// The denial of existence query is optional, it isn't sent when we are only checking
+	// the DNSKEYs
+	if d.denialResponseMessage == nil || len(d.denialResponseMessage.Question) == 0 {
+		return true
+	}
+
+	// A wildcard in the zone can synthesize an answer for the random name, in this case
+	// there's no denial of existence to check
+	if d.denialResponseMessage.Rcode != dns.RcodeNameError {
+		return true
+	}
+
+	dnskeys := dnsutils.FilterRRs(dnsResponseMessage.Answer, dns.TypeDNSKEY)
+	status, text := d.checkDenial(d.denialResponseMessage, dnskeys)
+	if status == model.DSStatusOK {
+		return true
+	}
+
+	// The authority section has the NSEC or NSEC3 records of the proof, that are the
+	// records that the domain's owner needs to fix
+	diagnostic := newDiagnostic(text, d.denialResponseMessage.Ns)
+
+	// Warnings don't break the chain of trust, so we don't replace other problems and can
+	// continue with other policies
+	if model.IsDSStatusWarning(status) {
+		for index, ds := range d.domain.DSSet {
+			if ds.LastStatus == model.DSStatusOK {
+				d.changeStatus(index, status, diagnostic)
+			}
+		}
+
+		return true
+	}
+
+	for index, ds := range d.domain.DSSet {
+		if isOKStatus(ds.LastStatus) {
+			d.changeStatus(index, status, diagnostic)
+		}
+	}
+
+	return false
+}
+
+// Check the NSEC or NSEC3 records of a negative answer. For NSEC3 we need the closest
+// encloser proof (RFC 5155 - section 7.2.1), as the random name is always directly below
+// the zone apex the closest encloser is the apex itself. We also check the NSEC3
+// parameters according to RFC 9276 - section 3.1, that recommends no extra iterations and
+// an empty salt. The parameters out of the recommendation are only a warning, unless the
+// iterations are above the limit of the validators
+func (d *DomainDSPolicy) checkDenial(denialResponseMessage *dns.Msg,
+	dnskeys []dns.RR) (model.DSStatus, string) {
+
+	qname := denialResponseMessage.Question[0].Name
+	apex := dns.Fqdn(d.domain.FQDN)
+	wildcard := "*." + apex
+
+	nsecs := dnsutils.FilterRRs(denialResponseMessage.Ns, dns.TypeNSEC)
+	nsec3s := dnsutils.FilterRRs(denialResponseMessage.Ns, dns.TypeNSEC3)
+
+	if len(nsecs) == 0 && len(nsec3s) == 0 {
+		return model.DSStatusNoDenialProof,
+			fmt.Sprintf("No NSEC or NSEC3 records in the negative answer for %s", qname)
+	}
+
+	rrsigs := dnsutils.FilterRRs(denialResponseMessage.Ns, dns.TypeRRSIG)
+	for _, rr := range append(nsecs, nsec3s...) {
+		if !d.checkDenialSignature(rr, rrsigs, dnskeys) {
+			return model.DSStatusDenialSignatureError,
+				fmt.Sprintf("No valid signature of the %s record of %s",
+					dns.TypeToString[rr.Header().Rrtype], rr.Header().Name)
+		}
+	}
+
+	if len(nsec3s) > 0 {
+		closestEncloser, nextCloser, wildcardCovered := false, false, false
+		recommendedParameters := true
+		maxIterations := d.parameters.Int("maxIterations", MaxNSEC3Iterations)
+		var iterations uint16
+
+		for _, rr := range nsec3s {
+			nsec3, ok := rr.(*dns.NSEC3)
+			if !ok {
+				continue
+			}
+
+			closestEncloser = closestEncloser || dnsutils.NSEC3Matches(nsec3, apex)
+			nextCloser = nextCloser || dnsutils.NSEC3Covers(nsec3, qname)
+			wildcardCovered = wildcardCovered || dnsutils.NSEC3Covers(nsec3, wildcard)
+
+			if nsec3.Iterations > 0 || (len(nsec3.Salt) > 0 && nsec3.Salt != "-") {
+				recommendedParameters = false
+			}
+
+			if nsec3.Iterations > iterations {
+				iterations = nsec3.Iterations
+			}
+		}
+
+		if !closestEncloser || !nextCloser || !wildcardCovered {
+			return model.DSStatusDenialProofError,
+				fmt.Sprintf("NSEC3 records don't prove that %s doesn't exist", qname)
+		}
+
+		if int(iterations) > maxIterations {
+			return model.DSStatusNSEC3Iterations,
+				fmt.Sprintf("NSEC3 records with %d iterations, above the limit of %d iterations "+
+					"of the validators (RFC 9276)", iterations, maxIterations)
+		}
+
+		if !recommendedParameters {
+			return model.DSStatusNSEC3Parameters,
+				"NSEC3 records with extra iterations or salt (RFC 9276)"
+		}
+
+		return model.DSStatusOK, ""
+	}
+
+	nameCovered, wildcardCovered := false, false
+	for _, rr := range nsecs {
+		nsec, ok := rr.(*dns.NSEC)
+		if !ok {
+			continue
+		}
+
+		nameCovered = nameCovered || dnsutils.NSECCovers(nsec, qname)
+		wildcardCovered = wildcardCovered || dnsutils.NSECCovers(nsec, wildcard)
+	}
+
+	if !nameCovered || !wildcardCovered {
+		return model.DSStatusDenialProofError,
+			fmt.Sprintf("NSEC records don't prove that %s doesn't exist", qname)
+	}
+
+	return model.DSStatusOK, ""
+}
+
+// Check if a NSEC or NSEC3 record has a valid signature generated by one of the zone's
+// DNSKEYs. Each NSEC or NSEC3 owner name has only one record, so the RRset that we verify
+// is the record itself
+func (d *DomainDSPolicy) checkDenialSignature(rr dns.RR, rrsigs []dns.RR,
+	dnskeys []dns.RR) bool {
+
+	for _, rrsigRR := range rrsigs {
+		rrsig, ok := rrsigRR.(*dns.RRSIG)
+		if !ok || rrsig.TypeCovered != rr.Header().Rrtype ||
+			!strings.EqualFold(rrsig.Hdr.Name, rr.Header().Name) {
+			continue
+		}
+
+		rrsig.Signature = strings.Replace(rrsig.Signature, " ", "", -1)
+
+		dnskey := d.selectDNSKEY(dnskeys, rrsig.KeyTag)
+		if dnskey == nil || !rrsig.ValidityPeriod(time.Now()) {
+			continue
+		}
+
+		if err := rrsig.Verify(dnskey, []dns.RR{rr}); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
 // For each DS of the domain object we verify a couple of rules with the DNS response
 // data. It will return beyond the DS status, the current expiration date retrieved from
 // the network, if the expiration date could not be retrieved, we return the current
-// expiration date of the DS object
+// expiration date of the DS object. The diagnostic of the problem is also returned
 func (d *DomainDSPolicy) checkDS(ds model.DS,
-	dnskeys []dns.RR, rrsigs []dns.RR) (model.DSStatus, time.Time) {
+	dnskeys []dns.RR, rrsigs []dns.RR) (model.DSStatus, time.Time, model.Diagnostic) {
 
 	// Find the DNSSEC public key related to the DS
 	selectedDNSKEY := d.selectDNSKEY(dnskeys, ds.Keytag)
 
 	if selectedDNSKEY == nil {
-		return model.DSStatusNoKey, ds.ExpiresAt
+		return model.DSStatusNoKey, ds.ExpiresAt,
+			newDiagnostic(fmt.Sprintf("No DNSKEY with keytag %d", ds.Keytag), dnskeys)
+	}
+
+	// The keytag isn't unique, so we also need to check if the key was generated with the
+	// same algorithm of the DS
+	if selectedDNSKEY.Algorithm != uint8(ds.Algorithm) {
+		return model.DSStatusAlgorithmMismatch, ds.ExpiresAt,
+			newDiagnostic(fmt.Sprintf("DNSKEY with algorithm %d and DS with algorithm %d",
+				selectedDNSKEY.Algorithm, ds.Algorithm), []dns.RR{selectedDNSKEY})
 	}
 
 	// Check if the DNSSEC key related to the DS has the security entry point. Check RFCs
 	// 3755 and 4034
 	if (selectedDNSKEY.Flags & dns.SEP) == 0 {
-		return model.DSStatusNoSEP, ds.ExpiresAt
+		return model.DSStatusNoSEP, ds.ExpiresAt,
+			newDiagnostic("DNSKEY without the secure entry point (SEP) flag",
+				[]dns.RR{selectedDNSKEY})
 	}
 
 	// Find the signature of the DNSSEC key that signed the keyset
@@ -160,22 +910,28 @@ func (d *DomainDSPolicy) checkDS(ds model.DS,
 
 		// Check signature expiration
 		if !selectedRRSIG.ValidityPeriod(time.Now()) {
-			return model.DSStatusExpiredSignature, signatureExpiration
+			return model.DSStatusExpiredSignature, signatureExpiration,
+				newDiagnostic("Signature of the DNSKEY RRset out of the validity period",
+					[]dns.RR{selectedRRSIG})
 		}
 
 		// Check signature consistency
 		if err := selectedRRSIG.Verify(selectedDNSKEY, dnskeys); err != nil {
-			return model.DSStatusSignatureError, signatureExpiration
+			return model.DSStatusSignatureError, signatureExpiration,
+				newDiagnostic("Invalid signature of the DNSKEY RRset: "+err.Error(),
+					[]dns.RR{selectedRRSIG})
 		}
 	}
 
 	// Check DNSKEY hash is the same of the DS digest, hash generated by library is always
 	// lower case
 	if selectedDNSKEY.ToDS(uint8(ds.DigestType)).Digest != strings.ToLower(ds.Digest) {
-		return model.DSStatusNoKey, signatureExpiration
+		return model.DSStatusNoKey, signatureExpiration,
+			newDiagnostic(fmt.Sprintf("Digest of the DNSKEY with keytag %d is different from "+
+				"the DS digest", ds.Keytag), []dns.RR{selectedDNSKEY})
 	}
 
-	return model.DSStatusOK, signatureExpiration
+	return model.DSStatusOK, signatureExpiration, model.Diagnostic{}
 }
 
 // selectDNSKEY is responsable for finding the DNSKEY that was used to generate the DS. We
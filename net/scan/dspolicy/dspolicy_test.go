@@ -6,8 +6,11 @@
 package dspolicy
 
 import (
+	"fmt"
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
 	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
+	"strings"
 	"testing"
 	"time"
 )
@@ -68,6 +71,53 @@ func TestDSNetworkError(t *testing.T) {
 		domain.DSSet[0].LastStatus != model.DSStatusDNSError {
 		t.Error("Not detecting DNS network errors")
 	}
+
+	if domain.DSSet[0].Diagnostic.Error != "lookup" {
+		t.Error("Not storing the network error in the diagnostic")
+	}
+}
+
+func TestFragmentationProbe(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "test.com.br",
+		DSSet: []model.DS{
+			{Keytag: 1234, LastStatus: model.DSStatusTimeout},
+		},
+	}
+
+	domainDSPolicy := NewDomainDSPolicy(domain)
+
+	requests := domainDSPolicy.FragmentationRequests(4096)
+	if len(requests) != 4 {
+		t.Fatalf("Unexpected number of fragmentation probes. Expected 4 and got %d", len(requests))
+	}
+
+	for i, bufferSize := range []uint16{512, 1232, 1480, 4096} {
+		if requests[i].Question[0].Qtype != dns.TypeDNSKEY ||
+			requests[i].Question[0].Name != "test.com.br." ||
+			requests[i].IsEdns0() == nil || !requests[i].IsEdns0().Do() ||
+			requests[i].IsEdns0().UDPSize() != bufferSize {
+
+			t.Errorf("Wrong fragmentation probe for the buffer size %d", bufferSize)
+		}
+	}
+
+	if requests := domainDSPolicy.FragmentationRequests(1232); len(requests) != 2 {
+		t.Error("Probing buffer sizes above the UDP max size")
+	}
+
+	if domainDSPolicy.CheckFragmentation(0, 4096) ||
+		domainDSPolicy.CheckFragmentation(4096, 4096) ||
+		domain.DSSet[0].LastStatus != model.DSStatusTimeout {
+
+		t.Error("Detecting fragmentation when all or none of the probes were answered")
+	}
+
+	if !domainDSPolicy.CheckFragmentation(1232, 4096) ||
+		domain.DSSet[0].LastStatus != model.DSStatusFragmentation {
+
+		t.Error("Not detecting fragmentation when only the big responses are lost")
+	}
 }
 
 func TestRunPolicies(t *testing.T) {
@@ -254,6 +304,13 @@ func TestDNSSECPolicyMissingKey(t *testing.T) {
 		domain.DSSet[0].LastStatus != model.DSStatusNoKey {
 		t.Error("Not detecting missing key")
 	}
+
+	diagnostic := domain.DSSet[0].Diagnostic
+	if diagnostic.Error != fmt.Sprintf("No DNSKEY with keytag %d", dnskey.KeyTag()) ||
+		len(diagnostic.Records) != 1 || diagnostic.Records[0] != otherDNSKEY.String() {
+
+		t.Errorf("Not describing the missing key correctly: %#v", diagnostic)
+	}
 }
 
 func TestDNSSECPolicyNoSEPKey(t *testing.T) {
@@ -434,6 +491,715 @@ func TestDNSSECPolicyWrongDSDigest(t *testing.T) {
 	}
 }
 
+func TestRunParent(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "test.br.",
+		DSSet: []model.DS{
+			{
+				Keytag:     41674,
+				Algorithm:  model.DSAlgorithmRSASHA1,
+				DigestType: model.DSDigestTypeSHA1,
+				Digest:     "EAA0978F38879DB70A53F9FF1ACF21D046A98B5C",
+				LastStatus: model.DSStatusOK,
+			},
+		},
+	}
+
+	domainDSPolicy := NewDomainDSPolicy(domain)
+
+	if domainDSPolicy.RunParent(nil) || domain.DSSet[0].LastStatus != model.DSStatusOK ||
+		domain.ParentDS.LastStatus != model.DSStatusNotChecked {
+		t.Error("Changing DS status without a parent zone response")
+	}
+
+	publishedDS := &dns.DS{
+		Hdr: dns.RR_Header{
+			Name:   "test.br.",
+			Rrtype: dns.TypeDS,
+		},
+		KeyTag:     41674,
+		Algorithm:  dns.RSASHA1,
+		DigestType: dns.SHA1,
+		Digest:     "eaa0978f38879db70a53f9ff1acf21d046a98b5c",
+	}
+
+	dnsResponseMessage := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Rcode: dns.RcodeSuccess,
+		},
+		Answer: []dns.RR{
+			publishedDS,
+		},
+	}
+
+	if !domainDSPolicy.RunParent(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusOK ||
+		domain.ParentDS.LastStatus != model.DSStatusOK {
+		t.Error("Not accepting a DS published in the parent zone")
+	}
+
+	dnsResponseMessage = &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Rcode: dns.RcodeSuccess,
+		},
+		Answer: []dns.RR{
+			publishedDS,
+			&dns.DS{
+				Hdr: dns.RR_Header{
+					Name:   "test.br.",
+					Rrtype: dns.TypeDS,
+				},
+				KeyTag:     45966,
+				Algorithm:  dns.RSASHA1NSEC3SHA1,
+				DigestType: dns.SHA1,
+				Digest:     "b7c0bde8f3c90e573b956b14a14caf5001a3e841",
+			},
+		},
+	}
+
+	if domainDSPolicy.RunParent(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusOK ||
+		domain.ParentDS.LastStatus != model.DSStatusNotRegistered ||
+		len(domain.ParentDS.ExtraDSSet) != 1 ||
+		domain.ParentDS.ExtraDSSet[0].Keytag != 45966 ||
+		domain.ParentDS.ExtraDSSet[0].Digest != "B7C0BDE8F3C90E573B956B14A14CAF5001A3E841" {
+		t.Error("Not detecting a DS published in the parent zone that isn't registered")
+	}
+
+	dnsResponseMessage = &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Rcode: dns.RcodeNameError,
+		},
+	}
+
+	if domainDSPolicy.RunParent(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusNotPublished ||
+		domain.ParentDS.LastStatus != model.DSStatusNotPublished ||
+		len(domain.ParentDS.ExtraDSSet) != 0 {
+		t.Error("Not detecting a DS that isn't published in the parent zone")
+	}
+
+	domain.DSSet[0].ChangeStatus(model.DSStatusNoKey)
+
+	dnsResponseMessage = &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Rcode: dns.RcodeSuccess,
+		},
+	}
+
+	if domainDSPolicy.RunParent(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusNoKey {
+		t.Error("Parent zone check is hiding a problem from the child zone")
+	}
+
+	dnsResponseMessage = &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Rcode: dns.RcodeServerFailure,
+		},
+	}
+
+	if domainDSPolicy.RunParent(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusNoKey ||
+		domain.ParentDS.LastStatus != model.DSStatusNotChecked {
+		t.Error("Changing DS status when the parent zone has problems")
+	}
+
+	// Unsigned domain with a DS record left in the parent zone, that makes the zone bogus
+	unsignedDomain := &model.Domain{
+		FQDN: "test.br.",
+	}
+	unsignedDSPolicy := NewDomainDSPolicy(unsignedDomain)
+
+	dnsResponseMessage = &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Rcode: dns.RcodeSuccess,
+		},
+		Answer: []dns.RR{
+			publishedDS,
+		},
+	}
+
+	if unsignedDSPolicy.RunParent(dnsResponseMessage) ||
+		unsignedDomain.ParentDS.LastStatus != model.DSStatusNotRegistered ||
+		len(unsignedDomain.ParentDS.ExtraDSSet) != 1 {
+		t.Error("Not detecting a DS published in the parent zone of an unsigned domain")
+	}
+
+	dnsResponseMessage = &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Rcode: dns.RcodeSuccess,
+		},
+	}
+
+	if !unsignedDSPolicy.RunParent(dnsResponseMessage) ||
+		unsignedDomain.ParentDS.LastStatus != model.DSStatusOK ||
+		len(unsignedDomain.ParentDS.ExtraDSSet) != 0 {
+		t.Error("Not accepting an unsigned domain without DS records in the parent zone")
+	}
+}
+
+func TestCheckNameservers(t *testing.T) {
+	now := time.Now()
+
+	domain := &model.Domain{
+		FQDN: "test.br.",
+		Nameservers: []model.Nameserver{
+			{Host: "ns1.test.br.", DNSKEYTags: []uint16{41674, 51674}},
+			{Host: "ns2.test.br.", DNSKEYTags: []uint16{41674, 51674}},
+		},
+		DSSet: []model.DS{
+			{
+				Keytag:     41674,
+				Algorithm:  model.DSAlgorithmRSASHA1,
+				DigestType: model.DSDigestTypeSHA1,
+				Digest:     "EAA0978F38879DB70A53F9FF1ACF21D046A98B5C",
+				Nameservers: []model.DSNameserver{
+					{
+						Host:       "ns1.test.br.",
+						LastStatus: model.DSStatusOK,
+						ExpiresAt:  now.Add(48 * time.Hour),
+					},
+					{
+						Host:       "ns2.test.br.",
+						LastStatus: model.DSStatusWeakKey,
+						ExpiresAt:  now.Add(24 * time.Hour),
+						Diagnostic: model.Diagnostic{
+							Address: "192.0.2.2:53",
+							Error:   "RSA key with 1024 bits, below the minimum of 2048 bits",
+						},
+					},
+					{
+						Host:       "ns3.test.br.",
+						LastStatus: model.DSStatusTimeout,
+					},
+				},
+			},
+		},
+	}
+
+	domainDSPolicy := NewDomainDSPolicy(domain)
+	domainDSPolicy.CheckNameservers()
+
+	if domain.DSSet[0].LastStatus != model.DSStatusWeakKey {
+		t.Errorf("Not using the worst nameserver result as the DS status. Expected %s "+
+			"and got %s", model.DSStatusToString(model.DSStatusWeakKey),
+			model.DSStatusToString(domain.DSSet[0].LastStatus))
+	}
+
+	if domain.DSSet[0].Diagnostic.Address != "192.0.2.2:53" {
+		t.Error("Not using the diagnostic of the worst nameserver result")
+	}
+
+	if len(domain.DSSet[0].Nameservers) != 2 {
+		t.Error("Not removing the results of nameservers that aren't in the domain anymore")
+	}
+
+	if !domain.DSSet[0].ExpiresAt.Equal(now.Add(24 * time.Hour)) {
+		t.Error("Not using the earliest signature expiration of the nameservers")
+	}
+
+	domain.DSSet[0].Nameservers[0].LastStatus = model.DSStatusNoKey
+	domain.DSSet[0].Nameservers[0].ExpiresAt = now.Add(time.Hour)
+	domainDSPolicy.CheckNameservers()
+
+	if domain.DSSet[0].LastStatus != model.DSStatusNoKey {
+		t.Error("A problem in one nameserver is hidden by the results of the other " +
+			"nameservers")
+	}
+
+	if !domain.DSSet[0].ExpiresAt.Equal(now.Add(24 * time.Hour)) {
+		t.Error("Using the signature expiration of a nameserver with problems")
+	}
+
+	domain.DSSet[0].Nameservers[0].LastStatus = model.DSStatusOK
+	domain.DSSet[0].Nameservers[1].LastStatus = model.DSStatusOK
+	domain.Nameservers[1].DNSKEYTags = []uint16{41674}
+	domainDSPolicy.CheckNameservers()
+
+	if domain.DSSet[0].LastStatus != model.DSStatusDNSKEYInconsistent {
+		t.Error("Not detecting different DNSKEY RRsets between the nameservers")
+	}
+
+	if domain.DSSet[0].Diagnostic.Error != "Different DNSKEY sets in the nameservers: "+
+		"ns1.test.br. [41674 51674], ns2.test.br. [41674]" {
+
+		t.Errorf("Not describing the different DNSKEY RRsets correctly: %s",
+			domain.DSSet[0].Diagnostic.Error)
+	}
+
+	domain.Nameservers[1].DNSKEYTags = nil
+	domainDSPolicy.CheckNameservers()
+
+	if domain.DSSet[0].LastStatus != model.DSStatusOK {
+		t.Error("Comparing the DNSKEY RRset of a nameserver that didn't answer it")
+	}
+}
+
+func TestDNSSECPolicyAlgorithmMismatch(t *testing.T) {
+	dnskey, rrsig, err := generateKeyAndSignZone("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := dnskey.ToDS(uint8(model.DSDigestTypeSHA1))
+
+	domain := &model.Domain{
+		DSSet: []model.DS{
+			{
+				Keytag:     dnskey.KeyTag(),
+				Algorithm:  model.DSAlgorithmRSASHA256,
+				DigestType: model.DSDigestTypeSHA1,
+				Digest:     ds.Digest,
+			},
+		},
+	}
+
+	domainDSPolicy := NewDomainDSPolicy(domain)
+
+	dnsResponseMessage := &dns.Msg{
+		Answer: []dns.RR{
+			dnskey,
+			rrsig,
+		},
+	}
+
+	if domainDSPolicy.dnssecPolicy(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusAlgorithmMismatch {
+		t.Error("Not detecting DS and DNSKEY algorithm mismatch")
+	}
+}
+
+func TestAlgorithmPolicy(t *testing.T) {
+	dnskey, rrsig, err := generateKeyAndSignZone("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		DSSet: []model.DS{
+			{
+				Keytag:     dnskey.KeyTag(),
+				Algorithm:  convertKeyAlgorithm(dnskey.Algorithm),
+				DigestType: model.DSDigestTypeSHA256,
+				LastStatus: model.DSStatusOK,
+			},
+		},
+	}
+
+	domainDSPolicy := NewDomainDSPolicy(domain)
+
+	dnsResponseMessage := &dns.Msg{
+		Answer: []dns.RR{
+			dnskey,
+			rrsig,
+		},
+	}
+
+	if !domainDSPolicy.algorithmPolicy(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusDeprecatedAlgorithm {
+		t.Error("Not alerting about a deprecated DS algorithm")
+	}
+
+	// Now we use a recommended algorithm with a deprecated digest type
+	dnskey, privateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rrsig, err = signRR(dnskey, privateKey, dnskey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain.DSSet[0] = model.DS{
+		Keytag:     dnskey.KeyTag(),
+		Algorithm:  convertKeyAlgorithm(dnskey.Algorithm),
+		DigestType: model.DSDigestTypeSHA1,
+		LastStatus: model.DSStatusOK,
+	}
+
+	dnsResponseMessage.Answer = []dns.RR{dnskey, rrsig}
+
+	if !domainDSPolicy.algorithmPolicy(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusDeprecatedDigestType {
+		t.Error("Not alerting about a deprecated DS digest type")
+	}
+
+	// The generated key has only 1024 bits
+	domain.DSSet[0].DigestType = model.DSDigestTypeSHA256
+	domain.DSSet[0].ChangeStatus(model.DSStatusOK)
+
+	if !domainDSPolicy.algorithmPolicy(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusWeakKey {
+		t.Error("Not alerting about a small RSA key")
+	}
+
+	domainDSPolicy.parameters = policy.Parameters{"minRSAKeySize": "1024"}
+
+	domain.DSSet[0].ChangeStatus(model.DSStatusOK)
+
+	if !domainDSPolicy.algorithmPolicy(dnsResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusOK {
+		t.Error("Not accepting a DS with recommended algorithm, digest type and key size")
+	}
+
+	// Zone isn't signed with the algorithm of the DS
+	domain.DSSet = append(domain.DSSet, model.DS{
+		Keytag:     12345,
+		Algorithm:  model.DSAlgorithmECDSASHA256,
+		DigestType: model.DSDigestTypeSHA256,
+		LastStatus: model.DSStatusOK,
+	})
+
+	if domainDSPolicy.algorithmPolicy(dnsResponseMessage) ||
+		domain.DSSet[1].LastStatus != model.DSStatusNoAlgorithmSignature {
+		t.Error("Not detecting a zone that isn't signed with all DS algorithms")
+	}
+}
+
+func TestRRSetPolicy(t *testing.T) {
+	dnskey, privateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newDomain := func() *model.Domain {
+		return &model.Domain{
+			FQDN: "test.br.",
+			DSSet: []model.DS{
+				{
+					Keytag:     dnskey.KeyTag(),
+					Algorithm:  convertKeyAlgorithm(dnskey.Algorithm),
+					DigestType: model.DSDigestTypeSHA1,
+					ExpiresAt:  time.Now().Add(30 * 24 * time.Hour),
+					LastStatus: model.DSStatusOK,
+				},
+			},
+		}
+	}
+
+	dnskeyResponseMessage := &dns.Msg{
+		Answer: []dns.RR{
+			dnskey,
+		},
+	}
+
+	soa := &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   "test.br.",
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Ns:     "ns1.test.br.",
+		Mbox:   "hostmaster.test.br.",
+		Serial: 2015010101,
+	}
+
+	ns := &dns.NS{
+		Hdr: dns.RR_Header{
+			Name:   "test.br.",
+			Rrtype: dns.TypeNS,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Ns: "ns1.test.br.",
+	}
+
+	soaExpiration := time.Now().Add(10 * 24 * time.Hour)
+	soaRRSIG, err := signRRWithValidity(dnskey, privateKey, soa,
+		time.Now().Add(-time.Hour), soaExpiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nsRRSIG, err := signRRWithValidity(dnskey, privateKey, ns,
+		time.Now().Add(-time.Hour), time.Now().Add(20*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newResponse := func(rrType uint16, rrs ...dns.RR) *dns.Msg {
+		dnsResponseMessage := &dns.Msg{
+			Answer: rrs,
+		}
+		dnsResponseMessage.SetQuestion("test.br.", rrType)
+		return dnsResponseMessage
+	}
+
+	domain := newDomain()
+	domainDSPolicy := NewDomainDSPolicy(domain)
+
+	if !domainDSPolicy.rrsetPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusOK {
+		t.Error("Checking RRset signatures without RRset responses")
+	}
+
+	domainDSPolicy.SetRRSetResponses(
+		newResponse(dns.TypeSOA, soa, soaRRSIG),
+		newResponse(dns.TypeNS, ns, nsRRSIG),
+	)
+
+	if !domainDSPolicy.rrsetPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusOK {
+		t.Errorf("Not accepting valid RRset signatures. Found %s",
+			model.DSStatusToString(domain.DSSet[0].LastStatus))
+	}
+
+	if domain.DSSet[0].ExpiresAt.Unix() != soaExpiration.Unix() {
+		t.Error("Not storing the earliest RRset signature expiration")
+	}
+
+	// Signature that expires before the RRset TTL
+	shortRRSIG, err := signRRWithValidity(dnskey, privateKey, soa,
+		time.Now().Add(-time.Hour), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain = newDomain()
+	domainDSPolicy = NewDomainDSPolicy(domain)
+	domainDSPolicy.SetRRSetResponses(newResponse(dns.TypeSOA, soa, shortRRSIG))
+
+	if !domainDSPolicy.rrsetPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusSignatureTTL {
+		t.Errorf("Not alerting about RRset TTL longer than the signature validity. Found %s",
+			model.DSStatusToString(domain.DSSet[0].LastStatus))
+	}
+
+	// Expired signature
+	expiredRRSIG, err := signRRWithValidity(dnskey, privateKey, soa,
+		time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain = newDomain()
+	domainDSPolicy = NewDomainDSPolicy(domain)
+	domainDSPolicy.SetRRSetResponses(newResponse(dns.TypeSOA, soa, expiredRRSIG))
+
+	if domainDSPolicy.rrsetPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusRRSetExpiredSignature {
+		t.Errorf("Not detecting an expired RRset signature. Found %s",
+			model.DSStatusToString(domain.DSSet[0].LastStatus))
+	}
+
+	// Missing signature
+	domain = newDomain()
+	domainDSPolicy = NewDomainDSPolicy(domain)
+	domainDSPolicy.SetRRSetResponses(newResponse(dns.TypeNS, ns))
+
+	if domainDSPolicy.rrsetPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusRRSetSignatureError {
+		t.Errorf("Not detecting a RRset without signature. Found %s",
+			model.DSStatusToString(domain.DSSet[0].LastStatus))
+	}
+
+	// Broken signature, the RRset changed after signing
+	changedSOA := *soa
+	changedSOA.Serial++
+
+	domain = newDomain()
+	domainDSPolicy = NewDomainDSPolicy(domain)
+	domainDSPolicy.SetRRSetResponses(newResponse(dns.TypeSOA, &changedSOA, soaRRSIG))
+
+	if domainDSPolicy.rrsetPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusRRSetSignatureError {
+		t.Errorf("Not detecting a broken RRset signature. Found %s",
+			model.DSStatusToString(domain.DSSet[0].LastStatus))
+	}
+}
+
+func TestDenialPolicy(t *testing.T) {
+	dnskey, privateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		FQDN: "test.br.",
+		DSSet: []model.DS{
+			{
+				Keytag:     dnskey.KeyTag(),
+				Algorithm:  convertKeyAlgorithm(dnskey.Algorithm),
+				DigestType: model.DSDigestTypeSHA1,
+				LastStatus: model.DSStatusOK,
+			},
+		},
+	}
+
+	domainDSPolicy := NewDomainDSPolicy(domain)
+
+	dnskeyResponseMessage := &dns.Msg{
+		Answer: []dns.RR{
+			dnskey,
+		},
+	}
+
+	if !domainDSPolicy.denialPolicy(dnskeyResponseMessage) {
+		t.Error("Checking denial of existence without a denial response")
+	}
+
+	nsec := &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   "test.br.",
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		NextDomain: "www.test.br.",
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY},
+	}
+
+	rrsig, err := signRR(dnskey, privateKey, nsec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	denialResponseMessage := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Rcode: dns.RcodeNameError,
+		},
+		Question: []dns.Question{
+			{Name: "shelter.test.br.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+		},
+		Ns: []dns.RR{
+			nsec,
+			rrsig,
+		},
+	}
+
+	domainDSPolicy.SetDenialResponse(denialResponseMessage)
+	if !domainDSPolicy.denialPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusOK {
+		t.Error("Not accepting a valid NSEC denial of existence proof")
+	}
+
+	denialResponseMessage.Question[0].Name = "zzz.test.br."
+	if domainDSPolicy.denialPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusDenialProofError {
+		t.Error("Not detecting a NSEC that doesn't cover the name")
+	}
+
+	domain.DSSet[0].ChangeStatus(model.DSStatusOK)
+	denialResponseMessage.Question[0].Name = "shelter.test.br."
+	denialResponseMessage.Ns = []dns.RR{nsec}
+
+	if domainDSPolicy.denialPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusDenialSignatureError {
+		t.Error("Not detecting a NSEC without signature")
+	}
+
+	domain.DSSet[0].ChangeStatus(model.DSStatusOK)
+	denialResponseMessage.Ns = nil
+
+	if domainDSPolicy.denialPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusNoDenialProof {
+		t.Error("Not detecting a negative answer without denial of existence proof")
+	}
+
+	domain.DSSet[0].ChangeStatus(model.DSStatusOK)
+	denialResponseMessage.Rcode = dns.RcodeSuccess
+
+	if !domainDSPolicy.denialPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusOK {
+		t.Error("Checking denial of existence of a name that exists")
+	}
+}
+
+func TestDenialPolicyNSEC3(t *testing.T) {
+	dnskey, privateKey, err := generateKey("test.br.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domain := &model.Domain{
+		FQDN: "test.br.",
+		DSSet: []model.DS{
+			{
+				Keytag:     dnskey.KeyTag(),
+				Algorithm:  convertKeyAlgorithm(dnskey.Algorithm),
+				DigestType: model.DSDigestTypeSHA1,
+				LastStatus: model.DSStatusOK,
+			},
+		},
+	}
+
+	domainDSPolicy := NewDomainDSPolicy(domain)
+
+	dnskeyResponseMessage := &dns.Msg{
+		Answer: []dns.RR{
+			dnskey,
+		},
+	}
+
+	// The zone has only the apex, so a single NSEC3 that points to itself proves that any
+	// other name doesn't exist
+	buildDenialResponse := func(iterations uint16, salt string) *dns.Msg {
+		hash := dns.HashName("test.br.", dns.SHA1, iterations, salt)
+		nsec3 := &dns.NSEC3{
+			Hdr: dns.RR_Header{
+				Name:   strings.ToLower(hash) + ".test.br.",
+				Rrtype: dns.TypeNSEC3,
+				Class:  dns.ClassINET,
+				Ttl:    900,
+			},
+			Hash:       dns.SHA1,
+			Iterations: iterations,
+			SaltLength: uint8(len(salt) / 2),
+			Salt:       salt,
+			HashLength: 20,
+			NextDomain: hash,
+			TypeBitMap: []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY},
+		}
+
+		rrsig, err := signRR(dnskey, privateKey, nsec3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &dns.Msg{
+			MsgHdr: dns.MsgHdr{
+				Rcode: dns.RcodeNameError,
+			},
+			Question: []dns.Question{
+				{Name: "shelter.test.br.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			},
+			Ns: []dns.RR{
+				nsec3,
+				rrsig,
+			},
+		}
+	}
+
+	domainDSPolicy.SetDenialResponse(buildDenialResponse(0, ""))
+	if !domainDSPolicy.denialPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusOK {
+		t.Error("Not accepting a valid NSEC3 denial of existence proof")
+	}
+
+	domainDSPolicy.SetDenialResponse(buildDenialResponse(10, "AABBCCDD"))
+	if !domainDSPolicy.denialPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusNSEC3Parameters {
+		t.Error("Not warning about NSEC3 parameters that aren't recommended")
+	}
+
+	domain.DSSet[0].ChangeStatus(model.DSStatusOK)
+
+	domainDSPolicy.SetDenialResponse(buildDenialResponse(100, "AABBCCDD"))
+	if domainDSPolicy.denialPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusNSEC3Iterations {
+		t.Error("Not detecting NSEC3 iterations above the limit of the validators")
+	}
+
+	domain.DSSet[0].ChangeStatus(model.DSStatusOK)
+	domainDSPolicy.parameters = policy.Parameters{"maxIterations": "150"}
+
+	if !domainDSPolicy.denialPolicy(dnskeyResponseMessage) ||
+		domain.DSSet[0].LastStatus != model.DSStatusNSEC3Parameters {
+		t.Error("Not using the iterations limit of the policy parameters")
+	}
+}
+
 func TestSelectDNSKEY(t *testing.T) {
 	dnskey, rrsig, err := generateKeyAndSignZone("test.br.")
 	if err != nil {
@@ -622,6 +1388,56 @@ func generateKeyAndSignZoneWithExpiredSignature(zone string) (*dns.DNSKEY, *dns.
 	return dnskey, rrsig, nil
 }
 
+func generateKey(zone string) (*dns.DNSKEY, dns.PrivateKey, error) {
+	dnskey := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    900,
+		},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.RSASHA256,
+	}
+
+	privateKey, err := dnskey.Generate(1024)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dnskey, privateKey, nil
+}
+
+func signRR(dnskey *dns.DNSKEY, privateKey dns.PrivateKey, rr dns.RR) (*dns.RRSIG, error) {
+	return signRRWithValidity(dnskey, privateKey, rr, time.Now(), time.Now().Add(10*time.Second))
+}
+
+func signRRWithValidity(dnskey *dns.DNSKEY, privateKey dns.PrivateKey, rr dns.RR,
+	inception, expiration time.Time) (*dns.RRSIG, error) {
+
+	rrsig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name:   rr.Header().Name,
+			Rrtype: dns.TypeRRSIG,
+			Class:  dns.ClassINET,
+			Ttl:    rr.Header().Ttl,
+		},
+		TypeCovered: rr.Header().Rrtype,
+		Algorithm:   dnskey.Algorithm,
+		Expiration:  uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		KeyTag:      dnskey.KeyTag(),
+		SignerName:  dnskey.Hdr.Name,
+	}
+
+	if err := rrsig.Sign(privateKey, []dns.RR{rr}); err != nil {
+		return nil, err
+	}
+
+	return rrsig, nil
+}
+
 func convertKeyAlgorithm(algorithm uint8) model.DSAlgorithm {
 	switch algorithm {
 	case dns.RSAMD5:
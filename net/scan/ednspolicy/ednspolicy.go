@@ -0,0 +1,313 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package ednspolicy store the EDNS compliance policies for nameserver checks
+package ednspolicy
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
+	"net"
+)
+
+const (
+	// Identification of the EDNS compliance probes in the policy registry. The probes are
+	// sent by the scan only when the policy is enabled for the domain
+	Policy = "ns.edns"
+
+	// EDNS version that isn't defined yet, the nameserver must answer with BADVERS and the
+	// highest version that it supports (RFC 6891 - section 6.1.3)
+	unknownEDNSVersion = 1
+
+	// EDNS flag that isn't defined yet. The EDNS flags are the lower 16 bits of the OPT
+	// record TTL, and only the DO bit is defined. The nameserver must ignore unknown flags
+	// and don't copy them to the response
+	unknownEDNSFlag = 0x0080
+
+	// Mask to retrieve the EDNS flags from the OPT record TTL without the DO bit
+	ednsFlagsMask = 0x7FFF
+
+	// UDP size advertised in the truncation probe. This is the maximum size of a DNS message
+	// over UDP without EDNS (RFC 1035)
+	truncationUDPSize = 512
+)
+
+var (
+	// List of all EDNS compliance probes that are going to be sent in the order defined
+	// here. Each probe has the function that builds the query and the policy that checks
+	// the response
+	ednsProbes = []ednsProbe{
+		{
+			probe:   model.EDNSProbeVersion,
+			request: (*DomainEDNSPolicy).versionRequest,
+			policy:  (*DomainEDNSPolicy).versionPolicy,
+		},
+		{
+			probe:   model.EDNSProbeOption,
+			request: (*DomainEDNSPolicy).optionRequest,
+			policy:  (*DomainEDNSPolicy).optionPolicy,
+		},
+		{
+			probe:   model.EDNSProbeFlag,
+			request: (*DomainEDNSPolicy).flagRequest,
+			policy:  (*DomainEDNSPolicy).flagPolicy,
+		},
+		{
+			probe:   model.EDNSProbeDO,
+			request: (*DomainEDNSPolicy).doRequest,
+			policy:  (*DomainEDNSPolicy).doPolicy,
+		},
+		{
+			probe:   model.EDNSProbeTruncation,
+			request: (*DomainEDNSPolicy).truncationRequest,
+			policy:  (*DomainEDNSPolicy).truncationPolicy,
+		},
+	}
+)
+
+func init() {
+	policy.Register(policy.Standalone{Identifier: Policy})
+}
+
+// ednsProbe store how to build the query of an EDNS compliance probe and how to check the
+// response of the nameserver
+type ednsProbe struct {
+	probe   model.EDNSProbe
+	request func(*DomainEDNSPolicy) *dns.Msg
+	policy  func(*DomainEDNSPolicy, *dns.Msg) model.EDNSStatus
+}
+
+// DomainEDNSPolicy store the domain object that is used to build the EDNS compliance
+// probes and the UDP max size supported in the network
+type DomainEDNSPolicy struct {
+	domain     *model.Domain // Domain object used to build the probes' queries
+	udpMaxSize uint16        // UDP max package size to pass over firewalls
+}
+
+// This function initialize a DomainEDNSPolicy object, it was created to force the
+// programmer to initialize the domain object, so we don't need to check if domain is nil
+// inside each method
+func NewDomainEDNSPolicy(domain *model.Domain, udpMaxSize uint16) DomainEDNSPolicy {
+	return DomainEDNSPolicy{
+		domain:     domain,
+		udpMaxSize: udpMaxSize,
+	}
+}
+
+// List the EDNS compliance probes in the order that they should be sent
+func Probes() []model.EDNSProbe {
+	var probes []model.EDNSProbe
+	for _, ednsProbe := range ednsProbes {
+		probes = append(probes, ednsProbe.probe)
+	}
+	return probes
+}
+
+// Build the query of an EDNS compliance probe. Returns nil if the probe doesn't exist
+func (d *DomainEDNSPolicy) Request(probe model.EDNSProbe) *dns.Msg {
+	for _, ednsProbe := range ednsProbes {
+		if ednsProbe.probe == probe {
+			return ednsProbe.request(d)
+		}
+	}
+
+	return nil
+}
+
+// When there's a error while sending an EDNS compliance probe, this method is responsable
+// for detecting the timeouts. Nameservers that drop queries with unknown EDNS data are a
+// common problem, so a timeout here is also a compliance problem
+func (d *DomainEDNSPolicy) CheckNetworkError(err error) model.EDNSStatus {
+	if err == nil {
+		return model.EDNSStatusOK
+	}
+
+	if netError, ok := err.(net.Error); ok && netError.Timeout() {
+		return model.EDNSStatusTimeout
+	}
+
+	return model.EDNSStatusError
+}
+
+// Method responsable for checking the response of an EDNS compliance probe
+func (d *DomainEDNSPolicy) Run(probe model.EDNSProbe,
+	dnsResponseMessage *dns.Msg) model.EDNSStatus {
+
+	// Something went really wrong, because if we got here there was no network error and it
+	// should have a DNS response message, but as a safety check we don't allow to continue
+	if dnsResponseMessage == nil {
+		return model.EDNSStatusError
+	}
+
+	for _, ednsProbe := range ednsProbes {
+		if ednsProbe.probe == probe {
+			return ednsProbe.policy(d, dnsResponseMessage)
+		}
+	}
+
+	return model.EDNSStatusError
+}
+
+// Build the basic EDNS query used by all probes, asking for the given type in the apex of
+// the zone
+func (d *DomainEDNSPolicy) newRequest(rrType uint16, udpSize uint16, do bool) *dns.Msg {
+	var dnsRequestMessage dns.Msg
+	dnsRequestMessage.SetQuestion(dns.Fqdn(d.domain.FQDN), rrType)
+	dnsRequestMessage.RecursionDesired = false
+	dnsRequestMessage.SetEdns0(udpSize, do)
+	return &dnsRequestMessage
+}
+
+// Query with an EDNS version that doesn't exist yet
+func (d *DomainEDNSPolicy) versionRequest() *dns.Msg {
+	dnsRequestMessage := d.newRequest(dns.TypeSOA, d.udpMaxSize, false)
+	dnsRequestMessage.IsEdns0().SetVersion(unknownEDNSVersion)
+	return dnsRequestMessage
+}
+
+// The nameserver must answer the unknown version with BADVERS, using the EDNS version
+// that it supports
+func (d *DomainEDNSPolicy) versionPolicy(dnsResponseMessage *dns.Msg) model.EDNSStatus {
+	opt := dnsResponseMessage.IsEdns0()
+	if opt == nil {
+		return model.EDNSStatusNoOPT
+	}
+
+	if rcode(dnsResponseMessage) != dns.RcodeBadVers {
+		return model.EDNSStatusRcode
+	}
+
+	if opt.Version() != 0 {
+		return model.EDNSStatusVersion
+	}
+
+	return model.EDNSStatusOK
+}
+
+// Query with an EDNS option that the authoritative nameservers don't implement. The DNS
+// library doesn't allow us to build an option with an unassigned code, so we are using
+// the update lease option (DNS-SD) that is used only in dynamic updates
+func (d *DomainEDNSPolicy) optionRequest() *dns.Msg {
+	dnsRequestMessage := d.newRequest(dns.TypeSOA, d.udpMaxSize, false)
+
+	opt := dnsRequestMessage.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_UL{
+		Code:  dns.EDNS0UL,
+		Lease: 3600,
+	})
+
+	return dnsRequestMessage
+}
+
+// The nameserver must ignore the unknown option and don't copy it to the response
+func (d *DomainEDNSPolicy) optionPolicy(dnsResponseMessage *dns.Msg) model.EDNSStatus {
+	if status := d.ednsPolicy(dnsResponseMessage); status != model.EDNSStatusOK {
+		return status
+	}
+
+	for _, option := range dnsResponseMessage.IsEdns0().Option {
+		if option.Option() == dns.EDNS0UL {
+			return model.EDNSStatusOption
+		}
+	}
+
+	return model.EDNSStatusOK
+}
+
+// Query with an EDNS flag that isn't defined yet
+func (d *DomainEDNSPolicy) flagRequest() *dns.Msg {
+	dnsRequestMessage := d.newRequest(dns.TypeSOA, d.udpMaxSize, false)
+	dnsRequestMessage.IsEdns0().Hdr.Ttl |= unknownEDNSFlag
+	return dnsRequestMessage
+}
+
+// The nameserver must ignore the unknown flag and don't copy it to the response
+func (d *DomainEDNSPolicy) flagPolicy(dnsResponseMessage *dns.Msg) model.EDNSStatus {
+	if status := d.ednsPolicy(dnsResponseMessage); status != model.EDNSStatusOK {
+		return status
+	}
+
+	if dnsResponseMessage.IsEdns0().Hdr.Ttl&ednsFlagsMask != 0 {
+		return model.EDNSStatusFlags
+	}
+
+	return model.EDNSStatusOK
+}
+
+// Query with the DO bit on, asking for DNSSEC records
+func (d *DomainEDNSPolicy) doRequest() *dns.Msg {
+	return d.newRequest(dns.TypeSOA, d.udpMaxSize, true)
+}
+
+// The nameserver must copy the DO bit to the response (RFC 3225 - section 3)
+func (d *DomainEDNSPolicy) doPolicy(dnsResponseMessage *dns.Msg) model.EDNSStatus {
+	if status := d.ednsPolicy(dnsResponseMessage); status != model.EDNSStatusOK {
+		return status
+	}
+
+	if !dnsResponseMessage.IsEdns0().Do() {
+		return model.EDNSStatusDO
+	}
+
+	return model.EDNSStatusOK
+}
+
+// Query for the DNSKEY set with the DO bit on, that is usually a big response, advertising
+// a small UDP size
+func (d *DomainEDNSPolicy) truncationRequest() *dns.Msg {
+	return d.newRequest(dns.TypeDNSKEY, truncationUDPSize, true)
+}
+
+// The nameserver must set the TC flag when the response doesn't fit in the advertised UDP
+// size
+func (d *DomainEDNSPolicy) truncationPolicy(dnsResponseMessage *dns.Msg) model.EDNSStatus {
+	if status := d.ednsPolicy(dnsResponseMessage); status != model.EDNSStatusOK {
+		return status
+	}
+
+	if dnsResponseMessage.Truncated {
+		return model.EDNSStatusOK
+	}
+
+	// The response was already unpacked, so we estimate the size of the message in the wire
+	// using name compression. The DNS library always returns one more byte than needed
+	compressedMessage := *dnsResponseMessage
+	compressedMessage.Compress = true
+
+	if compressedMessage.Len()-1 > truncationUDPSize {
+		return model.EDNSStatusTruncation
+	}
+
+	return model.EDNSStatusOK
+}
+
+// Basic checks for the probes that use a known EDNS version. The nameserver must answer
+// with the OPT record, without errors and using the same EDNS version
+func (d *DomainEDNSPolicy) ednsPolicy(dnsResponseMessage *dns.Msg) model.EDNSStatus {
+	opt := dnsResponseMessage.IsEdns0()
+	if opt == nil {
+		return model.EDNSStatusNoOPT
+	}
+
+	if rcode(dnsResponseMessage) != dns.RcodeSuccess {
+		return model.EDNSStatusRcode
+	}
+
+	if opt.Version() != 0 {
+		return model.EDNSStatusVersion
+	}
+
+	return model.EDNSStatusOK
+}
+
+// Retrieve the full return code of the response. EDNS extends the 4 bits return code of
+// the header with 8 bits in the OPT record (RFC 6891 - section 6.1.3)
+func rcode(dnsResponseMessage *dns.Msg) int {
+	if opt := dnsResponseMessage.IsEdns0(); opt != nil {
+		return int(opt.ExtendedRcode())<<4 | dnsResponseMessage.Rcode
+	}
+
+	return dnsResponseMessage.Rcode
+}
@@ -0,0 +1,241 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package ednspolicy store the EDNS compliance policies for nameserver checks
+package ednspolicy
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"strings"
+	"testing"
+)
+
+// myErr was created only to test possible network errors
+type myErr struct {
+	err     string
+	timeout bool
+}
+
+func (e myErr) Error() string {
+	return e.err
+}
+
+func (e myErr) Timeout() bool {
+	return e.timeout
+}
+
+func (e myErr) Temporary() bool {
+	return true
+}
+
+// Build a response for the EDNS probe with the OPT record copied from the request
+func newResponse(dnsRequestMessage *dns.Msg) *dns.Msg {
+	dnsResponseMessage := new(dns.Msg)
+	dnsResponseMessage.SetReply(dnsRequestMessage)
+	dnsResponseMessage.SetEdns0(4096, dnsRequestMessage.IsEdns0().Do())
+	return dnsResponseMessage
+}
+
+func TestEDNSNetworkError(t *testing.T) {
+	domainEDNSPolicy := NewDomainEDNSPolicy(&model.Domain{}, 4096)
+
+	if domainEDNSPolicy.CheckNetworkError(myErr{timeout: true}) != model.EDNSStatusTimeout {
+		t.Error("Not detecting network timeout")
+	}
+
+	if domainEDNSPolicy.CheckNetworkError(myErr{err: "generic"}) != model.EDNSStatusError {
+		t.Error("Not detecting a network generic error")
+	}
+
+	if domainEDNSPolicy.CheckNetworkError(nil) != model.EDNSStatusOK {
+		t.Error("Reporting error when everything was OK")
+	}
+}
+
+func TestProbes(t *testing.T) {
+	domainEDNSPolicy := NewDomainEDNSPolicy(&model.Domain{FQDN: "test.com.br"}, 4096)
+
+	probes := Probes()
+	if len(probes) != 5 {
+		t.Fatalf("Unexpected number of EDNS probes. Expected 5 and got %d", len(probes))
+	}
+
+	for _, probe := range probes {
+		dnsRequestMessage := domainEDNSPolicy.Request(probe)
+		if dnsRequestMessage == nil {
+			t.Errorf("Not building the query of the EDNS probe %s", model.EDNSProbeToString(probe))
+			continue
+		}
+
+		if dnsRequestMessage.Question[0].Name != "test.com.br." ||
+			dnsRequestMessage.RecursionDesired || dnsRequestMessage.IsEdns0() == nil {
+
+			t.Errorf("Wrong query for the EDNS probe %s", model.EDNSProbeToString(probe))
+		}
+	}
+
+	if domainEDNSPolicy.Request(999999) != nil {
+		t.Error("Building a query for an unknown EDNS probe")
+	}
+
+	if domainEDNSPolicy.Run(999999, new(dns.Msg)) != model.EDNSStatusError {
+		t.Error("Checking the response of an unknown EDNS probe")
+	}
+
+	if domainEDNSPolicy.Run(model.EDNSProbeDO, nil) != model.EDNSStatusError {
+		t.Error("Allowed an empty message in EDNS policies")
+	}
+}
+
+func TestVersionPolicy(t *testing.T) {
+	domainEDNSPolicy := NewDomainEDNSPolicy(&model.Domain{FQDN: "test.com.br"}, 4096)
+	dnsRequestMessage := domainEDNSPolicy.Request(model.EDNSProbeVersion)
+
+	if dnsRequestMessage.IsEdns0().Version() != unknownEDNSVersion {
+		t.Error("Not sending an unknown EDNS version")
+	}
+
+	dnsResponseMessage := newResponse(dnsRequestMessage)
+	dnsResponseMessage.IsEdns0().SetExtendedRcode(dns.RcodeBadVers >> 4)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeVersion, dnsResponseMessage) != model.EDNSStatusOK {
+		t.Error("Not accepting a BADVERS response")
+	}
+
+	dnsResponseMessage = newResponse(dnsRequestMessage)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeVersion, dnsResponseMessage) != model.EDNSStatusRcode {
+		t.Error("Not detecting a nameserver that answers an unknown EDNS version")
+	}
+
+	dnsResponseMessage = newResponse(dnsRequestMessage)
+	dnsResponseMessage.IsEdns0().SetExtendedRcode(dns.RcodeBadVers >> 4)
+	dnsResponseMessage.IsEdns0().SetVersion(unknownEDNSVersion)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeVersion, dnsResponseMessage) != model.EDNSStatusVersion {
+		t.Error("Not detecting a response with an unknown EDNS version")
+	}
+
+	dnsResponseMessage = new(dns.Msg)
+	dnsResponseMessage.SetReply(dnsRequestMessage)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeVersion, dnsResponseMessage) != model.EDNSStatusNoOPT {
+		t.Error("Not detecting a response without OPT record")
+	}
+}
+
+func TestOptionPolicy(t *testing.T) {
+	domainEDNSPolicy := NewDomainEDNSPolicy(&model.Domain{FQDN: "test.com.br"}, 4096)
+	dnsRequestMessage := domainEDNSPolicy.Request(model.EDNSProbeOption)
+
+	if len(dnsRequestMessage.IsEdns0().Option) != 1 {
+		t.Error("Not sending an unknown EDNS option")
+	}
+
+	dnsResponseMessage := newResponse(dnsRequestMessage)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeOption, dnsResponseMessage) != model.EDNSStatusOK {
+		t.Error("Not accepting a response without the unknown option")
+	}
+
+	opt := dnsResponseMessage.IsEdns0()
+	opt.Option = append(opt.Option, dnsRequestMessage.IsEdns0().Option...)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeOption, dnsResponseMessage) != model.EDNSStatusOption {
+		t.Error("Not detecting a response with the unknown option")
+	}
+
+	dnsResponseMessage = newResponse(dnsRequestMessage)
+	dnsResponseMessage.Rcode = dns.RcodeFormatError
+
+	if domainEDNSPolicy.Run(model.EDNSProbeOption, dnsResponseMessage) != model.EDNSStatusRcode {
+		t.Error("Not detecting a nameserver that doesn't ignore the unknown option")
+	}
+}
+
+func TestFlagPolicy(t *testing.T) {
+	domainEDNSPolicy := NewDomainEDNSPolicy(&model.Domain{FQDN: "test.com.br"}, 4096)
+	dnsRequestMessage := domainEDNSPolicy.Request(model.EDNSProbeFlag)
+
+	if dnsRequestMessage.IsEdns0().Hdr.Ttl&ednsFlagsMask != unknownEDNSFlag {
+		t.Error("Not sending an unknown EDNS flag")
+	}
+
+	dnsResponseMessage := newResponse(dnsRequestMessage)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeFlag, dnsResponseMessage) != model.EDNSStatusOK {
+		t.Error("Not accepting a response without the unknown flag")
+	}
+
+	dnsResponseMessage.IsEdns0().Hdr.Ttl |= unknownEDNSFlag
+
+	if domainEDNSPolicy.Run(model.EDNSProbeFlag, dnsResponseMessage) != model.EDNSStatusFlags {
+		t.Error("Not detecting a response with the unknown flag")
+	}
+}
+
+func TestDOPolicy(t *testing.T) {
+	domainEDNSPolicy := NewDomainEDNSPolicy(&model.Domain{FQDN: "test.com.br"}, 4096)
+	dnsRequestMessage := domainEDNSPolicy.Request(model.EDNSProbeDO)
+
+	if !dnsRequestMessage.IsEdns0().Do() {
+		t.Error("Not sending the DO bit")
+	}
+
+	dnsResponseMessage := newResponse(dnsRequestMessage)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeDO, dnsResponseMessage) != model.EDNSStatusOK {
+		t.Error("Not accepting a response with the DO bit")
+	}
+
+	dnsResponseMessage = new(dns.Msg)
+	dnsResponseMessage.SetReply(dnsRequestMessage)
+	dnsResponseMessage.SetEdns0(4096, false)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeDO, dnsResponseMessage) != model.EDNSStatusDO {
+		t.Error("Not detecting a response without the DO bit")
+	}
+}
+
+func TestTruncationPolicy(t *testing.T) {
+	domainEDNSPolicy := NewDomainEDNSPolicy(&model.Domain{FQDN: "test.com.br"}, 4096)
+	dnsRequestMessage := domainEDNSPolicy.Request(model.EDNSProbeTruncation)
+
+	if dnsRequestMessage.IsEdns0().UDPSize() != truncationUDPSize {
+		t.Error("Not advertising a small UDP size in the truncation probe")
+	}
+
+	dnsResponseMessage := newResponse(dnsRequestMessage)
+
+	if domainEDNSPolicy.Run(model.EDNSProbeTruncation, dnsResponseMessage) != model.EDNSStatusOK {
+		t.Error("Not accepting a small response")
+	}
+
+	for i := 0; i < 5; i++ {
+		dnsResponseMessage.Answer = append(dnsResponseMessage.Answer, &dns.DNSKEY{
+			Hdr: dns.RR_Header{
+				Name:   "test.com.br.",
+				Rrtype: dns.TypeDNSKEY,
+				Class:  dns.ClassINET,
+			},
+			Flags:     257,
+			Protocol:  3,
+			Algorithm: dns.RSASHA256,
+			PublicKey: strings.Repeat("A", 172),
+		})
+	}
+
+	if domainEDNSPolicy.Run(model.EDNSProbeTruncation, dnsResponseMessage) !=
+		model.EDNSStatusTruncation {
+
+		t.Error("Not detecting a big response without the TC flag")
+	}
+
+	dnsResponseMessage.Truncated = true
+
+	if domainEDNSPolicy.Run(model.EDNSProbeTruncation, dnsResponseMessage) != model.EDNSStatusOK {
+		t.Error("Not accepting a truncated response")
+	}
+}
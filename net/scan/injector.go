@@ -10,29 +10,29 @@ import (
 	"github.com/rafaeljusto/shelter/dao"
 	"github.com/rafaeljusto/shelter/model"
 	"sync"
+	"time"
 )
 
 // Injector is responsable for selecting all domains that are going to be checked. While
 // selecting the domains the injector will add to a channel, so that the querier can start
 // immediately
 type Injector struct {
-	Database                 *mgo.Database // Low level database connection
-	DomainsBufferSize        int           // Size of the domains to query channel
-	MaxOKVerificationDays    int           // Maximum number of days to verify a domain configured correctly with DNS/DNSSEC
-	MaxErrorVerificationDays int           // Maximum number of days to verify a domain with problems
-	MaxExpirationAlertDays   int           // Number of days to alert for DNSSEC signatures that are near from the expiration date
+	Database          *mgo.Database // Low level database connection
+	DomainsBufferSize int           // Size of the domains to query channel
+	ScanInterval      time.Duration // Time until the next scan, to select the domains that are due before it
+	FQDNs             []string      // Domains of a distributed scan batch, when defined only these domains are loaded
+	ScanStartedAt     time.Time     // Start of the scan, the domains already checked by a resumed scan aren't loaded again
+	control           *scanControl  // Stop loading domains when the user cancels the scan
 }
 
 // Return a new Injector object with the necessary fields for the scan filled
-func NewInjector(database *mgo.Database, domainsBufferSize, maxOKVerificationDays,
-	maxErrorVerificationDays, maxExpirationAlertDays int) *Injector {
+func NewInjector(database *mgo.Database, domainsBufferSize int,
+	scanInterval time.Duration) *Injector {
 
 	return &Injector{
-		Database:                 database,
-		DomainsBufferSize:        domainsBufferSize,
-		MaxOKVerificationDays:    maxOKVerificationDays,
-		MaxErrorVerificationDays: maxErrorVerificationDays,
-		MaxExpirationAlertDays:   maxExpirationAlertDays,
+		Database:          database,
+		DomainsBufferSize: domainsBufferSize,
+		ScanInterval:      scanInterval,
 	}
 }
 
@@ -57,8 +57,20 @@ func (i *Injector) Start(scanGroup *sync.WaitGroup, errorsChannel chan error) ch
 			Database: i.Database,
 		}
 
-		// Load all domains from database to begin the scan
-		domainChannel, err := domainDAO.FindAllAsync()
+		// Load the domains that are due from database to begin the scan. The domains that are
+		// due before the next scan are also checked now, otherwise they would wait a whole
+		// scan interval after their next check date. When scanning a batch of a distributed
+		// scan, the coordinator already selected the domains. When resuming an interrupted
+		// scan, the domains that the scan already checked are ignored
+		var domainChannel chan dao.DomainResult
+		var err error
+
+		if i.FQDNs != nil {
+			domainChannel, err = domainDAO.FindAllAsyncByFQDNs(i.FQDNs, i.control.done())
+		} else {
+			domainChannel, err = domainDAO.FindAllAsyncToBeScanned(
+				time.Now().Add(i.ScanInterval), i.ScanStartedAt, i.control.done())
+		}
 
 		// Low level error was detected. No domain was processed yet, but we still need to
 		// shutdown the querier and by consequence the collector, so we send back the error
@@ -76,8 +88,21 @@ func (i *Injector) Start(scanGroup *sync.WaitGroup, errorsChannel chan error) ch
 
 		// Dispatch the asynchronous part of the method
 		for {
-			// Get domain from the database (one-by-one)
-			domainResult := <-domainChannel
+			// Get domain from the database (one-by-one). When the user cancels the scan the
+			// database cursor is released and we send the poison pill immediately, the
+			// domains that weren't checked are selected again in the next scan
+			var domainResult dao.DomainResult
+			select {
+			case domainResult = <-domainChannel:
+			case <-i.control.done():
+				domainsToQueryChannel <- nil
+
+				// Tells the scan information structure that the injector is done
+				model.FinishLoadingDomainsForScan()
+
+				scanGroup.Done()
+				return
+			}
 
 			// Send back the error to the caller thread. We don't log the error here directly
 			// into the log interface because sometimes we want to do something when an error
@@ -98,16 +123,12 @@ func (i *Injector) Start(scanGroup *sync.WaitGroup, errorsChannel chan error) ch
 				return
 			}
 
-			// The logic that decides if a domain is going to be a part of this scan or not is
-			// inside the domain object for better unit testing
-			if domainResult.Domain.ShouldBeScanned(i.MaxOKVerificationDays,
-				i.MaxErrorVerificationDays, i.MaxExpirationAlertDays) {
-				// Send to the querier
-				domainsToQueryChannel <- domainResult.Domain
+			// The database already selected only the domains that are due, according to the
+			// next check date computed by the collector. Send to the querier
+			domainsToQueryChannel <- domainResult.Domain
 
-				// Count domain for the scan information to estimate the scan progress
-				model.LoadedDomainForScan()
-			}
+			// Count domain for the scan information to estimate the scan progress
+			model.LoadedDomainForScan()
 		}
 	}()
 
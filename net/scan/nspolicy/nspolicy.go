@@ -6,31 +6,117 @@
 package nspolicy
 
 import (
+	"fmt"
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
 	"github.com/rafaeljusto/shelter/model"
 	"github.com/rafaeljusto/shelter/net/scan/dnsutils"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
 	"net"
+	"strings"
 	"syscall"
+	"time"
 )
 
 var (
-	// List of all nameserver policies that are going to be executed in the order defined
-	// here. The order is important because the policies depends on each other, assuming
-	// that something was already verified
-	nsPolicies = []func(*DomainNSPolicy, *dns.Msg) model.NameserverStatus{
-		(*DomainNSPolicy).cnamePolicy,
-		(*DomainNSPolicy).rcodePolicy,
-		(*DomainNSPolicy).authorityPolicy,
-		(*DomainNSPolicy).soaPolicy,
+	// List of all nameserver policies of this package, registered in the policy registry.
+	// The registry executes the policies in the order defined here, after their
+	// dependencies. The dependencies are important because the policies assume that
+	// something was already verified
+	nsPolicies = []nsPolicy{
+		{"ns.cname", nil, (*DomainNSPolicy).cnamePolicy},
+		{"ns.rcode", nil, (*DomainNSPolicy).rcodePolicy},
+		{"ns.authority", []string{"ns.rcode"}, (*DomainNSPolicy).authorityPolicy},
+		{"ns.soa", []string{"ns.authority"}, (*DomainNSPolicy).soaPolicy},
+		{"ns.tcp", []string{"ns.rcode"}, (*DomainNSPolicy).tcpPolicy},
+		{"ns.nsset", []string{"ns.authority"}, (*DomainNSPolicy).nsSetPolicy},
+		{"ns.glue", []string{"ns.authority"}, (*DomainNSPolicy).gluePolicy},
+	}
+
+	// Policies of this package that the scan executes directly, because they don't check
+	// the SOA response of a single nameserver address. The registry only decides if they
+	// are enabled for the domain and with which parameters
+	standalonePolicies = []policy.Standalone{
+		{Identifier: SOAFieldsPolicy, Requires: []string{"ns.soa"}},
+		{Identifier: SerialPolicy, Requires: []string{"ns.soa"}},
+		{Identifier: RTTPolicy},
 	}
 )
 
-// DomainNSPolicy store the domain object and the version of the DNS zone. This is
-// necessary because we need to check the DNS zone version on each nameserver and detect
-// if they are different
+const (
+	SOAFieldsPolicy = "ns.soafields" // Sanity checks of the SOA record fields (CheckSOA)
+	SerialPolicy    = "ns.serial"    // Zone version comparison (CheckSerials)
+	RTTPolicy       = "ns.rtt"       // Round trip time limit of the nameservers (CheckRTT)
+)
+
+const (
+	// Recommended ranges (in seconds) for the SOA timers. The values are based on RFC 1912
+	// (section 2.2) and on the negative caching recommendations of RFC 2308. The "minRefresh",
+	// "maxRefresh", "minRetry", "maxRetry", "minExpire", "maxExpire", "minTTL" and "maxTTL"
+	// parameters of the "ns.soafields" policy replace these values
+	MinSOARefresh = 1200    // 20 minutes
+	MaxSOARefresh = 86400   // 1 day
+	MinSOARetry   = 120     // 2 minutes
+	MaxSOARetry   = 7200    // 2 hours
+	MinSOAExpire  = 604800  // 1 week
+	MaxSOAExpire  = 2419200 // 4 weeks
+	MinSOAMinTTL  = 300     // 5 minutes
+	MaxSOAMinTTL  = 86400   // 1 day
+
+	// Tolerance for a nameserver behind the newest version of the zone, as a zone transfer
+	// can take a while. The "maxIncrements" and "maxDelayHours" parameters of the
+	// "ns.serial" policy replace these values
+	MaxSerialIncrements = 1 // Versions behind the newest one
+	MaxSerialDelayHours = 6 // Hours behind the newest version
+)
+
+func init() {
+	for _, p := range nsPolicies {
+		policy.Register(p)
+	}
+
+	for _, p := range standalonePolicies {
+		policy.Register(p)
+	}
+}
+
+// nsPolicy is a nameserver policy of this package in the format of the policy registry.
+// The policies of this package use the state of the DomainNSPolicy (like the TCP and
+// delegation responses), so the Run method executes them directly
+type nsPolicy struct {
+	id           string                                                 // Identification in the configuration file
+	dependencies []string                                               // Policies that must be executed before
+	check        func(*DomainNSPolicy, *dns.Msg) model.NameserverStatus // Policy implementation
+}
+
+func (p nsPolicy) ID() string {
+	return p.id
+}
+
+func (p nsPolicy) Dependencies() []string {
+	return p.dependencies
+}
+
+// Execute the policy outside of the Run method. Only the response is available, so the
+// policies that depend on the TCP or delegation responses don't detect problems
+func (p nsPolicy) CheckNameserver(domain *model.Domain, dnsResponseMessage *dns.Msg,
+	parameters policy.Parameters) (model.NameserverStatus, model.Diagnostic) {
+
+	domainNSPolicy := NewDomainNSPolicy(domain)
+	status := p.check(&domainNSPolicy, dnsResponseMessage)
+	return status, domainNSPolicy.Diagnostic()
+}
+
+// DomainNSPolicy store the domain object and the SOA record of the DNS zone. The SOA
+// record is necessary because we need to check the DNS zone version on each nameserver and
+// detect if they are different
 type DomainNSPolicy struct {
-	domain     *model.Domain // Domain object is used for glue validations
-	soaVersion uint32        // Variable used to check if all nameservers have the same zone
+	domain              *model.Domain    // Domain object is used for glue validations
+	soa                 *dns.SOA         // SOA record of the zone found in the nameserver response
+	delegationResponses []*dns.Msg       // NS, A and AAAA responses used to check the delegation
+	tcpChecked          bool             // Flag that indicates if the request was sent over TCP
+	tcpResponseMessage  *dns.Msg         // Response of the same request sent over TCP
+	tcpError            error            // Network error of the request sent over TCP
+	diagnostic          model.Diagnostic // Details of the last problem detected by the policies
 }
 
 // This function initialize a DomainNSPolicy object, it was created to force the
@@ -38,8 +124,7 @@ type DomainNSPolicy struct {
 // inside each method. Maybe there's a better approach (think about)
 func NewDomainNSPolicy(domain *model.Domain) DomainNSPolicy {
 	return DomainNSPolicy{
-		domain:     domain,
-		soaVersion: 0,
+		domain: domain,
 	}
 }
 
@@ -52,6 +137,8 @@ func (d *DomainNSPolicy) CheckNetworkError(err error) model.NameserverStatus {
 		return model.NameserverStatusOK
 	}
 
+	d.diagnose(err.Error(), nil)
+
 	if netError, ok := err.(net.Error); ok && netError.Timeout() {
 		return model.NameserverStatusTimeout
 	}
@@ -76,17 +163,51 @@ func (d *DomainNSPolicy) CheckNetworkError(err error) model.NameserverStatus {
 	return model.NameserverStatusError
 }
 
-// Method responsable for running all nameserver policies. It will return the nameserver
-// status of the first error that occurred
+// Store the responses of the NS, A or AAAA queries sent to the nameserver, they are used
+// to compare the delegation in the zone with the registered nameservers and glue
+func (d *DomainNSPolicy) AddDelegationResponse(dnsResponseMessage *dns.Msg) {
+	if dnsResponseMessage != nil {
+		d.delegationResponses = append(d.delegationResponses, dnsResponseMessage)
+	}
+}
+
+// Store the response of the same request sent over TCP, used to check if the nameserver
+// supports TCP and if it gives the same answer over UDP and TCP
+func (d *DomainNSPolicy) SetTCPResponse(dnsResponseMessage *dns.Msg, err error) {
+	d.tcpChecked = true
+	d.tcpResponseMessage = dnsResponseMessage
+	d.tcpError = err
+}
+
+// Method responsable for running all nameserver policies enabled for the domain, including
+// the policies registered by other packages. It will return the nameserver status of the
+// first error that occurred
 func (d *DomainNSPolicy) Run(dnsResponseMessage *dns.Msg) model.NameserverStatus {
 	// Something went really wrong, because if we got here there was no network error and it
 	// should have a DNS response message, but as a safety check we don't allow to continue
 	if dnsResponseMessage == nil {
+		d.diagnose("No DNS response message to check", nil)
 		return model.NameserverStatusError
 	}
 
-	for _, policy := range nsPolicies {
-		if status := policy(d, dnsResponseMessage); status != model.NameserverStatusOK {
+	for _, execution := range policy.Enabled(d.domain.FQDN) {
+		var status model.NameserverStatus = model.NameserverStatusOK
+
+		switch p := execution.Policy.(type) {
+		case nsPolicy:
+			status = p.check(d, dnsResponseMessage)
+
+		case policy.NSPolicy:
+			var diagnostic model.Diagnostic
+			status, diagnostic = p.CheckNameserver(d.domain, dnsResponseMessage,
+				execution.Parameters)
+
+			if status != model.NameserverStatusOK {
+				d.diagnostic = diagnostic
+			}
+		}
+
+		if status != model.NameserverStatusOK {
 			return status
 		}
 	}
@@ -94,6 +215,144 @@ func (d *DomainNSPolicy) Run(dnsResponseMessage *dns.Msg) model.NameserverStatus
 	return model.NameserverStatusOK
 }
 
+// Details of the last problem detected by the policies, like the error message and the
+// records of the response related to the problem. The address of the nameserver, the
+// question and the response code aren't known by the policies, so they are filled by the
+// caller
+func (d *DomainNSPolicy) Diagnostic() model.Diagnostic {
+	return d.diagnostic
+}
+
+// SOA record found in the last response checked by the nameserver policies. Returns nil
+// if the response didn't have a SOA record
+func (d *DomainNSPolicy) SOA() *dns.SOA {
+	return d.soa
+}
+
+// Sanity checks over the fields of the SOA record, using the timer ranges of the
+// "ns.soafields" policy parameters. Values out of the recommended ranges or invalid names
+// don't break the DNS resolution, so the problems are reported as warnings
+func (d *DomainNSPolicy) CheckSOA(soa *dns.SOA,
+	parameters policy.Parameters) model.NameserverStatus {
+
+	if soa == nil {
+		return model.NameserverStatusOK
+	}
+
+	inRange := func(value uint32, minName string, minDefault int,
+		maxName string, maxDefault int) bool {
+
+		return int64(value) >= int64(parameters.Int(minName, minDefault)) &&
+			int64(value) <= int64(parameters.Int(maxName, maxDefault))
+	}
+
+	if !inRange(soa.Refresh, "minRefresh", MinSOARefresh, "maxRefresh", MaxSOARefresh) ||
+		!inRange(soa.Retry, "minRetry", MinSOARetry, "maxRetry", MaxSOARetry) ||
+		!inRange(soa.Expire, "minExpire", MinSOAExpire, "maxExpire", MaxSOAExpire) ||
+		!inRange(soa.Minttl, "minTTL", MinSOAMinTTL, "maxTTL", MaxSOAMinTTL) ||
+		soa.Retry >= soa.Refresh || soa.Expire <= soa.Refresh {
+
+		d.diagnose("SOA timers out of the recommended ranges", []dns.RR{soa})
+		return model.NameserverStatusSOATimers
+	}
+
+	// The MNAME is the primary nameserver of the zone, so it must be a valid hostname. The
+	// RNAME is the mailbox of the responsible for the zone, where the first label is the
+	// local part of the e-mail, so it needs at least two labels and can't use the "@"
+	// character in place of the first dot
+	if !dnsutils.IsHostname(soa.Ns) {
+		d.diagnose(fmt.Sprintf("Invalid primary nameserver %s in the SOA record", soa.Ns),
+			[]dns.RR{soa})
+		return model.NameserverStatusSOANames
+	}
+
+	if _, ok := dns.IsDomainName(soa.Mbox); !ok ||
+		len(dns.SplitDomainName(soa.Mbox)) < 2 || strings.Contains(soa.Mbox, "@") {
+
+		d.diagnose(fmt.Sprintf("Invalid responsible mailbox %s in the SOA record", soa.Mbox),
+			[]dns.RR{soa})
+		return model.NameserverStatusSOANames
+	}
+
+	return model.NameserverStatusOK
+}
+
+// Check if the round trip time of the nameserver is below the limit of the "maxMilliseconds"
+// parameter of the "ns.rtt" policy. Without the parameter (or with a zero value) there's no
+// limit. A slow nameserver still answers, so the problem is reported as a warning
+func (d *DomainNSPolicy) CheckRTT(rtt time.Duration,
+	parameters policy.Parameters) model.NameserverStatus {
+
+	maxRTT := time.Duration(parameters.Int("maxMilliseconds", 0)) * time.Millisecond
+	if maxRTT > 0 && rtt > maxRTT {
+		d.diagnose(fmt.Sprintf("Round trip time of %s above the limit of %s", rtt, maxRTT), nil)
+		return model.NameserverStatusSlow
+	}
+
+	return model.NameserverStatusOK
+}
+
+// Compare the version of the zone (SOA serial) between the nameservers of the domain. Only
+// the nameservers that answered correctly in the last check are compared. A nameserver
+// behind the newest version is reported as not synchronized only when it's behind by more
+// than the "maxIncrements" parameter of the "ns.serial" policy for longer than the
+// "maxDelayHours" parameter, so that zone transfers in progress and zones that change
+// often aren't reported as problems
+func (d *DomainNSPolicy) CheckSerials(parameters policy.Parameters) {
+	maxIncrements := parameters.Int("maxIncrements", MaxSerialIncrements)
+	maxDelay := time.Duration(parameters.Int("maxDelayHours", MaxSerialDelayHours)) * time.Hour
+
+	var newestSerial uint32
+	found := false
+
+	for _, nameserver := range d.domain.Nameservers {
+		if !serialChecked(nameserver) {
+			continue
+		}
+
+		if !found || dnsutils.CompareSerial(newestSerial, nameserver.SOASerial) < 0 {
+			newestSerial = nameserver.SOASerial
+			found = true
+		}
+	}
+
+	if !found {
+		return
+	}
+
+	now := time.Now()
+
+	for index := range d.domain.Nameservers {
+		nameserver := &d.domain.Nameservers[index]
+		if !serialChecked(*nameserver) {
+			continue
+		}
+
+		if dnsutils.CompareSerial(nameserver.SOASerial, newestSerial) >= 0 {
+			nameserver.SOASerialBehindSince = time.Time{}
+			continue
+		}
+
+		if nameserver.SOASerialBehindSince.IsZero() {
+			nameserver.SOASerialBehindSince = now
+		}
+
+		if int64(newestSerial-nameserver.SOASerial) > int64(maxIncrements) &&
+			now.Sub(nameserver.SOASerialBehindSince) > maxDelay {
+
+			// The nameserver isn't OK since it got behind the other nameservers, so we keep
+			// this date as the last OK date to alert the domain's owner correctly
+			nameserver.ChangeStatus(model.NameserverStatusNotSynchronized)
+			nameserver.LastOKAt = nameserver.SOASerialBehindSince
+			nameserver.Diagnostic = model.Diagnostic{
+				Error: fmt.Sprintf("Zone version (SOA serial) %d behind the newest version %d "+
+					"since %s", nameserver.SOASerial, newestSerial,
+					nameserver.SOASerialBehindSince.UTC().Format(time.RFC3339)),
+			}
+		}
+	}
+}
+
 // CNAME policy is responsable to check if there's no CNAME in the top level of the zone.
 // According to the RFC CNAME resource record cannot exist with another resource record
 // with the same name, as SOA resource record is mandatory in the top of the zone, CNAME
@@ -101,6 +360,7 @@ func (d *DomainNSPolicy) Run(dnsResponseMessage *dns.Msg) model.NameserverStatus
 func (d *DomainNSPolicy) cnamePolicy(dnsResponseMessage *dns.Msg) model.NameserverStatus {
 	for _, rr := range dnsResponseMessage.Answer {
 		if rr.Header().Name == d.domain.FQDN && rr.Header().Rrtype == dns.TypeCNAME {
+			d.diagnose("CNAME record in the zone apex", []dns.RR{rr})
 			return model.NameserverStatusCanonicalName
 		}
 	}
@@ -111,6 +371,11 @@ func (d *DomainNSPolicy) cnamePolicy(dnsResponseMessage *dns.Msg) model.Nameserv
 // DNS response message has the return code that indicates if something went wrong, in
 // this method we check it before proceding with other analyzes
 func (d *DomainNSPolicy) rcodePolicy(dnsResponseMessage *dns.Msg) model.NameserverStatus {
+	if dnsResponseMessage.MsgHdr.Rcode != dns.RcodeSuccess {
+		d.diagnose(fmt.Sprintf("Response with the %s code",
+			dns.RcodeToString[dnsResponseMessage.MsgHdr.Rcode]), nil)
+	}
+
 	switch dnsResponseMessage.MsgHdr.Rcode {
 	case dns.RcodeSuccess:
 		// Everything is OK with the DNS response message. In Go every switch case has a
@@ -135,34 +400,198 @@ func (d *DomainNSPolicy) rcodePolicy(dnsResponseMessage *dns.Msg) model.Nameserv
 // Check if the nameserver owns the domain or not
 func (d *DomainNSPolicy) authorityPolicy(dnsResponseMessage *dns.Msg) model.NameserverStatus {
 	if !dnsResponseMessage.Authoritative {
+		d.diagnose("Response without the authoritative answer (AA) flag",
+			dnsResponseMessage.Ns)
 		return model.NameserverStatusNoAuthority
 	}
 
 	return model.NameserverStatusOK
 }
 
-// Check if the zones between the nameservers have all the same version and also garantee
-// that the zones have the SOA record, that indicates the authority for the zone
+// Garantee that the zone has the SOA record, that indicates the authority for the zone.
+// The SOA record is stored so that we can compare the version of the zone between the
+// nameservers after all of them are checked
 func (d *DomainNSPolicy) soaPolicy(dnsResponseMessage *dns.Msg) model.NameserverStatus {
-	var soaRR *dns.SOA
+	rr := dnsutils.FilterFirstRR(dnsResponseMessage.Answer, dns.TypeSOA)
+	if rr == nil {
+		d.diagnose("No SOA record of the zone in the answer", dnsResponseMessage.Answer)
+		return model.NameserverStatusUnknownDomainName
+	}
 
-	// Check if the SOA resource record exists in the response
-	if rr := dnsutils.FilterFirstRR(dnsResponseMessage.Answer, dns.TypeSOA); rr != nil {
-		soaRR, _ = rr.(*dns.SOA)
+	d.soa, _ = rr.(*dns.SOA)
+	return model.NameserverStatusOK
+}
 
-	} else {
-		return model.NameserverStatusUnknownDomainName
+// According to RFC 7766 all authoritative nameservers must answer over TCP, and big DNSSEC
+// responses depend on it. The answer over TCP must also be the same of the answer over UDP
+func (d *DomainNSPolicy) tcpPolicy(dnsResponseMessage *dns.Msg) model.NameserverStatus {
+	// The TCP request is optional, so we only check it when it was sent
+	if !d.tcpChecked {
+		return model.NameserverStatusOK
 	}
 
-	// Find the SOA record to check the version of the zone. If it's the first nameserver
-	// that we are checking (version 0) we don't need to compare
+	if d.tcpError != nil {
+		d.diagnose("Request over TCP failed: "+d.tcpError.Error(), nil)
+		return model.NameserverStatusTCPUnreachable
 
-	if d.soaVersion == 0 {
-		d.soaVersion = soaRR.Serial
+	} else if d.tcpResponseMessage == nil {
+		d.diagnose("No response over TCP", nil)
+		return model.NameserverStatusTCPUnreachable
+	}
 
-	} else if d.soaVersion != soaRR.Serial {
-		return model.NameserverStatusNotSynchronized
+	if d.tcpResponseMessage.Rcode != dnsResponseMessage.Rcode ||
+		d.tcpResponseMessage.Authoritative != dnsResponseMessage.Authoritative ||
+		!dnsutils.SameRRs(d.tcpResponseMessage.Answer, dnsResponseMessage.Answer) {
+
+		d.diagnose("Different answers over UDP and TCP, records of the TCP answer",
+			d.tcpResponseMessage.Answer)
+		return model.NameserverStatusTCPMismatch
 	}
 
 	return model.NameserverStatusOK
 }
+
+// Compare the NS records of the zone with the registered nameservers. A response without
+// NS records in the answer section isn't conclusive (could be a referral), so we only
+// compare when the nameserver returns the NS set. Missing nameservers are worse than
+// extra ones, because they can point to lame delegations, so they are checked first
+func (d *DomainNSPolicy) nsSetPolicy(dnsResponseMessage *dns.Msg) model.NameserverStatus {
+	for _, response := range d.delegationResponses {
+		if len(response.Question) == 0 || response.Question[0].Qtype != dns.TypeNS ||
+			response.Rcode != dns.RcodeSuccess {
+			continue
+		}
+
+		nsRecords := dnsutils.FilterRRs(response.Answer, dns.TypeNS)
+		zoneHosts := make(map[string]bool)
+		for _, rr := range nsRecords {
+			if nsRecord, ok := rr.(*dns.NS); ok && sameName(nsRecord.Hdr.Name, d.domain.FQDN) {
+				zoneHosts[normalizeName(nsRecord.Ns)] = true
+			}
+		}
+
+		if len(zoneHosts) == 0 {
+			continue
+		}
+
+		registeredHosts := make(map[string]bool)
+		for _, nameserver := range d.domain.Nameservers {
+			registeredHosts[normalizeName(nameserver.Host)] = true
+		}
+
+		for host := range registeredHosts {
+			if !zoneHosts[host] {
+				d.diagnose(fmt.Sprintf("Nameserver %s missing in the NS records of the zone", host),
+					nsRecords)
+				d.diagnostic.Query = dnsutils.QuestionToString(response)
+				return model.NameserverStatusNSMissing
+			}
+		}
+
+		for host := range zoneHosts {
+			if !registeredHosts[host] {
+				d.diagnose(fmt.Sprintf("Nameserver %s of the zone isn't registered", host),
+					nsRecords)
+				d.diagnostic.Query = dnsutils.QuestionToString(response)
+				return model.NameserverStatusNSExtra
+			}
+		}
+	}
+
+	return model.NameserverStatusOK
+}
+
+// Compare the addresses of the in-bailiwick nameservers in the zone with the registered
+// glue. As in the NS set policy, we only compare when the nameserver returns addresses,
+// because the host could be inside a delegated zone below the domain
+func (d *DomainNSPolicy) gluePolicy(dnsResponseMessage *dns.Msg) model.NameserverStatus {
+	for _, response := range d.delegationResponses {
+		if len(response.Question) == 0 || response.Rcode != dns.RcodeSuccess {
+			continue
+		}
+
+		question := response.Question[0]
+
+		var glue net.IP
+		for _, nameserver := range d.domain.Nameservers {
+			if !sameName(nameserver.Host, question.Name) {
+				continue
+			}
+
+			if question.Qtype == dns.TypeA {
+				glue = nameserver.IPv4
+			} else if question.Qtype == dns.TypeAAAA {
+				glue = nameserver.IPv6
+			}
+			break
+		}
+
+		if glue == nil {
+			continue
+		}
+
+		var addresses []net.IP
+		var addressRecords []dns.RR
+		for _, rr := range dnsutils.FilterRRs(response.Answer, question.Qtype) {
+			if !sameName(rr.Header().Name, question.Name) {
+				continue
+			}
+
+			addressRecords = append(addressRecords, rr)
+
+			switch record := rr.(type) {
+			case *dns.A:
+				addresses = append(addresses, record.A)
+			case *dns.AAAA:
+				addresses = append(addresses, record.AAAA)
+			}
+		}
+
+		if len(addresses) == 0 {
+			continue
+		}
+
+		found := false
+		for _, address := range addresses {
+			if address.Equal(glue) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			d.diagnose(fmt.Sprintf("Addresses of %s in the zone are different from the "+
+				"registered glue %s", question.Name, glue), addressRecords)
+			d.diagnostic.Query = dnsutils.QuestionToString(response)
+			return model.NameserverStatusGlueMismatch
+		}
+	}
+
+	return model.NameserverStatusOK
+}
+
+// Store the details of the problem detected by a policy, replacing any previous problem
+func (d *DomainNSPolicy) diagnose(text string, rrs []dns.RR) {
+	d.diagnostic = model.Diagnostic{
+		Error:   text,
+		Records: dnsutils.RRsToString(rrs),
+	}
+}
+
+// Check if the SOA serial of the nameserver was retrieved in the last check, that only
+// happens when the nameserver answered correctly
+func serialChecked(nameserver model.Nameserver) bool {
+	return nameserver.LastStatus == model.NameserverStatusOK ||
+		model.IsNameserverStatusWarning(nameserver.LastStatus)
+}
+
+// Convert a name to the lower case FQDN format, so that we can compare names from the DNS
+// responses with the registered ones
+func normalizeName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+// Check if two names are the same, ignoring the case and the final dot
+func sameName(name1, name2 string) bool {
+	return normalizeName(name1) == normalizeName(name2)
+}
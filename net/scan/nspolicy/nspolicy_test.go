@@ -8,8 +8,11 @@ package nspolicy
 import (
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
 	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
 	"net"
+	"strings"
 	"testing"
+	"time"
 )
 
 // myErr was created only to test possible network errors
@@ -30,6 +33,27 @@ func (e myErr) Temporary() bool {
 	return true
 }
 
+// localPolicy was created only to test the policies registered by other packages
+type localPolicy struct{}
+
+func (p localPolicy) ID() string {
+	return "ns.local"
+}
+
+func (p localPolicy) Dependencies() []string {
+	return []string{"ns.authority"}
+}
+
+func (p localPolicy) CheckNameserver(domain *model.Domain, dnsResponseMessage *dns.Msg,
+	parameters policy.Parameters) (model.NameserverStatus, model.Diagnostic) {
+
+	if domain.FQDN != parameters["fqdn"] {
+		return model.NameserverStatusOK, model.Diagnostic{}
+	}
+
+	return model.NameserverStatusError, model.Diagnostic{Error: "Local policy"}
+}
+
 func TestNSNetworkError(t *testing.T) {
 	domain := &model.Domain{}
 	domainNSPolicy := NewDomainNSPolicy(domain)
@@ -43,7 +67,8 @@ func TestNSNetworkError(t *testing.T) {
 	}
 
 	opErr := &net.OpError{
-		Op: "dial",
+		Op:  "dial",
+		Err: myErr{err: "no such host"},
 	}
 
 	if domainNSPolicy.CheckNetworkError(opErr) != model.NameserverStatusUnknownHost {
@@ -51,7 +76,8 @@ func TestNSNetworkError(t *testing.T) {
 	}
 
 	opErr = &net.OpError{
-		Op: "read",
+		Op:  "read",
+		Err: myErr{err: "connection refused"},
 	}
 
 	if domainNSPolicy.CheckNetworkError(opErr) != model.NameserverStatusConnectionRefused {
@@ -67,6 +93,10 @@ func TestNSNetworkError(t *testing.T) {
 		t.Error("Not detecting a network generic error")
 	}
 
+	if domainNSPolicy.Diagnostic().Error != "generic" {
+		t.Error("Not storing the network error in the diagnostic")
+	}
+
 	if domainNSPolicy.CheckNetworkError(nil) != model.NameserverStatusOK {
 		t.Error("Reporting error when everything was OK")
 	}
@@ -151,6 +181,13 @@ func TestCNAMEPolicy(t *testing.T) {
 		t.Error("Not verfying CNAME in apex rule")
 	}
 
+	if diagnostic := domainNSPolicy.Diagnostic(); len(diagnostic.Error) == 0 ||
+		len(diagnostic.Records) != 1 ||
+		diagnostic.Records[0] != dnsResponseMessage.Answer[1].String() {
+
+		t.Error("Not storing the CNAME record in the diagnostic")
+	}
+
 	dnsResponseMessage = &dns.Msg{
 		Answer: []dns.RR{
 			&dns.SOA{
@@ -267,6 +304,10 @@ func TestSOAPolicy(t *testing.T) {
 		t.Error("Not detecting when there's no SOA record")
 	}
 
+	if domainNSPolicy.SOA() != nil {
+		t.Error("Storing a SOA record that doesn't exist")
+	}
+
 	dnsResponseMessage = &dns.Msg{
 		Answer: []dns.RR{
 			&dns.SOA{
@@ -280,28 +321,450 @@ func TestSOAPolicy(t *testing.T) {
 
 	if domainNSPolicy.soaPolicy(dnsResponseMessage) !=
 		model.NameserverStatusOK {
-		t.Error("Returning version problems only with one " +
-			"nameserver check")
+		t.Error("Returning problems when the SOA record exists")
 	}
 
-	if domainNSPolicy.soaPolicy(dnsResponseMessage) !=
+	if domainNSPolicy.SOA() == nil || domainNSPolicy.SOA().Serial != 1 {
+		t.Error("Not storing the SOA record of the response")
+	}
+}
+
+func TestCheckSOA(t *testing.T) {
+	domainNSPolicy := NewDomainNSPolicy(&model.Domain{})
+
+	newSOA := func() *dns.SOA {
+		return &dns.SOA{
+			Hdr: dns.RR_Header{
+				Name:   "test.com.br.",
+				Rrtype: dns.TypeSOA,
+			},
+			Ns:      "ns1.test.com.br.",
+			Mbox:    "hostmaster.test.com.br.",
+			Serial:  2013112600,
+			Refresh: 86400,
+			Retry:   7200,
+			Expire:  1209600,
+			Minttl:  3600,
+		}
+	}
+
+	if domainNSPolicy.CheckSOA(nil, nil) != model.NameserverStatusOK {
+		t.Error("Returning problems without a SOA record")
+	}
+
+	if domainNSPolicy.CheckSOA(newSOA(), nil) != model.NameserverStatusOK {
+		t.Error("Returning problems for a valid SOA record")
+	}
+
+	soa := newSOA()
+	soa.Refresh = 60
+	if domainNSPolicy.CheckSOA(soa, nil) != model.NameserverStatusSOATimers {
+		t.Error("Not detecting a small SOA refresh")
+	}
+
+	soa = newSOA()
+	soa.Retry = soa.Refresh
+	if domainNSPolicy.CheckSOA(soa, nil) != model.NameserverStatusSOATimers {
+		t.Error("Not detecting a SOA retry that isn't smaller than the refresh")
+	}
+
+	soa = newSOA()
+	soa.Expire = 3600
+	if domainNSPolicy.CheckSOA(soa, nil) != model.NameserverStatusSOATimers {
+		t.Error("Not detecting a small SOA expire")
+	}
+
+	soa = newSOA()
+	soa.Minttl = 604800
+	if domainNSPolicy.CheckSOA(soa, nil) != model.NameserverStatusSOATimers {
+		t.Error("Not detecting a big SOA minimum TTL")
+	}
+
+	soa = newSOA()
+	soa.Ns = "ns_1.test.com.br."
+	if domainNSPolicy.CheckSOA(soa, nil) != model.NameserverStatusSOANames {
+		t.Error("Not detecting an invalid SOA MNAME")
+	}
+
+	soa = newSOA()
+	soa.Mbox = "hostmaster@test.com.br."
+	if domainNSPolicy.CheckSOA(soa, nil) != model.NameserverStatusSOANames {
+		t.Error("Not detecting an e-mail address in the SOA RNAME")
+	}
+
+	soa = newSOA()
+	soa.Mbox = "br."
+	if domainNSPolicy.CheckSOA(soa, nil) != model.NameserverStatusSOANames {
+		t.Error("Not detecting a SOA RNAME without the local part")
+	}
+
+	soa = newSOA()
+	soa.Refresh = 600
+	soa.Retry = 300
+	if domainNSPolicy.CheckSOA(soa, policy.Parameters{"minRefresh": "300"}) !=
 		model.NameserverStatusOK {
-		t.Error("Returning version problems when the version is OK")
+
+		t.Error("Not using the SOA timers ranges of the policy parameters")
 	}
+}
 
-	dnsResponseMessage = &dns.Msg{
+func TestCheckRTT(t *testing.T) {
+	domainNSPolicy := NewDomainNSPolicy(&model.Domain{})
+
+	if domainNSPolicy.CheckRTT(10*time.Second, nil) != model.NameserverStatusOK {
+		t.Error("Checking the round trip time without a limit")
+	}
+
+	parameters := policy.Parameters{"maxMilliseconds": "500"}
+	if domainNSPolicy.CheckRTT(100*time.Millisecond, parameters) != model.NameserverStatusOK {
+		t.Error("Reporting a fast nameserver as slow")
+	}
+
+	if domainNSPolicy.CheckRTT(time.Second, parameters) != model.NameserverStatusSlow {
+		t.Error("Not detecting a slow nameserver")
+	}
+
+	if domainNSPolicy.Diagnostic().Error != "Round trip time of 1s above the limit of 500ms" {
+		t.Errorf("Not describing the slow nameserver correctly: %s",
+			domainNSPolicy.Diagnostic().Error)
+	}
+}
+
+func TestCheckSerials(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "test.com.br.",
+		Nameservers: []model.Nameserver{
+			{
+				Host:       "ns1.test.com.br.",
+				SOASerial:  4294967295,
+				LastStatus: model.NameserverStatusOK,
+			},
+			{
+				Host:       "ns2.test.com.br.",
+				SOASerial:  1,
+				LastStatus: model.NameserverStatusOpenRecursion,
+			},
+			{
+				Host:       "ns3.test.com.br.",
+				SOASerial:  10,
+				LastStatus: model.NameserverStatusTimeout,
+			},
+		},
+	}
+
+	domainNSPolicy := NewDomainNSPolicy(domain)
+	domainNSPolicy.CheckSerials(nil)
+
+	if domain.Nameservers[0].LastStatus != model.NameserverStatusOK ||
+		domain.Nameservers[0].SOASerialBehindSince.IsZero() {
+		t.Error("Not tolerating a nameserver that is behind for a short time")
+	}
+
+	domain.Nameservers[0].SOASerialBehindSince = time.Now().Add(-7 * time.Hour)
+	domainNSPolicy.CheckSerials(nil)
+
+	if domain.Nameservers[0].LastStatus != model.NameserverStatusNotSynchronized {
+		t.Error("Not using serial number arithmetic to find the newest version of the zone")
+	}
+
+	if !strings.HasPrefix(domain.Nameservers[0].Diagnostic.Error,
+		"Zone version (SOA serial) 4294967295 behind the newest version 1 ") {
+
+		t.Errorf("Not describing the zone version problem correctly: %s",
+			domain.Nameservers[0].Diagnostic.Error)
+	}
+
+	if !domain.Nameservers[0].LastOKAt.Equal(domain.Nameservers[0].SOASerialBehindSince) {
+		t.Error("Not storing since when the nameserver is behind the newest version")
+	}
+
+	if domain.Nameservers[1].LastStatus != model.NameserverStatusOpenRecursion ||
+		domain.Nameservers[2].LastStatus != model.NameserverStatusTimeout {
+		t.Error("Changing the status of nameservers that aren't behind")
+	}
+
+	parameters := policy.Parameters{
+		"maxIncrements": "5",
+		"maxDelayHours": "1",
+	}
+
+	domain.Nameservers[0].LastStatus = model.NameserverStatusOK
+	domainNSPolicy.CheckSerials(parameters)
+
+	if domain.Nameservers[0].LastStatus != model.NameserverStatusOK {
+		t.Error("Not tolerating a nameserver that is behind by a few versions")
+	}
+
+	domain.Nameservers[0].SOASerial = 4294967290
+	domain.Nameservers[0].SOASerialBehindSince = time.Time{}
+	domainNSPolicy.CheckSerials(parameters)
+
+	if domain.Nameservers[0].LastStatus != model.NameserverStatusOK ||
+		domain.Nameservers[0].SOASerialBehindSince.IsZero() {
+		t.Error("Not tolerating a nameserver that is behind for a short time")
+	}
+
+	domain.Nameservers[0].SOASerialBehindSince = time.Now().Add(-2 * time.Hour)
+	domainNSPolicy.CheckSerials(parameters)
+
+	if domain.Nameservers[0].LastStatus != model.NameserverStatusNotSynchronized {
+		t.Error("Not detecting a nameserver that is behind by many versions for a long time")
+	}
+
+	domain.Nameservers[0].LastStatus = model.NameserverStatusOK
+	domain.Nameservers[0].SOASerial = 1
+	domainNSPolicy.CheckSerials(parameters)
+
+	if domain.Nameservers[0].LastStatus != model.NameserverStatusOK ||
+		!domain.Nameservers[0].SOASerialBehindSince.IsZero() {
+		t.Error("Not resetting the delay when the nameserver is synchronized")
+	}
+}
+
+func TestTCPPolicy(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "test.com.br",
+	}
+
+	newSOAResponse := func(serial uint32) *dns.Msg {
+		return &dns.Msg{
+			MsgHdr: dns.MsgHdr{
+				Authoritative: true,
+				Rcode:         dns.RcodeSuccess,
+			},
+			Answer: []dns.RR{
+				&dns.SOA{
+					Hdr: dns.RR_Header{
+						Name:   "test.com.br.",
+						Rrtype: dns.TypeSOA,
+					},
+					Serial: serial,
+				},
+			},
+		}
+	}
+
+	domainNSPolicy := NewDomainNSPolicy(domain)
+
+	if domainNSPolicy.tcpPolicy(newSOAResponse(1)) != model.NameserverStatusOK {
+		t.Error("Checking TCP support when the request wasn't sent over TCP")
+	}
+
+	domainNSPolicy.SetTCPResponse(newSOAResponse(1), nil)
+
+	if domainNSPolicy.tcpPolicy(newSOAResponse(1)) != model.NameserverStatusOK {
+		t.Error("Not accepting the same answer over UDP and TCP")
+	}
+
+	domainNSPolicy.SetTCPResponse(newSOAResponse(2), nil)
+
+	if domainNSPolicy.tcpPolicy(newSOAResponse(1)) != model.NameserverStatusTCPMismatch {
+		t.Error("Not detecting different answers over UDP and TCP")
+	}
+
+	tcpResponseMessage := newSOAResponse(1)
+	tcpResponseMessage.Authoritative = false
+	domainNSPolicy.SetTCPResponse(tcpResponseMessage, nil)
+
+	if domainNSPolicy.tcpPolicy(newSOAResponse(1)) != model.NameserverStatusTCPMismatch {
+		t.Error("Not detecting different authority over UDP and TCP")
+	}
+
+	domainNSPolicy.SetTCPResponse(nil, &net.OpError{Op: "dial", Err: myErr{err: "refused"}})
+
+	if domainNSPolicy.tcpPolicy(newSOAResponse(1)) != model.NameserverStatusTCPUnreachable {
+		t.Error("Not detecting a nameserver that doesn't answer over TCP")
+	}
+}
+
+func TestNSSetPolicy(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "test.com.br",
+		Nameservers: []model.Nameserver{
+			{Host: "ns1.test.com.br"},
+			{Host: "NS2.example.net."},
+		},
+	}
+
+	newNSResponse := func(hosts ...string) *dns.Msg {
+		dnsResponseMessage := new(dns.Msg)
+		dnsResponseMessage.SetQuestion("test.com.br.", dns.TypeNS)
+
+		for _, host := range hosts {
+			dnsResponseMessage.Answer = append(dnsResponseMessage.Answer, &dns.NS{
+				Hdr: dns.RR_Header{
+					Name:   "test.com.br.",
+					Rrtype: dns.TypeNS,
+				},
+				Ns: host,
+			})
+		}
+
+		return dnsResponseMessage
+	}
+
+	domainNSPolicy := NewDomainNSPolicy(domain)
+	domainNSPolicy.AddDelegationResponse(newNSResponse("ns1.test.com.br.", "ns2.example.net."))
+
+	if domainNSPolicy.nsSetPolicy(nil) != model.NameserverStatusOK {
+		t.Error("Not accepting a NS set equal to the registered nameservers")
+	}
+
+	domainNSPolicy = NewDomainNSPolicy(domain)
+	domainNSPolicy.AddDelegationResponse(newNSResponse("ns1.test.com.br."))
+
+	if domainNSPolicy.nsSetPolicy(nil) != model.NameserverStatusNSMissing {
+		t.Error("Not detecting a NS set without a registered nameserver")
+	}
+
+	domainNSPolicy = NewDomainNSPolicy(domain)
+	domainNSPolicy.AddDelegationResponse(newNSResponse("ns1.test.com.br.",
+		"ns2.example.net.", "ns3.example.net."))
+
+	if domainNSPolicy.nsSetPolicy(nil) != model.NameserverStatusNSExtra {
+		t.Error("Not detecting a NS set with nameservers that aren't registered")
+	}
+
+	domainNSPolicy = NewDomainNSPolicy(domain)
+	domainNSPolicy.AddDelegationResponse(newNSResponse())
+
+	if domainNSPolicy.nsSetPolicy(nil) != model.NameserverStatusOK {
+		t.Error("Checking the NS set when the nameserver didn't return NS records")
+	}
+}
+
+func TestGluePolicy(t *testing.T) {
+	domain := &model.Domain{
+		FQDN: "test.com.br",
+		Nameservers: []model.Nameserver{
+			{
+				Host: "ns1.test.com.br",
+				IPv4: net.ParseIP("192.0.2.1"),
+				IPv6: net.ParseIP("2001:db8::1"),
+			},
+			{Host: "ns2.example.net"},
+		},
+	}
+
+	newAResponse := func(address string) *dns.Msg {
+		dnsResponseMessage := new(dns.Msg)
+		dnsResponseMessage.SetQuestion("ns1.test.com.br.", dns.TypeA)
+		dnsResponseMessage.Answer = []dns.RR{
+			&dns.A{
+				Hdr: dns.RR_Header{
+					Name:   "ns1.test.com.br.",
+					Rrtype: dns.TypeA,
+				},
+				A: net.ParseIP(address),
+			},
+		}
+		return dnsResponseMessage
+	}
+
+	newAAAAResponse := func(address string) *dns.Msg {
+		dnsResponseMessage := new(dns.Msg)
+		dnsResponseMessage.SetQuestion("ns1.test.com.br.", dns.TypeAAAA)
+		dnsResponseMessage.Answer = []dns.RR{
+			&dns.AAAA{
+				Hdr: dns.RR_Header{
+					Name:   "ns1.test.com.br.",
+					Rrtype: dns.TypeAAAA,
+				},
+				AAAA: net.ParseIP(address),
+			},
+		}
+		return dnsResponseMessage
+	}
+
+	domainNSPolicy := NewDomainNSPolicy(domain)
+	domainNSPolicy.AddDelegationResponse(newAResponse("192.0.2.1"))
+	domainNSPolicy.AddDelegationResponse(newAAAAResponse("2001:db8::1"))
+
+	if domainNSPolicy.gluePolicy(nil) != model.NameserverStatusOK {
+		t.Error("Not accepting addresses equal to the registered glue")
+	}
+
+	domainNSPolicy = NewDomainNSPolicy(domain)
+	domainNSPolicy.AddDelegationResponse(newAResponse("192.0.2.2"))
+
+	if domainNSPolicy.gluePolicy(nil) != model.NameserverStatusGlueMismatch {
+		t.Error("Not detecting an IPv4 address different from the registered glue")
+	}
+
+	domainNSPolicy = NewDomainNSPolicy(domain)
+	domainNSPolicy.AddDelegationResponse(newAAAAResponse("2001:db8::2"))
+
+	if domainNSPolicy.gluePolicy(nil) != model.NameserverStatusGlueMismatch {
+		t.Error("Not detecting an IPv6 address different from the registered glue")
+	}
+
+	dnsResponseMessage := new(dns.Msg)
+	dnsResponseMessage.SetQuestion("ns1.test.com.br.", dns.TypeA)
+
+	domainNSPolicy = NewDomainNSPolicy(domain)
+	domainNSPolicy.AddDelegationResponse(dnsResponseMessage)
+
+	if domainNSPolicy.gluePolicy(nil) != model.NameserverStatusOK {
+		t.Error("Checking the glue when the nameserver didn't return addresses")
+	}
+}
+
+func TestRunRegisteredPolicies(t *testing.T) {
+	policy.Register(localPolicy{})
+	defer policy.Configure(nil)
+
+	dnsResponseMessage := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Authoritative: false,
+			Rcode:         dns.RcodeSuccess,
+		},
 		Answer: []dns.RR{
 			&dns.SOA{
 				Hdr: dns.RR_Header{
+					Name:   "test.gov.br.",
 					Rrtype: dns.TypeSOA,
 				},
-				Serial: 2,
 			},
 		},
 	}
 
-	if domainNSPolicy.soaPolicy(dnsResponseMessage) !=
-		model.NameserverStatusNotSynchronized {
-		t.Error("Not detecting different versions of the same zone file")
+	disabled := false
+	err := policy.Configure(map[string]policy.Config{
+		"ns.authority": {
+			Zones: map[string]policy.Config{
+				"gov.br.": {Enabled: &disabled},
+			},
+		},
+		"ns.local": {
+			Parameters: policy.Parameters{"fqdn": "test.gov.br."},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domainNSPolicy := NewDomainNSPolicy(&model.Domain{FQDN: "test.gov.br."})
+	if domainNSPolicy.Run(dnsResponseMessage) != model.NameserverStatusOK {
+		t.Error("Not disabling the policies in the zone")
+	}
+
+	err = policy.Configure(map[string]policy.Config{
+		"ns.local": {
+			Parameters: policy.Parameters{"fqdn": "test.gov.br."},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dnsResponseMessage.Authoritative = true
+	if domainNSPolicy.Run(dnsResponseMessage) != model.NameserverStatusError {
+		t.Error("Not running the registered policies")
+	}
+
+	if domainNSPolicy.Diagnostic().Error != "Local policy" {
+		t.Error("Not storing the diagnostic of the registered policies")
 	}
 }
@@ -0,0 +1,399 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package policy is the registry of the configuration check policies executed by the scan
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// Error returned when the configuration refers to a policy that wasn't registered
+	ErrUnknownPolicy = errors.New("Unknown policy")
+
+	// Error returned when a policy depends on a policy that wasn't registered, or when the
+	// dependencies of the policies have a cycle, so that there's no order to execute them
+	ErrInvalidDependency = errors.New("Policy with an invalid dependency")
+
+	// Registry used by the policy packages of the system. Other packages can add local
+	// policies with the Register function, usually in the init function of the package
+	defaultRegistry = newRegistry()
+)
+
+// Policy is a configuration check executed by the scan. The identifier must be unique and
+// stable, because it's used in the configuration file to enable, disable or change the
+// parameters of the policy (e.g. "ns.tcp"). The dependencies are the identifiers of the
+// policies that must be executed before this one, a policy is only executed when all
+// policies that it depends on are enabled too
+type Policy interface {
+	ID() string
+	Dependencies() []string
+}
+
+// NSPolicy is a policy executed over the SOA response of each nameserver address. The
+// policy returns the nameserver status and, when there's a problem, a diagnostic with the
+// description and the related records. The address, the question and the response code
+// of the diagnostic are filled by the scan
+type NSPolicy interface {
+	Policy
+	CheckNameserver(domain *model.Domain, dnsResponseMessage *dns.Msg,
+		parameters Parameters) (model.NameserverStatus, model.Diagnostic)
+}
+
+// DSPolicy is a policy executed over the DNSKEY response of each nameserver. As the
+// response can affect each DS record differently, the policy changes the status of the DS
+// records directly in the domain object, and returns false when the next policies
+// shouldn't be executed
+type DSPolicy interface {
+	Policy
+	CheckDS(domain *model.Domain, dnsResponseMessage *dns.Msg, parameters Parameters) bool
+}
+
+// Standalone is a policy that the scan executes directly, outside of the nameserver and DS
+// policy runs, usually because it sends its own queries (e.g. EDNS probes) or because it
+// needs the results of all nameservers (e.g. zone version comparison). The registry only
+// decides if the policy is enabled for the domain and with which parameters. An opt-in
+// policy is disabled unless the configuration enables it, used for probes that the
+// nameservers' operators could see as an attack
+type Standalone struct {
+	Identifier string   // Identification in the configuration file
+	Requires   []string // Policies that must be enabled too
+	OptIn      bool     // Disabled unless the configuration enables it
+}
+
+func (s Standalone) ID() string {
+	return s.Identifier
+}
+
+func (s Standalone) Dependencies() []string {
+	return s.Requires
+}
+
+func (s Standalone) DisabledByDefault() bool {
+	return s.OptIn
+}
+
+// OptInPolicy is a policy that can be disabled when the configuration doesn't say anything
+// about it. The policies that don't implement this interface are enabled by default
+type OptInPolicy interface {
+	Policy
+	DisabledByDefault() bool
+}
+
+// Parameters store the values of the policy parameters defined in the configuration file,
+// indexed by the parameter name
+type Parameters map[string]string
+
+// Retrieve a number parameter. The default value is returned when the parameter wasn't
+// defined or isn't a valid number
+func (p Parameters) Int(name string, defaultValue int) int {
+	value, err := strconv.Atoi(strings.TrimSpace(p[name]))
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+// Retrieve a text parameter. The default value is returned when the parameter wasn't
+// defined
+func (p Parameters) String(name, defaultValue string) string {
+	value := strings.TrimSpace(p[name])
+	if len(value) == 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// Config store the configuration of a policy. When Enabled is nil the policy keeps the
+// state of the parent configuration, that is enabled by default, except for the opt-in
+// policies. The zones allow a different configuration for the domains below a zone (e.g.
+// "gov.br."). The configurations of all zones that contain the domain are applied, from
+// the less to the most specific zone, and the zones inside a zone configuration are
+// ignored
+type Config struct {
+	Enabled    *bool
+	Parameters Parameters
+	Zones      map[string]Config
+}
+
+// Execution is a policy enabled for a domain with the parameters of the domain's zone
+type Execution struct {
+	Policy     Policy
+	Parameters Parameters
+}
+
+// Register a policy in the registry used by the scan. As the policies are registered when
+// the program starts, an empty or duplicated identifier is a programming error and
+// causes a panic
+func Register(policy Policy) {
+	if err := defaultRegistry.register(policy); err != nil {
+		panic(err)
+	}
+}
+
+// Replace the configuration of the registered policies. An error is returned when the
+// configuration refers to an unknown policy or when the dependencies of the policies are
+// invalid, in this case the previous configuration is kept
+func Configure(configs map[string]Config) error {
+	return defaultRegistry.configure(configs)
+}
+
+// List the policies that are enabled for the domain, in the order that they must be
+// executed, with the parameters of the domain's zone
+func Enabled(fqdn string) []Execution {
+	return defaultRegistry.enabled(fqdn)
+}
+
+// Look for a policy in the list of policies enabled for a domain, returning the execution
+// with the parameters of the policy. Returns false when the policy isn't enabled
+func Find(executions []Execution, id string) (Execution, bool) {
+	for _, execution := range executions {
+		if execution.Policy.ID() == id {
+			return execution, true
+		}
+	}
+
+	return Execution{}, false
+}
+
+// registry store the policies in the order that they were registered and the
+// configuration of each one of them. The registry is accessed by all queriers of the
+// scan, so it's protected by a lock
+type registry struct {
+	sync.RWMutex
+	policies []Policy          // Policies in the registration order
+	ordered  []Policy          // Policies in the execution order, nil when it must be rebuilt
+	configs  map[string]Config // Configuration of the policies indexed by identifier
+}
+
+// Initialize an empty registry
+func newRegistry() *registry {
+	return &registry{
+		configs: make(map[string]Config),
+	}
+}
+
+func (r *registry) register(policy Policy) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(policy.ID()) == 0 {
+		return fmt.Errorf("Policy without identifier: %T", policy)
+	}
+
+	for _, registered := range r.policies {
+		if registered.ID() == policy.ID() {
+			return fmt.Errorf("Policy %s registered twice", policy.ID())
+		}
+	}
+
+	r.policies = append(r.policies, policy)
+	r.ordered = nil
+	return nil
+}
+
+func (r *registry) configure(configs map[string]Config) error {
+	r.Lock()
+	defer r.Unlock()
+
+	ids := make(map[string]bool)
+	for _, policy := range r.policies {
+		ids[policy.ID()] = true
+	}
+
+	for id := range configs {
+		if !ids[id] {
+			return fmt.Errorf("%s: %s", ErrUnknownPolicy, id)
+		}
+	}
+
+	ordered, err := sortPolicies(r.policies)
+	if err != nil {
+		return err
+	}
+
+	r.configs = make(map[string]Config)
+	for id, config := range configs {
+		r.configs[id] = normalizeZones(config)
+	}
+
+	r.ordered = ordered
+	return nil
+}
+
+func (r *registry) enabled(fqdn string) []Execution {
+	r.RLock()
+	ordered := r.ordered
+	r.RUnlock()
+
+	if ordered == nil {
+		r.Lock()
+		// The policies with invalid dependencies are left out of the execution order, the
+		// problem is reported when the configuration is loaded
+		r.ordered, _ = sortPolicies(r.policies)
+		ordered = r.ordered
+		r.Unlock()
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+
+	fqdn = strings.ToLower(dns.Fqdn(fqdn))
+	enabledIDs := make(map[string]bool)
+
+	var executions []Execution
+	for _, policy := range ordered {
+		enabled, parameters := resolveConfig(r.configs[policy.ID()], fqdn,
+			enabledByDefault(policy))
+		if !enabled {
+			continue
+		}
+
+		for _, dependency := range policy.Dependencies() {
+			if !enabledIDs[dependency] {
+				enabled = false
+				break
+			}
+		}
+
+		if !enabled {
+			continue
+		}
+
+		enabledIDs[policy.ID()] = true
+		executions = append(executions, Execution{
+			Policy:     policy,
+			Parameters: parameters,
+		})
+	}
+
+	return executions
+}
+
+// Sort the policies in the execution order, where each policy is executed after the
+// policies that it depends on. The registration order is kept when there's no dependency
+// between the policies. The policies with unknown dependencies or with a cycle in the
+// dependencies are left out of the list and an error is returned
+func sortPolicies(policies []Policy) ([]Policy, error) {
+	ids := make(map[string]bool)
+	for _, policy := range policies {
+		ids[policy.ID()] = true
+	}
+
+	var ordered []Policy
+	var err error
+	added := make(map[string]bool)
+
+	for len(ordered) < len(policies) {
+		progress := false
+
+		for _, policy := range policies {
+			if added[policy.ID()] {
+				continue
+			}
+
+			ready := true
+			for _, dependency := range policy.Dependencies() {
+				if !ids[dependency] {
+					err = fmt.Errorf("%s: %s depends on %s", ErrInvalidDependency,
+						policy.ID(), dependency)
+				}
+
+				if !added[dependency] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				ordered = append(ordered, policy)
+				added[policy.ID()] = true
+				progress = true
+				break
+			}
+		}
+
+		if !progress {
+			if err == nil {
+				err = fmt.Errorf("%s: cycle in the dependencies", ErrInvalidDependency)
+			}
+			break
+		}
+	}
+
+	return ordered, err
+}
+
+// Store the zones of the configuration in lower case FQDN format, so that they can be
+// compared with the domain names
+func normalizeZones(config Config) Config {
+	zones := make(map[string]Config)
+	for zone, zoneConfig := range config.Zones {
+		zones[strings.ToLower(dns.Fqdn(zone))] = zoneConfig
+	}
+
+	config.Zones = zones
+	return config
+}
+
+// Check if a policy is executed when the configuration doesn't enable or disable it
+func enabledByDefault(policy Policy) bool {
+	if optInPolicy, ok := policy.(OptInPolicy); ok {
+		return !optInPolicy.DisabledByDefault()
+	}
+
+	return true
+}
+
+// Find the state and the parameters of the policy for the domain, starting from the
+// default state of the policy. The configuration of each zone that contains the domain is
+// applied, from the less to the most specific zone
+func resolveConfig(config Config, fqdn string, enabled bool) (bool, Parameters) {
+	configs := []Config{config}
+
+	var zones []string
+	for zone := range config.Zones {
+		if dns.IsSubDomain(zone, fqdn) {
+			zones = append(zones, zone)
+		}
+	}
+
+	// The most specific zone has more labels. If two zones have the same number of labels
+	// only one of them can contain the domain
+	sort.Sort(byLabels(zones))
+	for _, zone := range zones {
+		configs = append(configs, config.Zones[zone])
+	}
+
+	parameters := make(Parameters)
+
+	for _, config := range configs {
+		if config.Enabled != nil {
+			enabled = *config.Enabled
+		}
+
+		for name, value := range config.Parameters {
+			parameters[name] = value
+		}
+	}
+
+	return enabled, parameters
+}
+
+// byLabels was created only to sort the zones by the number of labels
+type byLabels []string
+
+func (b byLabels) Len() int           { return len(b) }
+func (b byLabels) Less(i, j int) bool { return dns.CountLabel(b[i]) < dns.CountLabel(b[j]) }
+func (b byLabels) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
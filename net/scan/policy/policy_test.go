@@ -0,0 +1,273 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package policy is the registry of the configuration check policies executed by the scan
+package policy
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"strings"
+	"testing"
+)
+
+// testPolicy was created only to test the registry
+type testPolicy struct {
+	id           string
+	dependencies []string
+}
+
+func (p testPolicy) ID() string {
+	return p.id
+}
+
+func (p testPolicy) Dependencies() []string {
+	return p.dependencies
+}
+
+func (p testPolicy) CheckNameserver(domain *model.Domain, dnsResponseMessage *dns.Msg,
+	parameters Parameters) (model.NameserverStatus, model.Diagnostic) {
+
+	return model.NameserverStatusOK, model.Diagnostic{}
+}
+
+func TestRegister(t *testing.T) {
+	r := newRegistry()
+
+	if err := r.register(testPolicy{id: "ns.test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.register(testPolicy{id: "ns.test"}); err == nil {
+		t.Error("Registering the same policy twice")
+	}
+
+	if err := r.register(testPolicy{}); err == nil {
+		t.Error("Registering a policy without identifier")
+	}
+
+	executions := r.enabled("example.com.br.")
+	if len(executions) != 1 || executions[0].Policy.ID() != "ns.test" {
+		t.Error("Not enabling the registered policies by default")
+	}
+
+	if _, ok := executions[0].Policy.(NSPolicy); !ok {
+		t.Error("Not keeping the type of the registered policy")
+	}
+}
+
+func TestExecutionOrder(t *testing.T) {
+	r := newRegistry()
+	r.register(testPolicy{id: "ns.glue", dependencies: []string{"ns.authority"}})
+	r.register(testPolicy{id: "ns.cname"})
+	r.register(testPolicy{id: "ns.authority", dependencies: []string{"ns.rcode"}})
+	r.register(testPolicy{id: "ns.rcode"})
+
+	var ids []string
+	for _, execution := range r.enabled("example.com.br.") {
+		ids = append(ids, execution.Policy.ID())
+	}
+
+	if strings.Join(ids, ",") != "ns.cname,ns.rcode,ns.authority,ns.glue" {
+		t.Errorf("Not executing the policies after their dependencies. Got %v", ids)
+	}
+
+	if err := r.configure(nil); err != nil {
+		t.Error(err)
+	}
+
+	r.register(testPolicy{id: "ns.cycle1", dependencies: []string{"ns.cycle2"}})
+	r.register(testPolicy{id: "ns.cycle2", dependencies: []string{"ns.cycle1"}})
+
+	if err := r.configure(nil); err == nil ||
+		!strings.HasPrefix(err.Error(), ErrInvalidDependency.Error()) {
+
+		t.Error("Not detecting a cycle in the dependencies")
+	}
+
+	if len(r.enabled("example.com.br.")) != 4 {
+		t.Error("Executing policies with a cycle in the dependencies")
+	}
+
+	r = newRegistry()
+	r.register(testPolicy{id: "ns.local", dependencies: []string{"ns.unknown"}})
+
+	if err := r.configure(nil); err == nil {
+		t.Error("Not detecting an unknown dependency")
+	}
+
+	if len(r.enabled("example.com.br.")) != 0 {
+		t.Error("Executing a policy with an unknown dependency")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	enabled, disabled := true, false
+
+	r := newRegistry()
+	r.register(testPolicy{id: "ns.rcode"})
+	r.register(testPolicy{id: "ns.authority", dependencies: []string{"ns.rcode"}})
+	r.register(testPolicy{id: "ns.tcp"})
+
+	if err := r.configure(map[string]Config{"ns.unknown": {}}); err == nil ||
+		!strings.HasPrefix(err.Error(), ErrUnknownPolicy.Error()) {
+
+		t.Error("Not detecting an unknown policy in the configuration")
+	}
+
+	err := r.configure(map[string]Config{
+		"ns.rcode": {
+			Enabled: &disabled,
+			Zones: map[string]Config{
+				"GOV.BR": {Enabled: &enabled},
+			},
+		},
+		"ns.tcp": {
+			Parameters: Parameters{
+				"timeout": "5",
+				"retries": "3",
+			},
+			Zones: map[string]Config{
+				"br.": {
+					Parameters: Parameters{"timeout": "10"},
+				},
+				"gov.br.": {
+					Enabled:    &disabled,
+					Parameters: Parameters{"timeout": "20"},
+				},
+				"sp.gov.br.": {
+					Enabled:    &enabled,
+					Parameters: Parameters{"timeout": "30"},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executions := r.enabled("example.com.br.")
+	if len(executions) != 1 || executions[0].Policy.ID() != "ns.tcp" {
+		t.Fatal("Not disabling the policies that depend on a disabled policy")
+	}
+
+	if executions[0].Parameters.Int("timeout", 0) != 10 ||
+		executions[0].Parameters.Int("retries", 0) != 3 {
+
+		t.Error("Not merging the parameters of the zone")
+	}
+
+	executions = r.enabled("example.gov.br")
+	if len(executions) != 2 || executions[0].Policy.ID() != "ns.rcode" ||
+		executions[1].Policy.ID() != "ns.authority" {
+
+		t.Error("Not applying the configuration of the domain's zone")
+	}
+
+	executions = r.enabled("example.sp.gov.br.")
+	if len(executions) != 3 || executions[2].Parameters.Int("timeout", 0) != 30 {
+		t.Error("Not applying the configuration of the most specific zone")
+	}
+}
+
+func TestParametersInt(t *testing.T) {
+	parameters := Parameters{
+		"valid":   " 1024 ",
+		"invalid": "abc",
+	}
+
+	if parameters.Int("valid", 0) != 1024 {
+		t.Error("Not converting a valid number parameter")
+	}
+
+	if parameters.Int("invalid", 2048) != 2048 || parameters.Int("unknown", 2048) != 2048 {
+		t.Error("Not using the default value for invalid parameters")
+	}
+}
+
+func TestParametersString(t *testing.T) {
+	parameters := Parameters{
+		"name":  " example.com. ",
+		"empty": " ",
+	}
+
+	if parameters.String("name", "") != "example.com." {
+		t.Error("Not retrieving a text parameter")
+	}
+
+	if parameters.String("empty", "test.") != "test." ||
+		parameters.String("unknown", "test.") != "test." {
+		t.Error("Not using the default value for undefined parameters")
+	}
+}
+
+func TestFind(t *testing.T) {
+	r := newRegistry()
+
+	if err := r.register(Standalone{Identifier: "ns.test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := r.register(Standalone{Identifier: "ns.other", Requires: []string{"ns.test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disabled := false
+	err = r.configure(map[string]Config{
+		"ns.test": {Parameters: Parameters{"limit": "10"}},
+		"ns.other": {
+			Zones: map[string]Config{
+				"gov.br": {Enabled: &disabled},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	execution, ok := Find(r.enabled("example.com.br."), "ns.test")
+	if !ok || execution.Parameters.Int("limit", 0) != 10 {
+		t.Error("Not finding an enabled policy with its parameters")
+	}
+
+	if _, ok := Find(r.enabled("example.gov.br."), "ns.other"); ok {
+		t.Error("Finding a policy disabled for the domain")
+	}
+}
+
+func TestOptIn(t *testing.T) {
+	r := newRegistry()
+
+	if err := r.register(Standalone{Identifier: "ns.probe", OptIn: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := Find(r.enabled("example.com.br."), "ns.probe"); ok {
+		t.Error("Enabling an opt-in policy without configuration")
+	}
+
+	enabled := true
+	err := r.configure(map[string]Config{
+		"ns.probe": {
+			Zones: map[string]Config{
+				"gov.br": {Enabled: &enabled},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := Find(r.enabled("example.com.br."), "ns.probe"); ok {
+		t.Error("Enabling an opt-in policy outside of the configured zone")
+	}
+
+	if _, ok := Find(r.enabled("example.gov.br."), "ns.probe"); !ok {
+		t.Error("Not enabling an opt-in policy in the configured zone")
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"container/heap"
+	"github.com/rafaeljusto/shelter/model"
+	"time"
+)
+
+// PriorityWeights store the weight of each criterion used to rank the domains in the
+// scan. Each criterion is a number between 0 and 1 multiplied by its weight, so the
+// weights define how important each criterion is compared to the others. A zero weight
+// ignores the criterion
+type PriorityWeights struct {
+	Expiration float64 // DNSSEC signatures expiration proximity, expiring domains first
+	Error      float64 // Nameservers and DS records with errors, broken domains first
+	LastCheck  float64 // Time since the last check, domains waiting longer first
+}
+
+// Return the weights used when the configuration doesn't define them
+func defaultPriorityWeights() PriorityWeights {
+	return PriorityWeights{
+		Expiration: 3,
+		Error:      2,
+		LastCheck:  1,
+	}
+}
+
+// Compute the priority of the domain in the scan, the domains with higher priority are
+// checked first
+func domainPriority(domain *model.Domain, now time.Time, weights PriorityWeights) float64 {
+	return weights.Expiration*expirationPriority(domain, now) +
+		weights.Error*errorPriority(domain) +
+		weights.LastCheck*lastCheckPriority(domain, now)
+}
+
+// Store in the domain the priority that it will have when it's due, so that the scan
+// selects the most important domains first directly in the database. A domain that is
+// already due, or that goes to the next scan, gets the priority of now
+func schedulePriority(domain *model.Domain, weights PriorityWeights) {
+	dueAt := time.Now()
+	if domain.NextCheckAt.After(dueAt) {
+		dueAt = domain.NextCheckAt
+	}
+
+	domain.Priority = domainPriority(domain, dueAt, weights)
+}
+
+// Proximity of the oldest DNSSEC signature expiration date of the DS set. The criterion is
+// 1 when the signatures already expired and decreases with the number of days until the
+// expiration. Domains without the expiration date don't have priority in this criterion
+func expirationPriority(domain *model.Domain, now time.Time) float64 {
+	var expiresAt time.Time
+	for _, ds := range domain.DSSet {
+		if !ds.ExpiresAt.IsZero() && (expiresAt.IsZero() || ds.ExpiresAt.Before(expiresAt)) {
+			expiresAt = ds.ExpiresAt
+		}
+	}
+
+	if expiresAt.IsZero() {
+		return 0
+	}
+
+	days := expiresAt.Sub(now).Hours() / 24
+	if days <= 0 {
+		return 1
+	}
+
+	return 1 / (1 + days)
+}
+
+// Proportion of the checked nameservers and DS records with errors. Warnings don't break
+// the DNS resolution or the chain of trust, so they don't count as errors
+func errorPriority(domain *model.Domain) float64 {
+	checked, errors := 0, 0
+
+	for _, nameserver := range domain.Nameservers {
+		if nameserver.LastStatus == model.NameserverStatusNotChecked {
+			continue
+		}
+
+		checked += 1
+		if nameserver.LastStatus != model.NameserverStatusOK &&
+			!model.IsNameserverStatusWarning(nameserver.LastStatus) {
+
+			errors += 1
+		}
+	}
+
+	for _, ds := range domain.DSSet {
+		if ds.LastStatus == model.DSStatusNotChecked {
+			continue
+		}
+
+		checked += 1
+		if ds.LastStatus != model.DSStatusOK && !model.IsDSStatusWarning(ds.LastStatus) {
+			errors += 1
+		}
+	}
+
+	if checked == 0 {
+		return 0
+	}
+
+	return float64(errors) / float64(checked)
+}
+
+// Time since the most recent check of the nameservers and DS records. The criterion
+// increases with the number of days since the last check, and is 1 when the domain was
+// never checked
+func lastCheckPriority(domain *model.Domain, now time.Time) float64 {
+	var lastCheckAt time.Time
+	for _, nameserver := range domain.Nameservers {
+		if nameserver.LastCheckAt.After(lastCheckAt) {
+			lastCheckAt = nameserver.LastCheckAt
+		}
+	}
+
+	for _, ds := range domain.DSSet {
+		if ds.LastCheckAt.After(lastCheckAt) {
+			lastCheckAt = ds.LastCheckAt
+		}
+	}
+
+	if lastCheckAt.IsZero() {
+		return 1
+	}
+
+	days := now.Sub(lastCheckAt).Hours() / 24
+	if days <= 0 {
+		return 0
+	}
+
+	return days / (1 + days)
+}
+
+// Structure to store a domain waiting in the dispatcher with its priority. The sequence
+// keeps the arrival order of domains with the same priority
+type prioritizedDomain struct {
+	domain   *model.Domain // Domain waiting to be checked
+	priority float64       // Priority of the domain in the scan
+	sequence uint64        // Arrival order of the domain
+	onDemand bool          // Domain checked on demand, a user is waiting for it
+}
+
+// priorityQueue stores the domains waiting to be sent to the queriers ordered by their
+// priority. It's a heap, so the next domain to be checked is always the first one
+type priorityQueue struct {
+	domains  []prioritizedDomain
+	sequence uint64
+	weights  PriorityWeights // Weights used to compute the priority of the domains
+}
+
+func (p priorityQueue) Len() int { return len(p.domains) }
+
+func (p priorityQueue) Less(i, j int) bool {
+	if p.domains[i].onDemand != p.domains[j].onDemand {
+		return p.domains[i].onDemand
+	}
+
+	if p.domains[i].priority == p.domains[j].priority {
+		return p.domains[i].sequence < p.domains[j].sequence
+	}
+
+	return p.domains[i].priority > p.domains[j].priority
+}
+
+func (p priorityQueue) Swap(i, j int) {
+	p.domains[i], p.domains[j] = p.domains[j], p.domains[i]
+}
+
+func (p *priorityQueue) Push(x interface{}) {
+	p.domains = append(p.domains, x.(prioritizedDomain))
+}
+
+func (p *priorityQueue) Pop() interface{} {
+	old := p.domains
+	prioritized := old[len(old)-1]
+	p.domains = old[:len(old)-1]
+	return prioritized
+}
+
+// Add a domain to the queue, computing its priority
+func (p *priorityQueue) add(domain *model.Domain) {
+	p.sequence += 1
+	heap.Push(p, prioritizedDomain{
+		domain:   domain,
+		priority: domainPriority(domain, time.Now(), p.weights),
+		sequence: p.sequence,
+	})
+}
+
+// Add a domain checked on demand to the queue. A user is waiting for the result, so it
+// goes before the domains of the scan whatever its priority is
+func (p *priorityQueue) addOnDemand(domain *model.Domain) {
+	p.sequence += 1
+	heap.Push(p, prioritizedDomain{
+		domain:   domain,
+		sequence: p.sequence,
+		onDemand: true,
+	})
+}
+
+// Domain with the highest priority in the queue. The queue must not be empty
+func (p priorityQueue) first() *model.Domain {
+	return p.domains[0].domain
+}
+
+// Check if the domain with the highest priority in the queue was sent on demand. The
+// queue must not be empty
+func (p priorityQueue) firstOnDemand() bool {
+	return p.domains[0].onDemand
+}
+
+// Remove the domain with the highest priority from the queue. The queue must not be
+// empty
+func (p *priorityQueue) next() *model.Domain {
+	return heap.Pop(p).(prioritizedDomain).domain
+}
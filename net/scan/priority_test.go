@@ -0,0 +1,355 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"fmt"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDomainPriority(t *testing.T) {
+	now := time.Now()
+	weights := defaultPriorityWeights()
+
+	ok := &model.Domain{
+		FQDN: "ok.com.br.",
+		Nameservers: []model.Nameserver{
+			{LastStatus: model.NameserverStatusOK, LastCheckAt: now},
+		},
+	}
+
+	if priority := domainPriority(ok, now, weights); priority != 0 {
+		t.Errorf("Giving priority to a domain configured correctly and checked now. Got %f",
+			priority)
+	}
+
+	broken := &model.Domain{
+		FQDN: "broken.com.br.",
+		Nameservers: []model.Nameserver{
+			{LastStatus: model.NameserverStatusTimeout, LastCheckAt: now},
+			{LastStatus: model.NameserverStatusOK, LastCheckAt: now},
+			{LastStatus: model.NameserverStatusNotChecked},
+		},
+	}
+
+	if priority := domainPriority(broken, now, weights); priority != weights.Error/2 {
+		t.Errorf("Not giving priority to the domain errors. Got %f", priority)
+	}
+
+	expired := &model.Domain{
+		FQDN: "expired.com.br.",
+		DSSet: []model.DS{
+			{LastStatus: model.DSStatusOK, LastCheckAt: now, ExpiresAt: now.Add(-time.Hour)},
+		},
+	}
+
+	if priority := domainPriority(expired, now, weights); priority != weights.Expiration {
+		t.Errorf("Not giving priority to expired signatures. Got %f", priority)
+	}
+
+	expiring := &model.Domain{
+		FQDN: "expiring.com.br.",
+		DSSet: []model.DS{
+			{LastStatus: model.DSStatusOK, LastCheckAt: now, ExpiresAt: now.Add(24 * time.Hour)},
+		},
+	}
+
+	if priority := domainPriority(expiring, now, weights); priority != weights.Expiration/2 {
+		t.Errorf("Not giving priority to signatures near the expiration. Got %f", priority)
+	}
+
+	unchecked := &model.Domain{
+		FQDN: "unchecked.com.br.",
+		Nameservers: []model.Nameserver{
+			{Host: "ns1.unchecked.com.br."},
+		},
+	}
+
+	if priority := domainPriority(unchecked, now, weights); priority != weights.LastCheck {
+		t.Errorf("Not giving priority to a domain that was never checked. Got %f", priority)
+	}
+
+	old := &model.Domain{
+		FQDN: "old.com.br.",
+		Nameservers: []model.Nameserver{
+			{LastStatus: model.NameserverStatusOK, LastCheckAt: now.Add(-72 * time.Hour)},
+		},
+	}
+
+	if priority := domainPriority(old, now, weights); priority != weights.LastCheck*3/4 {
+		t.Errorf("Not giving priority to the time since the last check. Got %f", priority)
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	now := time.Now()
+
+	unchecked1 := &model.Domain{
+		FQDN: "unchecked1.com.br.",
+		Nameservers: []model.Nameserver{
+			{Host: "ns1.unchecked1.com.br."},
+		},
+	}
+
+	unchecked2 := &model.Domain{
+		FQDN: "unchecked2.com.br.",
+		Nameservers: []model.Nameserver{
+			{Host: "ns1.unchecked2.com.br."},
+		},
+	}
+
+	broken := &model.Domain{
+		FQDN: "broken.com.br.",
+		Nameservers: []model.Nameserver{
+			{LastStatus: model.NameserverStatusServerFailure, LastCheckAt: now},
+		},
+	}
+
+	expiring := &model.Domain{
+		FQDN: "expiring.com.br.",
+		DSSet: []model.DS{
+			{LastStatus: model.DSStatusOK, LastCheckAt: now, ExpiresAt: now.Add(time.Hour)},
+		},
+	}
+
+	domainsToDispatch := priorityQueue{weights: defaultPriorityWeights()}
+	domainsToDispatch.add(unchecked1)
+	domainsToDispatch.add(broken)
+	domainsToDispatch.add(unchecked2)
+	domainsToDispatch.add(expiring)
+
+	if domainsToDispatch.first() != expiring {
+		t.Error("Not retrieving the domain with the highest priority first")
+	}
+
+	// Domains with the same priority keep the arrival order
+	expected := []*model.Domain{expiring, broken, unchecked1, unchecked2}
+	for _, domain := range expected {
+		if next := domainsToDispatch.next(); next != domain {
+			t.Errorf("Wrong priority order. Expected %s and got %s", domain.FQDN, next.FQDN)
+		}
+	}
+
+	if domainsToDispatch.Len() != 0 {
+		t.Error("Not removing the domains from the queue")
+	}
+
+	// A domain checked on demand goes before the domains already queued, even with the
+	// lowest priority
+	rescan := &model.Domain{
+		FQDN: "rescan.com.br.",
+		Nameservers: []model.Nameserver{
+			{LastStatus: model.NameserverStatusOK, LastCheckAt: now},
+		},
+	}
+
+	domainsToDispatch.add(expiring)
+	domainsToDispatch.add(broken)
+	domainsToDispatch.addOnDemand(rescan)
+
+	if !domainsToDispatch.firstOnDemand() || domainsToDispatch.next() != rescan {
+		t.Error("Not retrieving the domain checked on demand first")
+	}
+
+	if domainsToDispatch.firstOnDemand() || domainsToDispatch.next() != expiring {
+		t.Error("Domain checked on demand changed the order of the other domains")
+	}
+}
+
+func TestQuerierDispatcherRescan(t *testing.T) {
+	model.StartNewScan()
+
+	querierDispatcher := NewQuerierDispatcher(1, 10, 4096, time.Second, time.Second,
+		time.Second, 1, "")
+
+	var scanGroup sync.WaitGroup
+	domainsToQueryChannel := make(chan *model.Domain)
+	domainsToSaveChannel := querierDispatcher.Start(&scanGroup, domainsToQueryChannel)
+
+	// A domain rescanned on demand was checked recently and is configured correctly, so it
+	// has the lowest priority, but it's alone in the queue and must not wait for other
+	// domains to fill the window
+	now := time.Now()
+	rescan := &model.Domain{
+		FQDN: "rescan.com.br.",
+		DSSet: []model.DS{
+			{LastStatus: model.DSStatusOK, LastCheckAt: now},
+		},
+	}
+
+	weights := querierDispatcher.PriorityWeights
+	if priority := domainPriority(rescan, now, weights); priority != 0 {
+		t.Fatalf("Giving priority to a domain checked now. Got %f", priority)
+	}
+
+	domainsToQueryChannel <- rescan
+
+	select {
+	case domain := <-domainsToSaveChannel:
+		if domain != rescan {
+			t.Error("Not checking the rescanned domain")
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("Holding the rescanned domain in the priority queue")
+	}
+
+	domainsToQueryChannel <- nil // Poison pill
+
+	if domain := <-domainsToSaveChannel; domain != nil {
+		t.Error("Not finishing the dispatcher after the rescan")
+	}
+
+	scanGroup.Wait()
+}
+
+func TestQuerierDispatcherOnDemand(t *testing.T) {
+	model.StartNewScan()
+
+	// The nameserver stores the order of the checks, using the first query of each domain
+	var queriesLock sync.Mutex
+	var checked []string
+	seen := make(map[string]bool)
+
+	address, stop := startResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if len(r.Question) == 0 {
+			return
+		}
+
+		queriesLock.Lock()
+		if name := r.Question[0].Name; !seen[name] {
+			seen[name] = true
+			checked = append(checked, name)
+		}
+		queriesLock.Unlock()
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		w.WriteMsg(m)
+	})
+	defer stop()
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	DNSPort, _ = strconv.Atoi(port)
+	defer func() {
+		DNSPort = 53
+	}()
+
+	newDomain := func(fqdn string) *model.Domain {
+		return &model.Domain{
+			FQDN: fqdn,
+			Nameservers: []model.Nameserver{
+				{Host: "ns1." + fqdn, IPv4: net.ParseIP("127.0.0.1")},
+			},
+		}
+	}
+
+	querierDispatcher := NewQuerierDispatcher(1, querierDomainsQueueSize, 4096, time.Second,
+		time.Second, time.Second, 1, "")
+	querierDispatcher.RateLimit = RateLimit{}
+
+	// The scan starts paused, so that the domains wait in the querier and in the priority
+	// queue of the dispatcher
+	querierDispatcher.control = newScanControl()
+	querierDispatcher.control.pause()
+
+	var scanGroup sync.WaitGroup
+	domainsToQueryChannel := make(chan *model.Domain)
+	domainsToSaveChannel := querierDispatcher.Start(&scanGroup, domainsToQueryChannel)
+
+	// One domain held by the querier, the ones that fit in its channel and a few more that
+	// can only be in the priority queue
+	numberOfDomains := 1 + querierDomainsQueueSize + 4
+	var domains []*model.Domain
+	for i := 0; i < numberOfDomains; i++ {
+		domain := newDomain(fmt.Sprintf("scan%d.com.br.", i))
+		domains = append(domains, domain)
+		domainsToQueryChannel <- domain
+	}
+
+	// The domain checked on demand was checked now and is configured correctly, so it has
+	// the lowest priority
+	rescan := newDomain("rescan.com.br.")
+	rescan.Nameservers[0].LastStatus = model.NameserverStatusOK
+	rescan.Nameservers[0].LastCheckAt = time.Now()
+
+	check := onDemandCheck{
+		domain:  rescan,
+		checked: make(chan bool, 1),
+	}
+	querierDispatcher.onDemand <- check
+
+	// The collector receives only the domains of the scan
+	saved := make(chan int)
+	go func() {
+		numberOfSaved := 0
+		for domain := range domainsToSaveChannel {
+			if domain == nil {
+				break
+			} else if domain == rescan {
+				t.Error("Sending the domain checked on demand to the collector")
+			}
+			numberOfSaved += 1
+		}
+		saved <- numberOfSaved
+	}()
+
+	querierDispatcher.control.proceed()
+
+	select {
+	case ok := <-check.checked:
+		if !ok {
+			t.Error("Not checking the domain on demand")
+		}
+
+	case <-time.After(10 * time.Second):
+		t.Error("Domain checked on demand is waiting for the scan")
+	}
+
+	domainsToQueryChannel <- nil // Poison pill
+
+	if numberOfSaved := <-saved; numberOfSaved != numberOfDomains {
+		t.Errorf("Not saving the domains of the scan. Expected %d and got %d",
+			numberOfDomains, numberOfSaved)
+	}
+
+	scanGroup.Wait()
+
+	if querierDispatcher.checkOnDemand(newDomain("late.com.br.")) {
+		t.Error("Checking a domain on demand after the dispatcher finished")
+	}
+
+	// The domains that were only in the priority queue when the domain was sent on demand
+	// must be checked after it
+	position := make(map[string]int)
+	queriesLock.Lock()
+	for i, name := range checked {
+		position[name] = i
+	}
+	queriesLock.Unlock()
+
+	rescanPosition, ok := position[rescan.FQDN]
+	if !ok {
+		t.Fatal("Domain checked on demand didn't query the nameserver")
+	}
+
+	for _, domain := range domains[1+querierDomainsQueueSize:] {
+		if position[domain.FQDN] < rescanPosition {
+			t.Errorf("Domain %s was checked before the domain checked on demand", domain.FQDN)
+		}
+	}
+}
@@ -6,12 +6,21 @@
 package scan
 
 import (
+	"fmt"
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
 	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/cdspolicy"
+	"github.com/rafaeljusto/shelter/net/scan/csyncpolicy"
+	"github.com/rafaeljusto/shelter/net/scan/dnsutils"
 	"github.com/rafaeljusto/shelter/net/scan/dspolicy"
+	"github.com/rafaeljusto/shelter/net/scan/ednspolicy"
 	"github.com/rafaeljusto/shelter/net/scan/nspolicy"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
+	"github.com/rafaeljusto/shelter/net/scan/securitypolicy"
+	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -31,14 +40,19 @@ var (
 // queries to notify the maximum UDP package size supported in the network. This object is
 // private for this package and should only be accessed by the querier dispatcher
 type querier struct {
-	client            dns.Client // Low level DNS client for network checks
-	UDPMaxSize        uint16     // UDP max package size to pass over firewalls
-	ConnectionRetries int        // Number of retries before setting timeout
+	client            dns.Client      // Low level DNS client for network checks
+	UDPMaxSize        uint16          // UDP max package size to pass over firewalls
+	ConnectionRetries int             // Number of retries before setting timeout
+	Resolver          string          // Recursive DNS (address:port) used to find parent zones
+	nameResolver      nameResolver    // Recursive DNS servers used to resolve the nameservers
+	RateLimit         RateLimit       // Limits of the queries sent to each nameserver host
+	control           *scanControl    // Pause or cancel the checks as requested by the user
+	onDemand          *onDemandChecks // Domains checked on demand, returned to the user instead of the collector
 }
 
 // Return a new Querier object with the necessary fields for the scan filled
 func newQuerier(udpMaxSize uint16, dialTimeout, readTimeout,
-	writeTimeout time.Duration, connectionRetries int) *querier {
+	writeTimeout time.Duration, connectionRetries int, resolver string) *querier {
 
 	return &querier{
 		client: dns.Client{
@@ -48,22 +62,18 @@ func newQuerier(udpMaxSize uint16, dialTimeout, readTimeout,
 		},
 		UDPMaxSize:        udpMaxSize,
 		ConnectionRetries: connectionRetries,
+		Resolver:          resolver,
 	}
 }
 
-// Structure to store domains that will be postponed because of to many queries sent to
-// onbly one host
-type postponedDomain struct {
-	domain *model.Domain // Domain postponed
-	index  int           // Host index that exceeded QPS
-}
-
 // Fire a querier that will process domains sent via channel until receives a poison pill
 // (nil domain), for go routines control this method receives a wait group, so that the
 // main thread can wait for everubody finishs. It also receives the channel where the
-// querier will put the domains for the collector save them in database
-func (q *querier) start(queriers *sync.WaitGroup,
-	domainsToSaveChannel chan *model.Domain) chan *model.Domain {
+// querier will put the domains for the collector save them in database, and a channel
+// that the dispatcher closes to remove the querier without blocking, in this case the
+// querier finishes after checking the domains that it already received
+func (q *querier) start(queriers *sync.WaitGroup, domainsToSaveChannel chan *model.Domain,
+	stop <-chan bool) chan *model.Domain {
 	// Create the communication channel that we are going to listen to retrieve domains, we
 	// can store more than one domain in this channel because some queriers can slow down
 	// when checking domains with timeouts
@@ -74,36 +84,68 @@ func (q *querier) start(queriers *sync.WaitGroup,
 	queriers.Add(1)
 
 	go func() {
-		var postponedDomains []postponedDomain
+		var postponedDomains postponedQueue
+
+		// Check domains that were postponed due to QPS limits for the nameservers, waiting
+		// for the hosts when necessary. The domains can be postponed again until they are
+		// dropped. When the user cancels the scan the postponed domains are discarded
+		finish := func() {
+			for postponedDomains.Len() > 0 && !q.control.isCanceled() {
+				time.Sleep(postponedDomains.wait())
+				q.checkAndSave(postponedDomains.next(), &postponedDomains,
+					domainsToSaveChannel)
+			}
 
-		for {
-			// Retrieve the domain from the channel
-			domain := <-querierChannel
-
-			// Detect the poison pill from the dispatcher
-			if domain == nil {
-
-				// Check domains that were postponed due to QPS limits for the nameservers. We
-				// don't use the foreach feature beacause, according to tests, we cannot push a
-				// new value into the slice while we iterate over it
-				for i := 0; i < len(postponedDomains); i++ {
-					postponed := postponedDomains[i]
-
-					// We also send the list to the method so it can postpone the domain again and
-					// again and again...
-					if q.checkPostponedDomains(postponedDomains, postponed) {
-						domainsToSaveChannel <- postponed.domain
-					}
-				}
+			// Tell everyone that we are done!
+			queriers.Done()
+		}
 
-				// Tell everyone that we are done!
-				queriers.Done()
+		stopping := false
+
+		for {
+			// The dispatcher doesn't send domains after removing the querier, so we finish
+			// when the domains already received were checked
+			if stopping && len(querierChannel) == 0 {
+				finish()
 				return
 			}
 
-			if q.checkDomain(domain, postponedDomains) {
-				// Send to collector the domain with the new state
-				domainsToSaveChannel <- domain
+			// While there are postponed domains we also wait for the first one that can be
+			// checked again, so that the hosts' rate limit doesn't hold the domains until the
+			// end of the scan
+			var ready <-chan time.Time
+			var timer *time.Timer
+			if postponedDomains.Len() > 0 {
+				timer = time.NewTimer(postponedDomains.wait())
+				ready = timer.C
+			}
+
+			select {
+			case domain := <-querierChannel:
+				if timer != nil {
+					timer.Stop()
+				}
+
+				// Detect the poison pill from the dispatcher
+				if domain == nil {
+					finish()
+					return
+				}
+
+				q.checkAndSave(postponedDomain{domain: domain}, &postponedDomains,
+					domainsToSaveChannel)
+
+			case <-ready:
+				q.checkAndSave(postponedDomains.next(), &postponedDomains, domainsToSaveChannel)
+
+			case <-stop:
+				if timer != nil {
+					timer.Stop()
+				}
+
+				// A closed channel is always ready, so we stop watching it
+				stop = nil
+				stopping = true
 			}
 		}
 	}()
@@ -111,76 +153,428 @@ func (q *querier) start(queriers *sync.WaitGroup,
 	return querierChannel
 }
 
-// Main function to check a domain DNS/DNSSEC configuration. Returns true if domain is
-// done checking and can be saved or false otherwise, that indicates that the domain was
-// postponed
-func (q *querier) checkDomain(domain *model.Domain,
-	postponedDomains []postponedDomain) bool {
+// Check the domain and send it to the collector. When a host of the domain exceeded the
+// QPS, the domain is added to the postponed queue instead, and the next check will start
+// from the nameserver of this host. While the scan is paused the querier waits before
+// checking the domain, and when the scan is canceled the domain isn't checked or saved.
+// The domains checked on demand don't belong to the scan, so they are returned to the
+// user without waiting for the scan control, and also when they are dropped by the rate
+// limit, with the nameservers that could be checked
+func (q *querier) checkAndSave(postponed postponedDomain, postponedDomains *postponedQueue,
+	domainsToSaveChannel chan *model.Domain) {
+
+	onDemand := q.onDemand.has(postponed.domain)
+	if !onDemand {
+		q.control.waitWhilePaused()
+		if q.control.isCanceled() {
+			return
+		}
+	}
 
-	for index, _ := range domain.Nameservers {
-		if !q.checkNameserver(domain, index, postponedDomains) {
-			return false
+	index, done := q.checkDomain(postponed.domain, postponed.index)
+	if done {
+		// Send to collector the domain with the new state
+		if !q.onDemand.finish(postponed.domain, true) {
+			domainsToSaveChannel <- postponed.domain
 		}
+		return
+	}
 
-		if !q.checkDS(domain, index, q.UDPMaxSize, postponedDomains) {
-			return false
+	postponed.index = index
+	if !postponedDomains.postpone(postponed, q.RateLimit) && onDemand {
+		q.onDemand.finish(postponed.domain, true)
+	}
+}
+
+// Main function to check a domain DNS/DNSSEC configuration, starting from the nameserver
+// of the given index. Returns true if domain is done checking and can be saved or false
+// otherwise, that indicates that the domain was postponed because of the nameserver in the
+// returned index. The checks that send their own queries are only executed when their
+// policies are enabled for the domain
+func (q *querier) checkDomain(domain *model.Domain, from int) (int, bool) {
+	executions := policy.Enabled(domain.FQDN)
+
+	for index := from; index < len(domain.Nameservers); index++ {
+		if !q.checkNameserver(domain, index, executions) {
+			return index, false
+		}
+
+		if !q.checkDS(domain, index, q.UDPMaxSize, executions) {
+			return index, false
 		}
 	}
 
-	return true
+	// Only after checking all nameservers we can compare the versions of the zone between
+	// them, summarize the DNSSEC results of each nameserver, compare the DS set with the one
+	// published in the parent zone and check the DS and nameserver updates that the child
+	// zone asks for
+	if execution, ok := policy.Find(executions, nspolicy.SerialPolicy); ok {
+		domainNSPolicy := nspolicy.NewDomainNSPolicy(domain)
+		domainNSPolicy.CheckSerials(execution.Parameters)
+	}
+
+	domainDSPolicy := dspolicy.NewDomainDSPolicy(domain)
+	domainDSPolicy.CheckNameservers()
+
+	if _, ok := policy.Find(executions, dspolicy.ParentPolicy); ok {
+		q.checkParentDS(domain)
+	}
+
+	if _, ok := policy.Find(executions, cdspolicy.Policy); ok {
+		q.checkCDS(domain)
+	}
+
+	if _, ok := policy.Find(executions, csyncpolicy.Policy); ok {
+		q.checkCSYNC(domain)
+	}
+
+	return len(domain.Nameservers), true
 }
 
 // Verify the DNS configuration on the nameservers. This method will send a SOA request
-// for each nameserver and verify the results. Returns true if nameserver is done checking
-// and can be saved or false otherwise, that indicates that the domain was postponed
-func (q *querier) checkNameserver(domain *model.Domain,
-	index int, postponedDomains []postponedDomain) bool {
+// for each address of the nameserver and verify the results. The nameserver status is the
+// status of the first address with problems. Returns true if nameserver is done checking
+// and can be saved or false otherwise, that indicates that the domain was postponed. The
+// probes and checks beyond the SOA response are executed only when their policies are in
+// the list of policies enabled for the domain
+func (q *querier) checkNameserver(domain *model.Domain, index int,
+	executions []policy.Execution) bool {
 
 	nameserver := domain.Nameservers[index]
-	domainNSPolicy := nspolicy.NewDomainNSPolicy(domain)
-
-	// Build message to send the request
-	var dnsRequestMessage dns.Msg
-	dnsRequestMessage.SetQuestion(domain.FQDN, dns.TypeSOA)
-	dnsRequestMessage.RecursionDesired = false
 
-	host, err := getHost(domain.FQDN, nameserver)
+	addresses, diagnostic, err := q.getAddresses(domain.FQDN, nameserver)
 	if err == ErrHostTimeout {
+		// The nameserver didn't answer in this scan, so the latency and the addresses of the
+		// previous scan don't describe it anymore
+		domain.Nameservers[index].LastRTT = 0
+		domain.Nameservers[index].Addresses = nil
+		domain.Nameservers[index].Warnings = nil
 		domain.Nameservers[index].ChangeStatus(model.NameserverStatusTimeout)
+		domain.Nameservers[index].Diagnostic = model.Diagnostic{
+			Error: err.Error(),
+		}
 		return true
 
 	} else if err == ErrHostQPSExceeded {
-		postponedDomains = append(postponedDomains, postponedDomain{
-			domain: domain,
-			index:  index,
-		})
 		return false
+
+	} else if err != nil {
+		// We couldn't resolve the nameserver, so there's no address to check. The status
+		// tells if the name doesn't exist or if the resolution failed
+		var status model.NameserverStatus = model.NameserverStatusUnknownHost
+		if err == ErrHostResolution {
+			status = model.NameserverStatusResolutionFailure
+		}
+
+		domain.Nameservers[index].LastRTT = 0
+		domain.Nameservers[index].Addresses = nil
+		domain.Nameservers[index].Warnings = nil
+		domain.Nameservers[index].ChangeStatus(status)
+		domain.Nameservers[index].Diagnostic = diagnostic
+		return true
 	}
 
-	dnsResponseMessage, err := q.sendDNSRequest(host, &dnsRequestMessage)
-	querierCache.Query(nameserver.Host)
+	var status model.NameserverStatus = model.NameserverStatusOK
+	var checkedAddresses []model.NameserverAddress
+	var soa *dns.SOA
+	var rtt time.Duration
+
+	for _, address := range addresses {
+		addressStatus, addressSOA, addressRTT, addressDiagnostic :=
+			q.checkNameserverAddress(domain, nameserver, formatAddress(address))
+
+		nameserverAddress := nameserver.Address(address)
+		nameserverAddress.ChangeStatus(addressStatus)
+		nameserverAddress.Diagnostic = addressDiagnostic
+		checkedAddresses = append(checkedAddresses, nameserverAddress)
+
+		if status == model.NameserverStatusOK {
+			status = addressStatus
+			diagnostic = addressDiagnostic
+		}
+
+		if soa == nil {
+			soa = addressSOA
+		}
+
+		// The resolvers can choose any address of the nameserver, so the slowest address
+		// defines the latency of the nameserver
+		if addressRTT > rtt {
+			rtt = addressRTT
+		}
+	}
+
+	if soa != nil {
+		domain.Nameservers[index].SOASerial = soa.Serial
+	}
+
+	domain.Nameservers[index].LastRTT = rtt
+
+	// We can only tell an EDNS or security problem from other problems when the nameserver
+	// is answering correctly. To avoid sending too many queries, the probes are sent only to
+	// one address
+	answering := status == model.NameserverStatusOK
+	host := formatAddress(preferredAddress(addresses))
+
+	if _, ok := policy.Find(executions, ednspolicy.Policy); ok &&
+		answering && !q.checkEDNS(domain, index, host) {
+
+		status = model.NameserverStatusEDNSNotCompliant
+		diagnostic = ednsDiagnostic(domain.Nameservers[index].EDNSTests, host)
+	}
+
+	// The warning checks don't depend on each other, so all of them are executed and each
+	// problem is stored, as the status can store only the first one
+	var warnings []model.NameserverStatus
+	warn := func(warning model.NameserverStatus, warningDiagnostic model.Diagnostic) {
+		if warning == model.NameserverStatusOK {
+			return
+		}
+
+		warnings = append(warnings, warning)
+		if status == model.NameserverStatusOK {
+			status = warning
+			diagnostic = warningDiagnostic
+		}
+	}
+
+	if answering {
+		domainNSPolicy := nspolicy.NewDomainNSPolicy(domain)
+
+		if execution, ok := policy.Find(executions, nspolicy.SOAFieldsPolicy); ok {
+			warning := domainNSPolicy.CheckSOA(soa, execution.Parameters)
+			warn(warning, dnsutils.FillDiagnostic(domainNSPolicy.Diagnostic(), host, nil, nil))
+		}
+
+		for _, warning := range q.checkSecurity(domain, nameserver, host, executions) {
+			warn(warning, securityDiagnostic(warning, host))
+		}
+
+		if execution, ok := policy.Find(executions, nspolicy.RTTPolicy); ok {
+			warning := domainNSPolicy.CheckRTT(rtt, execution.Parameters)
+			warn(warning, dnsutils.FillDiagnostic(domainNSPolicy.Diagnostic(), host, nil, nil))
+		}
+	}
+
+	domain.Nameservers[index].Warnings = warnings
+	domain.Nameservers[index].Addresses = checkedAddresses
+	domain.Nameservers[index].ChangeStatus(status)
+	domain.Nameservers[index].Diagnostic = diagnostic
+	return true
+}
+
+// Send the SOA request to one address (host:port) of the nameserver and run the nameserver
+// policies over the response. The SOA record of the response is also returned, so that we
+// can compare the versions of the zone between the nameservers, and the round trip time of
+// the SOA request, that is zero when the nameserver didn't answer. When the address has a
+// problem, the diagnostic with the details is returned too
+func (q *querier) checkNameserverAddress(domain *model.Domain, nameserver model.Nameserver,
+	host string) (model.NameserverStatus, *dns.SOA, time.Duration, model.Diagnostic) {
+
+	domainNSPolicy := nspolicy.NewDomainNSPolicy(domain)
+
+	// Build message to send the request
+	var dnsRequestMessage dns.Msg
+	dnsRequestMessage.SetQuestion(domain.FQDN, dns.TypeSOA)
+	dnsRequestMessage.RecursionDesired = false
+
+	dnsResponseMessage, rtt, err := q.sendDNSRequestWithRTT(host, &dnsRequestMessage)
+	querierCache.Query(nameserver.Host, q.RateLimit)
 
 	if status := domainNSPolicy.CheckNetworkError(err); status != model.NameserverStatusOK {
 		if status == model.NameserverStatusTimeout {
 			querierCache.Timeout(nameserver.Host)
 		}
 
-		domain.Nameservers[index].ChangeStatus(status)
+		return status, nil, 0, dnsutils.FillDiagnostic(domainNSPolicy.Diagnostic(), host,
+			&dnsRequestMessage, nil)
+	}
+
+	// Send the same request over TCP to check if the nameserver supports it. A timeout here
+	// isn't added to the host timeouts, because the nameserver is answering over UDP
+	tcpResponseMessage, err := q.sendTCPDNSRequest(host, &dnsRequestMessage)
+	querierCache.Query(nameserver.Host, q.RateLimit)
+	domainNSPolicy.SetTCPResponse(tcpResponseMessage, err)
+
+	// Retrieve the delegation data from the nameserver to compare with the registered
+	// nameservers and glue. Network problems were already detected in the SOA query, so
+	// we only ignore the responses that we couldn't retrieve
+	for _, delegationRequestMessage := range delegationRequests(domain) {
+		delegationResponseMessage, err := q.sendDNSRequest(host, delegationRequestMessage)
+		querierCache.Query(nameserver.Host, q.RateLimit)
+
+		if err == nil {
+			domainNSPolicy.AddDelegationResponse(delegationResponseMessage)
+		}
+	}
+
+	status := domainNSPolicy.Run(dnsResponseMessage)
+	if status == model.NameserverStatusOK {
+		return status, domainNSPolicy.SOA(), rtt, model.Diagnostic{}
+	}
+
+	return status, domainNSPolicy.SOA(), rtt, dnsutils.FillDiagnostic(
+		domainNSPolicy.Diagnostic(), host, &dnsRequestMessage, dnsResponseMessage)
+}
+
+// Build the diagnostic of the EDNS compliance probes, listing the probes that failed with
+// their results
+func ednsDiagnostic(ednsTests []model.EDNSTest, host string) model.Diagnostic {
+	var failures []string
+	for _, ednsTest := range ednsTests {
+		if ednsTest.LastStatus != model.EDNSStatusOK {
+			failures = append(failures, fmt.Sprintf("%s (%s)",
+				model.EDNSProbeToString(ednsTest.Probe),
+				model.EDNSStatusToString(ednsTest.LastStatus)))
+		}
+	}
+
+	return model.Diagnostic{
+		Address: host,
+		Error:   "EDNS probes with problems: " + strings.Join(failures, ", "),
+	}
+}
+
+// Build the diagnostic of the security probes. An empty diagnostic is returned when there
+// was no problem
+func securityDiagnostic(status model.NameserverStatus, host string) model.Diagnostic {
+	switch status {
+	case model.NameserverStatusOpenRecursion:
+		return model.Diagnostic{
+			Address: host,
+			Error:   "Nameserver answers recursive queries from any address",
+		}
+
+	case model.NameserverStatusOpenTransfer:
+		return model.Diagnostic{
+			Address: host,
+			Error:   "Nameserver allows zone transfers (AXFR) from any address",
+		}
+	}
+
+	return model.Diagnostic{}
+}
+
+// Send the EDNS compliance probes to one address (host:port) of the nameserver and store
+// the results in the nameserver. The results of an address that was probed recently in
+// the scan are reused, as many domains share the same nameservers. Returns true if the
+// nameserver answered all probes correctly or false otherwise
+func (q *querier) checkEDNS(domain *model.Domain, index int, host string) bool {
+	if ednsTests, found := querierCache.EDNSTests(host); found {
+		domain.Nameservers[index].EDNSTests = ednsTests
+		return ednsCompliant(ednsTests)
+	}
+
+	nameserver := domain.Nameservers[index]
+	domainEDNSPolicy := ednspolicy.NewDomainEDNSPolicy(domain, q.UDPMaxSize)
+
+	var ednsTests []model.EDNSTest
 
-	} else {
-		domain.Nameservers[index].ChangeStatus(domainNSPolicy.Run(dnsResponseMessage))
+	for _, probe := range ednspolicy.Probes() {
+		// The probes are sent only via UDP, because the truncation behavior is also verified
+		dnsResponseMessage, _, err := q.exchange(host, domainEDNSPolicy.Request(probe))
+		querierCache.Query(nameserver.Host, q.RateLimit)
+
+		status := domainEDNSPolicy.CheckNetworkError(err)
+		if status == model.EDNSStatusOK {
+			status = domainEDNSPolicy.Run(probe, dnsResponseMessage)
+		}
+
+		ednsTest := model.EDNSTest{
+			Probe: probe,
+		}
+		ednsTest.ChangeStatus(status)
+		ednsTests = append(ednsTests, ednsTest)
+	}
+
+	querierCache.StoreEDNSTests(host, ednsTests)
+	domain.Nameservers[index].EDNSTests = ednsTests
+	return ednsCompliant(ednsTests)
+}
+
+// Check if the nameserver answered all EDNS compliance probes correctly
+func ednsCompliant(ednsTests []model.EDNSTest) bool {
+	for _, ednsTest := range ednsTests {
+		if ednsTest.LastStatus != model.EDNSStatusOK {
+			return false
+		}
 	}
 
 	return true
 }
 
+// Send the security probes enabled for the domain to one address (host:port) of the
+// nameserver. Returns a warning status for each problem found, when the nameserver allows
+// open recursion or zone transfers
+func (q *querier) checkSecurity(domain *model.Domain, nameserver model.Nameserver,
+	host string, executions []policy.Execution) []model.NameserverStatus {
+
+	domainSecurityPolicy := securitypolicy.NewDomainSecurityPolicy(domain)
+
+	if execution, ok := policy.Find(executions, securitypolicy.RecursionPolicy); ok {
+		dnsResponseMessage, _, err := q.exchange(host,
+			domainSecurityPolicy.RecursionRequest(execution.Parameters))
+		querierCache.Query(nameserver.Host, q.RateLimit)
+
+		if err == nil {
+			domainSecurityPolicy.SetRecursionResponse(dnsResponseMessage)
+		}
+	}
+
+	// Zone transfers are only allowed over TCP. We only read the first message of the
+	// transfer, because it's enough to detect that the transfer is allowed
+	if _, ok := policy.Find(executions, securitypolicy.TransferPolicy); ok {
+		dnsResponseMessage, err := q.sendTCPDNSRequest(host,
+			domainSecurityPolicy.TransferRequest())
+		querierCache.Query(nameserver.Host, q.RateLimit)
+
+		if err == nil {
+			domainSecurityPolicy.SetTransferResponse(dnsResponseMessage)
+		}
+	}
+
+	return domainSecurityPolicy.Run()
+}
+
+// Build the queries used to check the delegation of the domain in the nameserver. We
+// always ask for the NS set of the zone and for the addresses of the in-bailiwick
+// nameservers that have glue registered
+func delegationRequests(domain *model.Domain) []*dns.Msg {
+	var requests []*dns.Msg
+
+	newRequest := func(name string, rrType uint16) *dns.Msg {
+		var dnsRequestMessage dns.Msg
+		dnsRequestMessage.SetQuestion(dns.Fqdn(name), rrType)
+		dnsRequestMessage.RecursionDesired = false
+		return &dnsRequestMessage
+	}
+
+	requests = append(requests, newRequest(domain.FQDN, dns.TypeNS))
+
+	for _, nameserver := range domain.Nameservers {
+		if !nameserver.NeedsGlue(domain.FQDN) {
+			continue
+		}
+
+		if nameserver.IPv4 != nil {
+			requests = append(requests, newRequest(nameserver.Host, dns.TypeA))
+		}
+
+		if nameserver.IPv6 != nil {
+			requests = append(requests, newRequest(nameserver.Host, dns.TypeAAAA))
+		}
+	}
+
+	return requests
+}
+
 // Check the DS with the domain DNSSEC keys and signatures. You need also to inform the
 // UDP max package size supported to pass into firewalls. Many firewalls don't allow
 // fragmented UDP packages or UDP packages bigger than 512 bytes. Returns true if DS set
 // is done checking and can be saved or false otherwise, that indicates that the domain
 // was postponed
 func (q *querier) checkDS(domain *model.Domain, index int, udpMaxSize uint16,
-	postponedDomains []postponedDomain) bool {
+	executions []policy.Execution) bool {
 
 	// Check if the domain has DNSSEC, this system will work with both kinds of domain. So
 	// when the domain don't have any DS record we assume that it does not have DNSSEC
@@ -190,7 +584,16 @@ func (q *querier) checkDS(domain *model.Domain, index int, udpMaxSize uint16,
 	}
 
 	nameserver := domain.Nameservers[index]
-	domainDSPolicy := dspolicy.NewDomainDSPolicy(domain)
+	domain.Nameservers[index].UDPPayloadLimit = 0
+	domain.Nameservers[index].DNSKEYTags = nil
+
+	// The DS policies run over a copy of the DS set, so that the result of this nameserver
+	// doesn't overwrite the results of the other nameservers. The summary status of each DS
+	// is only defined after checking all nameservers
+	nameserverDomain := *domain
+	nameserverDomain.DSSet = make([]model.DS, len(domain.DSSet))
+	copy(nameserverDomain.DSSet, domain.DSSet)
+	domainDSPolicy := dspolicy.NewDomainDSPolicy(&nameserverDomain)
 
 	// We are going to request the DNSSEC keys to validate with the DS information that we
 	// have from the domain
@@ -199,92 +602,195 @@ func (q *querier) checkDS(domain *model.Domain, index int, udpMaxSize uint16,
 	dnsRequestMessage.RecursionDesired = false
 	dnsRequestMessage.SetEdns0(udpMaxSize, true)
 
-	host, err := getHost(domain.FQDN, nameserver)
+	addresses, diagnostic, err := q.getAddresses(domain.FQDN, nameserver)
 	if err == ErrHostTimeout {
-		for index, _ := range domain.DSSet {
-			domain.DSSet[index].ChangeStatus(model.DSStatusTimeout)
+		for i, _ := range domain.DSSet {
+			domain.DSSet[i].ChangeNameserverStatus(nameserver.Host, model.DSStatusTimeout,
+				time.Time{}, model.Diagnostic{Error: err.Error()})
 		}
 		return true
 
 	} else if err == ErrHostQPSExceeded {
-		postponedDomains = append(postponedDomains, postponedDomain{
-			domain: domain,
-			index:  index,
-		})
 		return false
+
+	} else if err != nil {
+		// We can't check the DNSSEC configuration of a nameserver that we couldn't resolve,
+		// the problem is reported in the nameserver status
+		for i, _ := range domain.DSSet {
+			domain.DSSet[i].ChangeNameserverStatus(nameserver.Host, model.DSStatusDNSError,
+				time.Time{}, diagnostic)
+		}
+		return true
 	}
 
+	host := formatAddress(preferredAddress(addresses))
+
 	dnsResponseMessage, err := q.sendDNSRequest(host, &dnsRequestMessage)
-	querierCache.Query(nameserver.Host)
+	querierCache.Query(nameserver.Host, q.RateLimit)
 
 	if domainDSPolicy.CheckNetworkError(err) {
-		domainDSPolicy.Run(dnsResponseMessage)
-	}
+		// Ask for a name that doesn't exist to verify the denial of existence proof. A
+		// network problem in this query was already detected in the DNSKEY query or isn't a
+		// DNSSEC problem, so we only skip the proof verification
+		denialResponseMessage, err := q.sendDNSRequest(host,
+			nonExistentNameRequest(domain.FQDN, udpMaxSize))
+		querierCache.Query(nameserver.Host, q.RateLimit)
 
-	return true
-}
+		if err == nil {
+			domainDSPolicy.SetDenialResponse(denialResponseMessage)
+		}
 
-// Try to check the postponed domains. Maybe we should have some protection here to avoid
-// an almost forever loop when we have a lot of domains with the same nameserver. Returns
-// true if domain is done checking and can be saved or false otherwise, that indicates
-// that the domain was postponed again
-func (q *querier) checkPostponedDomains(postponedDomains []postponedDomain,
-	postponed postponedDomain) bool {
-
-	// We only need to check from the nameserver that had a problem (exceeded the QPS), so
-	// we are directly calling the checkNameserver method instead of the checkDomain method
-	for i := postponed.index; i < len(postponed.domain.Nameservers); i++ {
-		if !q.checkNameserver(postponed.domain, i, postponedDomains) {
-			return false
+		// Ask for other signed RRsets of the zone, as a broken zone signing key doesn't
+		// affect the DNSKEY RRset signatures
+		if _, ok := policy.Find(executions, dspolicy.RRSetPolicy); ok {
+			var rrsetResponseMessages []*dns.Msg
+			for _, rrType := range []uint16{dns.TypeSOA, dns.TypeNS} {
+				var rrsetRequestMessage dns.Msg
+				rrsetRequestMessage.SetQuestion(domain.FQDN, rrType)
+				rrsetRequestMessage.RecursionDesired = false
+				rrsetRequestMessage.SetEdns0(udpMaxSize, true)
+
+				rrsetResponseMessage, err := q.sendDNSRequest(host, &rrsetRequestMessage)
+				querierCache.Query(nameserver.Host, q.RateLimit)
+
+				if err == nil {
+					rrsetResponseMessages = append(rrsetResponseMessages, rrsetResponseMessage)
+				}
+			}
+
+			domainDSPolicy.SetRRSetResponses(rrsetResponseMessages...)
 		}
 
-		if !q.checkDS(postponed.domain, i, q.UDPMaxSize, postponedDomains) {
-			return false
+		domainDSPolicy.Run(dnsResponseMessage)
+
+		if dnsResponseMessage != nil && dnsResponseMessage.Rcode == dns.RcodeSuccess {
+			domain.Nameservers[index].DNSKEYTags = dnsutils.KeyTags(dnsResponseMessage.Answer)
 		}
+
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		// Find out if the timeout happened because the big responses are being lost in the
+		// path, so that we can give a better diagnosis than a simple timeout
+		if _, ok := policy.Find(executions, dspolicy.FragmentationPolicy); ok {
+			payloadLimit := q.checkFragmentation(nameserver, host,
+				domainDSPolicy.FragmentationRequests(udpMaxSize))
+
+			if domainDSPolicy.CheckFragmentation(payloadLimit, udpMaxSize) {
+				domain.Nameservers[index].UDPPayloadLimit = payloadLimit
+			}
+		}
+	}
+
+	for i, ds := range nameserverDomain.DSSet {
+		domain.DSSet[i].ChangeNameserverStatus(nameserver.Host, ds.LastStatus, ds.ExpiresAt,
+			dnsutils.FillDiagnostic(ds.Diagnostic, host, &dnsRequestMessage, dnsResponseMessage))
 	}
 
 	return true
 }
 
-func (q *querier) sendDNSRequest(host string, dnsRequestMessage *dns.Msg) (dnsResponseMessage *dns.Msg, err error) {
-	for i := 0; i < q.ConnectionRetries; i++ {
-		// For now we ignore the RTT, in the future we can use this for some report
-		dnsResponseMessage, _, err = q.client.Exchange(dnsRequestMessage, host)
+// Send the DNSKEY queries of the fragmentation probe over UDP, in ascending order of EDNS
+// buffer size, and return the size of the largest response that was delivered. Truncated
+// responses are ignored, because the buffer size only limits how much the nameserver
+// tried to send. Zero is returned when the nameserver didn't deliver any complete
+// response. We stop in the first loss because bigger responses would also be lost
+func (q *querier) checkFragmentation(nameserver model.Nameserver, host string,
+	dnsRequestMessages []*dns.Msg) uint16 {
 
-		// Check if there was a timeout in the connection, if so try again a couple of times
-		// just to make it sure that we didn't lose any UDP package
-		if err == nil {
-			break
+	var payloadLimit uint16
+	for _, dnsRequestMessage := range dnsRequestMessages {
+		dnsResponseMessage, _, err := q.exchange(host, dnsRequestMessage)
+		querierCache.Query(nameserver.Host, q.RateLimit)
 
-		} else if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		if err != nil {
 			break
 		}
+
+		if dnsResponseMessage.Truncated {
+			continue
+		}
+
+		if data, err := dnsResponseMessage.Pack(); err == nil && len(data) > int(payloadLimit) {
+			payloadLimit = uint16(len(data))
+		}
 	}
 
+	return payloadLimit
+}
+
+// Build a DNSSEC query for a random name directly below the domain, that probably doesn't
+// exist in the zone. The answer is used to verify the denial of existence proof (NSEC or
+// NSEC3) of the zone
+func nonExistentNameRequest(fqdn string, udpMaxSize uint16) *dns.Msg {
+	name := fmt.Sprintf("shelter-%x.%s", rand.Int63(), dns.Fqdn(fqdn))
+
+	var dnsRequestMessage dns.Msg
+	dnsRequestMessage.SetQuestion(name, dns.TypeA)
+	dnsRequestMessage.RecursionDesired = false
+	dnsRequestMessage.SetEdns0(udpMaxSize, true)
+	return &dnsRequestMessage
+}
+
+// Send the DNS request to the host, retrying via TCP when the response is truncated
+func (q *querier) sendDNSRequest(host string, dnsRequestMessage *dns.Msg) (*dns.Msg, error) {
+	dnsResponseMessage, _, err := q.sendDNSRequestWithRTT(host, dnsRequestMessage)
+	return dnsResponseMessage, err
+}
+
+// Send the DNS request to the host in the same way of sendDNSRequest, but also returning
+// the round trip time of the request that got the response
+func (q *querier) sendDNSRequestWithRTT(host string,
+	dnsRequestMessage *dns.Msg) (dnsResponseMessage *dns.Msg, rtt time.Duration, err error) {
+
+	dnsResponseMessage, rtt, err = q.exchange(host, dnsRequestMessage)
+
 	// Message truncated, let's retry using TCP connection. TCP connection will also get the
 	// same retries chances of the UDP connection for timeouts because the UDP connection
 	// proved in some point that the server is alive
 	if err == nil && dnsResponseMessage.Truncated {
-		q.client.Net = "tcp"
+		dnsResponseMessage, rtt, err = q.sendTCPDNSRequestWithRTT(host, dnsRequestMessage)
+	}
 
-		// Move back the Net value to empty so that the next package sent by this querier is
-		// via UDP connection
-		defer func() {
-			q.client.Net = ""
-		}()
+	return
+}
 
-		for i := 0; i < q.ConnectionRetries; i++ {
-			// For now we ignore the RTT, in the future we can use this for some report
-			dnsResponseMessage, _, err = q.client.Exchange(dnsRequestMessage, host)
+// Send the DNS request to the host using a TCP connection
+func (q *querier) sendTCPDNSRequest(host string, dnsRequestMessage *dns.Msg) (*dns.Msg, error) {
+	dnsResponseMessage, _, err := q.sendTCPDNSRequestWithRTT(host, dnsRequestMessage)
+	return dnsResponseMessage, err
+}
 
-			// Check if there was a timeout in the connection, if so try again a couple of times
-			// just to make it sure that we didn't lose any UDP package
-			if err == nil {
-				break
+// Send the DNS request to the host using a TCP connection, also returning the round trip
+// time of the request
+func (q *querier) sendTCPDNSRequestWithRTT(host string,
+	dnsRequestMessage *dns.Msg) (*dns.Msg, time.Duration, error) {
 
-			} else if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
-				break
-			}
+	q.client.Net = "tcp"
+
+	// Move back the Net value to empty so that the next package sent by this querier is
+	// via UDP connection
+	defer func() {
+		q.client.Net = ""
+	}()
+
+	return q.exchange(host, dnsRequestMessage)
+}
+
+// Send the DNS request to the host using the current network of the client, retrying a
+// couple of times when there's a timeout. The round trip time of the last try is returned
+// to build the latency statistics of the nameservers
+func (q *querier) exchange(host string,
+	dnsRequestMessage *dns.Msg) (dnsResponseMessage *dns.Msg, rtt time.Duration, err error) {
+
+	for i := 0; i < q.ConnectionRetries; i++ {
+		dnsResponseMessage, rtt, err = q.client.Exchange(dnsRequestMessage, host)
+
+		// Check if there was a timeout in the connection, if so try again a couple of times
+		// just to make it sure that we didn't lose any UDP package
+		if err == nil {
+			break
+
+		} else if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			break
 		}
 	}
 
@@ -292,26 +798,39 @@ func (q *querier) sendDNSRequest(host string, dnsRequestMessage *dns.Msg) (dnsRe
 }
 
 // Useful function to retrieve the proper host and port to send the request. The host can
-// change because of glue records needs or not. This function alsos resolve hostnames and
-// store the addresses in a cache
-func getHost(fqdn string, nameserver model.Nameserver) (string, error) {
-	// Using cache to store host addresses when there's no glue
-	if addresses, err := querierCache.Get(nameserver, fqdn); err == nil || len(addresses) > 0 {
-		// Found information in cache, lets use it to speed up the scan. We will try to use an IPv4 from
-		// the returned addresses. if we don't find any we will use the first IPv6 address
-		for _, address := range addresses {
-			if address.To4() != nil {
-				return "[" + address.String() + "]:" + strconv.Itoa(DNSPort), nil
-			}
-		}
-		return "[" + addresses[0].String() + "]:" + strconv.Itoa(DNSPort), nil
-
-	} else if err == ErrHostTimeout || err == ErrHostQPSExceeded {
-		// Control errors were returned, we need to return them to take an action
+// change because of glue records needs or not. An error is returned when we couldn't
+// resolve the nameserver
+func (q *querier) getHost(fqdn string, nameserver model.Nameserver) (string, error) {
+	addresses, _, err := q.getAddresses(fqdn, nameserver)
+	if err != nil {
 		return "", err
 	}
 
-	// Error ocurred to retrieve the information from cache. Let's query without using the
-	// cache
-	return nameserver.Host + ":" + strconv.Itoa(DNSPort), nil
+	return formatAddress(preferredAddress(addresses)), nil
+}
+
+// Select the address used when we send the request only to one address of the nameserver.
+// We prefer the IPv4 addresses, if we don't find any we use the first IPv6 address
+func preferredAddress(addresses []net.IP) net.IP {
+	for _, address := range addresses {
+		if address.To4() != nil {
+			return address
+		}
+	}
+	return addresses[0]
+}
+
+// Retrieve all addresses of the nameserver. This function alsos resolve hostnames and
+// store the addresses in a cache. Beyond the control errors (timeout and QPS exceeded), the
+// resolution errors (unknown host and resolution failure) are returned with a diagnostic
+// of the failure. When there's no error the list of addresses is never empty
+func (q *querier) getAddresses(fqdn string,
+	nameserver model.Nameserver) ([]net.IP, model.Diagnostic, error) {
+
+	return querierCache.lookup(nameserver, fqdn, q.nameResolver, q.RateLimit)
+}
+
+// Build the host and port used to send the request to a specific address
+func formatAddress(address net.IP) string {
+	return "[" + address.String() + "]:" + strconv.Itoa(DNSPort)
 }
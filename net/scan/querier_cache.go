@@ -18,14 +18,26 @@ const (
 	// Maximum number of timeouts in a host before we start setting every query from this
 	// host as timeout without checking it
 	maxTimeoutsPerHost = 500
+
+	// Default limits of the queries sent to each nameserver host, used when the
+	// configuration doesn't define them
+	defaultMaxQPSPerHost   = 500
+	defaultMaxBurstPerHost = 500
+
+	// Time that the EDNS compliance probe results of an address are reused by the other
+	// domains checked in the scan. The compliance belongs to the nameserver, and large
+	// providers host many domains, so we avoid sending the same probes many times
+	ednsCacheDuration = time.Hour
 )
 
 var (
 	// Global variable used by all queriers (go routines) to access the cache
 	querierCache QuerierCache
 
-	// Maximum number of queries per second that a host will receive
-	MaxQPSPerHost = uint64(500)
+	// Number of queries per second defined by the user for the scan being executed, that
+	// replaces the configured limit while non-negative. Accessed atomically, as it's changed while
+	// the queriers are running
+	queriesPerSecondOverride = int64(-1)
 
 	// Error to identify a nameserver that had too many timeouts and is probably down
 	ErrHostTimeout = errors.New("Nameserver down after too many timeouts detected")
@@ -38,6 +50,27 @@ var (
 func init() {
 	querierCache = QuerierCache{
 		hosts: make(map[string]*hostCache),
+		edns:  make(map[string]ednsCache),
+	}
+}
+
+// RateLimit store the limits of the queries sent to each nameserver host. Many domains
+// share the same hosts, and large providers can rate limit us when we send too many
+// queries. The queries are controlled by a token bucket for each host, so a host can
+// receive more queries in a short period (limited by the burst) if it didn't receive
+// queries before
+type RateLimit struct {
+	QueriesPerSecond uint64 // Queries per second that a host will receive, zero disables the limit
+	Burst            uint64 // Queries that a host can receive at once, handled as one when lower
+	MaxPostponements int    // Times that a domain can wait for the hosts before being dropped
+}
+
+// Return the limits used when the configuration doesn't define them
+func defaultRateLimit() RateLimit {
+	return RateLimit{
+		QueriesPerSecond: defaultMaxQPSPerHost,
+		Burst:            defaultMaxBurstPerHost,
+		MaxPostponements: defaultMaxPostponements,
 	}
 }
 
@@ -46,26 +79,129 @@ func init() {
 // avoid making queries whitout necessity. We also control the number of queries per
 // second to avoid rate limit algorithms
 type hostCache struct {
-	addresses        []net.IP // nameserver's addresses
-	lastEpoch        int64    // last query epoch
-	queriesPerSecond uint64   // number of queries per second (epoch)
-	timeouts         uint64   // counter that detects if this nameserver is down
+	addresses  []net.IP         // nameserver's addresses
+	expiresAt  time.Time        // when the addresses must be resolved again, zero if never
+	diagnostic model.Diagnostic // details of the resolution failure
+	err        error            // resolution failure (negative cache)
+	timeouts   uint64           // counter that detects if this nameserver is down
+	bucket     tokenBucket      // rate of queries sent to this nameserver
+}
+
+// Method to detect if the addresses of the host must be resolved again
+func (h *hostCache) expired(now time.Time) bool {
+	return !h.expiresAt.IsZero() && now.After(h.expiresAt)
 }
 
 // Method to detect if the number of timeouts in a host was exceeded
-func (h hostCache) timeoutsPerHostExceeded() bool {
-	return h.timeouts > maxTimeoutsPerHost
+func (h *hostCache) timeoutsPerHostExceeded() bool {
+	return atomic.LoadUint64(&h.timeouts) > maxTimeoutsPerHost
 }
 
 // Mehtod to check if the number of queries per second on this host was exceeded
-func (h hostCache) queriesPerSecondExceeded() bool {
-	// If the parameter that indicates if the host has to many requests is zero, we assume that the
-	// user wants to turn off this feature
-	if MaxQPSPerHost == 0 {
-		return false
+func (h *hostCache) queriesPerSecondExceeded(rateLimit RateLimit) bool {
+	return !h.bucket.available(time.Now(), rateLimit)
+}
+
+// ednsCache store the results of the EDNS compliance probes sent to an address
+type ednsCache struct {
+	tests     []model.EDNSTest // results of the probes
+	expiresAt time.Time        // when the probes must be sent again
+}
+
+// tokenBucket control the rate of queries sent to a host. The bucket is refilled with the
+// queries per second of the rate limit up to the burst tokens, and each query takes one
+// token. A domain is only checked when there's a token available, but the check can send
+// more than one query to the host, so the tokens can become negative. This debt delays the
+// next checks in the same host
+type tokenBucket struct {
+	sync.Mutex
+	tokens    float64   // Number of queries that can be sent, negative when there's a debt
+	updatedAt time.Time // Last time that the bucket was refilled
+}
+
+// Return a full bucket, so that a host that didn't receive queries yet can receive a burst
+func newTokenBucket(now time.Time, rateLimit RateLimit) tokenBucket {
+	return tokenBucket{
+		tokens:    burst(rateLimit),
+		updatedAt: now,
+	}
+}
+
+// Add the tokens generated since the last refill. The lock must be acquired by the caller
+func (b *tokenBucket) refill(now time.Time, rateLimit RateLimit) {
+	if now.After(b.updatedAt) {
+		b.tokens += now.Sub(b.updatedAt).Seconds() * float64(maxQPSPerHost(rateLimit))
+		b.updatedAt = now
+	}
+
+	if b.tokens > burst(rateLimit) {
+		b.tokens = burst(rateLimit)
+	}
+}
+
+// Check if there's a token available to send a query to the host
+func (b *tokenBucket) available(now time.Time, rateLimit RateLimit) bool {
+	// If the parameter that indicates if the host has to many requests is zero, we assume
+	// that the user wants to turn off this feature
+	if maxQPSPerHost(rateLimit) == 0 {
+		return true
 	}
 
-	return time.Now().Unix() == h.lastEpoch && h.queriesPerSecond > MaxQPSPerHost
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(now, rateLimit)
+	return b.tokens >= 1
+}
+
+// Take a token for a query sent to the host, even if the bucket is empty
+func (b *tokenBucket) take(now time.Time, rateLimit RateLimit) {
+	if maxQPSPerHost(rateLimit) == 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(now, rateLimit)
+	b.tokens -= 1
+}
+
+// Time that we need to wait until there's a token available in the bucket
+func (b *tokenBucket) delay(now time.Time, rateLimit RateLimit) time.Duration {
+	qps := maxQPSPerHost(rateLimit)
+	if qps == 0 {
+		return 0
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(now, rateLimit)
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / float64(qps) * float64(time.Second))
+}
+
+// Number of queries per second that a host will receive, considering the value defined by
+// the user for the scan being executed
+func maxQPSPerHost(rateLimit RateLimit) uint64 {
+	if override := atomic.LoadInt64(&queriesPerSecondOverride); override >= 0 {
+		return uint64(override)
+	}
+
+	return rateLimit.QueriesPerSecond
+}
+
+// Maximum number of tokens that a bucket can store
+func burst(rateLimit RateLimit) float64 {
+	if rateLimit.Burst < 1 {
+		return 1
+	}
+
+	return float64(rateLimit.Burst)
 }
 
 // QuerierCache was created to make the name resolution faster. Many domains use ISP the
@@ -73,29 +209,76 @@ func (h hostCache) queriesPerSecondExceeded() bool {
 type QuerierCache struct {
 	hosts      map[string]*hostCache // key-value structure that store nameserver data
 	hostsMutex sync.RWMutex          // Lock to allow concurrent access
+	edns       map[string]ednsCache  // EDNS probe results indexed by address (host:port)
+	ednsMutex  sync.RWMutex          // Lock to allow concurrent access to the EDNS results
 }
 
 // Method used to retrieve addresses of a given nameserver, if the address does not exist
-// in the local cache the system will lookup for the domain and will store the result
-func (q *QuerierCache) Get(nameserver model.Nameserver, fqdn string) ([]net.IP, error) {
+// in the local cache or expired the system will lookup for the domain and will store the
+// result. When the name doesn't exist (ErrHostUnknown) or couldn't be resolved
+// (ErrHostResolution) the failure is also stored for a limited time. The hostnames are
+// resolved with the given recursive DNS servers (address:port), or with the operating
+// system when there's none, and the host rate limit is checked with the given limits
+func (q *QuerierCache) Get(nameserver model.Nameserver, fqdn string, resolvers []string,
+	rateLimit RateLimit) ([]net.IP, error) {
+
+	resolver := nameResolver{addresses: resolvers}
+	addresses, _, err := q.lookup(nameserver, fqdn, resolver, rateLimit)
+	return addresses, err
+}
+
+// Method that works like Get, but also returning the details of the resolution failures
+func (q *QuerierCache) lookup(nameserver model.Nameserver, fqdn string,
+	resolver nameResolver, rateLimit RateLimit) ([]net.IP, model.Diagnostic, error) {
+
+	now := time.Now()
+
 	q.hostsMutex.RLock()
 	host, found := q.hosts[nameserver.Host]
+	expired := found && host.expired(now)
 	q.hostsMutex.RUnlock()
 
-	if found {
-		if host.timeoutsPerHostExceeded() {
-			return nil, ErrHostTimeout
+	if !found || expired {
+		result := q.resolve(nameserver, fqdn, resolver)
 
-		} else if host.queriesPerSecondExceeded() {
-			return nil, ErrHostQPSExceeded
-
-		} else {
-			return host.addresses, nil
+		q.hostsMutex.Lock()
+		// The counters of the host are kept when the addresses are resolved again
+		if host, found = q.hosts[nameserver.Host]; !found {
+			host = &hostCache{
+				bucket: newTokenBucket(now, rateLimit),
+			}
+			q.hosts[nameserver.Host] = host
 		}
+
+		host.addresses = result.addresses
+		host.expiresAt = result.expiresAt
+		host.diagnostic = result.diagnostic
+		host.err = result.err
+		q.hostsMutex.Unlock()
 	}
 
-	// Not found in cache, lets discover the address of this name sending DNS requests or
-	// retrieving from the namserver object (glue record)
+	q.hostsMutex.RLock()
+	addresses, diagnostic, err := host.addresses, host.diagnostic, host.err
+	q.hostsMutex.RUnlock()
+
+	if err != nil {
+		return nil, diagnostic, err
+
+	} else if host.timeoutsPerHostExceeded() {
+		return nil, model.Diagnostic{}, ErrHostTimeout
+
+	} else if host.queriesPerSecondExceeded(rateLimit) {
+		return nil, model.Diagnostic{}, ErrHostQPSExceeded
+	}
+
+	return addresses, model.Diagnostic{}, nil
+}
+
+// Discover the addresses of the nameserver retrieving from the namserver object (glue
+// record) or sending DNS requests
+func (q *QuerierCache) resolve(nameserver model.Nameserver, fqdn string,
+	resolver nameResolver) resolution {
+
 	var addresses []net.IP
 
 	if nameserver.NeedsGlue(fqdn) {
@@ -110,23 +293,12 @@ func (q *QuerierCache) Get(nameserver model.Nameserver, fqdn string) ([]net.IP,
 
 	// In case that the nameserver doesn't have a glue record we try to resolve the hostname
 	if len(addresses) == 0 {
-		var err error
-		addresses, err = net.LookupIP(nameserver.Host)
-		if err != nil {
-			return nil, err
-		}
+		return resolver.resolve(nameserver.Host)
 	}
 
-	q.hostsMutex.Lock()
-	q.hosts[nameserver.Host] = &hostCache{
-		addresses:        addresses,
-		lastEpoch:        0,
-		queriesPerSecond: 0,
-		timeouts:         0,
+	return resolution{
+		addresses: addresses,
 	}
-	q.hostsMutex.Unlock()
-
-	return addresses, nil
 }
 
 // Method used to notify when a host got timeout for a query, after a special number of
@@ -143,25 +315,55 @@ func (q *QuerierCache) Timeout(name string) {
 
 // Method used to notify when a new query was made to a host. This is used to control the
 // maximum number of queries sent to a host, avoiding rate limit startegies
-func (q *QuerierCache) Query(name string) {
+func (q *QuerierCache) Query(name string, rateLimit RateLimit) {
+	q.hostsMutex.RLock()
+	host, found := q.hosts[name]
+	q.hostsMutex.RUnlock()
+
+	if found {
+		host.bucket.take(time.Now(), rateLimit)
+	}
+}
+
+// Method used to retrieve the time that we need to wait before sending a new query to a
+// host that exceeded the maximum number of queries per second. Unknown hosts don't need to
+// wait
+func (q *QuerierCache) Delay(name string, rateLimit RateLimit) time.Duration {
 	q.hostsMutex.RLock()
 	host, found := q.hosts[name]
 	q.hostsMutex.RUnlock()
 
 	if !found {
-		return
+		return 0
 	}
 
-	now := time.Now().Unix()
+	return host.bucket.delay(time.Now(), rateLimit)
+}
 
-	if now == host.lastEpoch {
-		atomic.AddUint64(&host.queriesPerSecond, 1)
+// Method used to retrieve the EDNS compliance probe results of an address (host:port)
+// that were stored recently. Returns false when the probes must be sent to the address
+func (q *QuerierCache) EDNSTests(address string) ([]model.EDNSTest, bool) {
+	q.ednsMutex.RLock()
+	cache, found := q.edns[address]
+	q.ednsMutex.RUnlock()
 
-	} else if now > host.lastEpoch {
-		if atomic.CompareAndSwapInt64(&host.lastEpoch, host.lastEpoch, now) {
-			atomic.StoreUint64(&host.queriesPerSecond, 1)
-		}
+	if !found || time.Now().After(cache.expiresAt) {
+		return nil, false
 	}
+
+	// Each domain stores its own copy of the results
+	return append([]model.EDNSTest(nil), cache.tests...), true
+}
+
+// Method used to store the EDNS compliance probe results of an address (host:port), so
+// that the other domains hosted in the same address don't send the probes again
+func (q *QuerierCache) StoreEDNSTests(address string, ednsTests []model.EDNSTest) {
+	q.ednsMutex.Lock()
+	q.edns[address] = ednsCache{
+		tests:     append([]model.EDNSTest(nil), ednsTests...),
+		expiresAt: time.Now().Add(ednsCacheDuration),
+	}
+	q.ednsMutex.Unlock()
 }
 
 // Clear cache. This method is for now used in integration test scenarios to get more
@@ -170,4 +372,8 @@ func (q *QuerierCache) Clear() {
 	q.hostsMutex.Lock()
 	q.hosts = make(map[string]*hostCache)
 	q.hostsMutex.Unlock()
+
+	q.ednsMutex.Lock()
+	q.edns = make(map[string]ednsCache)
+	q.ednsMutex.Unlock()
 }
@@ -30,45 +30,66 @@ func TestMaxTimeoutsPerHost(t *testing.T) {
 	}
 }
 
-func TestQueriesPerSecondExceeded(t *testing.T) {
-	h := hostCache{
-		lastEpoch:        time.Now().Unix(),
-		queriesPerSecond: MaxQPSPerHost + 1,
+func TestTokenBucket(t *testing.T) {
+	rateLimit := RateLimit{QueriesPerSecond: 10, Burst: 2}
+
+	now := time.Now()
+	b := newTokenBucket(now, rateLimit)
+
+	if !b.available(now, rateLimit) {
+		t.Fatal("Not allowing queries in a new bucket")
 	}
 
-	// This test is really fast, but there's a little chance to fail when the epoch from the
-	// created object is different from the current epoch. This will happen if we are
-	// creating the object in the end of a second. After some tests using shell scripts a
-	// saw that this scenario is not frequent, so I will stay with this strategy
-	if !h.queriesPerSecondExceeded() {
-		t.Error("Not checking when QPS per host exceeded")
+	b.take(now, rateLimit)
+	b.take(now, rateLimit)
+	b.take(now, rateLimit)
+
+	if b.available(now, rateLimit) {
+		t.Error("Allowing queries after the burst")
 	}
 
-	h = hostCache{
-		lastEpoch:        time.Now().Unix(),
-		queriesPerSecond: MaxQPSPerHost - 1,
+	// With 10 queries per second a token is generated every 100ms, and we have a debt of
+	// one token
+	if delay := b.delay(now, rateLimit); delay != 200*time.Millisecond {
+		t.Errorf("Wrong delay until the next token. Expected 200ms and got %s", delay)
 	}
 
-	if h.queriesPerSecondExceeded() {
-		t.Error("Alerting maximum QPS per host when it's not")
+	if b.available(now.Add(150*time.Millisecond), rateLimit) {
+		t.Error("Allowing queries before paying the debt")
 	}
 
-	h = hostCache{
-		lastEpoch:        time.Now().Unix(),
-		queriesPerSecond: MaxQPSPerHost + 1,
+	if !b.available(now.Add(200*time.Millisecond), rateLimit) {
+		t.Error("Not refilling the bucket")
+	}
+
+	if delay := b.delay(now.Add(200*time.Millisecond), rateLimit); delay != 0 {
+		t.Error("Delaying queries when there's a token available")
+	}
+
+	b.refill(now.Add(time.Hour), rateLimit)
+	if b.tokens != 2 {
+		t.Error("Refilling the bucket beyond the burst")
 	}
 
-	MaxQPSPerHost = 0
-	if h.queriesPerSecondExceeded() {
+	rateLimit.Burst = 0
+	b = newTokenBucket(now, rateLimit)
+	b.take(now, rateLimit)
+
+	if b.available(now, rateLimit) {
+		t.Error("Not handling an empty burst as one query")
+	}
+
+	rateLimit.QueriesPerSecond = 0
+	if !b.available(now, rateLimit) || b.delay(now, rateLimit) != 0 {
 		t.Error("Not working with disabled QPS per host feature")
 	}
-	MaxQPSPerHost = 500
 }
 
 func TestQuerierCacheGet(t *testing.T) {
 	querierCache.hosts = make(map[string]*hostCache)
 
-	addresses, err := querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.")
+	addresses, err := querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.",
+		nil, defaultRateLimit())
 	if err != nil {
 		t.Fatal("Not resolving a valid nameserver")
 	}
@@ -90,7 +111,8 @@ func TestQuerierCacheGet(t *testing.T) {
 		}
 	}
 
-	_, err = querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.")
+	_, err = querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.",
+		nil, defaultRateLimit())
 	if err != nil {
 		t.Fatal("Not recovering correctly from local cache")
 	}
@@ -111,7 +133,7 @@ func TestQuerierCacheGet(t *testing.T) {
 		Host: "ns1.example.com.",
 		IPv4: net.ParseIP("127.0.0.1"),
 		IPv6: net.ParseIP("::1"),
-	}, "example.com.")
+	}, "example.com.", nil, defaultRateLimit())
 
 	if err != nil {
 		t.Fatal("Not resolving a nameserver with glue record")
@@ -136,32 +158,28 @@ func TestQuerierCacheGet(t *testing.T) {
 		t.Error("Storing different data from the returned one on nameservers with glue records")
 	}
 
-	// The tests bellow are really fast, but there's a little chance to fail when the epoch
-	// from the created object is different from the current epoch. This will happen if we
-	// are creating the object in the end of a second. After some tests using shell scripts
-	// a saw that this scenario is not frequent, so I will stay with this strategy
-
-	h.lastEpoch = time.Now().Unix()
-	h.queriesPerSecond = MaxQPSPerHost + 1
+	h.bucket.tokens = 0
 	h.timeouts = 0
 	querierCache.hosts["localhost"] = h
 
-	addresses, err = querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.")
+	addresses, err = querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.",
+		nil, defaultRateLimit())
 	if err != ErrHostQPSExceeded {
 		t.Error("Not returning error when maximum QPS per host is exceeded")
 	}
 
-	h.lastEpoch = 0
-	h.queriesPerSecond = 0
+	h.bucket = newTokenBucket(time.Now(), defaultRateLimit())
 	h.timeouts = maxTimeoutsPerHost + 1
 	querierCache.hosts["localhost"] = h
 
-	addresses, err = querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.")
+	addresses, err = querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.",
+		nil, defaultRateLimit())
 	if err != ErrHostTimeout {
 		t.Error("Not returning error when maximum timeouts in the host is exceeded")
 	}
 
-	_, err = querierCache.Get(model.Nameserver{Host: "abc123idontexist321cba.com.br"}, "example.com.")
+	_, err = querierCache.Get(model.Nameserver{Host: "abc123idontexist321cba.com.br"},
+		"example.com.", nil, defaultRateLimit())
 	if err == nil {
 		t.Error("Resolving an unknown name")
 	}
@@ -170,7 +188,8 @@ func TestQuerierCacheGet(t *testing.T) {
 func TestQuerierCacheTimeout(t *testing.T) {
 	querierCache.hosts = make(map[string]*hostCache)
 
-	_, err := querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.")
+	_, err := querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.",
+		nil, defaultRateLimit())
 	if err != nil {
 		t.Fatal("Not resolving a valid nameserver")
 	}
@@ -191,72 +210,91 @@ func TestQuerierCacheTimeout(t *testing.T) {
 func TestQuerierCacheQuery(t *testing.T) {
 	querierCache.hosts = make(map[string]*hostCache)
 
-	// The tests bellow are really fast, but there's a little chance to fail when the epoch
-	// from the created object is different from the current epoch. This will happen if we
-	// are creating the object in the end of a second. After some tests using shell scripts
-	// a saw that this scenario is not frequent, so I will stay with this strategy
-
-	querierCache.Query("localhost")
+	querierCache.Query("localhost", defaultRateLimit())
 
 	if _, exists := querierCache.hosts["localhost"]; exists {
 		t.Error("Creating cache entry when alerting about a query")
 	}
 
-	_, err := querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.")
+	if querierCache.Delay("localhost", defaultRateLimit()) != 0 {
+		t.Error("Delaying queries to an unknown host")
+	}
+
+	_, err := querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.",
+		nil, defaultRateLimit())
 	if err != nil {
 		t.Fatal("Not resolving a valid nameserver")
 	}
 
-	querierCache.Query("localhost")
-
 	h, exists := querierCache.hosts["localhost"]
 
 	if !exists {
 		t.Fatal("Not creating cache entries when necessary")
 	}
 
-	if h.lastEpoch != time.Now().Unix() {
-		t.Error("Not setting epoch correctly")
+	// The bucket is refilled while the test runs, so we can only check that the tokens are
+	// decreasing
+	rateLimit := defaultRateLimit()
+	for i := uint64(0); i < rateLimit.Burst; i++ {
+		querierCache.Query("localhost", rateLimit)
 	}
 
-	if h.queriesPerSecond != 1 {
-		t.Error("Not counting QPS correctly")
+	if h.bucket.tokens >= 1 {
+		t.Error("Not taking tokens from the bucket for each query")
 	}
 
-	querierCache.Query("localhost")
+	if querierCache.Delay("localhost", rateLimit) == 0 {
+		t.Error("Not delaying queries after exceeding the burst")
+	}
+}
 
-	h, exists = querierCache.hosts["localhost"]
+func TestQuerierCacheEDNSTests(t *testing.T) {
+	querierCache.Clear()
 
-	if !exists {
-		t.Fatal("Removing cache entry in an awkward moment")
+	if _, found := querierCache.EDNSTests("192.0.2.1:53"); found {
+		t.Error("Finding EDNS results of an address that wasn't probed")
 	}
 
-	if h.queriesPerSecond != 2 {
-		t.Error("Not counting QPS correctly")
+	ednsTests := []model.EDNSTest{
+		{Probe: model.EDNSProbeVersion, LastStatus: model.EDNSStatusOK},
+		{Probe: model.EDNSProbeOption, LastStatus: model.EDNSStatusTimeout},
 	}
+	querierCache.StoreEDNSTests("192.0.2.1:53", ednsTests)
 
-	// Forcing epoch change
-	time.Sleep(1 * time.Second)
+	cachedTests, found := querierCache.EDNSTests("192.0.2.1:53")
+	if !found || len(cachedTests) != 2 ||
+		cachedTests[1].LastStatus != model.EDNSStatusTimeout {
 
-	querierCache.Query("localhost")
+		t.Fatal("Not reusing the EDNS results of an address probed recently")
+	}
 
-	if !exists {
-		t.Fatal("Removing cache entry in an awkward moment")
+	cachedTests[0].LastStatus = model.EDNSStatusTimeout
+	cachedTests, _ = querierCache.EDNSTests("192.0.2.1:53")
+	if cachedTests[0].LastStatus != model.EDNSStatusOK {
+		t.Error("Sharing the same EDNS results between the domains")
 	}
 
-	if h.lastEpoch != time.Now().Unix() {
-		t.Error("Not replacing epoch correctly")
+	if _, found := querierCache.EDNSTests("192.0.2.2:53"); found {
+		t.Error("Reusing the EDNS results of other address")
 	}
 
-	if h.queriesPerSecond != 1 {
-		t.Error("Not counting QPS correctly")
+	querierCache.edns["192.0.2.1:53"] = ednsCache{
+		tests:     ednsTests,
+		expiresAt: time.Now().Add(-time.Second),
 	}
+
+	if _, found := querierCache.EDNSTests("192.0.2.1:53"); found {
+		t.Error("Reusing expired EDNS results")
+	}
+
+	querierCache.Clear()
 }
 
 func TestQuerierCacheClear(t *testing.T) {
 	querierCache.hosts = make(map[string]*hostCache)
 
-	_, err := querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.")
+	_, err := querierCache.Get(model.Nameserver{Host: "localhost"}, "example.com.",
+		nil, defaultRateLimit())
 	if err != nil {
 		t.Fatal("Not resolving a valid nameserver")
 	}
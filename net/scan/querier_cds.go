@@ -0,0 +1,47 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/cdspolicy"
+)
+
+// Check the CDS and CDNSKEY records that the child zone publishes to ask for a DS update
+// (RFC 7344 and RFC 8078). Only domains with DNSSEC are checked, because the records must
+// be signed by a key of the current chain of trust. All nameservers are queried, as the DS
+// update is only accepted when they agree
+func (q *querier) checkCDS(domain *model.Domain) {
+	if len(domain.DSSet) == 0 {
+		return
+	}
+
+	domainCDSPolicy := cdspolicy.NewDomainCDSPolicy(domain)
+	requests := domainCDSPolicy.Requests(q.UDPMaxSize)
+
+	for _, nameserver := range domain.Nameservers {
+		responses := make([]*dns.Msg, len(requests))
+
+		host, err := q.getHost(domain.FQDN, nameserver)
+		if err == nil {
+			for i, request := range requests {
+				// A failed query is stored as nil, so that the policy knows that it couldn't
+				// retrieve the records from this nameserver
+				dnsResponseMessage, err := q.sendDNSRequest(host, request)
+				querierCache.Query(nameserver.Host, q.RateLimit)
+
+				if err == nil {
+					responses[i] = dnsResponseMessage
+				}
+			}
+		}
+
+		domainCDSPolicy.AddResponses(responses)
+	}
+
+	domainCDSPolicy.Run()
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/csyncpolicy"
+)
+
+// Check the CSYNC record that the child zone publishes to ask for a nameserver and glue
+// update (RFC 7477). Only domains with DNSSEC are checked, because the records must be
+// signed by a key of the current chain of trust. All nameservers are queried, as the
+// update is only accepted when they agree
+func (q *querier) checkCSYNC(domain *model.Domain) {
+	if len(domain.DSSet) == 0 {
+		return
+	}
+
+	domainCSYNCPolicy := csyncpolicy.NewDomainCSYNCPolicy(domain)
+	requests := domainCSYNCPolicy.Requests(q.UDPMaxSize)
+
+	for _, nameserver := range domain.Nameservers {
+		host, err := q.getHost(domain.FQDN, nameserver)
+		if err != nil {
+			domainCSYNCPolicy.AddResponses(nil)
+			continue
+		}
+
+		responses := q.sendCSYNCRequests(nameserver, host, requests)
+		responses = append(responses, q.sendCSYNCRequests(nameserver, host,
+			domainCSYNCPolicy.GlueRequests(responses, q.UDPMaxSize))...)
+
+		// Ask for the SOA record again to detect zone changes during the check
+		responses = append(responses, q.sendCSYNCRequests(nameserver, host, requests[:1])...)
+
+		domainCSYNCPolicy.AddResponses(responses)
+	}
+
+	domainCSYNCPolicy.Run()
+}
+
+// Send the requests to the nameserver, storing a nil response for each query that failed,
+// so that the policy knows that it couldn't retrieve the records from this nameserver
+func (q *querier) sendCSYNCRequests(nameserver model.Nameserver, host string,
+	requests []*dns.Msg) []*dns.Msg {
+
+	responses := make([]*dns.Msg, len(requests))
+	for i, request := range requests {
+		dnsResponseMessage, err := q.sendDNSRequest(host, request)
+		querierCache.Query(nameserver.Host, q.RateLimit)
+
+		if err == nil {
+			responses[i] = dnsResponseMessage
+		}
+	}
+	return responses
+}
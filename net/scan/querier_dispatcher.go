@@ -16,24 +16,37 @@ import (
 // The object has many attributes to control the scan query performance, allow packages to
 // pass network firewall rules, determinate the amount of time that the queriers are going
 // to wait in network operations when there's no answer and determinate the number of
-// concurrently go routines that will resolve the domains
+// concurrently go routines that will resolve the domains. The domains are sent to the
+// queriers by priority, so that broken and expiring domains are checked first, and the
+// domains checked on demand while the scan is running go before all of them
 type QuerierDispatcher struct {
-	NumberOfQueriers  int           // Number of queriers to concurrently check the domains
-	DomainsBufferSize int           // Size of the domains to save channel
-	UDPMaxSize        uint16        // UDP max package size to pass over firewalls
-	DialTimeout       time.Duration // Timeout while connecting to a server
-	ReadTimeout       time.Duration // Timeout while waiting for a response
-	WriteTimeout      time.Duration // Timeout to write a query to the DNS server
-	ConnectionRetries int           // Number of retries before setting timeout
+	NumberOfQueriers  int                // Number of queriers to concurrently check the domains
+	DomainsBufferSize int                // Size of the domains to save channel and of the priority queue
+	UDPMaxSize        uint16             // UDP max package size to pass over firewalls
+	DialTimeout       time.Duration      // Timeout while connecting to a server
+	ReadTimeout       time.Duration      // Timeout while waiting for a response
+	WriteTimeout      time.Duration      // Timeout to write a query to the DNS server
+	ConnectionRetries int                // Number of retries before setting timeout
+	Resolver          string             // Recursive DNS (address:port) used to find parent zones
+	Resolvers         []string           // Recursive DNS servers (address:port) used to resolve the nameservers
+	RateLimit         RateLimit          // Limits of the queries sent to each nameserver host
+	PriorityWeights   PriorityWeights    // Weights used to rank the domains
+	control           *scanControl       // Pause, continue or cancel the scan and change the number of queriers
+	onDemand          chan onDemandCheck // Domains checked on demand, sent by the users while the scan is running
+	onDemandChecks    *onDemandChecks    // Domains checked on demand that are waiting for the queriers
+	finished          chan bool          // Closed when the dispatcher doesn't receive domains anymore
 }
 
-// Return a new QuerierDispatcher object with the necessary fields for the scan filled
+// Return a new QuerierDispatcher object with the necessary fields for the scan filled. The
+// rate limit and the priority weights start with the default values, and the nameservers
+// are resolved by the operating system until the resolvers are defined
 func NewQuerierDispatcher(
 	numberOfQueriers,
 	domainsBufferSize int,
 	udpMaxSize uint16,
 	dialTimeout, readTimeout, writeTimeout time.Duration,
 	connectionRetries int,
+	resolver string,
 ) *QuerierDispatcher {
 
 	return &QuerierDispatcher{
@@ -44,9 +57,34 @@ func NewQuerierDispatcher(
 		ReadTimeout:       readTimeout,
 		WriteTimeout:      writeTimeout,
 		ConnectionRetries: connectionRetries,
+		Resolver:          resolver,
+		RateLimit:         defaultRateLimit(),
+		PriorityWeights:   defaultPriorityWeights(),
+		onDemand:          make(chan onDemandCheck),
+		onDemandChecks:    newOnDemandChecks(),
+		finished:          make(chan bool),
 	}
 }
 
+// Check a domain on demand using the queriers of the running scan. The domain goes before
+// the domains of the scan waiting in the priority queue and this method blocks until the
+// domain is checked. Returns false when the domain couldn't be checked, because the
+// dispatcher already finished or the scan was canceled
+func (q *QuerierDispatcher) checkOnDemand(domain *model.Domain) bool {
+	check := onDemandCheck{
+		domain:  domain,
+		checked: make(chan bool, 1),
+	}
+
+	select {
+	case q.onDemand <- check:
+	case <-q.finished:
+		return false
+	}
+
+	return <-check.checked
+}
+
 // This is the method that start the querier dispatcher and the queriers. It is
 // asynchronous and will ends after receiving the poison pill from the injector. It
 // receives a object to sinalize to the main thread the end and a channel that tells the
@@ -58,24 +96,40 @@ func (q *QuerierDispatcher) Start(scanGroup *sync.WaitGroup,
 	// the poison pill is the nil domain object
 	domainsToSaveChannel := make(chan *model.Domain, q.DomainsBufferSize)
 
-	// Allocate the number of queriers
-	queriersChannels := make([]chan *model.Domain, q.NumberOfQueriers)
-
 	// Create a sync group to control the end of all queriers. The dispatcher can only ends
 	// after all queriers finished their jobs
 	var queriers sync.WaitGroup
 
-	// Initialize each querier
-	for index, _ := range queriersChannels {
+	// Initialize a querier, returning the channel used to send domains to it and the
+	// channel closed to remove it
+	startQuerier := func() (chan *model.Domain, chan bool) {
 		querier := newQuerier(
 			q.UDPMaxSize,
 			q.DialTimeout,
 			q.ReadTimeout,
 			q.WriteTimeout,
 			q.ConnectionRetries,
+			q.Resolver,
+		)
+		querier.nameResolver = newNameResolver(
+			q.Resolvers,
+			q.DialTimeout,
+			q.ReadTimeout,
+			q.WriteTimeout,
 		)
+		querier.RateLimit = q.RateLimit
+		querier.control = q.control
+		querier.onDemand = q.onDemandChecks
 
-		queriersChannels[index] = querier.start(&queriers, domainsToSaveChannel)
+		stop := make(chan bool)
+		return querier.start(&queriers, domainsToSaveChannel, stop), stop
+	}
+
+	// Allocate the number of queriers
+	queriersChannels := make([]chan *model.Domain, q.NumberOfQueriers)
+	queriersStops := make([]chan bool, q.NumberOfQueriers)
+	for index, _ := range queriersChannels {
+		queriersChannels[index], queriersStops[index] = startQuerier()
 	}
 
 	// Add one more to the group of scan go routines
@@ -84,12 +138,81 @@ func (q *QuerierDispatcher) Start(scanGroup *sync.WaitGroup,
 	go func() {
 		index := 0
 
+		// Domains received from the injector wait in a priority queue, so that the most
+		// important domains are sent first to the queriers. The queue stores at most the
+		// size of the domains buffer, as the injector can load many domains faster than the
+		// queriers check them. The database query already returns the domains ordered by the
+		// priority stored when they were scheduled, and the queue corrects the order inside
+		// this window with the current priority. A domain alone in the queue is sent
+		// immediately whatever its priority is
+		domainsToDispatch := priorityQueue{weights: q.PriorityWeights}
+		windowSize := q.DomainsBufferSize
+		if windowSize <= 0 {
+			windowSize = 1
+		}
+
+		finished := false
+		canceled := q.control.done()
+
 		for {
-			// Retrieve a domain from the injector
-			domain := <-domainsToQueryChannel
+			// The user can change the number of queriers while the scan is running. The
+			// removed queriers are stopped without waiting for them, as they could be busy
+			// with the domains that they already received
+			numberOfQueriers := q.control.queriers(len(queriersChannels))
+			for len(queriersChannels) < numberOfQueriers {
+				querierChannel, stop := startQuerier()
+				queriersChannels = append(queriersChannels, querierChannel)
+				queriersStops = append(queriersStops, stop)
+			}
+
+			for len(queriersChannels) > numberOfQueriers {
+				last := len(queriersChannels) - 1
+				close(queriersStops[last])
+				queriersChannels = queriersChannels[:last]
+				queriersStops = queriersStops[:last]
+			}
+
+			// When the user cancels the scan the domains waiting in the queue are discarded.
+			// The domains that weren't checked are selected again in the next scan
+			if q.control.isCanceled() {
+				for _, prioritized := range domainsToDispatch.domains {
+					q.onDemandChecks.finish(prioritized.domain, false)
+				}
+				domainsToDispatch = priorityQueue{weights: q.PriorityWeights}
+			}
+
+			// We only receive more domains from the injector while there's space in the
+			// queue, and only send a domain to a querier when there's a domain in the queue
+			var input chan *model.Domain
+			if !finished && domainsToDispatch.Len() < windowSize {
+				input = domainsToQueryChannel
+			}
+
+			// We are going to use a round robin strategy to distribute the domains for the
+			// queriers, so if we reach the last channel, go back to the first one
+			if index >= len(queriersChannels) {
+				index = 0
+			}
+
+			var output chan *model.Domain
+			var domain *model.Domain
+			if domainsToDispatch.Len() > 0 {
+				output = queriersChannels[index]
+				domain = domainsToDispatch.first()
+
+				// A domain checked on demand goes to the querier with less domains waiting, so
+				// that the user waits as little as possible
+				if domainsToDispatch.firstOnDemand() {
+					output = leastBusy(queriersChannels)
+				}
+			}
+
+			// Detect the end of the domains from the injector
+			if input == nil && output == nil {
+				// Stop receiving domains checked on demand, the users will check them without
+				// the scan
+				close(q.finished)
 
-			// Detect the poinson pill from the injector
-			if domain == nil {
 				// Finish all queriers sending a nil domain
 				for _, queriersChannels := range queriersChannels {
 					queriersChannels <- nil
@@ -98,6 +221,10 @@ func (q *QuerierDispatcher) Start(scanGroup *sync.WaitGroup,
 				// Wait for queriers to finish
 				queriers.Wait()
 
+				// Domains checked on demand that the queriers discarded, because the scan was
+				// canceled
+				q.onDemandChecks.finishAll()
+
 				// Send the poison pill to the collector
 				domainsToSaveChannel <- nil
 
@@ -105,19 +232,50 @@ func (q *QuerierDispatcher) Start(scanGroup *sync.WaitGroup,
 				return
 			}
 
-			// We are going to use a round robin strategy to distribute the domains for the
-			// queriers, so if we reach the last channel, go back to the first one
-			if index >= len(queriersChannels) {
-				index = 0
-			}
+			select {
+			case domain := <-input:
+				// Detect the poinson pill from the injector
+				if domain == nil {
+					finished = true
+				} else if !q.control.isCanceled() {
+					domainsToDispatch.add(domain)
+				}
+
+			case check := <-q.onDemand:
+				if q.control.isCanceled() {
+					check.checked <- false
+				} else {
+					q.onDemandChecks.add(check)
+					domainsToDispatch.addOnDemand(check.domain)
+				}
 
-			// Send to the querier a domain
-			queriersChannels[index] <- domain
+			case output <- domain:
+				// The querier received the domain with the highest priority, remove it from the
+				// queue and move to the next querier. The domains checked on demand don't change
+				// the round robin of the scan
+				if !domainsToDispatch.firstOnDemand() {
+					index += 1
+				}
+				domainsToDispatch.next()
 
-			// Move to the next querier
-			index += 1
+			case <-canceled:
+				// Wake up to discard the domains waiting in the queue, even when the queriers
+				// are busy. A closed channel is always ready, so we stop watching it
+				canceled = nil
+			}
 		}
 	}()
 
 	return domainsToSaveChannel
 }
+
+// Choose the querier with less domains waiting in its channel
+func leastBusy(queriersChannels []chan *model.Domain) chan *model.Domain {
+	least := queriersChannels[0]
+	for _, querierChannel := range queriersChannels[1:] {
+		if len(querierChannel) < len(least) {
+			least = querierChannel
+		}
+	}
+	return least
+}
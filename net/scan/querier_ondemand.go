@@ -0,0 +1,118 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"github.com/rafaeljusto/shelter/model"
+	"sync"
+)
+
+var (
+	// Dispatcher of the scan that is checking domains in this instance, nil when there's no
+	// scan being executed. The on-demand checks are sent to it, so that they share the
+	// queriers and the priority queue of the scan
+	activeDispatcher     *QuerierDispatcher
+	activeDispatcherLock sync.Mutex
+)
+
+// Structure used to send a domain checked on demand to the dispatcher. The result is sent
+// back in the checked channel: true when the domain was checked, or false when the
+// dispatcher couldn't check it, as the scan was canceled or is finishing
+type onDemandCheck struct {
+	domain  *model.Domain // Domain that the user is waiting for
+	checked chan bool     // Result of the check, it must have space for the result
+}
+
+// onDemandChecks stores the domains checked on demand that are waiting in the dispatcher
+// or in the queriers, so that the querier returns them to the user instead of sending them
+// to the collector. All methods can be called on a nil object, for the dispatchers that
+// don't receive on-demand checks
+type onDemandChecks struct {
+	sync.Mutex
+	checks map[*model.Domain]chan bool // Channel of the user waiting for each domain
+}
+
+// Return a new object to store the on-demand checks of a dispatcher
+func newOnDemandChecks() *onDemandChecks {
+	return &onDemandChecks{
+		checks: make(map[*model.Domain]chan bool),
+	}
+}
+
+// Store the on-demand check until the domain is checked
+func (o *onDemandChecks) add(check onDemandCheck) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.checks[check.domain] = check.checked
+}
+
+// Check if the domain was sent on demand by a user
+func (o *onDemandChecks) has(domain *model.Domain) bool {
+	if o == nil {
+		return false
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	_, found := o.checks[domain]
+	return found
+}
+
+// Send the result to the user waiting for the domain. Returns false when the domain
+// wasn't sent on demand, so it belongs to the scan
+func (o *onDemandChecks) finish(domain *model.Domain, checked bool) bool {
+	if o == nil {
+		return false
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	checkedChannel, found := o.checks[domain]
+	if !found {
+		return false
+	}
+
+	delete(o.checks, domain)
+	checkedChannel <- checked
+	return true
+}
+
+// Tell the users waiting for the remaining domains that they weren't checked, used when
+// the dispatcher finishes
+func (o *onDemandChecks) finishAll() {
+	if o == nil {
+		return
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	for domain, checkedChannel := range o.checks {
+		delete(o.checks, domain)
+		checkedChannel <- false
+	}
+}
+
+// Register the dispatcher of the scan that started checking domains in this instance, or
+// remove it using nil when the scan finished
+func setActiveDispatcher(querierDispatcher *QuerierDispatcher) {
+	activeDispatcherLock.Lock()
+	defer activeDispatcherLock.Unlock()
+
+	activeDispatcher = querierDispatcher
+}
+
+// Retrieve the dispatcher of the scan that is checking domains in this instance, nil when
+// there's no scan being executed
+func getActiveDispatcher() *QuerierDispatcher {
+	activeDispatcherLock.Lock()
+	defer activeDispatcherLock.Unlock()
+
+	return activeDispatcher
+}
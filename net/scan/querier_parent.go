@@ -0,0 +1,189 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/dspolicy"
+	"strings"
+	"sync"
+)
+
+var (
+	// Global variable used by all queriers (go routines) to access the parent zones
+	// nameservers. Many domains have the same parent zone, so we avoid asking the resolver
+	// for the same information again and again
+	parentCache ParentCache
+
+	// Error returned when we couldn't find the nameservers of the parent zone using the
+	// configured resolver
+	ErrParentNotFound = errors.New("Could not find the parent zone nameservers")
+)
+
+func init() {
+	parentCache = ParentCache{
+		zones: make(map[string]parentZone),
+	}
+}
+
+// parentZone stores the name of the zone cut above a domain and the hosts of the
+// authoritative nameservers of this zone
+type parentZone struct {
+	name        string   // Parent zone name
+	nameservers []string // Parent zone nameservers' hosts
+}
+
+// ParentCache was created to store the nameservers of the parent zones, so that we don't
+// need to query the resolver every time that we check the DS records of a domain
+type ParentCache struct {
+	zones     map[string]parentZone // key-value structure that store parent zone data
+	zonesLock sync.RWMutex          // Lock to allow concurrent access
+}
+
+// Method used to retrieve the parent zone that was already found for a given name
+func (p *ParentCache) get(name string) (parentZone, bool) {
+	p.zonesLock.RLock()
+	defer p.zonesLock.RUnlock()
+
+	zone, found := p.zones[name]
+	return zone, found
+}
+
+// Method used to store the parent zone found for a given name
+func (p *ParentCache) set(name string, zone parentZone) {
+	p.zonesLock.Lock()
+	defer p.zonesLock.Unlock()
+
+	p.zones[name] = zone
+}
+
+// Clear cache. This method is for now used in integration test scenarios to get more
+// realistic results in performance reports
+func (p *ParentCache) Clear() {
+	p.zonesLock.Lock()
+	p.zones = make(map[string]parentZone)
+	p.zonesLock.Unlock()
+}
+
+// Check if the DS records registered for the domain are really published in the parent
+// zone and if the parent zone doesn't publish other DS records, what is also checked for
+// unsigned domains. We query the authoritative nameservers of the parent zone directly,
+// because a resolver could answer with a cached DS RRset. Network problems with the
+// parent zone are not a problem of the domain, so in this case we keep the current DS
+// status and only register that the parent zone wasn't checked
+func (q *querier) checkParentDS(domain *model.Domain) {
+	if len(q.Resolver) == 0 {
+		domain.ParentDS.ChangeStatus(model.DSStatusNotChecked, nil, model.Diagnostic{
+			Error: "No resolver configured to find the parent zone nameservers",
+		})
+		return
+	}
+
+	zone, err := q.findParentZone(domain.FQDN)
+	if err != nil {
+		domain.ParentDS.ChangeStatus(model.DSStatusNotChecked, nil, model.Diagnostic{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	var dnsRequestMessage dns.Msg
+	dnsRequestMessage.SetQuestion(domain.FQDN, dns.TypeDS)
+	dnsRequestMessage.RecursionDesired = false
+	dnsRequestMessage.SetEdns0(q.UDPMaxSize, true)
+
+	var problems []string
+
+	for _, nameserverHost := range zone.nameservers {
+		nameserver := model.Nameserver{
+			Host: nameserverHost,
+		}
+
+		host, err := q.getHost(zone.name, nameserver)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", nameserverHost, err))
+			continue
+		}
+
+		dnsResponseMessage, err := q.sendDNSRequest(host, &dnsRequestMessage)
+		querierCache.Query(nameserver.Host, q.RateLimit)
+
+		// Try the next parent nameserver when something goes wrong, the parent zone should
+		// have more than one nameserver
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", nameserverHost, err))
+			continue
+
+		} else if !dnsResponseMessage.Authoritative {
+			problems = append(problems, fmt.Sprintf("%s: answer not authoritative", nameserverHost))
+			continue
+		}
+
+		domainDSPolicy := dspolicy.NewDomainDSPolicy(domain)
+		domainDSPolicy.RunParent(dnsResponseMessage)
+		return
+	}
+
+	// None of the parent zone nameservers could be used, including the ones that we
+	// didn't query to respect the rate limit
+	domain.ParentDS.ChangeStatus(model.DSStatusNotChecked, nil, model.Diagnostic{
+		Error: "Could not query the parent zone nameservers: " + strings.Join(problems, ", "),
+	})
+}
+
+// Find the zone cut above the domain asking the resolver for the NS records of each
+// ancestor name, until we find one that has nameservers. For a domain like
+// example.com.br. we will try com.br. and then br.
+func (q *querier) findParentZone(fqdn string) (parentZone, error) {
+	labels := dns.SplitDomainName(fqdn)
+
+	for i := 1; i <= len(labels); i++ {
+		name := "."
+		if i < len(labels) {
+			name = dns.Fqdn(strings.Join(labels[i:], "."))
+		}
+
+		if zone, found := parentCache.get(name); found {
+			return zone, nil
+		}
+
+		var dnsRequestMessage dns.Msg
+		dnsRequestMessage.SetQuestion(name, dns.TypeNS)
+		dnsRequestMessage.RecursionDesired = true
+
+		// Allow retrieving the parent zone information when there's a DNSSEC problem in the
+		// chain-of-trust
+		dnsRequestMessage.CheckingDisabled = true
+
+		dnsResponseMessage, err := q.sendDNSRequest(q.Resolver, &dnsRequestMessage)
+		if err != nil {
+			return parentZone{}, err
+		}
+
+		zone := parentZone{
+			name: name,
+		}
+
+		for _, answer := range dnsResponseMessage.Answer {
+			nsRecord, ok := answer.(*dns.NS)
+			if !ok || !strings.EqualFold(nsRecord.Hdr.Name, name) {
+				continue
+			}
+
+			zone.nameservers = append(zone.nameservers, nsRecord.Ns)
+		}
+
+		if len(zone.nameservers) > 0 {
+			parentCache.set(name, zone)
+			return zone, nil
+		}
+	}
+
+	return parentZone{}, ErrParentNotFound
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"container/heap"
+	"github.com/rafaeljusto/shelter/log"
+	"github.com/rafaeljusto/shelter/model"
+	"time"
+)
+
+const (
+	// Number of times that a domain can be postponed because of the hosts' rate limit, used
+	// when the configuration doesn't define it. After that the domain is dropped from the
+	// scan and will be selected again in the next scan
+	defaultMaxPostponements = 10
+)
+
+// Structure to store domains that will be postponed because of to many queries sent to
+// onbly one host
+type postponedDomain struct {
+	domain        *model.Domain // Domain postponed
+	index         int           // Host index that exceeded QPS
+	postponements int           // Number of times that the domain was postponed
+	readyAt       time.Time     // Time that the host will accept queries again
+}
+
+// postponedQueue is a delay queue that stores the postponed domains of a querier ordered
+// by the time that they can be checked again. It's a heap, so the next domain to be
+// checked is always the first one
+type postponedQueue []postponedDomain
+
+func (p postponedQueue) Len() int           { return len(p) }
+func (p postponedQueue) Less(i, j int) bool { return p[i].readyAt.Before(p[j].readyAt) }
+func (p postponedQueue) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (p *postponedQueue) Push(x interface{}) {
+	*p = append(*p, x.(postponedDomain))
+}
+
+func (p *postponedQueue) Pop() interface{} {
+	old := *p
+	postponed := old[len(old)-1]
+	*p = old[:len(old)-1]
+	return postponed
+}
+
+// Add a domain to the queue, waiting until the host that exceeded the QPS has tokens
+// available again according to the rate limit. When the domain was postponed too many
+// times it is dropped, to avoid an almost forever loop when we have a lot of domains with
+// the same nameserver. Returns false when the domain was dropped
+func (p *postponedQueue) postpone(postponed postponedDomain, rateLimit RateLimit) bool {
+	postponed.postponements += 1
+	if postponed.postponements > rateLimit.MaxPostponements {
+		log.Debugf("Domain %s dropped from the scan after %d postponements",
+			postponed.domain.FQDN, rateLimit.MaxPostponements)
+
+		model.DroppedDomainForScan()
+		return false
+	}
+
+	host := postponed.domain.Nameservers[postponed.index].Host
+	postponed.readyAt = time.Now().Add(querierCache.Delay(host, rateLimit))
+	heap.Push(p, postponed)
+
+	model.PostponedDomainForScan()
+	return true
+}
+
+// Time that we need to wait until the first domain of the queue can be checked again. The
+// queue must not be empty
+func (p postponedQueue) wait() time.Duration {
+	wait := p[0].readyAt.Sub(time.Now())
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+// Remove the first domain of the queue. The queue must not be empty
+func (p *postponedQueue) next() postponedDomain {
+	return heap.Pop(p).(postponedDomain)
+}
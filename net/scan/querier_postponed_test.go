@@ -0,0 +1,61 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"github.com/rafaeljusto/shelter/model"
+	"testing"
+	"time"
+)
+
+func TestPostponedQueue(t *testing.T) {
+	rateLimit := RateLimit{QueriesPerSecond: 10, Burst: 1, MaxPostponements: 10}
+
+	querierCache.hosts = map[string]*hostCache{
+		"ns1.example.com.br.": {bucket: tokenBucket{tokens: -1, updatedAt: time.Now()}},
+		"ns2.example.com.br.": {bucket: tokenBucket{tokens: 0, updatedAt: time.Now()}},
+	}
+
+	slow := &model.Domain{
+		FQDN:        "slow.com.br.",
+		Nameservers: []model.Nameserver{{Host: "ns1.example.com.br."}},
+	}
+
+	fast := &model.Domain{
+		FQDN: "fast.com.br.",
+		Nameservers: []model.Nameserver{
+			{Host: "ns1.example.com.br."},
+			{Host: "ns2.example.com.br."},
+		},
+	}
+
+	var postponedDomains postponedQueue
+	postponedDomains.postpone(postponedDomain{domain: slow}, rateLimit)
+	postponedDomains.postpone(postponedDomain{domain: fast, index: 1}, rateLimit)
+
+	if postponedDomains.Len() != 2 {
+		t.Fatal("Not storing the postponed domains")
+	}
+
+	if wait := postponedDomains.wait(); wait <= 0 || wait > 100*time.Millisecond {
+		t.Errorf("Not waiting for the host of the first domain. Waiting %s", wait)
+	}
+
+	postponed := postponedDomains.next()
+	if postponed.domain != fast || postponed.index != 1 || postponed.postponements != 1 {
+		t.Error("Not ordering the postponed domains by the time that they can be checked")
+	}
+
+	postponed = postponedDomains.next()
+	if postponed.domain != slow {
+		t.Error("Losing postponed domains")
+	}
+
+	postponed.postponements = rateLimit.MaxPostponements
+	if postponedDomains.postpone(postponed, rateLimit) || postponedDomains.Len() != 0 {
+		t.Error("Not dropping a domain after too many postponements")
+	}
+}
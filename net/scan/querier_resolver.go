@@ -0,0 +1,255 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"errors"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/dnsutils"
+	"net"
+	"time"
+)
+
+var (
+	// Maximum time that a nameserver's name that doesn't exist stays in the cache. The
+	// negative TTL of the zone (RFC 2308) is used when it's lower
+	MaxNegativeTTL = 15 * time.Minute
+
+	// Time that a nameserver's name stays in the cache after all resolvers failed, so that
+	// we don't send to the resolvers the queries that are going to fail again
+	FailureTTL = 1 * time.Minute
+
+	// Error returned when the nameserver's name doesn't exist or doesn't have addresses
+	ErrHostUnknown = errors.New("Nameserver's name doesn't exist or doesn't have addresses")
+
+	// Error returned when the resolvers failed to resolve the nameserver's name, so we don't
+	// know if the name exists
+	ErrHostResolution = errors.New("Nameserver's name couldn't be resolved")
+)
+
+// nameResolver store the recursive DNS servers used to resolve the nameservers' names and
+// the timeouts of the scan, used in the queries sent to them. The zero value resolves the
+// names with the operating system
+type nameResolver struct {
+	addresses    []string      // Recursive DNS servers (address:port), in the order that they are used
+	dialTimeout  time.Duration // Timeout while connecting to a resolver
+	readTimeout  time.Duration // Timeout while waiting for a response
+	writeTimeout time.Duration // Timeout to write a query to the resolver
+}
+
+// Return a new nameResolver that sends the queries to the given recursive DNS servers
+// (address:port) with the timeouts of the scan
+func newNameResolver(addresses []string,
+	dialTimeout, readTimeout, writeTimeout time.Duration) nameResolver {
+
+	return nameResolver{
+		addresses:    addresses,
+		dialTimeout:  dialTimeout,
+		readTimeout:  readTimeout,
+		writeTimeout: writeTimeout,
+	}
+}
+
+// resolution store the result of a nameserver's name resolution. The diagnostic and the
+// error are only filled when the resolution failed
+type resolution struct {
+	addresses  []net.IP         // Addresses of the nameserver
+	expiresAt  time.Time        // When the result must be resolved again, zero if it never expires
+	diagnostic model.Diagnostic // Details of the resolution failure
+	err        error            // ErrHostUnknown or ErrHostResolution
+}
+
+// Resolve the addresses (IPv4 and IPv6) of the nameserver's name with the recursive DNS
+// servers, in the order that they are given. The next resolver is only used when the
+// previous one doesn't answer or answers with a failure. When there's no resolver, the
+// name is resolved by the operating system. The result expires according to the TTL of
+// the records, or according to the negative cache limits when the resolution failed. Some
+// resolvers answer NXDOMAIN only for one of the types, so the name is only unknown when
+// no type returned addresses
+func (r nameResolver) resolve(name string) resolution {
+	if len(r.addresses) == 0 {
+		return resolveWithSystem(name)
+	}
+
+	now := time.Now()
+	var result resolution
+
+	var response, nxdomainResponse *dns.Msg
+	var diagnostic, nxdomainDiagnostic model.Diagnostic
+	var err error
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		response, diagnostic, err = r.exchange(name, qtype)
+		if err != nil && len(result.addresses) > 0 {
+			// The IPv4 addresses are enough to check the nameserver
+			break
+
+		} else if err != nil {
+			return resolution{
+				expiresAt:  now.Add(FailureTTL),
+				diagnostic: diagnostic,
+				err:        ErrHostResolution,
+			}
+		}
+
+		if response.Rcode == dns.RcodeNameError {
+			if nxdomainResponse == nil {
+				nxdomainResponse, nxdomainDiagnostic = response, diagnostic
+			}
+			continue
+		}
+
+		// The resolver can answer with the CNAME chain of the name, so the addresses are only
+		// valid while all records of the answer are valid
+		for _, rr := range response.Answer {
+			switch record := rr.(type) {
+			case *dns.A:
+				result.addresses = append(result.addresses, record.A)
+			case *dns.AAAA:
+				result.addresses = append(result.addresses, record.AAAA)
+			}
+
+			expiresAt := now.Add(time.Duration(rr.Header().Ttl) * time.Second)
+			if result.expiresAt.IsZero() || expiresAt.Before(result.expiresAt) {
+				result.expiresAt = expiresAt
+			}
+		}
+	}
+
+	if len(result.addresses) == 0 && nxdomainResponse != nil {
+		nxdomainDiagnostic.Error = "Nameserver's name doesn't exist"
+		return resolution{
+			expiresAt:  now.Add(negativeTTL(nxdomainResponse)),
+			diagnostic: nxdomainDiagnostic,
+			err:        ErrHostUnknown,
+		}
+
+	} else if len(result.addresses) == 0 {
+		diagnostic.Error = "Nameserver's name doesn't have addresses (A or AAAA records)"
+		return resolution{
+			expiresAt:  now.Add(negativeTTL(response)),
+			diagnostic: diagnostic,
+			err:        ErrHostUnknown,
+		}
+	}
+
+	return result
+}
+
+// Send the query to the resolvers, in order, until one of them answers without a failure.
+// A response with NXDOMAIN isn't a failure, because it's a valid answer about the name.
+// When all resolvers fail, the diagnostic of the last resolver is returned
+func (r nameResolver) exchange(name string, qtype uint16) (*dns.Msg, model.Diagnostic, error) {
+
+	var dnsRequestMessage dns.Msg
+	dnsRequestMessage.SetQuestion(dns.Fqdn(name), qtype)
+	dnsRequestMessage.RecursionDesired = true
+
+	var diagnostic model.Diagnostic
+	for _, resolver := range r.addresses {
+		dnsResponseMessage, err := r.send(&dnsRequestMessage, resolver)
+		if err != nil {
+			diagnostic = dnsutils.FillDiagnostic(model.Diagnostic{
+				Error: err.Error(),
+			}, resolver, &dnsRequestMessage, nil)
+			continue
+		}
+
+		diagnostic = dnsutils.FillDiagnostic(model.Diagnostic{}, resolver,
+			&dnsRequestMessage, dnsResponseMessage)
+
+		if dnsResponseMessage.Rcode != dns.RcodeSuccess &&
+			dnsResponseMessage.Rcode != dns.RcodeNameError {
+
+			diagnostic.Error = "Resolver failed to resolve the nameserver's name"
+			continue
+		}
+
+		return dnsResponseMessage, diagnostic, nil
+	}
+
+	return nil, diagnostic, ErrHostResolution
+}
+
+// Send the query to one resolver. When the response is truncated the query is sent again
+// over TCP, as a nameserver with many addresses could not fit in a UDP response
+func (r nameResolver) send(dnsRequestMessage *dns.Msg, resolver string) (*dns.Msg, error) {
+	client := dns.Client{
+		DialTimeout:  r.dialTimeout,
+		ReadTimeout:  r.readTimeout,
+		WriteTimeout: r.writeTimeout,
+	}
+
+	dnsResponseMessage, _, err := client.Exchange(dnsRequestMessage, resolver)
+	if err != nil || !dnsResponseMessage.Truncated {
+		return dnsResponseMessage, err
+	}
+
+	client.Net = "tcp"
+	dnsResponseMessage, _, err = client.Exchange(dnsRequestMessage, resolver)
+	return dnsResponseMessage, err
+}
+
+// Retrieve the time that a negative answer can stay in the cache, from the SOA record of
+// the authority section (RFC 2308). The time is limited by MaxNegativeTTL
+func negativeTTL(dnsResponseMessage *dns.Msg) time.Duration {
+	ttl := MaxNegativeTTL
+
+	if dnsResponseMessage == nil {
+		return ttl
+	}
+
+	soa, ok := dnsutils.FilterFirstRR(dnsResponseMessage.Ns, dns.TypeSOA).(*dns.SOA)
+	if !ok {
+		return ttl
+	}
+
+	soaTTL := soa.Hdr.Ttl
+	if soa.Minttl < soaTTL {
+		soaTTL = soa.Minttl
+	}
+
+	if time.Duration(soaTTL)*time.Second < ttl {
+		ttl = time.Duration(soaTTL) * time.Second
+	}
+
+	return ttl
+}
+
+// Resolve the nameserver's name with the operating system, used when there's no resolver
+// configured. The TTL of the records isn't known, so the addresses never expire
+func resolveWithSystem(name string) resolution {
+	addresses, err := net.LookupIP(name)
+	if err == nil && len(addresses) > 0 {
+		return resolution{
+			addresses: addresses,
+		}
+	}
+
+	diagnostic := model.Diagnostic{
+		Query: dns.Fqdn(name),
+		Error: "Nameserver's name doesn't have addresses",
+	}
+
+	if err != nil {
+		diagnostic.Error = err.Error()
+	}
+
+	if dnsErr, ok := err.(*net.DNSError); err == nil || (ok && dnsErr.IsNotFound) {
+		return resolution{
+			expiresAt:  time.Now().Add(MaxNegativeTTL),
+			diagnostic: diagnostic,
+			err:        ErrHostUnknown,
+		}
+	}
+
+	return resolution{
+		expiresAt:  time.Now().Add(FailureTTL),
+		diagnostic: diagnostic,
+		err:        ErrHostResolution,
+	}
+}
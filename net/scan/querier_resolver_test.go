@@ -0,0 +1,232 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"net"
+	"testing"
+	"time"
+)
+
+// Start a DNS server in a random local port, over UDP and TCP, to simulate a resolver. The
+// function returns the address of the server and a function to stop it
+func startResolver(t *testing.T, handler dns.HandlerFunc) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	udpServer := &dns.Server{
+		PacketConn: conn,
+		Handler:    handler,
+	}
+
+	tcpServer := &dns.Server{
+		Listener: listener,
+		Handler:  handler,
+	}
+
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+
+	return conn.LocalAddr().String(), func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	}
+}
+
+func TestResolve(t *testing.T) {
+	servfail, stopServfail := startResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+	defer stopServfail()
+
+	resolver, stopResolver := startResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		// The server receives an empty message when it's stopped
+		if len(r.Question) == 0 {
+			return
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		name, qtype := r.Question[0].Name, r.Question[0].Qtype
+
+		switch {
+		case name == "ns1.example.com.br." && qtype == dns.TypeA:
+			m.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+					A:   net.ParseIP("192.0.2.1"),
+				},
+			}
+
+		case name == "ns1.example.com.br." && qtype == dns.TypeAAAA:
+			m.Answer = []dns.RR{
+				&dns.AAAA{
+					Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+					AAAA: net.ParseIP("2001:db8::1"),
+				},
+			}
+
+		case name == "ns2.example.com.br.":
+			// Name without addresses (NODATA)
+
+		case name == "ns3.example.com.br." && qtype == dns.TypeA:
+			m.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+					A:   net.ParseIP("192.0.2.3"),
+				},
+			}
+
+		case name == "ns4.example.com.br." && qtype == dns.TypeA:
+			// Only the TCP response has the addresses
+			if _, ok := w.RemoteAddr().(*net.TCPAddr); !ok {
+				m.Truncated = true
+				break
+			}
+
+			m.Answer = []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+					A:   net.ParseIP("192.0.2.4"),
+				},
+			}
+
+		case name == "ns4.example.com.br.":
+			// Name without IPv6 addresses (NODATA)
+
+		default:
+			m.Rcode = dns.RcodeNameError
+			m.Ns = []dns.RR{
+				&dns.SOA{
+					Hdr:    dns.RR_Header{Name: "br.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 900},
+					Ns:     "a.dns.br.",
+					Mbox:   "hostmaster.registro.br.",
+					Minttl: 120,
+				},
+			}
+		}
+
+		w.WriteMsg(m)
+	})
+	defer stopResolver()
+
+	r := newNameResolver([]string{servfail, resolver}, time.Second, time.Second, time.Second)
+	now := time.Now()
+
+	result := r.resolve("ns1.example.com.br.")
+	if result.err != nil {
+		t.Fatalf("Not failing over to the next resolver. Got %s", result.err)
+	}
+
+	if len(result.addresses) != 2 ||
+		!result.addresses[0].Equal(net.ParseIP("192.0.2.1")) ||
+		!result.addresses[1].Equal(net.ParseIP("2001:db8::1")) {
+
+		t.Errorf("Not resolving the addresses correctly. Got %v", result.addresses)
+	}
+
+	if result.expiresAt.Before(now.Add(60*time.Second)) ||
+		result.expiresAt.After(now.Add(61*time.Second)) {
+
+		t.Error("Not using the lowest TTL of the records")
+	}
+
+	result = r.resolve("ns2.example.com.br.")
+	if result.err != ErrHostUnknown || result.diagnostic.Address != resolver {
+		t.Error("Not detecting a name without addresses")
+	}
+
+	// The resolver answers NXDOMAIN only for the AAAA query
+	result = r.resolve("ns3.example.com.br.")
+	if result.err != nil || len(result.addresses) != 1 {
+		t.Error("Not ignoring a NXDOMAIN of only one of the types")
+	}
+
+	result = r.resolve("ns4.example.com.br.")
+	if result.err != nil || len(result.addresses) != 1 ||
+		!result.addresses[0].Equal(net.ParseIP("192.0.2.4")) {
+
+		t.Error("Not retrying over TCP when the response is truncated")
+	}
+
+	result = r.resolve("ns1.unknown.com.br.")
+	if result.err != ErrHostUnknown {
+		t.Fatal("Not detecting a name that doesn't exist")
+	}
+
+	if result.diagnostic.Rcode != "NXDOMAIN" ||
+		result.diagnostic.Query != "ns1.unknown.com.br. IN A" {
+
+		t.Errorf("Wrong diagnostic for a name that doesn't exist: %#v", result.diagnostic)
+	}
+
+	if result.expiresAt.After(now.Add(121 * time.Second)) {
+		t.Error("Not using the negative TTL of the zone")
+	}
+
+	r = newNameResolver([]string{servfail}, time.Second, time.Second, time.Second)
+	result = r.resolve("ns1.example.com.br.")
+	if result.err != ErrHostResolution || result.diagnostic.Rcode != "SERVFAIL" {
+		t.Error("Not detecting when all resolvers failed")
+	}
+
+	if result.expiresAt.After(time.Now().Add(FailureTTL)) {
+		t.Error("Caching the resolution failure for too long")
+	}
+}
+
+func TestQuerierCacheLookup(t *testing.T) {
+	querierCache.hosts = map[string]*hostCache{
+		"ns1.example.com.br.": {
+			expiresAt: time.Now().Add(time.Hour),
+			diagnostic: model.Diagnostic{
+				Query: "ns1.example.com.br. IN A",
+				Rcode: "SERVFAIL",
+			},
+			err:    ErrHostResolution,
+			bucket: newTokenBucket(time.Now(), defaultRateLimit()),
+		},
+	}
+
+	nameserver := model.Nameserver{Host: "ns1.example.com.br."}
+
+	_, diagnostic, err := querierCache.lookup(nameserver, "example.com.br.", nameResolver{},
+		defaultRateLimit())
+	if err != ErrHostResolution || diagnostic.Rcode != "SERVFAIL" {
+		t.Error("Not using the resolution failure stored in the cache")
+	}
+
+	nameserver.IPv4 = net.ParseIP("192.0.2.1")
+	querierCache.hosts["ns1.example.com.br."].expiresAt = time.Now().Add(-time.Second)
+	querierCache.hosts["ns1.example.com.br."].timeouts = 10
+
+	addresses, _, err := querierCache.lookup(nameserver, "example.com.br.", nameResolver{},
+		defaultRateLimit())
+	if err != nil || len(addresses) != 1 {
+		t.Fatal("Not resolving again an expired host")
+	}
+
+	h := querierCache.hosts["ns1.example.com.br."]
+	if h.err != nil || !h.expiresAt.IsZero() {
+		t.Error("Not replacing the resolution result of an expired host")
+	}
+
+	if h.timeouts != 10 {
+		t.Error("Not keeping the counters of an expired host")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package scan is the scan service
+package scan
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/dspolicy"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckFragmentation(t *testing.T) {
+	domainDSPolicy := dspolicy.NewDomainDSPolicy(&model.Domain{
+		FQDN: "example.com.br.",
+	})
+	requests := domainDSPolicy.FragmentationRequests(4096)
+
+	buildResponse := func(r *dns.Msg) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.SetEdns0(r.IsEdns0().UDPSize(), true)
+		m.Answer = []dns.RR{
+			&dns.DNSKEY{
+				Hdr: dns.RR_Header{
+					Name:   r.Question[0].Name,
+					Rrtype: dns.TypeDNSKEY,
+					Class:  dns.ClassINET,
+					Ttl:    3600,
+				},
+				Flags:     257,
+				Protocol:  3,
+				Algorithm: dns.RSASHA256,
+				PublicKey: strings.Repeat("A", 684),
+			},
+		}
+		return m
+	}
+
+	data, err := buildResponse(requests[1]).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The nameserver truncates the responses to the smallest buffer, answers the
+	// intermediate buffers and its big responses are lost in the path
+	address, stop := startResolver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		opt := r.IsEdns0()
+		if opt == nil || opt.UDPSize() > 1480 {
+			return
+		}
+		bufferSize := opt.UDPSize()
+
+		m := buildResponse(r)
+		if bufferSize < 1232 {
+			m.Answer = nil
+			m.Truncated = true
+		}
+		w.WriteMsg(m)
+	})
+	defer stop()
+
+	q := newQuerier(4096, time.Second, 200*time.Millisecond, time.Second, 1, "")
+	payloadLimit := q.checkFragmentation(model.Nameserver{Host: "ns1.example.com.br."},
+		address, requests)
+
+	if payloadLimit != uint16(len(data)) {
+		t.Errorf("Not storing the size of the largest delivered response. "+
+			"Expected %d and got %d", len(data), payloadLimit)
+	}
+}
+
+func TestCheckParentDSQPSExceeded(t *testing.T) {
+	parentCache.Clear()
+	defer parentCache.Clear()
+
+	parentCache.set("br.", parentZone{
+		name:        "br.",
+		nameservers: []string{"a.dns.br."},
+	})
+
+	querierCache.hostsMutex.Lock()
+	querierCache.hosts["a.dns.br."] = &hostCache{
+		addresses: []net.IP{net.ParseIP("127.0.0.1")},
+		bucket:    tokenBucket{updatedAt: time.Now()},
+	}
+	querierCache.hostsMutex.Unlock()
+	defer querierCache.Clear()
+
+	domain := &model.Domain{
+		FQDN: "example.br.",
+	}
+
+	q := newQuerier(4096, time.Second, 200*time.Millisecond, time.Second, 1, "127.0.0.1:53")
+	q.RateLimit = defaultRateLimit()
+	q.checkParentDS(domain)
+
+	if domain.ParentDS.LastStatus != model.DSStatusNotChecked ||
+		domain.ParentDS.LastCheckAt.IsZero() ||
+		!strings.Contains(domain.ParentDS.Diagnostic.Error, ErrHostQPSExceeded.Error()) {
+
+		t.Error("Not registering that the parent zone wasn't checked because of the rate limit")
+	}
+}
@@ -6,18 +6,21 @@
 package scan
 
 import (
-	"fmt"
+	"net"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2"
 	"github.com/rafaeljusto/shelter/config"
 	"github.com/rafaeljusto/shelter/dao"
 	"github.com/rafaeljusto/shelter/database/mongodb"
 	"github.com/rafaeljusto/shelter/log"
 	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
 )
 
 // When converting a DNSKEY into a DS we need to choose wich digest type are we going to
@@ -42,6 +45,10 @@ func ScanDomains() {
 		}
 	}()
 
+	// Only one scan is executed at a time
+	scanLock.Lock()
+	defer scanLock.Unlock()
+
 	log.Info("Start scan job")
 	defer func() {
 		log.Info("End scan job")
@@ -68,31 +75,131 @@ func ScanDomains() {
 	}
 	defer databaseSession.Close()
 
+	scheduleLegacyDomains(database)
+
+	// In a distributed scan the domains are scanned by the workers, this instance only
+	// splits the domains into batches and merges the statistics
+	if config.ShelterConfig.Scan.Distributed.Enabled {
+		coordinateScan(database)
+		return
+	}
+
+	// Create a new scan information
+	model.StartNewScan()
+	executeScan(database)
+}
+
+// Schedule the domains stored before the next check date existed. Each domain is scheduled
+// according to its own last check, the same rule that selected the domains before, so the
+// domains stay spread over the verification intervals instead of being checked all in the
+// next scan. Only the domains that were never checked go to the next scan
+func scheduleLegacyDomains(database *mgo.Database) {
+	domainDAO := dao.DomainDAO{
+		Database: database,
+	}
+
+	domainChannel, err := domainDAO.FindAllAsyncUnscheduled()
+	if err != nil {
+		log.Println("Error while selecting the domains without schedule. Details:", err)
+		return
+	}
+
+	weights := priorityWeights()
+	scheduled := 0
+	for {
+		domainResult := <-domainChannel
+
+		// The last result has no domain, and the error of the query when it failed
+		if domainResult.Error != nil || domainResult.Domain == nil {
+			if domainResult.Error != nil {
+				log.Println("Error while selecting the domains without schedule. Details:",
+					domainResult.Error)
+			}
+			break
+		}
+
+		domain := domainResult.Domain
+		domain.ScheduleNextCheck(
+			config.ShelterConfig.Scan.VerificationIntervals.MaxOKDays,
+			config.ShelterConfig.Scan.VerificationIntervals.MaxErrorDays,
+			config.ShelterConfig.Scan.VerificationIntervals.MaxExpirationAlertDays,
+		)
+		schedulePriority(domain, weights)
+
+		if err := domainDAO.SaveNextCheck(domain); err != nil {
+			log.Println("Error while scheduling domain", domain.FQDN, "Details:", err)
+			continue
+		}
+
+		scheduled++
+	}
+
+	if scheduled > 0 {
+		log.Infof("%d domains without schedule were scheduled according to their last check",
+			scheduled)
+	}
+}
+
+// Check the domains of the current scan information, storing the progress in the
+// database while the scan is executing, and save the scan information at the end. The
+// scan information must be already started or resumed
+func executeScan(database *mgo.Database) {
+	stopCheckpoints := checkpointScan(database)
+	errorDetected := runScan(database, nil, newScanControl())
+	stopCheckpoints()
+
+	scanDAO := dao.ScanDAO{
+		Database: database,
+	}
+
+	// Save the scan information for future reports
+	if err := model.FinishAndSaveScan(errorDetected, scanDAO.Save); err != nil {
+		log.Println("Error while saving scan information. Details:", err)
+	}
+}
+
+// Check the domains that are due, or only the given domains when scanning a batch of a
+// distributed scan, persisting the results in the database. The scan information must be
+// already started. The given control allows the user, or the worker that lost the lease of
+// the batch, to pause and cancel the scan. This function is synchronous and returns true
+// if errors were detected while executing the scan
+func runScan(database *mgo.Database, fqdns []string, control *scanControl) bool {
 	injector := NewInjector(
 		database,
 		config.ShelterConfig.Scan.DomainsBufferSize,
-		config.ShelterConfig.Scan.VerificationIntervals.MaxOKDays,
-		config.ShelterConfig.Scan.VerificationIntervals.MaxErrorDays,
-		config.ShelterConfig.Scan.VerificationIntervals.MaxExpirationAlertDays,
+		time.Duration(config.ShelterConfig.Scan.IntervalHours)*time.Hour,
 	)
+	injector.FQDNs = fqdns
 
-	querierDispatcher := NewQuerierDispatcher(
-		config.ShelterConfig.Scan.NumberOfQueriers,
-		config.ShelterConfig.Scan.DomainsBufferSize,
-		config.ShelterConfig.Scan.UDPMaxSize,
-		time.Duration(config.ShelterConfig.Scan.Timeouts.DialSeconds)*time.Second,
-		time.Duration(config.ShelterConfig.Scan.Timeouts.ReadSeconds)*time.Second,
-		time.Duration(config.ShelterConfig.Scan.Timeouts.WriteSeconds)*time.Second,
-		config.ShelterConfig.Scan.ConnectionRetries,
-	)
+	// The domains are marked with the start of the scan, so that a resumed scan doesn't
+	// check them again
+	scanStartedAt := model.GetCurrentScan().StartedAt
+	injector.ScanStartedAt = scanStartedAt
+
+	querierDispatcher := newQuerierDispatcher()
 
 	collector := NewCollector(
 		database,
 		config.ShelterConfig.Scan.SaveAtOnce,
 	)
-
-	// Create a new scan information
-	model.StartNewScan()
+	collector.ApplyCDS = config.ShelterConfig.Scan.CDS.AutoApply
+	collector.ApplyCSYNC = config.ShelterConfig.Scan.CSYNC.AutoApply
+	collector.MaxOKVerificationDays =
+		config.ShelterConfig.Scan.VerificationIntervals.MaxOKDays
+	collector.MaxErrorVerificationDays =
+		config.ShelterConfig.Scan.VerificationIntervals.MaxErrorDays
+	collector.MaxExpirationAlertDays =
+		config.ShelterConfig.Scan.VerificationIntervals.MaxExpirationAlertDays
+	collector.ScanStartedAt = scanStartedAt
+	collector.PriorityWeights = priorityWeights()
+
+	// The user can pause, continue or cancel the scan and change the number of queriers
+	// while the domains are checked
+	injector.control = control
+	querierDispatcher.control = control
+
+	setActiveControl(control)
+	defer setActiveControl(nil)
 
 	var scanGroup sync.WaitGroup
 	errorsChannel := make(chan error, config.ShelterConfig.Scan.ErrorsBufferSize)
@@ -100,6 +207,10 @@ func ScanDomains() {
 	domainsToSaveChannel := querierDispatcher.Start(&scanGroup, domainsToQueryChannel)
 	collector.Start(&scanGroup, domainsToSaveChannel, errorsChannel)
 
+	// The on-demand checks are sent to the dispatcher of the scan while it's running
+	setActiveDispatcher(querierDispatcher)
+	defer setActiveDispatcher(nil)
+
 	// Keep track of errors for the scan information structure
 	errorDetected := false
 
@@ -127,29 +238,30 @@ func ScanDomains() {
 	// Finish the error listener sending a poison pill
 	errorsChannel <- nil
 
-	scanDAO := dao.ScanDAO{
-		Database: database,
-	}
-
-	// Save the scan information for future reports
-	if err := model.FinishAndSaveScan(errorDetected, scanDAO.Save); err != nil {
-		log.Println("Error while saving scan information. Details:", err)
-	}
+	return errorDetected
 }
 
 // Function created to check a single domain without persisting in database. Useful for online
 // domain checking. As we update the same object, we update the parameter pointer and don't return
-// nothing
+// nothing. While a scan is running the domain is sent to the dispatcher of the scan, where it goes
+// before the domains waiting in the priority queue and shares the queriers and the hosts' rate
+// limit with the scan. A paused scan would hold the domain, so in this case, or when there's no
+// scan running, the domain is checked by its own dispatcher
 func ScanDomain(domain *model.Domain) {
-	querierDispatcher := NewQuerierDispatcher(
-		config.ShelterConfig.Scan.NumberOfQueriers,
-		config.ShelterConfig.Scan.DomainsBufferSize,
-		config.ShelterConfig.Scan.UDPMaxSize,
-		time.Duration(config.ShelterConfig.Scan.Timeouts.DialSeconds)*time.Second,
-		time.Duration(config.ShelterConfig.Scan.Timeouts.ReadSeconds)*time.Second,
-		time.Duration(config.ShelterConfig.Scan.Timeouts.WriteSeconds)*time.Second,
-		config.ShelterConfig.Scan.ConnectionRetries,
-	)
+	querierDispatcher := getActiveDispatcher()
+	if querierDispatcher == nil || querierDispatcher.control.isPaused() ||
+		!querierDispatcher.checkOnDemand(domain) {
+
+		scanDomainAlone(domain)
+	}
+
+	// The collector isn't used in an on-demand check, so the findings are built here
+	domain.UpdateFindings(config.ShelterConfig.Scan.VerificationIntervals.MaxExpirationAlertDays)
+}
+
+// Check a single domain with a dispatcher created only for it
+func scanDomainAlone(domain *model.Domain) {
+	querierDispatcher := newQuerierDispatcher()
 
 	var scanGroup sync.WaitGroup
 	domainsToQueryChannel := make(chan *model.Domain)
@@ -157,7 +269,14 @@ func ScanDomain(domain *model.Domain) {
 
 	domainsToQueryChannel <- domain
 	domainsToQueryChannel <- nil // Poison pill
-	domain = <-domainsToSaveChannel
+
+	// The checked domain is the same object, and it's followed by the poison pill. When the
+	// domain was dropped by the rate limit only the poison pill is received
+	for checked := range domainsToSaveChannel {
+		if checked == nil {
+			break
+		}
+	}
 
 	// Wait for all parts of the scan to finish their job
 	scanGroup.Wait()
@@ -178,12 +297,10 @@ func QueryDomain(fqdn string) (model.Domain, error) {
 		time.Duration(config.ShelterConfig.Scan.Timeouts.ReadSeconds)*time.Second,
 		time.Duration(config.ShelterConfig.Scan.Timeouts.WriteSeconds)*time.Second,
 		config.ShelterConfig.Scan.ConnectionRetries,
+		resolverAddress(),
 	)
 
-	resolver := fmt.Sprintf("%s:%d",
-		config.ShelterConfig.Scan.Resolver.Address,
-		config.ShelterConfig.Scan.Resolver.Port,
-	)
+	resolver := resolverAddress()
 
 	var dnsRequestMessage dns.Msg
 	dnsRequestMessage.SetQuestion(fqdn, dns.TypeNS)
@@ -278,3 +395,128 @@ func QueryDomain(fqdn string) (model.Domain, error) {
 
 	return domain, nil
 }
+
+// Build the querier dispatcher with the scan settings of the configuration. The settings
+// are copied when the scan starts, so a configuration reload only affects the next scans
+func newQuerierDispatcher() *QuerierDispatcher {
+	querierDispatcher := NewQuerierDispatcher(
+		config.ShelterConfig.Scan.NumberOfQueriers,
+		config.ShelterConfig.Scan.DomainsBufferSize,
+		config.ShelterConfig.Scan.UDPMaxSize,
+		time.Duration(config.ShelterConfig.Scan.Timeouts.DialSeconds)*time.Second,
+		time.Duration(config.ShelterConfig.Scan.Timeouts.ReadSeconds)*time.Second,
+		time.Duration(config.ShelterConfig.Scan.Timeouts.WriteSeconds)*time.Second,
+		config.ShelterConfig.Scan.ConnectionRetries,
+		resolverAddress(),
+	)
+
+	querierDispatcher.Resolvers = resolverAddresses()
+	querierDispatcher.RateLimit = rateLimit()
+	querierDispatcher.PriorityWeights = priorityWeights()
+	return querierDispatcher
+}
+
+// Build the address of the recursive DNS server from the configuration. When there's no
+// resolver configured, an empty string is returned, and the checks that depend on the
+// resolver are disabled
+func resolverAddress() string {
+	if len(config.ShelterConfig.Scan.Resolver.Address) == 0 {
+		return ""
+	}
+
+	return net.JoinHostPort(
+		config.ShelterConfig.Scan.Resolver.Address,
+		strconv.Itoa(config.ShelterConfig.Scan.Resolver.Port),
+	)
+}
+
+// Build the addresses of all recursive DNS servers from the configuration, in the order
+// that they must be used. The fallback resolvers use the same port of the main resolver
+func resolverAddresses() []string {
+	if len(config.ShelterConfig.Scan.Resolver.Address) == 0 {
+		return nil
+	}
+
+	resolvers := []string{resolverAddress()}
+	for _, address := range config.ShelterConfig.Scan.Resolver.Fallbacks {
+		resolvers = append(resolvers, net.JoinHostPort(
+			address,
+			strconv.Itoa(config.ShelterConfig.Scan.Resolver.Port),
+		))
+	}
+
+	return resolvers
+}
+
+// Build the limits of the queries sent to each nameserver host from the configuration.
+// Zero keeps the default limit and a negative number of queries per second disables the
+// rate limit
+func rateLimit() RateLimit {
+	limit := defaultRateLimit()
+
+	if config.ShelterConfig.Scan.RateLimit.QueriesPerSecond > 0 {
+		limit.QueriesPerSecond = uint64(config.ShelterConfig.Scan.RateLimit.QueriesPerSecond)
+	} else if config.ShelterConfig.Scan.RateLimit.QueriesPerSecond < 0 {
+		limit.QueriesPerSecond = 0
+	}
+
+	if config.ShelterConfig.Scan.RateLimit.Burst > 0 {
+		limit.Burst = uint64(config.ShelterConfig.Scan.RateLimit.Burst)
+	}
+
+	if config.ShelterConfig.Scan.RateLimit.MaxPostponements > 0 {
+		limit.MaxPostponements = config.ShelterConfig.Scan.RateLimit.MaxPostponements
+	}
+
+	return limit
+}
+
+// Build the weights of the domains' priority criteria from the configuration
+func priorityWeights() PriorityWeights {
+	weights := defaultPriorityWeights()
+	weights.Expiration = priorityWeight(
+		config.ShelterConfig.Scan.Priority.ExpirationWeight, weights.Expiration)
+	weights.Error = priorityWeight(
+		config.ShelterConfig.Scan.Priority.ErrorWeight, weights.Error)
+	weights.LastCheck = priorityWeight(
+		config.ShelterConfig.Scan.Priority.LastCheckWeight, weights.LastCheck)
+	return weights
+}
+
+// Load the configuration of the scan policies from the configuration file. It must be
+// called when the configuration file is loaded, and not for each scan, as the policies
+// are shared by all scans. An error is returned when the configuration refers to an
+// unknown policy or when the policy dependencies are invalid, in this case the previous
+// configuration of the policies is kept
+func LoadPolicies() error {
+	configs := make(map[string]policy.Config)
+	for id, policyConfig := range config.ShelterConfig.Scan.Policies {
+		zones := make(map[string]policy.Config)
+		for zone, zoneConfig := range policyConfig.Zones {
+			zones[zone] = policy.Config{
+				Enabled:    zoneConfig.Enabled,
+				Parameters: policy.Parameters(zoneConfig.Parameters),
+			}
+		}
+
+		configs[id] = policy.Config{
+			Enabled:    policyConfig.Enabled,
+			Parameters: policy.Parameters(policyConfig.Parameters),
+			Zones:      zones,
+		}
+	}
+
+	return policy.Configure(configs)
+}
+
+// Choose the weight of a priority criterion from the configuration. Zero keeps the default
+// weight and a negative number ignores the criterion
+func priorityWeight(configured, defaultWeight float64) float64 {
+	if configured > 0 {
+		return configured
+	} else if configured < 0 {
+		return 0
+	}
+
+	return defaultWeight
+}
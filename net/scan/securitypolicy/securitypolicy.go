@@ -0,0 +1,136 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package securitypolicy store the security policies for the nameservers. The problems
+// found here don't break the DNS resolution, so they are reported as warnings
+package securitypolicy
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/dnsutils"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
+)
+
+const (
+	// Identification of the open recursion probe in the policy registry
+	RecursionPolicy = "ns.recursion"
+
+	// Identification of the zone transfer probe in the policy registry
+	TransferPolicy = "ns.transfer"
+
+	// Name unrelated to the registered domains, used to check if the nameservers answer
+	// recursive queries. The "probeName" parameter of the "ns.recursion" policy replaces
+	// this value, useful when the default name belongs to a local zone
+	RecursionProbeName = "example.com."
+)
+
+// The probes send queries that a nameserver operator didn't expect from a monitoring
+// system (recursive queries and zone transfers), and intrusion detection systems can
+// report them as an attack. So the probes are only sent when the configuration enables
+// their policies
+func init() {
+	policy.Register(policy.Standalone{Identifier: RecursionPolicy, OptIn: true})
+	policy.Register(policy.Standalone{Identifier: TransferPolicy, OptIn: true})
+}
+
+// DomainSecurityPolicy store the domain object and the responses of the security probes
+// sent to a nameserver
+type DomainSecurityPolicy struct {
+	domain            *model.Domain // Domain object used to build the probes' queries
+	recursionResponse *dns.Msg      // Response of the recursive query for an unrelated name
+	transferResponse  *dns.Msg      // First message of the zone transfer of the domain
+}
+
+// This function initialize a DomainSecurityPolicy object, it was created to force the
+// programmer to initialize the domain object, so we don't need to check if domain is nil
+// inside each method
+func NewDomainSecurityPolicy(domain *model.Domain) DomainSecurityPolicy {
+	return DomainSecurityPolicy{
+		domain: domain,
+	}
+}
+
+// Build the recursive query for a name that the nameserver shouldn't be authoritative for,
+// using the parameters of the "ns.recursion" policy
+func (d *DomainSecurityPolicy) RecursionRequest(parameters policy.Parameters) *dns.Msg {
+	var dnsRequestMessage dns.Msg
+	dnsRequestMessage.SetQuestion(dns.Fqdn(parameters.String("probeName",
+		RecursionProbeName)), dns.TypeA)
+	dnsRequestMessage.RecursionDesired = true
+	return &dnsRequestMessage
+}
+
+// Build the zone transfer query of the domain. The query must be sent over TCP
+func (d *DomainSecurityPolicy) TransferRequest() *dns.Msg {
+	var dnsRequestMessage dns.Msg
+	dnsRequestMessage.SetAxfr(dns.Fqdn(d.domain.FQDN))
+	return &dnsRequestMessage
+}
+
+// Store the response of the recursion probe. Network errors in the probe aren't a security
+// problem, so in this case the response shouldn't be stored
+func (d *DomainSecurityPolicy) SetRecursionResponse(dnsResponseMessage *dns.Msg) {
+	d.recursionResponse = dnsResponseMessage
+}
+
+// Store the first message of the zone transfer. Network errors in the probe aren't a
+// security problem, so in this case the response shouldn't be stored
+func (d *DomainSecurityPolicy) SetTransferResponse(dnsResponseMessage *dns.Msg) {
+	d.transferResponse = dnsResponseMessage
+}
+
+// Method responsable for running all security policies. It will return the warning status
+// of each problem found, so that a nameserver with many problems reports all of them
+func (d *DomainSecurityPolicy) Run() []model.NameserverStatus {
+	var statuses []model.NameserverStatus
+	for _, status := range []model.NameserverStatus{
+		d.recursionPolicy(),
+		d.transferPolicy(),
+	} {
+		if status != model.NameserverStatusOK {
+			statuses = append(statuses, status)
+		}
+	}
+
+	return statuses
+}
+
+// An authoritative nameserver that answers recursive queries for anyone can be used in
+// DNS amplification attacks and is vulnerable to cache poisoning. We detect it when the
+// nameserver offers recursion and answers a name that it isn't authoritative for
+func (d *DomainSecurityPolicy) recursionPolicy() model.NameserverStatus {
+	if d.recursionResponse == nil {
+		return model.NameserverStatusOK
+	}
+
+	if d.recursionResponse.RecursionAvailable &&
+		!d.recursionResponse.Authoritative &&
+		d.recursionResponse.Rcode == dns.RcodeSuccess &&
+		len(d.recursionResponse.Answer) > 0 {
+
+		return model.NameserverStatusOpenRecursion
+	}
+
+	return model.NameserverStatusOK
+}
+
+// A zone transfer that is allowed for anyone exposes all records of the zone. A transfer
+// starts with the SOA record of the zone, so we only need the first message to detect it
+func (d *DomainSecurityPolicy) transferPolicy() model.NameserverStatus {
+	if d.transferResponse == nil || d.transferResponse.Rcode != dns.RcodeSuccess {
+		return model.NameserverStatusOK
+	}
+
+	if len(d.transferResponse.Answer) == 0 {
+		return model.NameserverStatusOK
+	}
+
+	soa, ok := d.transferResponse.Answer[0].(*dns.SOA)
+	if !ok || dnsutils.CompareCanonical(soa.Hdr.Name, d.domain.FQDN) != 0 {
+		return model.NameserverStatusOK
+	}
+
+	return model.NameserverStatusOpenTransfer
+}
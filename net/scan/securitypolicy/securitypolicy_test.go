@@ -0,0 +1,164 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package securitypolicy store the security policies for the nameservers. The problems
+// found here don't break the DNS resolution, so they are reported as warnings
+package securitypolicy
+
+import (
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
+	"net"
+	"testing"
+)
+
+func TestRequests(t *testing.T) {
+	domainSecurityPolicy := NewDomainSecurityPolicy(&model.Domain{
+		FQDN: "test.com.br",
+	})
+
+	dnsRequestMessage := domainSecurityPolicy.RecursionRequest(nil)
+	if !dnsRequestMessage.RecursionDesired ||
+		dnsRequestMessage.Question[0].Name != dns.Fqdn(RecursionProbeName) {
+
+		t.Error("Not building a recursive query for an unrelated name")
+	}
+
+	dnsRequestMessage = domainSecurityPolicy.RecursionRequest(policy.Parameters{
+		"probeName": "example.net",
+	})
+
+	if dnsRequestMessage.Question[0].Name != "example.net." {
+		t.Error("Not using the probe name of the policy parameters")
+	}
+
+	dnsRequestMessage = domainSecurityPolicy.TransferRequest()
+	if dnsRequestMessage.Question[0].Qtype != dns.TypeAXFR ||
+		dnsRequestMessage.Question[0].Name != "test.com.br." {
+
+		t.Error("Not building a zone transfer query for the domain")
+	}
+}
+
+func TestRecursionPolicy(t *testing.T) {
+	domainSecurityPolicy := NewDomainSecurityPolicy(&model.Domain{
+		FQDN: "test.com.br",
+	})
+
+	if len(domainSecurityPolicy.Run()) != 0 {
+		t.Error("Reporting problems without security probes' responses")
+	}
+
+	dnsResponseMessage := new(dns.Msg)
+	dnsResponseMessage.SetReply(domainSecurityPolicy.RecursionRequest(nil))
+	dnsResponseMessage.RecursionAvailable = true
+	dnsResponseMessage.Answer = []dns.RR{
+		&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   dns.Fqdn(RecursionProbeName),
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+			},
+			A: net.ParseIP("192.0.2.1"),
+		},
+	}
+
+	domainSecurityPolicy.SetRecursionResponse(dnsResponseMessage)
+
+	if statuses := domainSecurityPolicy.Run(); len(statuses) != 1 ||
+		statuses[0] != model.NameserverStatusOpenRecursion {
+
+		t.Error("Not detecting open recursion")
+	}
+
+	dnsResponseMessage.Answer = nil
+	dnsResponseMessage.Rcode = dns.RcodeRefused
+
+	if len(domainSecurityPolicy.Run()) != 0 {
+		t.Error("Reporting open recursion when the recursive query was refused")
+	}
+}
+
+func TestTransferPolicy(t *testing.T) {
+	domainSecurityPolicy := NewDomainSecurityPolicy(&model.Domain{
+		FQDN: "test.com.br",
+	})
+
+	dnsResponseMessage := new(dns.Msg)
+	dnsResponseMessage.SetReply(domainSecurityPolicy.TransferRequest())
+	dnsResponseMessage.Answer = []dns.RR{
+		&dns.SOA{
+			Hdr: dns.RR_Header{
+				Name:   "test.com.br.",
+				Rrtype: dns.TypeSOA,
+				Class:  dns.ClassINET,
+			},
+			Ns:     "ns1.test.com.br.",
+			Mbox:   "hostmaster.test.com.br.",
+			Serial: 2014010100,
+		},
+	}
+
+	domainSecurityPolicy.SetTransferResponse(dnsResponseMessage)
+
+	if statuses := domainSecurityPolicy.Run(); len(statuses) != 1 ||
+		statuses[0] != model.NameserverStatusOpenTransfer {
+
+		t.Error("Not detecting open zone transfer")
+	}
+
+	dnsResponseMessage.Answer = nil
+	dnsResponseMessage.Rcode = dns.RcodeRefused
+
+	if len(domainSecurityPolicy.Run()) != 0 {
+		t.Error("Reporting open zone transfer when the transfer was refused")
+	}
+}
+
+func TestRunAllPolicies(t *testing.T) {
+	domainSecurityPolicy := NewDomainSecurityPolicy(&model.Domain{
+		FQDN: "test.com.br",
+	})
+
+	recursionResponseMessage := new(dns.Msg)
+	recursionResponseMessage.SetReply(domainSecurityPolicy.RecursionRequest(nil))
+	recursionResponseMessage.RecursionAvailable = true
+	recursionResponseMessage.Answer = []dns.RR{
+		&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   dns.Fqdn(RecursionProbeName),
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+			},
+			A: net.ParseIP("192.0.2.1"),
+		},
+	}
+
+	transferResponseMessage := new(dns.Msg)
+	transferResponseMessage.SetReply(domainSecurityPolicy.TransferRequest())
+	transferResponseMessage.Answer = []dns.RR{
+		&dns.SOA{
+			Hdr: dns.RR_Header{
+				Name:   "test.com.br.",
+				Rrtype: dns.TypeSOA,
+				Class:  dns.ClassINET,
+			},
+			Ns:     "ns1.test.com.br.",
+			Mbox:   "hostmaster.test.com.br.",
+			Serial: 2014010100,
+		},
+	}
+
+	domainSecurityPolicy.SetRecursionResponse(recursionResponseMessage)
+	domainSecurityPolicy.SetTransferResponse(transferResponseMessage)
+
+	statuses := domainSecurityPolicy.Run()
+	if len(statuses) != 2 ||
+		statuses[0] != model.NameserverStatusOpenRecursion ||
+		statuses[1] != model.NameserverStatusOpenTransfer {
+
+		t.Errorf("Not reporting all security problems. Found: %v", statuses)
+	}
+}
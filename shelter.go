@@ -153,7 +153,14 @@ func main() {
 		log.Info("Web client started")
 	}
 
-	if config.ShelterConfig.Scan.Enabled {
+	if config.ShelterConfig.Scan.Enabled && config.ShelterConfig.Scan.Distributed.Enabled &&
+		!config.ShelterConfig.Scan.Distributed.Coordinator {
+
+		// The workers of a distributed scan don't have a scan job, they scan the batches
+		// created by the coordinator as soon as they are available
+		go scan.WorkOnScanBatches()
+
+	} else if config.ShelterConfig.Scan.Enabled {
 		// Attention: Cannot use timezone abbreviations
 		// http://stackoverflow.com/questions/25368415/golang-timezone-parsing
 		scanTime, err := time.Parse("15:04:05 -0700", config.ShelterConfig.Scan.Time)
@@ -189,6 +196,10 @@ func main() {
 			log.Println("Current scan information got an error while initializing. Details:", err)
 			os.Exit(ErrCurrentScanInitialize)
 		}
+
+		// A scan interrupted by a crash or restart is resumed or marked as aborted. The next
+		// scheduled scan waits until the interrupted scan is resumed
+		go scan.RecoverInterruptedScans()
 	}
 
 	if config.ShelterConfig.Notification.Enabled {
@@ -293,5 +304,7 @@ func loadSettings() error {
 		}
 	}
 
-	return nil
+	// The scan policies are shared by all scans, so we load them only when the
+	// configuration file changes, and not before each scan
+	return scan.LoadPolicies()
 }
@@ -7,12 +7,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2/bson"
 	"github.com/rafaeljusto/shelter/dao"
 	"github.com/rafaeljusto/shelter/database/mongodb"
 	"github.com/rafaeljusto/shelter/model"
 	"github.com/rafaeljusto/shelter/testing/utils"
 	"net"
 	"net/mail"
+	"strings"
 	"time"
 )
 
@@ -88,6 +90,8 @@ func main() {
 	domainsNotification(domainDAO)
 	domainsExpand(domainDAO)
 	domainFilter(domainDAO)
+	domainsUnscheduled(domainDAO)
+	domainsToBeScanned(domainDAO)
 
 	// Domain DAO performance report is optional and only generated when the report file
 	// path parameter is given
@@ -363,7 +367,7 @@ func domainsPagination(domainDAO dao.DomainDAO) {
 		},
 	}
 
-	domains, err := domainDAO.FindAll(&pagination, true, "")
+	domains, err := domainDAO.FindAll(&pagination, true, "", nil)
 	if err != nil {
 		utils.Fatalln("Error retrieving domains", err)
 	}
@@ -391,7 +395,7 @@ func domainsPagination(domainDAO dao.DomainDAO) {
 		},
 	}
 
-	domains, err = domainDAO.FindAll(&pagination, true, "")
+	domains, err = domainDAO.FindAll(&pagination, true, "", nil)
 	if err != nil {
 		utils.Fatalln("Error retrieving domains", err)
 	}
@@ -538,6 +542,7 @@ func domainsNotification(domainDAO dao.DomainDAO) {
 		dsErrorAlertDays,
 		dsTimeoutAlertDays,
 		maxExpirationAlertDays,
+		nil,
 	)
 
 	if err != nil {
@@ -584,7 +589,7 @@ func domainsExpand(domainDAO dao.DomainDAO) {
 	}
 
 	pagination := dao.DomainDAOPagination{}
-	domains, err := domainDAO.FindAll(&pagination, false, "")
+	domains, err := domainDAO.FindAll(&pagination, false, "", nil)
 
 	if err != nil {
 		utils.Fatalln("Error retrieving domains", err)
@@ -618,7 +623,7 @@ func domainsExpand(domainDAO dao.DomainDAO) {
 		}
 	}
 
-	domains, err = domainDAO.FindAll(&pagination, true, "")
+	domains, err = domainDAO.FindAll(&pagination, true, "", nil)
 
 	if err != nil {
 		utils.Fatalln("Error retrieving domains", err)
@@ -670,6 +675,17 @@ func domainFilter(domainDAO dao.DomainDAO) {
 			FQDN: fmt.Sprintf("example%d.com.br", i),
 		}
 
+		if i == 3 {
+			domain.Nameservers = []model.Nameserver{
+				{
+					Host: "ns1.example3.com.br.",
+					Findings: []model.Finding{
+						{Code: "SLOW", Severity: model.SeverityWarning},
+					},
+				},
+			}
+		}
+
 		if err := domainDAO.Save(&domain); err != nil {
 			utils.Fatalln("Error saving domain in database", err)
 		}
@@ -686,7 +702,7 @@ func domainFilter(domainDAO dao.DomainDAO) {
 		},
 	}
 
-	domains, err := domainDAO.FindAll(&pagination, true, "example1\\.com.*")
+	domains, err := domainDAO.FindAll(&pagination, true, "example1\\.com.*", nil)
 	if err != nil {
 		utils.Fatalln("Error retrieving domains", err)
 	}
@@ -700,6 +716,18 @@ func domainFilter(domainDAO dao.DomainDAO) {
 		utils.Fatalln("Wrong domain returned", nil)
 	}
 
+	pagination.Page = 1
+	domains, err = domainDAO.FindAll(&pagination, true, "",
+		[]model.Severity{model.SeverityWarning, model.SeverityError})
+
+	if err != nil {
+		utils.Fatalln("Error retrieving domains", err)
+	}
+
+	if len(domains) != 1 || domains[0].FQDN != "example3.com.br" {
+		utils.Fatalln("Not filtering domains by the severity of the findings", nil)
+	}
+
 	for i := 0; i < numberOfItems; i++ {
 		fqdn := fmt.Sprintf("example%d.com.br", i)
 		if err := domainDAO.RemoveByFQDN(fqdn); err != nil {
@@ -708,6 +736,115 @@ func domainFilter(domainDAO dao.DomainDAO) {
 	}
 }
 
+// Test the selection and scheduling of the domains stored before the next check date
+// existed
+func domainsUnscheduled(domainDAO dao.DomainDAO) {
+	domain := newDomain()
+	if err := domainDAO.Save(&domain); err != nil {
+		utils.Fatalln("Couldn't save domain in database", err)
+	}
+
+	// Simulate a domain stored by an older version of the system
+	err := domainDAO.Database.C("domain").UpdateId(domain.Id, bson.M{
+		"$unset": bson.M{"nextcheckat": 1},
+	})
+
+	if err != nil {
+		utils.Fatalln("Couldn't remove the next check date of the domain", err)
+	}
+
+	domainChannel, err := domainDAO.FindAllAsyncUnscheduled()
+	if err != nil {
+		utils.Fatalln("Error retrieving domains without schedule", err)
+	}
+
+	var domains []*model.Domain
+	for {
+		domainResult := <-domainChannel
+		if domainResult.Error != nil {
+			utils.Fatalln("Error retrieving domains without schedule", domainResult.Error)
+		}
+
+		if domainResult.Domain == nil {
+			break
+		}
+
+		domains = append(domains, domainResult.Domain)
+	}
+
+	if len(domains) != 1 || domains[0].FQDN != domain.FQDN {
+		utils.Fatalln("Not selecting the domains without schedule", nil)
+	}
+
+	domains[0].NextCheckAt = time.Now().Add(24 * time.Hour)
+	if err := domainDAO.SaveNextCheck(domains[0]); err != nil {
+		utils.Fatalln("Couldn't schedule the domain", err)
+	}
+
+	domainRetrieved, err := domainDAO.FindByFQDN(domain.FQDN)
+	if err != nil {
+		utils.Fatalln("Couldn't find the scheduled domain in database", err)
+	}
+
+	if domainRetrieved.NextCheckAt.IsZero() || domainRetrieved.Revision != domain.Revision {
+		utils.Fatalln("Not storing only the next check date of the domain", nil)
+	}
+
+	if err := domainDAO.RemoveByFQDN(domain.FQDN); err != nil {
+		utils.Fatalln("Error while trying to remove a domain", err)
+	}
+}
+
+// Check if the domains that are due are selected by priority, and by the next check date
+// between the domains with the same priority
+func domainsToBeScanned(domainDAO dao.DomainDAO) {
+	now := time.Now()
+
+	domains := []*model.Domain{
+		{FQDN: "waiting.com.br.", NextCheckAt: now.Add(-48 * time.Hour), Priority: 1},
+		{FQDN: "broken.com.br.", NextCheckAt: now.Add(-time.Hour), Priority: 3},
+		{FQDN: "recent.com.br.", NextCheckAt: now.Add(-time.Hour), Priority: 1},
+		{FQDN: "future.com.br.", NextCheckAt: now.Add(48 * time.Hour), Priority: 5},
+	}
+
+	for _, domain := range domains {
+		if err := domainDAO.Save(domain); err != nil {
+			utils.Fatalln("Couldn't save domain in database", err)
+		}
+	}
+
+	domainChannel, err := domainDAO.FindAllAsyncToBeScanned(now, time.Time{}, nil)
+	if err != nil {
+		utils.Fatalln("Error retrieving domains to be scanned", err)
+	}
+
+	var fqdns []string
+	for {
+		domainResult := <-domainChannel
+		if domainResult.Error != nil {
+			utils.Fatalln("Error retrieving domains to be scanned", domainResult.Error)
+		}
+
+		if domainResult.Domain == nil {
+			break
+		}
+
+		fqdns = append(fqdns, domainResult.Domain.FQDN)
+	}
+
+	expected := []string{"broken.com.br.", "waiting.com.br.", "recent.com.br."}
+	if strings.Join(fqdns, " ") != strings.Join(expected, " ") {
+		utils.Fatalln(fmt.Sprintf("Not selecting the domains by priority. Expected %v and got %v",
+			expected, fqdns), nil)
+	}
+
+	for _, domain := range domains {
+		if err := domainDAO.RemoveByFQDN(domain.FQDN); err != nil {
+			utils.Fatalln("Error while trying to remove a domain", err)
+		}
+	}
+}
+
 // Generates a report with the amount of time for each operation in the domain DAO. For
 // more realistic values it does the same operation for the same amount of data X number
 // of times to get the average time of the operation. After some results, with indexes we
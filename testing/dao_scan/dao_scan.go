@@ -76,6 +76,7 @@ func main() {
 	scanLifeCycle(scanDAO)
 	scanConcurrency(scanDAO)
 	scanStatistics(scanDAO)
+	scansUnfinished(scanDAO)
 	scansPagination(scanDAO)
 	scansExpand(scanDAO)
 
@@ -197,6 +198,50 @@ func scanStatistics(scanDAO dao.ScanDAO) {
 	}
 }
 
+// Test the search of the scans interrupted while executing, that are stored with the
+// progress of the last checkpoint
+func scansUnfinished(scanDAO dao.ScanDAO) {
+	scans := newScans()
+	scans[0].Status = model.ScanStatusRunning
+	scans[0].RTTHistogram = model.RTTHistogram{10: 5, 20: 1}
+	scans[1].Status = model.ScanStatusLoadingData
+
+	// A scan that finished must not be retrieved
+	finishedScan := newScan()
+	finishedScan.StartedAt = time.Now().Add(-30 * time.Minute)
+	scans = append(scans, finishedScan)
+
+	for i := range scans {
+		if err := scanDAO.Save(&scans[i]); err != nil {
+			utils.Fatalln("Couldn't save scan in database", err)
+		}
+	}
+
+	unfinishedScans, err := scanDAO.FindAllUnfinished()
+	if err != nil {
+		utils.Fatalln("Couldn't find the unfinished scans", err)
+	}
+
+	if len(unfinishedScans) != 2 {
+		utils.Fatalln(fmt.Sprintf("Not retrieving only the unfinished scans. "+
+			"Expected 2 and got %d", len(unfinishedScans)), nil)
+	}
+
+	if !utils.CompareScan(scans[1], unfinishedScans[0]) ||
+		!utils.CompareScan(scans[0], unfinishedScans[1]) {
+
+		utils.Fatalln("Not retrieving the unfinished scans in the order that they started", nil)
+	}
+
+	if unfinishedScans[1].RTTHistogram[10] != 5 || unfinishedScans[1].RTTHistogram[20] != 1 {
+		utils.Fatalln("Not retrieving the RTT histogram of the scan correctly", nil)
+	}
+
+	if err := scanDAO.RemoveAll(); err != nil {
+		utils.Fatalln("Error removing scans from database", err)
+	}
+}
+
 func scansPagination(scanDAO dao.ScanDAO) {
 	numberOfItems := 1000
 
@@ -0,0 +1,266 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/rafaeljusto/shelter/Godeps/_workspace/src/gopkg.in/mgo.v2"
+	"github.com/rafaeljusto/shelter/dao"
+	"github.com/rafaeljusto/shelter/database/mongodb"
+	"github.com/rafaeljusto/shelter/model"
+	"github.com/rafaeljusto/shelter/testing/utils"
+	"time"
+)
+
+// This test objective is to verify the scan batch persistence used by the distributed
+// scan. The strategy is to create batches and lease them as different workers, checking
+// that a batch is never leased by two workers at the same time and that the batches of a
+// crashed worker can be leased again
+
+var (
+	configFilePath string // Path for the configuration file with the database connection information
+)
+
+// ScanBatchDAOTestConfigFile is a structure to store the test configuration file data
+type ScanBatchDAOTestConfigFile struct {
+	Database struct {
+		URI  string
+		Name string
+	}
+}
+
+func init() {
+	utils.TestName = "ScanBatchDAO"
+	flag.StringVar(&configFilePath, "config", "", "Configuration file for ScanBatchDAO test")
+}
+
+func main() {
+	flag.Parse()
+
+	var config ScanBatchDAOTestConfigFile
+	err := utils.ReadConfigFile(configFilePath, &config)
+
+	if err == utils.ErrConfigFileUndefined {
+		fmt.Println(err.Error())
+		fmt.Println("Usage:")
+		flag.PrintDefaults()
+		return
+
+	} else if err != nil {
+		utils.Fatalln("Error reading configuration file", err)
+	}
+
+	database, databaseSession, err := mongodb.Open(
+		[]string{config.Database.URI},
+		config.Database.Name,
+		false, "", "",
+	)
+
+	if err != nil {
+		utils.Fatalln("Error connecting the database", err)
+	}
+	defer databaseSession.Close()
+
+	scanBatchDAO := dao.ScanBatchDAO{
+		Database: database,
+	}
+
+	// If there was some problem in the last test, there could be some data in the
+	// database, so let's clear it to don't affect this test. We avoid checking the error,
+	// because if the collection does not exist yet, it will be created in the first
+	// insert
+	scanBatchDAO.RemoveAll()
+
+	scanBatchLease(scanBatchDAO)
+	scanBatchExpiredLease(scanBatchDAO)
+	scanBatchFailed(scanBatchDAO)
+
+	utils.Println("SUCCESS!")
+}
+
+// Test the lease and commit of the batches by many workers
+func scanBatchLease(scanBatchDAO dao.ScanBatchDAO) {
+	scanStartedAt := createBatches(scanBatchDAO, 2)
+
+	batch1, err := scanBatchDAO.Lease("worker1", time.Minute)
+	if err != nil {
+		utils.Fatalln("Couldn't lease the first batch", err)
+	}
+
+	if batch1.Sequence != 1 || batch1.Worker != "worker1" ||
+		batch1.Status != model.ScanBatchStatusLeased || batch1.Leases != 1 ||
+		len(batch1.FQDNs) != 2 {
+
+		utils.Fatalln(fmt.Sprintf("Leasing the batches in the wrong order or not "+
+			"storing the lease: %+v", batch1), nil)
+	}
+
+	batch2, err := scanBatchDAO.Lease("worker2", time.Minute)
+	if err != nil {
+		utils.Fatalln("Couldn't lease the second batch", err)
+	}
+
+	if batch2.Sequence != 2 || batch2.Worker != "worker2" {
+		utils.Fatalln("Leasing the same batch for two workers", nil)
+	}
+
+	if _, err := scanBatchDAO.Lease("worker3", time.Minute); err != mgo.ErrNotFound {
+		utils.Fatalln("Leasing a batch when all batches are leased", err)
+	}
+
+	if err := scanBatchDAO.Renew(&batch1, time.Minute); err != nil {
+		utils.Fatalln("Couldn't renew the lease of a batch", err)
+	}
+
+	batch1.Statistics = model.ScanBatchStatistics{
+		DomainsScanned:       2,
+		NameserverStatistics: map[string]uint64{"OK": 4},
+		RTTHistogram:         map[string]uint64{"10": 4},
+	}
+
+	if err := scanBatchDAO.Commit(&batch1); err != nil {
+		utils.Fatalln("Couldn't commit a batch", err)
+	}
+
+	batches, err := scanBatchDAO.FindAllByScan(scanStartedAt, false)
+	if err != nil {
+		utils.Fatalln("Couldn't find the batches of the scan", err)
+	}
+
+	if len(batches) != 2 ||
+		batches[0].Status != model.ScanBatchStatusCommitted ||
+		batches[0].Statistics.DomainsScanned != 2 ||
+		batches[0].Statistics.NameserverStatistics["OK"] != 4 ||
+		batches[0].Statistics.RTTHistogram["10"] != 4 ||
+		batches[1].Status != model.ScanBatchStatusLeased {
+
+		utils.Fatalln("Not storing the batches' status and statistics", nil)
+	}
+
+	if len(batches[0].FQDNs) > 0 {
+		utils.Fatalln("Loading the domains of the batches without the expand flag", nil)
+	}
+
+	batches, err = scanBatchDAO.FindAllByScan(scanStartedAt, true)
+	if err != nil || len(batches) != 2 || len(batches[1].FQDNs) != 2 {
+		utils.Fatalln("Not loading the domains of the batches with the expand flag", err)
+	}
+
+	if err := scanBatchDAO.RemoveAllByScan(scanStartedAt); err != nil {
+		utils.Fatalln("Couldn't remove the batches of the scan", err)
+	}
+
+	if batches, err := scanBatchDAO.FindAllByScan(scanStartedAt, false); err != nil || len(batches) > 0 {
+		utils.Fatalln("Batches of the scan were not removed from database", err)
+	}
+}
+
+// Test the recovery of a batch leased by a worker that crashed
+func scanBatchExpiredLease(scanBatchDAO dao.ScanBatchDAO) {
+	scanStartedAt := createBatches(scanBatchDAO, 1)
+
+	crashedBatch, err := scanBatchDAO.Lease("worker1", -time.Second)
+	if err != nil {
+		utils.Fatalln("Couldn't lease the batch", err)
+	}
+
+	batch, err := scanBatchDAO.Lease("worker2", time.Minute)
+	if err != nil {
+		utils.Fatalln("Not leasing again a batch with an expired lease", err)
+	}
+
+	if batch.Id != crashedBatch.Id || batch.Worker != "worker2" || batch.Leases != 2 {
+		utils.Fatalln("Not recovering the batch with an expired lease", nil)
+	}
+
+	if err := scanBatchDAO.Renew(&crashedBatch, time.Minute); err != dao.ErrScanBatchDAOLeaseLost {
+		utils.Fatalln("Renewing a lease lost to another worker", err)
+	}
+
+	if err := scanBatchDAO.Commit(&crashedBatch); err != dao.ErrScanBatchDAOLeaseLost {
+		utils.Fatalln("Committing a batch leased by another worker", err)
+	}
+
+	if err := scanBatchDAO.Commit(&batch); err != nil {
+		utils.Fatalln("Couldn't commit the recovered batch", err)
+	}
+
+	if err := scanBatchDAO.RemoveAllByScan(scanStartedAt); err != nil {
+		utils.Fatalln("Couldn't remove the batches of the scan", err)
+	}
+}
+
+// Test the batches given up by the coordinator when the workers stopped
+func scanBatchFailed(scanBatchDAO dao.ScanBatchDAO) {
+	scanStartedAt := createBatches(scanBatchDAO, 3)
+
+	committedBatch, err := scanBatchDAO.Lease("worker1", time.Minute)
+	if err != nil {
+		utils.Fatalln("Couldn't lease the first batch", err)
+	}
+
+	if err := scanBatchDAO.Commit(&committedBatch); err != nil {
+		utils.Fatalln("Couldn't commit the first batch", err)
+	}
+
+	leasedBatch, err := scanBatchDAO.Lease("worker1", time.Minute)
+	if err != nil {
+		utils.Fatalln("Couldn't lease the second batch", err)
+	}
+
+	if err := scanBatchDAO.FailAllByScan(scanStartedAt); err != nil {
+		utils.Fatalln("Couldn't give up the batches of the scan", err)
+	}
+
+	batches, err := scanBatchDAO.FindAllByScan(scanStartedAt, false)
+	if err != nil {
+		utils.Fatalln("Couldn't find the batches of the scan", err)
+	}
+
+	if len(batches) != 3 ||
+		batches[0].Status != model.ScanBatchStatusCommitted ||
+		batches[1].Status != model.ScanBatchStatusFailed ||
+		batches[2].Status != model.ScanBatchStatusFailed {
+
+		utils.Fatalln("Not giving up only the batches that weren't committed", nil)
+	}
+
+	if _, err := scanBatchDAO.Lease("worker2", time.Minute); err != mgo.ErrNotFound {
+		utils.Fatalln("Leasing a batch that was given up", err)
+	}
+
+	if err := scanBatchDAO.Renew(&leasedBatch, time.Minute); err != dao.ErrScanBatchDAOLeaseLost {
+		utils.Fatalln("Renewing the lease of a batch that was given up", err)
+	}
+
+	if err := scanBatchDAO.RemoveAllByScan(scanStartedAt); err != nil {
+		utils.Fatalln("Couldn't remove the batches of the scan", err)
+	}
+}
+
+// Function to create the pending batches of a new scan, with two domains in each batch.
+// Returns the start of the scan that identifies the batches
+func createBatches(scanBatchDAO dao.ScanBatchDAO, numberOfBatches int) time.Time {
+	scanStartedAt := time.Now().UTC()
+
+	for i := 1; i <= numberOfBatches; i++ {
+		batch := model.ScanBatch{
+			ScanStartedAt: scanStartedAt,
+			Sequence:      i,
+			FQDNs: []string{
+				fmt.Sprintf("example%d-1.com.br.", i),
+				fmt.Sprintf("example%d-2.com.br.", i),
+			},
+			Status: model.ScanBatchStatusPending,
+		}
+
+		if err := scanBatchDAO.Save(&batch); err != nil {
+			utils.Fatalln("Couldn't save scan batch in database", err)
+		}
+	}
+
+	return scanStartedAt
+}
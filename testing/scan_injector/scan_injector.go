@@ -34,10 +34,11 @@ type ScanInjectorTestConfigFile struct {
 		Name string
 	}
 
-	// Indicates if a domain is going to be selected for scan or not, based on the last check,
-	// if has errors or if the DNSSEC expiration date is near
+	// Indicates when a domain is going to be checked again, based on the last check, if has
+	// errors or if the DNSSEC expiration date is near
 	Scan struct {
 		DomainsBufferSize int // Size of the channel
+		IntervalHours     int // Time between scans
 
 		VerificationIntervals struct {
 			MaxOKDays              int
@@ -107,6 +108,7 @@ func domainWithDNSErrors(config ScanInjectorTestConfigFile, domainDAO dao.Domain
 		domain.Nameservers[index].LastStatus = model.NameserverStatusServerFailure
 	}
 
+	scheduleNextCheck(config, &domain)
 	if err := domainDAO.Save(&domain); err != nil {
 		utils.Fatalln("Error saving domain for scan scenario", err)
 	}
@@ -144,6 +146,7 @@ func domainWithDNSSECErrors(config ScanInjectorTestConfigFile, domainDAO dao.Dom
 		domain.DSSet[index].LastStatus = model.DSStatusTimeout
 	}
 
+	scheduleNextCheck(config, &domain)
 	if err := domainDAO.Save(&domain); err != nil {
 		utils.Fatalln("Error saving domain for scan scenario", err)
 	}
@@ -171,20 +174,21 @@ func domainWithDNSSECErrors(config ScanInjectorTestConfigFile, domainDAO dao.Dom
 func domainWithNoErrors(config ScanInjectorTestConfigFile, domainDAO dao.DomainDAO) {
 	domain := newDomain()
 
-	// Set all nameservers as configured correctly and the last check as now, this domain is
-	// unlikely to be selected
+	// Set all nameservers as configured correctly and the last check as now, this domain
+	// must not be selected
 	for index, _ := range domain.Nameservers {
 		domain.Nameservers[index].LastCheckAt = time.Now()
 		domain.Nameservers[index].LastStatus = model.NameserverStatusOK
 	}
 
-	// Set all DS records as configured correctly and the last check as now, this domain is
-	// unlikely to be selected
+	// Set all DS records as configured correctly and the last check as now, this domain
+	// must not be selected
 	for index, _ := range domain.DSSet {
 		domain.DSSet[index].LastCheckAt = time.Now()
 		domain.DSSet[index].LastStatus = model.DSStatusOK
 	}
 
+	scheduleNextCheck(config, &domain)
 	if err := domainDAO.Save(&domain); err != nil {
 		utils.Fatalln("Error saving domain for scan scenario", err)
 	}
@@ -214,9 +218,7 @@ func runScan(config ScanInjectorTestConfigFile, domainDAO dao.DomainDAO) []*mode
 	scanInjector := scan.NewInjector(
 		domainDAO.Database,
 		config.Scan.DomainsBufferSize,
-		config.Scan.VerificationIntervals.MaxOKDays,
-		config.Scan.VerificationIntervals.MaxErrorDays,
-		config.Scan.VerificationIntervals.MaxExpirationAlertDays,
+		time.Duration(config.Scan.IntervalHours)*time.Hour,
 	)
 
 	// Go routines group control created, but not used for this tests, as we are simulating
@@ -253,6 +255,16 @@ func runScan(config ScanInjectorTestConfigFile, domainDAO dao.DomainDAO) []*mode
 	return domains
 }
 
+// Compute the next check of the domain in the same way that the scan collector does
+// before saving it
+func scheduleNextCheck(config ScanInjectorTestConfigFile, domain *model.Domain) {
+	domain.ScheduleNextCheck(
+		config.Scan.VerificationIntervals.MaxOKDays,
+		config.Scan.VerificationIntervals.MaxErrorDays,
+		config.Scan.VerificationIntervals.MaxExpirationAlertDays,
+	)
+}
+
 // Function to mock a domain object
 func newDomain() model.Domain {
 	var domain model.Domain
@@ -164,7 +164,7 @@ func domainWithNoDNSErrors(config ScanQuerierTestConfigFile) {
 		w.WriteMsg(dnsResponseMessage)
 	})
 
-	domains := runScan(config, domainsToQueryChannel)
+	domains := runScan(config, domainsToQueryChannel, 0)
 	for _, domain := range domains {
 		if domain.FQDN != "br." ||
 			domain.Nameservers[0].LastStatus != model.NameserverStatusOK {
@@ -250,7 +250,7 @@ func domainWithNoDNSSECErrors(config ScanQuerierTestConfigFile) {
 		}
 	})
 
-	domains := runScan(config, domainsToQueryChannel)
+	domains := runScan(config, domainsToQueryChannel, 0)
 	for _, domain := range domains {
 		if domain.FQDN != "br." ||
 			domain.DSSet[0].LastStatus != model.DSStatusOK {
@@ -273,7 +273,7 @@ func domainDNSTimeout(config ScanQuerierTestConfigFile) {
 	}
 	domainsToQueryChannel <- nil // Poison pill
 
-	domains := runScan(config, domainsToQueryChannel)
+	domains := runScan(config, domainsToQueryChannel, 0)
 	for _, domain := range domains {
 		if domain.Nameservers[0].LastStatus != model.NameserverStatusTimeout {
 			utils.Fatalln("Error checking a timeout domain", nil)
@@ -293,7 +293,7 @@ func domainDNSUnknownHost(config ScanQuerierTestConfigFile) {
 	}
 	domainsToQueryChannel <- nil // Poison pill
 
-	domains := runScan(config, domainsToQueryChannel)
+	domains := runScan(config, domainsToQueryChannel, 0)
 	for _, domain := range domains {
 		if domain.Nameservers[0].LastStatus != model.NameserverStatusUnknownHost {
 			utils.Fatalln(fmt.Sprintf("Error checking a unknown host. Expected status %d "+
@@ -393,7 +393,7 @@ func scanQuerierReport(config ScanQuerierTestConfigFile) {
 
 		utils.Println(fmt.Sprintf("Generating report - scale %d", numberOfItems))
 		totalDuration, queriesPerSecond, _, _ :=
-			calculateScanQuerierDurations(config, domains)
+			calculateScanQuerierDurations(config, domains, 0)
 
 		var memStats runtime.MemStats
 		runtime.ReadMemStats(&memStats)
@@ -417,11 +417,6 @@ func inputScanReport(config ScanQuerierTestConfigFile) {
 	// querier performance
 	scan.DNSPort = 53
 
-	// As we are using the same domains repeatedly we should be careful about how many
-	// requests we send to only one host to avoid abuses. This value should be beteween 5
-	// and 10
-	scan.MaxQPSPerHost = 5
-
 	report := " #       | Total            | QPS  | Memory (MB)\n" +
 		"---------------------------------------------------\n"
 
@@ -436,8 +431,11 @@ func inputScanReport(config ScanQuerierTestConfigFile) {
 	dsStatusCounter := 0
 	dsSetStatus := make(map[model.DSStatus]int)
 
+	// As we are using the same domains repeatedly we should be careful about how many
+	// requests we send to only one host to avoid abuses. This value should be beteween 5
+	// and 10
 	totalDuration, queriesPerSecond, nameserversStatus, dsSetStatus :=
-		calculateScanQuerierDurations(config, domains)
+		calculateScanQuerierDurations(config, domains, 5)
 
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
@@ -477,7 +475,7 @@ func inputScanReport(config ScanQuerierTestConfigFile) {
 }
 
 func calculateScanQuerierDurations(config ScanQuerierTestConfigFile,
-	domains []*model.Domain) (totalDuration time.Duration,
+	domains []*model.Domain, queriesPerHost uint64) (totalDuration time.Duration,
 	queriesPerSecond int64, nameserversStatus map[model.NameserverStatus]int,
 	dsSetStatus map[model.DSStatus]int) {
 
@@ -490,7 +488,7 @@ func calculateScanQuerierDurations(config ScanQuerierTestConfigFile,
 	}()
 
 	beginTimer := time.Now()
-	results := runScan(config, domainsToQueryChannel)
+	results := runScan(config, domainsToQueryChannel, queriesPerHost)
 	totalDuration = time.Since(beginTimer)
 
 	totalDurationSeconds := int64(totalDuration / time.Second)
@@ -517,9 +515,10 @@ func calculateScanQuerierDurations(config ScanQuerierTestConfigFile,
 	return
 }
 
-// Method responsable to configure and start scan injector for tests
+// Method responsable to configure and start scan injector for tests. When the number of
+// queries per host is zero the default rate limit of the scan is used
 func runScan(config ScanQuerierTestConfigFile,
-	domainsToQueryChannel chan *model.Domain) []*model.Domain {
+	domainsToQueryChannel chan *model.Domain, queriesPerHost uint64) []*model.Domain {
 
 	dialTimeout := config.Scan.Timeouts.DialSeconds * time.Second
 	readTimeout := config.Scan.Timeouts.ReadSeconds * time.Second
@@ -533,8 +532,14 @@ func runScan(config ScanQuerierTestConfigFile,
 		readTimeout,
 		writeTimeout,
 		config.Scan.ConnectionRetries,
+		"", // No resolver, parent zone checks are disabled
 	)
 
+	if queriesPerHost > 0 {
+		querierDispatcher.RateLimit.QueriesPerSecond = queriesPerHost
+		querierDispatcher.RateLimit.Burst = queriesPerHost
+	}
+
 	// Go routines group control created, but not used for this tests, as we are simulating
 	// a collector receiver
 	var scanGroup sync.WaitGroup
@@ -22,4 +22,9 @@ func ClearDatabase(database *mgo.Database) {
 		Database: database,
 	}
 	scanDAO.RemoveAll()
+
+	scanBatchDAO := dao.ScanBatchDAO{
+		Database: database,
+	}
+	scanBatchDAO.RemoveAll()
 }
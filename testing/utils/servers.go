@@ -14,6 +14,13 @@ import (
 	"github.com/rafaeljusto/shelter/net/http/rest"
 	"github.com/rafaeljusto/shelter/net/http/rest/messages"
 	"github.com/rafaeljusto/shelter/net/scan"
+	"github.com/rafaeljusto/shelter/net/scan/cdspolicy"
+	"github.com/rafaeljusto/shelter/net/scan/csyncpolicy"
+	"github.com/rafaeljusto/shelter/net/scan/dspolicy"
+	"github.com/rafaeljusto/shelter/net/scan/ednspolicy"
+	"github.com/rafaeljusto/shelter/net/scan/nspolicy"
+	"github.com/rafaeljusto/shelter/net/scan/policy"
+	"github.com/rafaeljusto/shelter/net/scan/securitypolicy"
 	"os"
 	"path/filepath"
 	"time"
@@ -23,6 +30,25 @@ func StartDNSServer(port int, udpMaxSize uint16) (server *dns.Server) {
 	// Change the querier DNS port for the scan
 	scan.DNSPort = port
 
+	// The test DNS handlers don't implement EDNS, answer any query type, use SOA records
+	// with empty timers and don't sign the SOA and NS records, so we disable the policies
+	// of the EDNS compliance, security, CDS and CSYNC probes, of the SOA fields and of the
+	// SOA and NS signatures
+	disabled := false
+	err := policy.Configure(map[string]policy.Config{
+		ednspolicy.Policy:              {Enabled: &disabled},
+		securitypolicy.RecursionPolicy: {Enabled: &disabled},
+		securitypolicy.TransferPolicy:  {Enabled: &disabled},
+		nspolicy.SOAFieldsPolicy:       {Enabled: &disabled},
+		dspolicy.RRSetPolicy:           {Enabled: &disabled},
+		cdspolicy.Policy:               {Enabled: &disabled},
+		csyncpolicy.Policy:             {Enabled: &disabled},
+	})
+
+	if err != nil {
+		Fatalln("Error disabling the scan policies", err)
+	}
+
 	server = &dns.Server{
 		Net:     "udp",
 		Addr:    fmt.Sprintf("localhost:%d", port),
@@ -35,6 +61,19 @@ func StartDNSServer(port int, udpMaxSize uint16) (server *dns.Server) {
 		}
 	}()
 
+	// The scan also checks if the nameservers answer over TCP, so we listen on the same port
+	// using TCP with the same handlers
+	tcpServer := &dns.Server{
+		Net:  "tcp",
+		Addr: fmt.Sprintf("localhost:%d", port),
+	}
+
+	go func() {
+		if err := tcpServer.ListenAndServe(); err != nil {
+			Fatalln("Error starting DNS test server over TCP", err)
+		}
+	}()
+
 	// Wait the DNS server to start before testing
 	time.Sleep(1 * time.Second)
 	return